@@ -42,10 +42,16 @@ export const useAuth = () => {
     } catch {}
   };
 
-  const authFetch = (apiPath: string, method: string, bodyJson?: string) =>
+  const authFetch = (
+    apiPath: string,
+    method: string,
+    bodyJson?: string,
+    headers?: Record<string, string>,
+  ) =>
     fetch(`${BACKEND_URL}/api/${apiPath}`, {
       method: method,
       headers: {
+        ...headers,
         [CSRF_TOKEN_NAME]: getCookie(CSRF_TOKEN_NAME) ?? '',
       },
       body: bodyJson && bodyJson,
@@ -56,8 +62,9 @@ export const useAuth = () => {
     apiPath: string,
     method: string,
     bodyJson?: string,
+    headers?: Record<string, string>,
   ) =>
-    authFetch(apiPath, method, bodyJson)
+    authFetch(apiPath, method, bodyJson, headers)
       .then(response => response.json())
       .then(data => data as T);
 
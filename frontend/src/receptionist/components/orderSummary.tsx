@@ -3,7 +3,7 @@ import { CartItem, useCart } from '@/receptionist/contexts/cartContext';
 import TrashcanIcon from '@/icons/trashcanIcon';
 import { useNavigate, useParams } from 'react-router-dom';
 import { SplitBillSection } from './splitBillSection';
-import { useState, useEffect } from 'react';
+import { useState, useEffect, useRef } from 'react';
 import Toast from '@/global/components/toast';
 import {
   createStripeCheckout,
@@ -13,15 +13,18 @@ import { useAuth } from '@/global/hooks/auth';
 import { mutate } from 'swr';
 import i18n from '@/i18n';
 import { TipSection } from './orderTipSection';
+import { idempotencyKey } from '@/utils/idempotencyKey';
 
 type OrderSummaryProps = {
   onBack?: () => void;
   showPaymentSection?: boolean;
+  etag?: string;
 };
 
 export default function OrderSummary({
   onBack,
   showPaymentSection = false,
+  etag,
 }: OrderSummaryProps) {
   const { t } = useTranslation();
   const params = useParams();
@@ -51,6 +54,8 @@ export default function OrderSummary({
     type: 'success' | 'error';
   } | null>(null);
   const [isProcessing, setIsProcessing] = useState(false);
+  // Same for every retry while this summary is open, see idempotencyKey.
+  const idempotencyBaseKey = useRef(crypto.randomUUID());
 
   useEffect(() => {
     if (!hasItems && isSplitMode) {
@@ -76,9 +81,31 @@ export default function OrderSummary({
     try {
       const orderId = params.orderId;
       if (orderId) {
-        await authFetch(`order/${orderId}`, 'PATCH', JSON.stringify(order));
+        const response = await authFetch(
+          `order/${orderId}`,
+          'PATCH',
+          JSON.stringify(order),
+          { 'If-Match': etag ?? '*' },
+        );
+        if (response.status === 412) {
+          await mutate(`order/${orderId}`);
+          showToast(
+            t(
+              'orderSummary.conflictError',
+              'Order was changed by someone else. Review it and save again.',
+            ),
+            'error',
+          );
+          return;
+        }
       } else {
-        await authFetch(`order/`, 'POST', JSON.stringify(order));
+        const body = JSON.stringify(order);
+        await authFetch(`order/`, 'POST', body, {
+          'Idempotency-Key': await idempotencyKey(
+            idempotencyBaseKey.current,
+            body,
+          ),
+        });
       }
       await mutate('order'); // refresh orders list if cached
 
@@ -112,19 +139,31 @@ export default function OrderSummary({
       const existingOrderId = params.orderId;
       if (existingOrderId) {
         // Update existing order
-        await authFetchJson<string>(
+        const response = await authFetch(
           `order/${existingOrderId}`,
           'PATCH',
           JSON.stringify(order),
+          { 'If-Match': etag ?? '*' },
         );
+        if (response.status === 412) {
+          await mutate(`order/${existingOrderId}`);
+          throw new Error('order was changed by someone else');
+        }
         orderId = parseInt(existingOrderId);
         await mutate(`order/${existingOrderId}`);
       } else {
         // Create new order and get the order ID
+        const body = JSON.stringify(order);
         const response = await authFetchJson<{ id: number; message: string }>(
           `order/`,
           'POST',
-          JSON.stringify(order),
+          body,
+          {
+            'Idempotency-Key': await idempotencyKey(
+              idempotencyBaseKey.current,
+              body,
+            ),
+          },
         );
         orderId = response.id;
         await mutate(`order/${orderId}`);
@@ -137,6 +176,7 @@ export default function OrderSummary({
         orderId,
         paymentAmount,
         currency.toLowerCase(),
+        idempotencyBaseKey.current,
       );
 
       if (payerIndex === undefined || !isSplitMode) {
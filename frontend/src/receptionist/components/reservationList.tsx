@@ -47,6 +47,7 @@ export type Reservation = {
     | 'cancelled'
     | 'refund_pending'
     | 'refunded';
+  version: number;
 };
 
 function buildISOString(
@@ -177,6 +178,7 @@ export default function ReservationList() {
       status: reservation.status as any,
       notes: '',
       price: selectedService?.price ?? 0,
+      version: reservation.version,
     });
     setEditModalOpen(true);
   };
@@ -223,6 +225,11 @@ export default function ReservationList() {
         `reservation/${reservationData.id}`,
         'PUT',
         JSON.stringify(payload),
+        {
+          'If-Match': selectedReservationToEdit?.version
+            ? `"${selectedReservationToEdit.version}"`
+            : '*',
+        },
       );
       if (!res.ok) {
         const errBody = await res.json().catch(() => null);
@@ -352,6 +359,7 @@ export default function ReservationList() {
               `reservation/${selectedReservation.id}`,
               'PUT',
               JSON.stringify({ status }),
+              { 'If-Match': `"${selectedReservation.version}"` },
             );
             if (!res.ok) {
               const errBody = await res.json().catch(() => null);
@@ -27,10 +27,15 @@ export default function EditOrderPage() {
 
 function OrderPanel() {
   const params = useParams();
-  const { authFetchJson } = useAuth();
-  const { data: items } = useSWR(
+  const { authFetch } = useAuth();
+  const { data } = useSWR(
     `order/${params.orderId}`,
-    url => authFetchJson<CartItem[]>(url, 'GET'),
+    async url => {
+      const response = await authFetch(url, 'GET');
+      const items = (await response.json()) as CartItem[];
+      // ETag is sent back as If-Match when saving, so concurrent edits are detected.
+      return { items, etag: response.headers.get('ETag') ?? undefined };
+    },
     {
       suspense: true,
       revalidateOnMount: true,
@@ -38,7 +43,7 @@ function OrderPanel() {
   );
 
   return (
-    <CartProvider initItems={items}>
+    <CartProvider initItems={data?.items}>
       <main className="flex-1 overflow-y-auto">
         <div className="flex gap-6 p-6">
           {/* Left: Products */}
@@ -48,7 +53,7 @@ function OrderPanel() {
 
           {/* Right: Order summary */}
           <div className="w-1/3 max-w-md">
-            <OrderSummary showPaymentSection={true} />
+            <OrderSummary showPaymentSection={true} etag={data?.etag} />
           </div>
         </div>
       </main>
@@ -20,6 +20,7 @@ type ApiReservation = {
   serviceId: string;
   datetime: string;
   status: string;
+  version: number;
 };
 
 type ApiStaff = Omit<Staff, 'nameKey'>;
@@ -104,6 +105,7 @@ function ReservationPanel() {
       status: apiReservation.status as any,
       notes: '',
       price: selectedService?.price ?? 0,
+      version: apiReservation.version,
     } as Reservation;
   }, [reservationId, reservations, services]);
 
@@ -122,6 +124,11 @@ function ReservationPanel() {
         `reservation/${updatedReservation.id}`,
         'PUT',
         JSON.stringify(payload),
+        {
+          'If-Match': reservationData?.version
+            ? `"${reservationData.version}"`
+            : '*',
+        },
       );
       if (!res.ok) {
         const errBody = await res.json().catch(() => null);
@@ -0,0 +1,15 @@
+// Builds an Idempotency-Key for a request body.
+// Retrying the same body with the same base key sends the same key,
+// so the backend replays the first response instead of repeating the request.
+export const idempotencyKey = async (baseKey: string, body: string) => {
+  const digest = await crypto.subtle.digest(
+    'SHA-256',
+    new TextEncoder().encode(body),
+  );
+  const hash = Array.from(new Uint8Array(digest))
+    .slice(0, 8)
+    .map(b => b.toString(16).padStart(2, '0'))
+    .join('');
+
+  return `${baseKey}-${hash}`;
+};
@@ -1,4 +1,5 @@
 import { useAuth } from "@/global/hooks/auth";
+import { idempotencyKey } from "@/utils/idempotencyKey";
 
 export interface StripeCheckoutRequest {
   order_id: number;
@@ -33,16 +34,21 @@ export const createStripeCheckout = async (
   orderId: number,
   amount: number,
   currency: string,
+  idempotencyBaseKey?: string,
 ): Promise<StripeCheckoutResponse> => {
   const { authFetch } = useAuth();
+  const body = JSON.stringify({
+    order_id: orderId,
+    amount,
+    currency,
+  } as StripeCheckoutRequest);
   const response = await authFetch(
     'payment/stripe/create-checkout-session',
     'POST',
-    JSON.stringify({
-      order_id: orderId,
-      amount,
-      currency,
-    } as StripeCheckoutRequest)
+    body,
+    idempotencyBaseKey
+      ? { 'Idempotency-Key': await idempotencyKey(idempotencyBaseKey, body) }
+      : undefined,
   );
 
   if (!response.ok) {
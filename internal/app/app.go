@@ -37,7 +37,7 @@ func New(config config.Config) App {
 	// Create payment service for checkout sessions
 	paymentService := &payment.PaymentService{}
 
-	setupApiRoutes(mainRouter, config, authController.AuthenticateMiddleware, refundService)
+	setupApiRoutes(mainRouter, config, authController.AuthenticateMiddleware, refundService, paymentService)
 	setupPaymentRoutes(mainRouter, config, authController.AuthenticateMiddleware, paymentService)
 
 	mainRouter.NotFound(func(w http.ResponseWriter, r *http.Request) {
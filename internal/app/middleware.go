@@ -2,6 +2,7 @@ package app
 
 import (
 	"dreampos/internal/config"
+	"dreampos/internal/idempotency"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -22,8 +23,8 @@ func attachGlobalMiddlewares(router *chi.Mux, config config.Config) {
 	corsOptions := cors.Options{
 		AllowedOrigins:   []string{"http://localhost:" + fmt.Sprint(config.VitePort)},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Location", config.XSRFHeaderKey},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Location", "If-Match", "Last-Event-ID", idempotency.HeaderKey, config.XSRFHeaderKey},
+		ExposedHeaders:   []string{"Link", "ETag", "Idempotent-Replayed", "X-Total-Count"},
 		AllowCredentials: true,
 		MaxAge:           60 * 5, // Seconds
 	}
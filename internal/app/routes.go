@@ -4,11 +4,16 @@ import (
 	"dreampos/internal/auth"
 	"dreampos/internal/config"
 	"dreampos/internal/data"
+	"dreampos/internal/events"
+	"dreampos/internal/idempotency"
+	"dreampos/internal/kitchen"
 	"dreampos/internal/order"
 	"dreampos/internal/payment"
 	"dreampos/internal/product"
 	"dreampos/internal/refund"
 	"dreampos/internal/reservation"
+	"dreampos/internal/storage"
+	"dreampos/internal/table"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -39,9 +44,14 @@ func setupAuth(router *chi.Mux, config config.Config) *auth.AuthController {
 	return c
 }
 
-func setupApiRoutes(router *chi.Mux, config config.Config, authMiddleware func(http.Handler) http.Handler, refundService refund.Service) {
+func setupApiRoutes(router *chi.Mux, config config.Config, authMiddleware func(http.Handler) http.Handler, refundService refund.Service, paymentService *payment.PaymentService) {
 	apiRouter := chi.NewRouter()
 	db := data.MustCreatePostgresDb(config)
+	idempotencyMiddleware := idempotency.Middleware{
+		Store:     db,
+		Retention: time.Hour * 24,
+	}
+	go idempotency.RunCleanup(db, time.Hour)
 
 	{
 		// TODO: if this becomes more complicated extract to controller
@@ -65,6 +75,8 @@ func setupApiRoutes(router *chi.Mux, config config.Config, authMiddleware func(h
 		c := order.OrderController{
 			OrderRepo:   db,
 			ProductRepo: db,
+			Checkouts:   paymentService, // Stripe keys are set in setupPaymentRoutes
+			Idempotency: idempotencyMiddleware.Handler,
 		}
 
 		apiRouter.With(authMiddleware).Mount("/order", c.Routes())
@@ -101,8 +113,58 @@ func setupApiRoutes(router *chi.Mux, config config.Config, authMiddleware func(h
 	}
 
 	{
+		c := table.TableController{
+			TableRepo: db,
+		}
+
+		apiRouter.With(authMiddleware).Mount("/table", c.Routes())
+	}
+
+	kitchenHub := kitchen.NewHub()
+	{
+		broker := events.NewBroker()
+		listener := data.MustCreatePostgresListener(config, db)
+		go listener.Listen(func(event events.Event) {
+			broker.Publish(event)
+			kitchenHub.PublishAppEvent(event)
+		})
+
+		c := events.EventController{
+			EventRepo: db,
+			Broker:    broker,
+		}
+
+		apiRouter.With(authMiddleware).Mount("/events", c.Routes())
+	}
+
+	{
+		c := kitchen.KitchenController{
+			KitchenRepo: db,
+			Hub:         kitchenHub,
+		}
+
+		apiRouter.With(authMiddleware).Mount("/kitchen", c.Routes())
+	}
+
+	{
+		scheduler := product.PriceScheduler{
+			CatalogRepo: db,
+			Interval:    time.Minute,
+		}
+		go scheduler.Run()
+
+		images := storage.MustCreateLocalStore(config.ImageDir, "/api/product/image/")
+		sweeper := product.ImageSweeper{
+			CatalogRepo: db,
+			Images:      images,
+			Interval:    time.Hour,
+		}
+		go sweeper.Run()
+
 		c := product.ProductController{
 			ProductRepo: db,
+			CatalogRepo: db,
+			Images:      images,
 		}
 
 		apiRouter.With(authMiddleware).Mount("/product", c.Routes())
@@ -125,15 +187,22 @@ func setupPaymentRoutes(router *chi.Mux, config config.Config, authMiddleware fu
 	paymentService.ReservationCancelURL = fmt.Sprintf("http://%s/reservation-payment/cancel", config.FrontendUrl)
 	paymentService.OrderTotals = db
 	paymentService.OrderStatus = db
+	paymentService.OrderState = db
 	paymentService.PaymentRepo = db
 	paymentService.OrderItems = db
 	paymentService.OrderTip = db
+	paymentService.OrderEvents = db
 	paymentService.ReservationTotals = db
 	paymentService.ReservationStatus = db
 	paymentService.ReservationItems = db
 
 	paymentController := &payment.PaymentController{
-		Service: paymentService,
+		Service:        paymentService,
+		AuthMiddleware: authMiddleware,
+		Idempotency: idempotency.Middleware{
+			Store:     db,
+			Retention: time.Hour * 24,
+		}.Handler,
 	}
 
 	// Mount payment routes
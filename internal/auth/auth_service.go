@@ -108,6 +108,7 @@ func (s AuthService) getUserDetails(sessionToken *JwtSessionToken) (User, error)
 	user := User{
 		Username: sessionToken.Username,
 		Roles: userDetails.Roles,
+		Permissions: userDetails.Permissions,
 	}
 	return user, nil
 }
@@ -9,11 +9,13 @@ type LoginInfo struct {
 type UserDetails struct {
 	PasswordHash 	string
 	Roles			[]string
+	Permissions		[]string
 }
 
 type User struct {
 	Username	string 		`json:"username"`
 	Roles		[]string	`json:"roles"`
+	Permissions	[]string	`json:"permissions"`
 }
 
 type LoginResponse struct {
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Names of the rows in the permissions table.
+const (
+	PermissionFullAdmin     = "FULL_ADMIN"
+	PermissionManageCatalog = "MANAGE_CATALOG"
+	// Needed for free-text modifiers that change the price of an order line.
+	PermissionPriceModifiers = "PRICE_MODIFIERS"
+)
+
+// Users with FULL_ADMIN have every permission.
+func (u User) HasPermission(permission string) bool {
+	return slices.Contains(u.Permissions, permission) || slices.Contains(u.Permissions, PermissionFullAdmin)
+}
+
+// RequirePermission only lets through users with the permission.
+// Must be used after the auth middleware.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := r.Context().Value("user").(User)
+			if !ok || user.Username == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !user.HasPermission(permission) {
+				http.Error(w, "missing permission "+permission, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
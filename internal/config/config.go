@@ -32,6 +32,9 @@ type Config struct {
 	TwilioAuthToken  string
 	TwilioFromNumber string
 	TwilioEnabled    bool
+
+	// Directory uploaded product images are kept in
+	ImageDir string
 }
 
 func LoadConfig() (*Config, error) {
@@ -42,6 +45,11 @@ func LoadConfig() (*Config, error) {
 
 	twilioEnabled := os.Getenv("TWILIO_ENABLED") == "true"
 
+	imageDir := os.Getenv("IMAGE_DIR")
+	if imageDir == "" {
+		imageDir = "uploads/images"
+	}
+
 	config := &Config{
 		Url:         os.Getenv("URL"),
 		FrontendUrl: os.Getenv("FRONTEND_URL"),
@@ -64,6 +72,8 @@ func LoadConfig() (*Config, error) {
 		TwilioAuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
 		TwilioFromNumber: os.Getenv("TWILIO_FROM_NUMBER"),
 		TwilioEnabled:    twilioEnabled,
+
+		ImageDir: imageDir,
 	}
 
 	return config, nil
@@ -129,7 +129,7 @@ func (s MockDataSource) GetOrderItems(orderId int32) ([]order.Item, error) {
 		{
 			Product:            s.Products[0],
 			SelectedVariations: []order.Variation{},
-			Quantity:           5,
+			Quantity:           order.WholeQuantity(5),
 		},
 		{
 			Product: s.Products[0],
@@ -139,12 +139,12 @@ func (s MockDataSource) GetOrderItems(orderId int32) ([]order.Item, error) {
 					PriceModifier: 200,
 				},
 			},
-			Quantity: 1,
+			Quantity: order.WholeQuantity(1),
 		},
 		{
 			Product:            s.Products[1],
 			SelectedVariations: []order.Variation{},
-			Quantity:           3,
+			Quantity:           order.WholeQuantity(3),
 		},
 	}, nil
 }
@@ -1,10 +1,14 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -15,10 +19,15 @@ import (
 
 	"dreampos/internal/auth"
 	"dreampos/internal/config"
+	"dreampos/internal/events"
+	"dreampos/internal/idempotency"
+	"dreampos/internal/kitchen"
 	"dreampos/internal/order"
 	"dreampos/internal/payment"
+	"dreampos/internal/product"
 	"dreampos/internal/refund"
 	"dreampos/internal/reservation"
+	"dreampos/internal/table"
 )
 
 type PostgresDb struct {
@@ -26,17 +35,19 @@ type PostgresDb struct {
 }
 
 func MustCreatePostgresDb(config config.Config) PostgresDb {
-	dbDataSource := fmt.Sprintf(
+	return PostgresDb{
+		Db: sqlx.MustConnect("postgres", dataSourceName(config)),
+	}
+}
+
+func dataSourceName(config config.Config) string {
+	return fmt.Sprintf(
 		"host=%s port=%s dbname=%s user=%s password=%s sslmode=disable",
 		config.DbHostname,
 		config.DbPort,
 		config.DbName,
 		config.DbUser,
 		config.DbPass)
-
-	return PostgresDb{
-		Db: sqlx.MustConnect("postgres", dbDataSource),
-	}
 }
 
 // -------------------------------------------------------------------------------------------------
@@ -86,6 +97,27 @@ func (pdb PostgresDb) GetUserDetails(username string) (auth.UserDetails, error)
 
 		userDetails.Roles = rolesNames
 	}
+	{
+		const query = `
+		SELECT DISTINCT permissions.name
+		FROM employee_role
+		JOIN role_permission
+			ON role_permission.role_id = employee_role.role_id
+			AND employee_role.employee_id = $1
+		JOIN permissions
+			ON permissions.id = role_permission.permission_id
+		ORDER BY permissions.name
+		`
+
+		var permissionNames []string
+
+		err := pdb.Db.Select(&permissionNames, query, userId)
+		if err != nil {
+			return auth.UserDetails{}, err
+		}
+
+		userDetails.Permissions = permissionNames
+	}
 
 	return userDetails, nil
 }
@@ -160,27 +192,86 @@ func (pdb PostgresDb) GetBusinessInfo(username string) (auth.BusinessInfo, error
 // order.OrderRepo implimentation ----------------------------------------------------------------
 // -------------------------------------------------------------------------------------------------
 
-func (pdb PostgresDb) GetOrders(filter order.OrderFilter) ([]order.OrderSummary, error) {
+// Conditions of the order search, shared by GetOrders and CountOrders.
+// Arguments are given by orderSearchArgs.
+const orderSearchCondition = `
+	($1::order_status IS NULL OR o.status = $1::order_status)
+	AND ($2::timestamp IS NULL OR $2::timestamp <= o.created_at)
+	AND ($3::timestamp IS NULL OR o.created_at <= $3::timestamp)
+	AND ($4::bigint IS NULL OR o.id = $4::bigint)
+	AND ($5::bigint IS NULL OR o.employee_id = $5::bigint)
+	AND ($6::bigint IS NULL OR EXISTS (
+		SELECT 1
+		FROM order_item oi
+		WHERE oi.order_id = o.id AND oi.item_id = $6::bigint
+	))
+	AND ($7::payment_method IS NULL OR EXISTS (
+		SELECT 1
+		FROM payment p
+		WHERE
+			p.order_id = o.id
+			AND p.payment_method = $7::payment_method
+			AND p.status = 'COMPLETED'
+	))
+	AND ($8::bigint IS NULL OR o.total >= $8::bigint)
+	AND ($9::bigint IS NULL OR o.total <= $9::bigint)
+	AND ($10::text IS NULL OR o.customer_phone LIKE $10::text || '%' OR EXISTS (
+		SELECT 1
+		FROM refund_data rd
+		WHERE rd.order_id = o.id AND rd.phone LIKE $10::text || '%'
+	))
+	AND ($11::bigint IS NULL OR o.location_id = $11::bigint OR (
+		-- Orders without a location are matched by the items sold there
+		o.location_id IS NULL
+		AND EXISTS (
+			SELECT 1
+			FROM order_item oi
+			JOIN item_location il
+				ON oi.item_id = il.item_id
+			WHERE oi.order_id = o.id AND il.location_id = $11::bigint
+		)
+	))
+`
+
+func orderSearchArgs(filter order.OrderFilter) []any {
+	var status *string
 	if filter.OrderStatus != nil {
-		*filter.OrderStatus = strings.ToUpper(*filter.OrderStatus)
+		upper := strings.ToUpper(*filter.OrderStatus)
+		status = &upper
+	}
+
+	return []any{
+		status,
+		filter.From,
+		filter.To,
+		filter.Id,
+		filter.EmployeeId,
+		filter.ProductId,
+		filter.PaymentMethod,
+		filter.MinTotal,
+		filter.MaxTotal,
+		filter.CustomerPhone,
+		filter.LocationId,
 	}
+}
 
+func (pdb PostgresDb) GetOrders(filter order.OrderFilter) ([]order.OrderSummary, error) {
+	// Keyset pagination walks the primary key index, so deep pages are as fast as the first one.
 	const query = `
-	SELECT id, total, created_at, status
-	FROM order_detail
-	WHERE 
-		($1::order_status IS NULL OR status = $1::order_status)
-		AND ($2::timestamp IS NULL OR $2::timestamp <= created_at)
-		AND ($3::timestamp IS NULL OR created_at <= $3::timestamp)
-		AND ($4::bigint IS NULL OR id = $4::bigint)
+	SELECT o.id, o.total, o.created_at, o.status, o.customer_label, o.held_at IS NOT NULL AS held, LOWER(o.fulfillment_type::TEXT) AS fulfillment_type
+	FROM order_detail o
+	WHERE ` + orderSearchCondition + `
+		AND ($12::bigint IS NULL OR o.id < $12::bigint)
 	ORDER BY
-		id DESC
-	LIMIT COALESCE($5::bigint, 100)
-	OFFSET COALESCE($6::bigint, 0)
+		o.id DESC
+	LIMIT COALESCE($13::bigint, 100)
+	OFFSET COALESCE($14::bigint, 0)
 	`
 
+	args := append(orderSearchArgs(filter), filter.Cursor, filter.Limit, filter.Offset)
+
 	orders := []order.OrderSummary{}
-	err := pdb.Db.Select(&orders, query, filter.OrderStatus, filter.From, filter.To, filter.Id, filter.Limit, filter.Offset)
+	err := pdb.Db.Select(&orders, query, args...)
 	if err != nil {
 		slog.Error(err.Error())
 		return []order.OrderSummary{}, ErrInternal
@@ -193,13 +284,210 @@ func (pdb PostgresDb) GetOrders(filter order.OrderFilter) ([]order.OrderSummary,
 	return orders, nil
 }
 
-// TODO: implement or remove idk
+func (pdb PostgresDb) CountOrders(filter order.OrderFilter) (uint64, error) {
+	const query = `
+	SELECT COUNT(*)
+	FROM order_detail o
+	WHERE ` + orderSearchCondition
+
+	var count uint64
+	err := pdb.Db.Get(&count, query, orderSearchArgs(filter)...)
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return count, nil
+}
+
+// Line amounts for exports, VAT is derived from gross and net so that net + VAT always adds up to gross.
+// Net is taken from order_item_total, which splits bundle lines by the VAT rates of their components.
+const exportLineAmounts = `
+	t.net_total::BIGINT               AS net,
+	(t.total - t.net_total)::BIGINT   AS vat,
+	t.total::BIGINT                   AS gross
+`
+
+func (pdb PostgresDb) ExportOrderLines(filter order.OrderFilter, fn func(order.ExportLine) error) error {
+	const query = `
+	SELECT
+		o.id                        AS order_id,
+		o.created_at,
+		LOWER(o.status::TEXT)       AS status,
+		LOWER(o.currency::TEXT)     AS currency,
+		t.order_item_id,
+		t.item_id,
+		t.item_name,
+		t.quantity,
+		LOWER(t.unit::TEXT)         AS unit,
+		t.gross::BIGINT             AS unit_price,
+		t.vat::TEXT                 AS vat_rate,
+	` + exportLineAmounts + `
+	FROM order_detail o
+	JOIN order_item_total t
+		ON t.order_id = o.id
+	WHERE ` + orderSearchCondition + `
+	ORDER BY
+		o.id,
+		t.order_item_id
+	`
+
+	rows, err := pdb.Db.Queryx(query, orderSearchArgs(filter)...)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line order.ExportLine
+		if err := rows.StructScan(&line); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) ExportOrders(filter order.OrderFilter, fn func(order.ExportOrder) error) error {
+	const query = `
+	SELECT
+		o.id                                                AS order_id,
+		o.created_at,
+		LOWER(o.status::TEXT)                               AS status,
+		LOWER(o.currency::TEXT)                             AS currency,
+		COALESCE(e.first_name || ' ' || e.last_name, '')    AS employee,
+		lines.lines,
+		COALESCE(lines.net, 0)                              AS net,
+		COALESCE(lines.vat, 0)                              AS vat,
+		COALESCE(lines.gross, 0)                            AS gross,
+		o.discount::BIGINT                                  AS discount,
+		o.service_charge::BIGINT                            AS service_charge,
+		o.total::BIGINT                                     AS total,
+		o.tip::BIGINT                                       AS tip,
+		o.total_with_tip::BIGINT                            AS total_with_tip
+	FROM order_detail o
+	LEFT JOIN employee e
+		ON e.id = o.employee_id
+	LEFT JOIN LATERAL (
+		SELECT
+			COUNT(*)                AS lines,
+			SUM(amounts.net)::BIGINT   AS net,
+			SUM(amounts.vat)::BIGINT   AS vat,
+			SUM(amounts.gross)::BIGINT AS gross
+		FROM (
+			SELECT ` + exportLineAmounts + `
+			FROM order_item_total t
+			WHERE t.order_id = o.id
+		) amounts
+	) lines ON TRUE
+	WHERE ` + orderSearchCondition + `
+	ORDER BY
+		o.id
+	`
+
+	rows, err := pdb.Db.Queryx(query, orderSearchArgs(filter)...)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o order.ExportOrder
+		if err := rows.StructScan(&o); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
 func (pdb PostgresDb) GetOrderCounts(filter order.OrderFilter) (order.OrderCounts, error) {
-	return order.OrderCounts{}, nil
+	const query = `
+	SELECT
+		status,
+		COUNT(*) AS count
+	FROM order_data
+	WHERE
+		($1::timestamp IS NULL OR $1::timestamp <= created_at)
+		AND ($2::timestamp IS NULL OR created_at <= $2::timestamp)
+	GROUP BY status
+	`
+
+	rows := []struct {
+		Status string `db:"status"`
+		Count  uint64 `db:"count"`
+	}{}
+
+	err := pdb.Db.Select(&rows, query, filter.From, filter.To)
+	if err != nil {
+		slog.Error(err.Error())
+		return order.OrderCounts{}, ErrInternal
+	}
+
+	counts := order.OrderCounts{}
+	for _, row := range rows {
+		counts.All += row.Count
+		switch row.Status {
+		case "OPEN":
+			counts.Open += row.Count
+		case "CLOSED":
+			counts.Closed += row.Count
+		case "REFUND_PENDING":
+			counts.RefundPending += row.Count
+		case "REFUNDED":
+			counts.Refunded += row.Count
+		case "CANCELLED":
+			counts.Cancelled += row.Count
+		}
+	}
+
+	return counts, nil
 }
 
-func (pdb PostgresDb) CreateOrder(username string, order order.Order) (int64, error) {
-	currency := strings.ToUpper(order.Currency)
+func (pdb PostgresDb) GetSales(filter order.OrderFilter) (order.OrderSales, error) {
+	// Refund pending orders are still paid for, so they are counted until refunded.
+	const query = `
+	SELECT
+		COUNT(*) AS orders,
+		CAST(ROUND(COALESCE(SUM(total), 0)) AS BIGINT) AS total,
+		CAST(ROUND(COALESCE(SUM(tip), 0)) AS BIGINT) AS tips
+	FROM order_detail
+	WHERE
+		status IN ('CLOSED', 'REFUND_PENDING')
+		AND ($1::timestamp IS NULL OR $1::timestamp <= created_at)
+		AND ($2::timestamp IS NULL OR created_at <= $2::timestamp)
+	`
+
+	var sales order.OrderSales
+	err := pdb.Db.Get(&sales, query, filter.From, filter.To)
+	if err != nil {
+		slog.Error(err.Error())
+		return order.OrderSales{}, ErrInternal
+	}
+
+	return sales, nil
+}
+
+func (pdb PostgresDb) CreateOrder(username string, o order.Order) (int64, error) {
+	currency := strings.ToUpper(o.Currency)
 
 	employeeID := int64(0)
 	{
@@ -218,21 +506,83 @@ func (pdb PostgresDb) CreateOrder(username string, order order.Order) (int64, er
 		}
 	}
 
+	if o.LocationId != nil {
+		err := checkLocationExists(pdb.Db, *o.LocationId)
+		if errors.Is(err, product.ErrLocationNotFound) {
+			return 0, order.ErrLocationNotFound
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	priceListId := int64(0)
+	if o.PriceListId != nil && *o.PriceListId > 0 {
+		priceListId = *o.PriceListId
+		if err := checkPriceList(pdb.Db, o.LocationId, priceListId); err != nil {
+			return 0, err
+		}
+	}
+
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
 	createOrderStatement := `
-	INSERT INTO order_data (employee_id, currency)
-		VALUES ($1, $2)
+	INSERT INTO order_data (employee_id, currency, party_size, fulfillment_type, location_id, price_list_id)
+		VALUES ($1, $2, $3, $4::fulfillment_type, $5, NULLIF($6, 0))
 		RETURNING id
 	`
 
+	fulfillment := order.FulfillmentDineIn
+	if o.FulfillmentType != nil {
+		fulfillment = *o.FulfillmentType
+	}
+
 	orderId := int64(-1)
-	err := pdb.Db.QueryRow(createOrderStatement, employeeID, currency).Scan(&orderId)
+	err = transaction.QueryRow(
+		createOrderStatement,
+		employeeID,
+		currency,
+		o.PartySize,
+		fulfillment.DbValue(),
+		o.LocationId,
+		priceListId,
+	).Scan(&orderId)
 	if err != nil {
 		slog.Error(err.Error())
+		_ = transaction.Rollback()
 		return 0, ErrInternal
 	}
 
-	err = pdb.ModifyOrder(orderId, order)
+	err = recordOrderEvent(transaction, orderId, username, order.HistoryCreated, map[string]any{
+		"currency": currency,
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	if o.TableId != nil {
+		err = seatOrder(transaction, orderId, *o.TableId, username)
+		// Tables of other locations are not offered for the order
+		if errors.Is(err, table.ErrTableNotFound) || errors.Is(err, table.ErrOrderLocation) {
+			_ = transaction.Rollback()
+			return 0, order.ErrTableNotFound
+		} else if err != nil {
+			_ = transaction.Rollback()
+			return 0, err
+		}
+	}
+
+	// Items, label and the rest are set the same way as when an order is modified
+	_, err = modifyOrder(transaction, orderId, username, nil, o)
 	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	if err := transaction.Commit(); err != nil {
 		slog.Error(err.Error())
 		return 0, ErrInternal
 	}
@@ -240,817 +590,6237 @@ func (pdb PostgresDb) CreateOrder(username string, order order.Order) (int64, er
 	return orderId, nil
 }
 
-func (pdb PostgresDb) ModifyOrder(orderId int64, order order.Order) error {
+// Modifies an open order and returns its new version.
+// If version is not nil, the order is modified only if it's still at that version.
+func (pdb PostgresDb) ModifyOrder(orderId int64, username string, version *int64, o order.Order) (int64, error) {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	newVersion, err := modifyOrder(transaction, orderId, username, version, o)
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return newVersion, nil
+}
+
+// Does the work of ModifyOrder in the given transaction, the caller commits or rolls back.
+func modifyOrder(transaction *sqlx.Tx, orderId int64, username string, version *int64, o order.Order) (int64, error) {
+	var err error
+
+	var locationId *int64
 	{
 		checkIfOrderIsOpenQuery := `
-		SELECT COUNT(*)
+		SELECT status, version, location_id
 		FROM order_data
-		WHERE 
-			id = $1
-			AND status = 'OPEN'
+		WHERE id = $1
+		FOR UPDATE
 		`
-		matchedOrderCount := 0
-		err := pdb.Db.QueryRow(checkIfOrderIsOpenQuery, orderId).Scan(&matchedOrderCount)
+		var current struct {
+			Status     string `db:"status"`
+			Version    int64  `db:"version"`
+			LocationId *int64 `db:"location_id"`
+		}
+		err := transaction.Get(&current, checkIfOrderIsOpenQuery, orderId)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, order.ErrOrderNotFound
+			}
 			slog.Error(err.Error())
-			return ErrInternal
+			return 0, ErrInternal
 		}
-		if matchedOrderCount != 1 {
-			slog.Error(fmt.Sprintf("expected to 1 row, got %d.", matchedOrderCount))
-			return ErrInternal
+		if version != nil && *version != current.Version {
+			return 0, order.ErrVersionMismatch
 		}
+		if order.ParseStatus(current.Status) != order.StatusOpen {
+			return 0, order.ErrOrderNotOpen
+		}
+		locationId = current.LocationId
 	}
 
-	transaction, err := pdb.Db.Begin()
-	if err != nil {
-		slog.Error(err.Error())
-		return ErrInternal
+	// The checkout would close the order for the amount it was started with
+	if err := checkNoPendingCheckout(transaction, orderId); err != nil {
+		return 0, err
 	}
 
-	if order.Tip > -1 {
+	if o.Tip > -1 {
 		updateOrderInfoStatement := `
 		UPDATE order_data
 		SET tip = $2
-		WHERE id = $1
+		WHERE
+			id = $1
+			AND tip <> $2
 		`
 
-		err = pdb.Db.QueryRow(updateOrderInfoStatement, orderId, order.Tip).Err()
+		res, err := transaction.Exec(updateOrderInfoStatement, orderId, o.Tip)
 		if err != nil {
 			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
-		}
-	}
-
-	for _, item := range order.Items {
-		if item.Id > 0 {
-			itemModificationStatement := `
-			UPDATE order_item
-			SET
-				order_id = $2,
-				item_id  = $3,
-				quantity = $4
-			WHERE id = $1
-			RETURNING id
-			`
-			err = pdb.Db.QueryRow(itemModificationStatement, item.Id, orderId, item.Product.Id, item.Quantity).Scan(&item.Id)
-		} else {
-			itemModificationStatement := `
-			INSERT INTO order_item (order_id, item_id, quantity)
-				VALUES ($1, $2, $3)
-			RETURNING id
-			`
-			err = pdb.Db.QueryRow(itemModificationStatement, orderId, item.Product.Id, item.Quantity).Scan(&item.Id)
+			return 0, ErrInternal
 		}
 
-		if err != nil {
-			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
+		if rows, _ := res.RowsAffected(); rows == 1 {
+			err = recordOrderEvent(transaction, orderId, username, order.HistoryTipSet, map[string]any{
+				"tip": o.Tip,
+			})
+			if err != nil {
+				return 0, err
+			}
 		}
+	}
 
-		// Very safe
-		nukeVariationsStatement := `
-		DELETE FROM order_item_variation 
-		WHERE order_item_id = $1
+	if o.CustomerLabel != nil || o.Notes != nil || o.CustomerPhone != nil {
+		// An empty phone removes it
+		const updateLabelStatement = `
+		UPDATE order_data
+		SET
+			customer_label = COALESCE($2, customer_label),
+			notes          = COALESCE($3, notes),
+			customer_phone = CASE WHEN $4::TEXT IS NULL THEN customer_phone ELSE NULLIF($4, '') END
+		WHERE
+			id = $1
+			AND (customer_label, notes, COALESCE(customer_phone, '')) <> (COALESCE($2, customer_label), COALESCE($3, notes), COALESCE($4, customer_phone, ''))
+		RETURNING customer_label, notes, COALESCE(customer_phone, '') AS customer_phone
 		`
-		_, err = pdb.Db.Exec(nukeVariationsStatement, item.Id)
 
-		for _, variation := range item.SelectedVariations {
-			const insertVariationStatement = `
-			INSERT INTO order_item_variation (order_item_id, variation_id)
-				VALUES ($1, $2)
-			`
-			err = pdb.Db.QueryRow(insertVariationStatement, item.Id, variation.Id).Err()
+		var updated struct {
+			CustomerLabel string `db:"customer_label"`
+			Notes         string `db:"notes"`
+			CustomerPhone string `db:"customer_phone"`
+		}
+		err := transaction.Get(&updated, updateLabelStatement, orderId, o.CustomerLabel, o.Notes, o.CustomerPhone)
+		if err == nil {
+			err = recordOrderEvent(transaction, orderId, username, order.HistoryLabelChanged, map[string]any{
+				"customerLabel": updated.CustomerLabel,
+				"notes":         updated.Notes,
+				"customerPhone": updated.CustomerPhone,
+			})
 			if err != nil {
-				slog.Error(err.Error())
-				_ = transaction.Rollback()
-				return ErrInternal
+				return 0, err
 			}
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error(err.Error())
+			return 0, ErrInternal
 		}
 	}
 
-	_ = transaction.Commit()
-
-	return nil
-}
-
-func (pdb PostgresDb) MarkOrderClosed(orderID int64) error {
-	const query = `
-	UPDATE order_data
-	SET status = 'CLOSED'
-	WHERE id = $1
-		AND status = 'OPEN'
-	`
-
-	res, err := pdb.Db.Exec(query, orderID)
-	if err != nil {
-		slog.Error("Failed to mark order as closed", "error", err, "order_id", orderID)
-		return ErrInternal
-	}
-
-	_, _ = res.RowsAffected()
-
-	return nil
-}
-
-func (pdb PostgresDb) CreateRefundRequest(orderId int64, refundData order.RefundData) error {
-	transaction, err := pdb.Db.Beginx()
-	if err != nil {
-		slog.Error(err.Error())
-		return ErrInternal
-	}
+	// Lines keep the prices they were added with, unless the order changes what they are priced by
+	repriceLines := false
 
-	{
-		const orderStatusStatement = `
+	if o.FulfillmentType != nil {
+		const updateFulfillmentStatement = `
 		UPDATE order_data
-		SET status = 'REFUND_PENDING'
+		SET fulfillment_type = $2::fulfillment_type
 		WHERE
 			id = $1
-			AND status = 'CLOSED'
+			AND fulfillment_type <> $2::fulfillment_type
 		`
 
-		res, err := transaction.Exec(orderStatusStatement, orderId)
+		res, err := transaction.Exec(updateFulfillmentStatement, orderId, o.FulfillmentType.DbValue())
 		if err != nil {
 			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
+			return 0, ErrInternal
 		}
-		rows, err := res.RowsAffected()
-		if err != nil || rows != 1 {
-			slog.Error("unexpected rows affected when setting refund pending", "rows", rows, "error", err)
-			_ = transaction.Rollback()
-			return ErrInternal
-		}
-	}
-	{
-		const refundDataStatement = `
-		INSERT INTO refund_data (order_id, name, phone, email, reason)
-			VALUES ($1, $2, $3, $4, $5)
-		`
 
-		_, err = transaction.Exec(
-			refundDataStatement,
-			orderId,
-			refundData.Name,
-			refundData.Phone,
-			refundData.Email,
-			refundData.Reason,
-		)
-		if err != nil {
-			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
+		if rows, _ := res.RowsAffected(); rows == 1 {
+			repriceLines = true
+			err = recordOrderEvent(transaction, orderId, username, order.HistoryFulfillmentSet, map[string]any{
+				"fulfillmentType": *o.FulfillmentType,
+			})
+			if err != nil {
+				return 0, err
+			}
 		}
 	}
 
-	_ = transaction.Commit()
-
-	return nil
-}
-
-func (pdb PostgresDb) CancelRefundRequest(orderId int64) error {
-	transaction, err := pdb.Db.Beginx()
-	if err != nil {
-		slog.Error(err.Error())
-		return ErrInternal
-	}
+	if o.PriceListId != nil {
+		if *o.PriceListId > 0 {
+			if err := checkPriceList(transaction, locationId, *o.PriceListId); err != nil {
+				return 0, err
+			}
+		}
 
-	{
-		const orderStatusStatement = `
+		const updatePriceListStatement = `
 		UPDATE order_data
-		SET status = 'CLOSED'
+		SET price_list_id = NULLIF($2, 0)
 		WHERE
 			id = $1
-			AND status = 'REFUND_PENDING'
+			AND price_list_id IS DISTINCT FROM NULLIF($2, 0)
+		RETURNING price_list_id
 		`
 
-		res, err := transaction.Exec(orderStatusStatement, orderId)
-		if err != nil {
+		var priceListId *int64
+		err := transaction.Get(&priceListId, updatePriceListStatement, orderId, *o.PriceListId)
+		if err == nil {
+			repriceLines = true
+			err = recordOrderEvent(transaction, orderId, username, order.HistoryPriceListSet, map[string]any{
+				"priceListId": priceListId,
+			})
+			if err != nil {
+				return 0, err
+			}
+		} else if !errors.Is(err, sql.ErrNoRows) {
 			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
-		}
-		rows, err := res.RowsAffected()
-		if err != nil || rows != 1 {
-			slog.Error("unexpected rows affected when cancelling refund request", "rows", rows, "error", err)
-			_ = transaction.Rollback()
-			return ErrInternal
+			return 0, ErrInternal
 		}
 	}
-	{
-		const refundDataStatement = `
-		DELETE FROM refund_data
-		WHERE order_id = $1
-		`
 
-		res, err := transaction.Exec(refundDataStatement, orderId)
-		if err != nil {
-			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
-		}
+	if err := checkItemQuantities(transaction, o.Items); err != nil {
+		return 0, err
+	}
 
-		numberOfRows, err := res.RowsAffected()
-		if err != nil {
+	if len(o.Items) > 0 || repriceLines {
+		// Changes that became due since the scheduler last ran are applied first,
+		// so the lines get the prices that are in effect now
+		const applyPriceChangesStatement = `
+		SELECT apply_item_price_changes()
+		`
+		if _, err := transaction.Exec(applyPriceChangesStatement); err != nil {
 			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
+			return 0, ErrInternal
 		}
-		if numberOfRows > 1 {
-			slog.Error("tried to delete more than one refund request at a time")
-			_ = transaction.Rollback()
-			return ErrInternal
+	}
+	if repriceLines {
+		if err := repriceOrderItems(transaction, orderId); err != nil {
+			return 0, err
 		}
 	}
 
-	_ = transaction.Commit()
+	for _, item := range o.Items {
+		variationIds := selectedVariationIds(item.SelectedVariations)
+
+		eventType := order.HistoryItemAdded
+		// Pricing rules are chosen for new lines only, so a line keeps its happy hour price after it ends
+		choosePricingRule := true
+		// Lines that keep their product and variations are not checked again, the rules may have changed since
+		checkVariations := true
+		// Same for the components, options may have been removed since
+		checkComponents := true
+		// Archived variations can only stay on the line that already had them
+		var keptVariationIds []int64
+		if item.Id > 0 {
+			// Lines are resent on every update, only actual changes end up in the history
+			const previousQuery = `
+			SELECT
+				item_id,
+				quantity,
+				note,
+				sent_at IS NOT NULL AS sent,
+				ARRAY(
+					SELECT variation_id
+					FROM order_item_variation
+					WHERE order_item_id = order_item.id
+					ORDER BY variation_id
+				) AS variation_ids
+			FROM order_item
+			WHERE
+				id = $1
+				AND order_id = $2
+			`
+			var previous struct {
+				ItemId       int64          `db:"item_id"`
+				Quantity     order.Quantity `db:"quantity"`
+				Note         string         `db:"note"`
+				Sent         bool           `db:"sent"`
+				VariationIds pq.Int64Array  `db:"variation_ids"`
+			}
+			err = transaction.Get(&previous, previousQuery, item.Id, orderId)
+			// Lines of other orders are moved by merging or splitting them
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, order.ErrLineNotFound
+			} else if err != nil {
+				slog.Error(err.Error())
+				return 0, ErrInternal
+			}
 
-	return nil
-}
+			var previousModifiers []order.Modifier
+			previousModifiers, err = getOrderItemModifiers(transaction, item.Id)
+			if err != nil {
+				return 0, err
+			}
 
-// -------------------------------------------------------------------------------------------------
-// refund.RefundRepo implementation ----------------------------------------------------------------
-// -------------------------------------------------------------------------------------------------
+			var previousComponents []order.Component
+			previousComponents, err = getOrderItemComponents(transaction, item.Id)
+			if err != nil {
+				return 0, err
+			}
 
-func (pdb PostgresDb) GetPendingRefunds() ([]refund.Refund, error) {
-	paymentQuery := `
-	SELECT DISTINCT ON (order_id)
-		order_id,
-		COALESCE(stripe_payment_intent_id, '') AS stripe_payment_intent_id,
-		COALESCE(payment_method::TEXT, '') AS payment_method
-	FROM payment
-	ORDER BY
-		order_id,
-		(status = 'COMPLETED') DESC,
-		updated_at DESC,
-		id DESC
-	`
+			eventType = order.HistoryItemChanged
+			choosePricingRule = previous.ItemId != item.Product.Id
+			checkVariations = choosePricingRule || !slices.Equal([]int64(previous.VariationIds), variationIds)
+			checkComponents = choosePricingRule ||
+				(item.Components != nil && !slices.EqualFunc(previousComponents, item.Components, sameComponent))
+			// Lines that keep their product stay even if it's no longer sold at the location
+			if !choosePricingRule {
+				keptVariationIds = previous.VariationIds
+			} else if err := checkItemAvailable(transaction, orderId, item.Product.Id); err != nil {
+				return 0, err
+			}
+			unchanged := previous.ItemId == item.Product.Id &&
+				previous.Quantity == item.Quantity &&
+				slices.Equal([]int64(previous.VariationIds), variationIds) &&
+				(item.Modifiers == nil || slices.Equal(previousModifiers, item.Modifiers)) &&
+				(item.Components == nil || slices.EqualFunc(previousComponents, item.Components, sameComponent))
+			// The kitchen is already making what was sent, changes go on a new line
+			if previous.Sent && !unchanged {
+				return 0, order.ErrLineAlreadySent
+			}
+			if unchanged && (item.Note == nil || *item.Note == previous.Note) {
+				eventType = ""
+			}
 
-	orderQuery := fmt.Sprintf(`
-	SELECT
-		od.id AS id,
-		od.id AS order_id,
-		0 AS reservation_id,
-		'order' AS refund_type,
-		rd.reason AS reason,
-		od.created_at AS requested_at,
-		CAST(ROUND(odetail.total) AS BIGINT) AS amount_cents,
-		COALESCE(p.stripe_payment_intent_id, '') AS stripe_payment_intent_id,
-		COALESCE(p.payment_method::TEXT, '') AS payment_method
-	FROM order_data od
-	JOIN order_detail odetail
-		ON odetail.id = od.id
-	JOIN refund_data rd
-		ON rd.order_id = od.id
-	LEFT JOIN (%s) p
-		ON p.order_id = od.id
-	WHERE od.status = 'REFUND_PENDING'
-	`, paymentQuery)
+			itemModificationStatement := `
+			UPDATE order_item
+			SET
+				item_id  = $3,
+				quantity = $4,
+				note     = COALESCE($5, note)
+			WHERE
+				id = $1
+				AND order_id = $2
+			RETURNING id
+			`
+			err = transaction.QueryRow(itemModificationStatement, item.Id, orderId, item.Product.Id, item.Quantity, item.Note).Scan(&item.Id)
+		} else {
+			if err := checkItemAvailable(transaction, orderId, item.Product.Id); err != nil {
+				return 0, err
+			}
 
-	reservationQuery := fmt.Sprintf(`
-	SELECT
-		a.id AS id,
-		0 AS order_id,
-		a.id AS reservation_id,
-		'reservation' AS refund_type,
-		rrd.reason AS reason,
-		COALESCE(ab.created_at, a.appointment_at, NOW()) AS requested_at,
-		CAST(ROUND(sl.price) AS BIGINT) AS amount_cents,
-		COALESCE(p.stripe_payment_intent_id, '') AS stripe_payment_intent_id,
-		COALESCE(p.payment_method::TEXT, '') AS payment_method
-	FROM appointment a
-	JOIN service_location sl
-		ON a.service_location_id = sl.id
-	LEFT JOIN (
-		SELECT appointment_id, MAX(created_at) AS created_at
-		FROM appointment_bill
-		GROUP BY appointment_id
-	) ab
-		ON ab.appointment_id = a.id
-	JOIN reservation_refund_data rrd
-		ON rrd.appointment_id = a.id
-	LEFT JOIN (%s) p
-		ON p.order_id = a.id
-	WHERE a.status = 'REFUND_PENDING'
-	`, paymentQuery)
+			itemModificationStatement := `
+			INSERT INTO order_item (order_id, item_id, quantity, note)
+				VALUES ($1, $2, $3, COALESCE($4, ''))
+			RETURNING id
+			`
+			err = transaction.QueryRow(itemModificationStatement, orderId, item.Product.Id, item.Quantity, item.Note).Scan(&item.Id)
+		}
 
-	combinedQuery := orderQuery + " UNION ALL " + reservationQuery + " ORDER BY requested_at DESC"
+		if err != nil {
+			slog.Error(err.Error())
+			return 0, ErrInternal
+		}
 
-	var rows []struct {
-		ID                    int64     `db:"id"`
-		OrderID               int64     `db:"order_id"`
-		ReservationID         int64     `db:"reservation_id"`
-		RefundType            string    `db:"refund_type"`
-		Reason                string    `db:"reason"`
-		RequestedAt           time.Time `db:"requested_at"`
-		AmountCents           int64     `db:"amount_cents"`
-		StripePaymentIntentID string    `db:"stripe_payment_intent_id"`
-		PaymentMethod         string    `db:"payment_method"`
-	}
+		var pricingRuleId *int64
+		if choosePricingRule {
+			// No rule matches orders without a location, the rules belong to a business
+			const pricingRuleStatement = `
+			UPDATE order_item
+			SET (pricing_rule_id, pricing_rule_name, price_adjustment_type, price_adjustment) = (
+				SELECT matched.id, matched.name, matched.adjustment_type, matched.adjustment
+				FROM order_data
+				LEFT JOIN LATERAL find_pricing_rule(order_item.item_id, order_data.location_id) matched
+					ON TRUE
+				WHERE order_data.id = order_item.order_id
+			)
+			WHERE id = $1
+			RETURNING pricing_rule_id
+			`
+			err = transaction.Get(&pricingRuleId, pricingRuleStatement, item.Id)
+			if err != nil {
+				slog.Error(err.Error())
+				return 0, ErrInternal
+			}
+		}
 
-	if err := pdb.Db.Select(&rows, combinedQuery); err != nil {
-		slog.Error("Failed to get pending refunds", "error", err)
-		return nil, ErrInternal
-	}
+		// Defaults are only added when the product is, later on the variations are as the user left them
+		if checkVariations {
+			item.SelectedVariations, err = checkItemVariations(transaction, item.Product.Id, item.SelectedVariations, keptVariationIds, choosePricingRule)
+			if err != nil {
+				return 0, err
+			}
+			variationIds = selectedVariationIds(item.SelectedVariations)
+		}
 
-	refunds := make([]refund.Refund, 0, len(rows))
-	for _, row := range rows {
-		refundItem := refund.Refund{
-			ID:                    uint32(row.ID),
-			RefundType:            row.RefundType,
-			Amount:                float64(row.AmountCents) / 100.0,
-			AmountCents:           row.AmountCents,
-			Reason:                row.Reason,
-			Status:                refund.StatusPending,
-			RequestedAt:           row.RequestedAt,
-			StripePaymentIntentID: row.StripePaymentIntentID,
-			PaymentMethod:         strings.ToLower(row.PaymentMethod),
+		// Unchanged variations keep the names and prices they were added with
+		if checkVariations {
+			nukeVariationsStatement := `
+			DELETE FROM order_item_variation
+			WHERE order_item_id = $1
+			`
+			_, err = transaction.Exec(nukeVariationsStatement, item.Id)
+			if err != nil {
+				slog.Error(err.Error())
+				return 0, ErrInternal
+			}
+
+			for _, variation := range item.SelectedVariations {
+				const insertVariationStatement = `
+				INSERT INTO order_item_variation (order_item_id, variation_id)
+					VALUES ($1, $2)
+				`
+				_, err = transaction.Exec(insertVariationStatement, item.Id, variation.Id)
+				if err != nil {
+					slog.Error(err.Error())
+					return 0, ErrInternal
+				}
+			}
 		}
 
-		if row.OrderID > 0 {
-			refundItem.OrderID = uint32(row.OrderID)
+		if item.Modifiers != nil {
+			const nukeModifiersStatement = `
+			DELETE FROM order_item_modifier
+			WHERE order_item_id = $1
+			`
+			_, err = transaction.Exec(nukeModifiersStatement, item.Id)
+			if err != nil {
+				slog.Error(err.Error())
+				return 0, ErrInternal
+			}
+
+			for _, modifier := range item.Modifiers {
+				const insertModifierStatement = `
+				INSERT INTO order_item_modifier (order_item_id, group_name, name, price_difference)
+					VALUES ($1, $2, $3, $4)
+				`
+				_, err = transaction.Exec(insertModifierStatement, item.Id, modifier.Group, modifier.Name, modifier.PriceModifier)
+				if err != nil {
+					slog.Error(err.Error())
+					return 0, ErrInternal
+				}
+			}
 		}
-		if row.ReservationID > 0 {
-			refundItem.ReservationID = uint32(row.ReservationID)
+
+		// New lines and lines that got another product need the components of their product
+		if checkComponents {
+			if err := checkItemComponents(transaction, item.Product.Id, item.Components); err != nil {
+				return 0, err
+			}
+			for _, component := range item.Components {
+				if err := checkItemAvailable(transaction, orderId, component.ProductId); err != nil {
+					return 0, err
+				}
+			}
+			if err := setOrderItemComponents(transaction, item.Id, item.Components); err != nil {
+				return 0, err
+			}
 		}
 
-		refunds = append(refunds, refundItem)
+		if eventType != "" {
+			details := map[string]any{
+				"orderItemId":  item.Id,
+				"productId":    item.Product.Id,
+				"quantity":     item.Quantity,
+				"variationIds": variationIds,
+			}
+			if item.Note != nil {
+				details["note"] = *item.Note
+			}
+			if item.Modifiers != nil {
+				details["modifiers"] = item.Modifiers
+			}
+			if pricingRuleId != nil {
+				details["pricingRuleId"] = *pricingRuleId
+			}
+			if item.Components != nil {
+				components := make([]map[string]any, 0, len(item.Components))
+				for _, component := range item.Components {
+					components = append(components, map[string]any{
+						"slotId":    *component.SlotId,
+						"productId": component.ProductId,
+					})
+				}
+				details["components"] = components
+			}
+			err = recordOrderEvent(transaction, orderId, username, eventType, details)
+			if err != nil {
+				return 0, err
+			}
+		}
 	}
 
-	return refunds, nil
+	newVersion := int64(0)
+	{
+		const versionStatement = `
+		UPDATE order_data
+		SET version = version + 1
+		WHERE id = $1
+		RETURNING version
+		`
+		err = transaction.Get(&newVersion, versionStatement, orderId)
+		if err != nil {
+			slog.Error(err.Error())
+			return 0, ErrInternal
+		}
+	}
+
+	return newVersion, nil
 }
 
-func (pdb PostgresDb) GetRefundByID(id uint32) (*refund.Refund, error) {
-	paymentQuery := `
-	SELECT DISTINCT ON (order_id)
-		order_id,
-		COALESCE(stripe_payment_intent_id, '') AS stripe_payment_intent_id,
-		COALESCE(payment_method::TEXT, '') AS payment_method
-	FROM payment
-	ORDER BY
-		order_id,
-		(status = 'COMPLETED') DESC,
-		updated_at DESC,
-		id DESC
+// Prices the lines of the order and their components again, after the location, price list or
+// fulfillment type of the order changed. Names, variations and upcharges stay as they were added.
+func repriceOrderItems(transaction *sqlx.Tx, orderId int64) error {
+	const itemStatement = `
+	UPDATE order_item
+	SET
+		price_per_unit = item_price(item.id, order_data.location_id, order_data.price_list_id),
+		vat            = COALESCE(item_vat.vat, item.vat)
+	FROM item
+	JOIN order_data
+		ON order_data.id = $1
+	LEFT JOIN item_vat
+		ON item_vat.item_id = item.id
+		AND item_vat.fulfillment_type = order_data.fulfillment_type
+	WHERE
+		order_item.item_id = item.id
+		AND order_item.order_id = $1
 	`
+	if _, err := transaction.Exec(itemStatement, orderId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
 
-	orderQuery := fmt.Sprintf(`
-	SELECT
-		od.id AS id,
-		od.id AS order_id,
-		0 AS reservation_id,
-		'order' AS refund_type,
-		rd.reason AS reason,
-		od.created_at AS requested_at,
-		CAST(ROUND(odetail.total) AS BIGINT) AS amount_cents,
-		COALESCE(p.stripe_payment_intent_id, '') AS stripe_payment_intent_id,
-		COALESCE(p.payment_method::TEXT, '') AS payment_method
-	FROM order_data od
-	JOIN order_detail odetail
-		ON odetail.id = od.id
-	JOIN refund_data rd
-		ON rd.order_id = od.id
-	LEFT JOIN (%s) p
-		ON p.order_id = od.id
-	WHERE od.id = $1
-		AND od.status = 'REFUND_PENDING'
-	LIMIT 1
-	`, paymentQuery)
+	const componentStatement = `
+	UPDATE order_item_component
+	SET
+		price_per_unit = item_price(item.id, order_data.location_id, order_data.price_list_id),
+		vat            = COALESCE(item_vat.vat, item.vat)
+	FROM item
+	JOIN order_data
+		ON order_data.id = $1
+	LEFT JOIN item_vat
+		ON item_vat.item_id = item.id
+		AND item_vat.fulfillment_type = order_data.fulfillment_type
+	WHERE
+		order_item_component.item_id = item.id
+		AND order_item_component.order_item_id IN (
+			SELECT id
+			FROM order_item
+			WHERE order_id = $1
+		)
+	`
+	if _, err := transaction.Exec(componentStatement, orderId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
 
-	reservationQuery := fmt.Sprintf(`
+	return nil
+}
+
+func (pdb PostgresDb) MarkOrderClosed(orderID int64, amountCents int64) error {
+	return pdb.runOrderTransition(orderID, order.EventPay, "stripe", orderTransitionPayload{
+		lockedTotalCents: &amountCents,
+	})
+}
+
+func (pdb PostgresDb) CreateRefundRequest(orderId int64, username string, refundData order.RefundData) error {
+	return pdb.runOrderTransition(orderId, order.EventRequestRefund, username, orderTransitionPayload{
+		refundData: &refundData,
+	})
+}
+
+func (pdb PostgresDb) CancelRefundRequest(orderId int64, username string) error {
+	return pdb.runOrderTransition(orderId, order.EventCancelRefund, username, orderTransitionPayload{})
+}
+
+func (pdb PostgresDb) CancelOrder(orderId int64, username string, cancellation order.Cancellation) error {
+	return pdb.runOrderTransition(orderId, order.EventCancel, username, orderTransitionPayload{
+		cancellation: &cancellation,
+	})
+}
+
+// Data needed by the side effects of an order transition.
+type orderTransitionPayload struct {
+	refundData   *order.RefundData
+	cancellation *order.Cancellation
+	// Total the customer agreed to pay, used by the guards instead of the current total.
+	lockedTotalCents *int64
+}
+
+func (pdb PostgresDb) runOrderTransition(orderId int64, event order.Event, actor string, payload orderTransitionPayload) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	err = transitionOrder(transaction, orderId, event, actor, payload)
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) GetOrderFacts(orderId int64) (order.OrderFacts, error) {
+	return getOrderFacts(pdb.Db, orderId)
+}
+
+// Reads what the order state machine needs to know about the order.
+func getOrderFacts(queryer sqlx.Queryer, orderId int64) (order.OrderFacts, error) {
+	const query = `
 	SELECT
-		a.id AS id,
-		0 AS order_id,
-		a.id AS reservation_id,
-		'reservation' AS refund_type,
-		rrd.reason AS reason,
-		COALESCE(ab.created_at, a.appointment_at, NOW()) AS requested_at,
-		CAST(ROUND(sl.price) AS BIGINT) AS amount_cents,
-		COALESCE(p.stripe_payment_intent_id, '') AS stripe_payment_intent_id,
-		COALESCE(p.payment_method::TEXT, '') AS payment_method
-	FROM appointment a
-	JOIN service_location sl
-		ON a.service_location_id = sl.id
-	LEFT JOIN (
-		SELECT appointment_id, MAX(created_at) AS created_at
-		FROM appointment_bill
-		GROUP BY appointment_id
-	) ab
-		ON ab.appointment_id = a.id
-	JOIN reservation_refund_data rrd
-		ON rrd.appointment_id = a.id
-	LEFT JOIN (%s) p
-		ON p.order_id = a.id
-	WHERE a.id = $1
-		AND a.status = 'REFUND_PENDING'
-	LIMIT 1
-	`, paymentQuery)
+		status,
+		CAST(ROUND(total_with_tip) AS BIGINT) AS total_cents,
+		(
+			SELECT CAST(COALESCE(SUM(amount), 0) AS BIGINT)
+			FROM payment
+			WHERE
+				order_id = $1
+				AND status = 'COMPLETED'
+		) AS paid_cents
+	FROM order_detail
+	WHERE id = $1
+	`
 
 	var row struct {
-		ID                    int64     `db:"id"`
-		OrderID               int64     `db:"order_id"`
-		ReservationID         int64     `db:"reservation_id"`
-		RefundType            string    `db:"refund_type"`
-		Reason                string    `db:"reason"`
-		RequestedAt           time.Time `db:"requested_at"`
-		AmountCents           int64     `db:"amount_cents"`
-		StripePaymentIntentID string    `db:"stripe_payment_intent_id"`
-		PaymentMethod         string    `db:"payment_method"`
+		Status     string `db:"status"`
+		TotalCents int64  `db:"total_cents"`
+		PaidCents  int64  `db:"paid_cents"`
 	}
-
-	err := pdb.Db.Get(&row, orderQuery, id)
+	err := sqlx.Get(queryer, &row, query, orderId)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			err = pdb.Db.Get(&row, reservationQuery, id)
-			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					return nil, errors.New("refund not found")
-				}
-				slog.Error("Failed to get reservation refund by ID", "error", err, "id", id)
-				return nil, ErrInternal
+			return order.OrderFacts{}, order.ErrOrderNotFound
+		}
+		slog.Error(err.Error())
+		return order.OrderFacts{}, ErrInternal
+	}
+
+	return order.OrderFacts{
+		Status:     order.ParseStatus(row.Status),
+		TotalCents: row.TotalCents,
+		PaidCents:  row.PaidCents,
+	}, nil
+}
+
+// Moves the order through the order state machine.
+// The status change, its side effects and the history record are all done in the given transaction,
+// the order row stays locked until the transaction ends.
+func transitionOrder(transaction *sqlx.Tx, orderId int64, event order.Event, actor string, payload orderTransitionPayload) error {
+	{
+		const query = `
+		SELECT id
+		FROM order_data
+		WHERE id = $1
+		FOR UPDATE
+		`
+
+		var id int64
+		err := transaction.Get(&id, query, orderId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return order.ErrOrderNotFound
 			}
-		} else {
-			slog.Error("Failed to get order refund by ID", "error", err, "order_id", id)
-			return nil, ErrInternal
+			slog.Error(err.Error())
+			return ErrInternal
 		}
 	}
 
-	refundItem := &refund.Refund{
-		ID:                    uint32(row.ID),
-		RefundType:            row.RefundType,
-		Amount:                float64(row.AmountCents) / 100.0,
-		AmountCents:           row.AmountCents,
-		Reason:                row.Reason,
-		Status:                refund.StatusPending,
-		RequestedAt:           row.RequestedAt,
-		StripePaymentIntentID: row.StripePaymentIntentID,
-		PaymentMethod:         strings.ToLower(row.PaymentMethod),
+	facts, err := getOrderFacts(transaction, orderId)
+	if err != nil {
+		return err
+	}
+	if payload.lockedTotalCents != nil {
+		facts.TotalCents = *payload.lockedTotalCents
 	}
 
-	if row.OrderID > 0 {
-		refundItem.OrderID = uint32(row.OrderID)
+	step, err := order.Next(facts, event)
+	if err != nil {
+		slog.Warn("order transition refused", "order_id", orderId, "error", err)
+		return err
 	}
-	if row.ReservationID > 0 {
-		refundItem.ReservationID = uint32(row.ReservationID)
+
+	{
+		const statusStatement = `
+		UPDATE order_data
+		SET
+			status  = $2::order_status,
+			version = version + 1
+		WHERE id = $1
+		`
+
+		_, err := transaction.Exec(statusStatement, orderId, step.To.DbValue())
+		if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
 	}
 
-	return refundItem, nil
+	for _, effect := range step.Effects {
+		switch effect {
+		case order.EffectStoreRefundData:
+			if payload.refundData == nil {
+				slog.Error("missing refund data for order transition", "order_id", orderId)
+				return ErrInternal
+			}
+
+			const refundDataStatement = `
+			INSERT INTO refund_data (order_id, name, phone, email, reason)
+				VALUES ($1, $2, $3, $4, $5)
+			`
+
+			_, err := transaction.Exec(
+				refundDataStatement,
+				orderId,
+				payload.refundData.Name,
+				payload.refundData.Phone,
+				payload.refundData.Email,
+				payload.refundData.Reason,
+			)
+			if err != nil {
+				slog.Error(err.Error())
+				return ErrInternal
+			}
+		case order.EffectClearRefundData:
+			const refundDataStatement = `
+			DELETE FROM refund_data
+			WHERE order_id = $1
+			`
+
+			_, err := transaction.Exec(refundDataStatement, orderId)
+			if err != nil {
+				slog.Error(err.Error())
+				return ErrInternal
+			}
+		case order.EffectStoreCancellation:
+			if payload.cancellation == nil {
+				slog.Error("missing cancellation for order transition", "order_id", orderId)
+				return ErrInternal
+			}
+
+			const cancellationStatement = `
+			INSERT INTO order_cancellation (order_id, reason, note, cancelled_by)
+				SELECT $1, $2::order_cancel_reason, $3, id
+				FROM employee
+				WHERE username = $4
+			`
+
+			res, err := transaction.Exec(
+				cancellationStatement,
+				orderId,
+				strings.ToUpper(string(payload.cancellation.Reason)),
+				payload.cancellation.Note,
+				actor,
+			)
+			if err != nil {
+				slog.Error(err.Error())
+				return ErrInternal
+			}
+			rows, err := res.RowsAffected()
+			if err != nil || rows != 1 {
+				slog.Error("unexpected rows affected when storing order cancellation", "rows", rows, "error", err)
+				return ErrInternal
+			}
+		default:
+			slog.Error("unknown order transition effect", "effect", effect)
+			return ErrInternal
+		}
+	}
+
+	{
+		const historyStatement = `
+		INSERT INTO order_status_change (order_id, from_status, to_status, event, actor, employee_id)
+			VALUES (
+				$1,
+				$2::order_status,
+				$3::order_status,
+				$4,
+				$5,
+				(SELECT id FROM employee WHERE username = $5)
+			)
+		`
+
+		_, err := transaction.Exec(
+			historyStatement,
+			orderId,
+			step.From.DbValue(),
+			step.To.DbValue(),
+			string(step.Event),
+			actor,
+		)
+		if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+	}
+
+	return nil
 }
 
-func (pdb PostgresDb) UpdateRefundStatus(id uint32, status refund.RefundStatus, stripeRefundID string) (*refund.Refund, error) {
-	refundRecord, err := pdb.GetRefundByID(id)
+func (pdb PostgresDb) GetOrderItemsWithVersion(orderId int64) ([]order.Item, int64, error) {
+	// Both reads see the same snapshot, so the version matches the items
+	transaction, err := pdb.Db.BeginTxx(context.Background(), &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
 	if err != nil {
-		return nil, err
+		slog.Error(err.Error())
+		return nil, 0, ErrInternal
+	}
+	defer func() { _ = transaction.Rollback() }()
+
+	const query = `
+	SELECT version
+	FROM order_data
+	WHERE id = $1
+	`
+
+	var version int64
+	err = transaction.Get(&version, query, orderId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, order.ErrOrderNotFound
+		}
+		slog.Error(err.Error())
+		return nil, 0, ErrInternal
+	}
+
+	items, err := getOrderItems(transaction, orderId)
+	if err != nil {
+		return nil, 0, err
 	}
 
+	return items, version, nil
+}
+
+func (pdb PostgresDb) HoldOrder(orderId int64, username string, hold order.Hold) error {
 	transaction, err := pdb.Db.Beginx()
 	if err != nil {
 		slog.Error(err.Error())
-		return nil, ErrInternal
+		return ErrInternal
 	}
 
-	rollback := func(err error) (*refund.Refund, error) {
+	{
+		const query = `
+		SELECT status
+		FROM order_data
+		WHERE id = $1
+		FOR UPDATE
+		`
+		var status string
+		err := transaction.Get(&status, query, orderId)
+		if err != nil {
+			_ = transaction.Rollback()
+			if errors.Is(err, sql.ErrNoRows) {
+				return order.ErrOrderNotFound
+			}
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if order.ParseStatus(status) != order.StatusOpen {
+			_ = transaction.Rollback()
+			return order.ErrOrderNotOpen
+		}
+	}
+
+	var customerLabel string
+	{
+		const statement = `
+		UPDATE order_data
+		SET
+			held_at        = NOW(),
+			held_terminal  = $2,
+			picked_up_by   = NULL,
+			customer_label = COALESCE($3, customer_label),
+			notes          = COALESCE($4, notes),
+			version        = version + 1
+		WHERE id = $1
+		RETURNING customer_label
+		`
+		err := transaction.Get(&customerLabel, statement, orderId, hold.TerminalId, hold.CustomerLabel, hold.Notes)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	err = recordOrderEvent(transaction, orderId, username, order.HistoryHeld, map[string]any{
+		"terminalId":    hold.TerminalId,
+		"customerLabel": customerLabel,
+	})
+	if err != nil {
 		_ = transaction.Rollback()
-		return nil, err
+		return err
 	}
 
-	isOrder := refundRecord.RefundType == "order"
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
 
-	switch status {
-	case refund.StatusDisapproved:
+	return nil
+}
+
+func (pdb PostgresDb) PickUpOrder(orderId int64, username string, terminalId string) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	// The row lock makes sure that only the first terminal gets the order
+	const statement = `
+	UPDATE order_data
+	SET
+		held_at      = NULL,
+		picked_up_by = $2,
+		version      = version + 1
+	WHERE
+		id = $1
+		AND status = 'OPEN'
+		AND held_at IS NOT NULL
+	RETURNING id
+	`
+
+	var pickedUpId int64
+	err = transaction.Get(&pickedUpId, statement, orderId, terminalId)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = transaction.Rollback()
+
+		const existsQuery = `
+		SELECT EXISTS (SELECT 1 FROM order_data WHERE id = $1)
+		`
+		exists := false
+		if err := pdb.Db.Get(&exists, existsQuery, orderId); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if !exists {
+			return order.ErrOrderNotFound
+		}
+		return order.ErrOrderNotHeld
+	} else if err != nil {
+		slog.Error(err.Error())
+		_ = transaction.Rollback()
+		return ErrInternal
+	}
+
+	err = recordOrderEvent(transaction, orderId, username, order.HistoryPickedUp, map[string]any{
+		"terminalId": terminalId,
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) GetHeldOrders(filter order.HeldOrderFilter) ([]order.HeldOrder, error) {
+	const query = `
+	SELECT
+		o.id,
+		o.customer_label,
+		o.notes,
+		o.total,
+		o.created_at,
+		o.held_at,
+		d.held_terminal,
+		d.location_id
+	FROM order_detail o
+	JOIN order_data d ON d.id = o.id
+	WHERE
+		o.held_at IS NOT NULL
+		AND o.status = 'OPEN'
+		AND ($1::text IS NULL OR d.held_terminal = $1::text)
+		AND ($2::bigint IS NULL OR d.location_id = $2::bigint)
+	ORDER BY
+		o.held_at
+	`
+
+	orders := []order.HeldOrder{}
+	err := pdb.Db.Select(&orders, query, filter.TerminalId, filter.LocationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []order.HeldOrder{}, ErrInternal
+	}
+
+	return orders, nil
+}
+
+func (pdb PostgresDb) MergeOrders(fromOrderId int64, intoOrderId int64, username string) error {
+	if fromOrderId == intoOrderId {
+		return order.ErrSameOrder
+	}
+
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	err = lockOpenOrders(transaction, fromOrderId, intoOrderId)
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	{
+		// Lines are priced for the fulfillment type, location and price list of their order
+		const query = `
+		SELECT
+			COUNT(DISTINCT fulfillment_type)            AS fulfillment_types,
+			COUNT(DISTINCT COALESCE(location_id, 0))    AS locations,
+			COUNT(DISTINCT COALESCE(price_list_id, 0))  AS price_lists
+		FROM order_data
+		WHERE id = $1 OR id = $2
+		`
+		var distinct struct {
+			FulfillmentTypes int `db:"fulfillment_types"`
+			Locations        int `db:"locations"`
+			PriceLists       int `db:"price_lists"`
+		}
+		err := transaction.Get(&distinct, query, fromOrderId, intoOrderId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+		if distinct.FulfillmentTypes != 1 {
+			_ = transaction.Rollback()
+			return order.ErrFulfillmentDiffer
+		}
+		if distinct.Locations != 1 {
+			_ = transaction.Rollback()
+			return order.ErrLocationDiffer
+		}
+		if distinct.PriceLists != 1 {
+			_ = transaction.Rollback()
+			return order.ErrPriceListDiffer
+		}
+	}
+
+	movedItemIds := []int64{}
+	{
+		const statement = `
+		UPDATE order_item
+		SET order_id = $2
+		WHERE order_id = $1
+		RETURNING id
+		`
+		err := transaction.Select(&movedItemIds, statement, fromOrderId, intoOrderId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	{
+		const statement = `
+		UPDATE order_data AS target
+		SET
+			tip            = target.tip + source.tip,
+			discount       = target.discount + source.discount,
+			service_charge = target.service_charge + source.service_charge,
+			version        = target.version + 1
+		FROM order_data AS source
+		WHERE
+			target.id = $2
+			AND source.id = $1
+		`
+		_, err := transaction.Exec(statement, fromOrderId, intoOrderId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	{
+		const statement = `
+		UPDATE order_data
+		SET
+			tip            = 0,
+			discount       = 0,
+			service_charge = 0
+		WHERE id = $1
+		`
+		_, err := transaction.Exec(statement, fromOrderId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	err = transitionOrder(transaction, fromOrderId, order.EventCancel, username, orderTransitionPayload{
+		cancellation: &order.Cancellation{
+			Reason: order.CancelMerged,
+			Note:   fmt.Sprintf("merged into order #%d", intoOrderId),
+		},
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	err = recordOrderEvent(transaction, fromOrderId, username, order.HistoryMerged, map[string]any{
+		"intoOrderId":  intoOrderId,
+		"orderItemIds": movedItemIds,
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+	err = recordOrderEvent(transaction, intoOrderId, username, order.HistoryMerged, map[string]any{
+		"fromOrderId":  fromOrderId,
+		"orderItemIds": movedItemIds,
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) SplitOrder(orderId int64, username string, lines []order.SplitLine) (int64, error) {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	err = lockOpenOrders(transaction, orderId)
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	quantities := map[int64]order.Quantity{}
+	units := map[int64]order.Unit{}
+	{
+		const query = `
+		SELECT order_item.id, quantity, LOWER(item.unit::TEXT) AS unit
+		FROM order_item
+		JOIN item
+			ON item.id = order_item.item_id
+		WHERE order_id = $1
+		`
+		var current []struct {
+			Id       int64          `db:"id"`
+			Quantity order.Quantity `db:"quantity"`
+			Unit     order.Unit     `db:"unit"`
+		}
+		err := transaction.Select(&current, query, orderId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return 0, ErrInternal
+		}
+		for _, line := range current {
+			quantities[line.Id] = line.Quantity
+			units[line.Id] = line.Unit
+		}
+	}
+
+	seen := map[int64]bool{}
+	for _, line := range lines {
+		available, ok := quantities[line.OrderItemId]
+		if !ok || seen[line.OrderItemId] || !units[line.OrderItemId].Allows(line.Quantity) || line.Quantity > available {
+			_ = transaction.Rollback()
+			return 0, order.ErrInvalidSplit
+		}
+		seen[line.OrderItemId] = true
+	}
+
+	newOrderId := int64(0)
+	{
+		// The new order stays at the same table and location as the original one, with the same prices
+		const statement = `
+		INSERT INTO order_data (employee_id, currency, table_id, location_id, price_list_id, fulfillment_type, party_size)
+			SELECT
+				COALESCE((SELECT id FROM employee WHERE username = $2), employee_id),
+				currency,
+				table_id,
+				location_id,
+				price_list_id,
+				fulfillment_type,
+				party_size
+			FROM order_data
+			WHERE id = $1
+		RETURNING id
+		`
+		err := transaction.Get(&newOrderId, statement, orderId, username)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return 0, ErrInternal
+		}
+	}
+
+	movedItemIds := make([]int64, 0, len(lines))
+	for _, line := range lines {
+		movedItemId := line.OrderItemId
+		if line.Quantity == quantities[line.OrderItemId] {
+			const statement = `
+			UPDATE order_item
+			SET order_id = $2
+			WHERE id = $1
+			`
+			_, err := transaction.Exec(statement, line.OrderItemId, newOrderId)
+			if err != nil {
+				slog.Error(err.Error())
+				_ = transaction.Rollback()
+				return 0, ErrInternal
+			}
+		} else {
+			const reduceStatement = `
+			UPDATE order_item
+			SET quantity = quantity - $2
+			WHERE id = $1
+			`
+			_, err := transaction.Exec(reduceStatement, line.OrderItemId, line.Quantity)
+			if err != nil {
+				slog.Error(err.Error())
+				_ = transaction.Rollback()
+				return 0, ErrInternal
+			}
+
+			// Snapshot columns are copied, so the moved part keeps the same price
+			const copyStatement = `
+			INSERT INTO order_item (
+				order_id, item_id, quantity, discount, item_name, price_per_unit, vat, note, sent_at, prep_status, prep_status_changed_at,
+				pricing_rule_id, pricing_rule_name, price_adjustment_type, price_adjustment
+			)
+				SELECT
+					$2, item_id, $3, discount, item_name, price_per_unit, vat, note, sent_at, prep_status, prep_status_changed_at,
+					pricing_rule_id, pricing_rule_name, price_adjustment_type, price_adjustment
+				FROM order_item
+				WHERE id = $1
+			RETURNING id
+			`
+			err = transaction.Get(&movedItemId, copyStatement, line.OrderItemId, newOrderId, line.Quantity)
+			if err != nil {
+				slog.Error(err.Error())
+				_ = transaction.Rollback()
+				return 0, ErrInternal
+			}
+
+			const copyVariationsStatement = `
+			INSERT INTO order_item_variation (order_item_id, variation_id, variation_name, price_difference)
+				SELECT $2, variation_id, variation_name, price_difference
+				FROM order_item_variation
+				WHERE order_item_id = $1
+			`
+			_, err = transaction.Exec(copyVariationsStatement, line.OrderItemId, movedItemId)
+			if err != nil {
+				slog.Error(err.Error())
+				_ = transaction.Rollback()
+				return 0, ErrInternal
+			}
+
+			const copyModifiersStatement = `
+			INSERT INTO order_item_modifier (order_item_id, group_name, name, price_difference)
+				SELECT $2, group_name, name, price_difference
+				FROM order_item_modifier
+				WHERE order_item_id = $1
+				ORDER BY id
+			`
+			_, err = transaction.Exec(copyModifiersStatement, line.OrderItemId, movedItemId)
+			if err != nil {
+				slog.Error(err.Error())
+				_ = transaction.Rollback()
+				return 0, ErrInternal
+			}
+
+			const copyComponentsStatement = `
+			INSERT INTO order_item_component (order_item_id, slot_id, slot_name, item_id, item_name, upcharge, price_per_unit, vat)
+				SELECT $2, slot_id, slot_name, item_id, item_name, upcharge, price_per_unit, vat
+				FROM order_item_component
+				WHERE order_item_id = $1
+				ORDER BY id
+			`
+			_, err = transaction.Exec(copyComponentsStatement, line.OrderItemId, movedItemId)
+			if err != nil {
+				slog.Error(err.Error())
+				_ = transaction.Rollback()
+				return 0, ErrInternal
+			}
+		}
+		movedItemIds = append(movedItemIds, movedItemId)
+	}
+
+	{
+		const versionStatement = `
+		UPDATE order_data
+		SET version = version + 1
+		WHERE id = $1
+		`
+		_, err := transaction.Exec(versionStatement, orderId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return 0, ErrInternal
+		}
+	}
+
+	err = recordOrderEvent(transaction, orderId, username, order.HistorySplit, map[string]any{
+		"newOrderId": newOrderId,
+		"lines":      lines,
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+	err = recordOrderEvent(transaction, newOrderId, username, order.HistorySplit, map[string]any{
+		"fromOrderId":  orderId,
+		"orderItemIds": movedItemIds,
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return 0, err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return newOrderId, nil
+}
+
+// Locks the orders for the rest of the transaction and checks that all of them are open
+// and none has a Stripe checkout that could still be paid for the old amount.
+// Rows are locked in id order, so two merges of the same orders can't deadlock.
+func lockOpenOrders(transaction *sqlx.Tx, orderIds ...int64) error {
+	{
+		const query = `
+		SELECT status
+		FROM order_data
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE
+		`
+		statuses := []string{}
+		err := transaction.Select(&statuses, query, pq.Int64Array(orderIds))
+		if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if len(statuses) != len(orderIds) {
+			return order.ErrOrderNotFound
+		}
+		for _, status := range statuses {
+			if order.ParseStatus(status) != order.StatusOpen {
+				return order.ErrOrderNotOpen
+			}
+		}
+	}
+
+	return checkNoPendingCheckout(transaction, orderIds...)
+}
+
+// Returns order.ErrPaymentPending if one of the orders has a Stripe checkout that could still be paid.
+// queryer is either the DB or a transaction.
+func checkNoPendingCheckout(queryer sqlx.Queryer, orderIds ...int64) error {
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM payment
+		WHERE
+			order_id = ANY($1)
+			AND payment_method = 'STRIPE'
+			AND status = 'PENDING'
+	)
+	`
+
+	pending := false
+	if err := sqlx.Get(queryer, &pending, query, pq.Int64Array(orderIds)); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if pending {
+		return order.ErrPaymentPending
+	}
+
+	return nil
+}
+
+// Records a domain event on the order timeline.
+// execer is either the DB or the transaction the event belongs to.
+func recordOrderEvent(execer sqlx.Execer, orderId int64, actor string, eventType order.HistoryEventType, details any) error {
+	detailsJson, err := json.Marshal(details)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	const statement = `
+	INSERT INTO order_event (order_id, type, actor, employee_id, details)
+		VALUES (
+			$1,
+			$2,
+			$3,
+			(SELECT id FROM employee WHERE username = $3),
+			$4::jsonb
+		)
+	`
+
+	_, err = execer.Exec(statement, orderId, string(eventType), actor, string(detailsJson))
+	if err != nil {
+		slog.Error("Failed to record order event", "error", err, "order_id", orderId, "type", eventType)
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) RecordOrderEvent(orderId int64, actor string, eventType order.HistoryEventType, details any) error {
+	return recordOrderEvent(pdb.Db, orderId, actor, eventType, details)
+}
+
+func (pdb PostgresDb) GetOrderHistory(orderId int64) ([]order.HistoryEvent, error) {
+	{
+		const query = `
+		SELECT COUNT(*)
+		FROM order_data
+		WHERE id = $1
+		`
+		matchedOrderCount := 0
+		if err := pdb.Db.Get(&matchedOrderCount, query, orderId); err != nil {
+			slog.Error(err.Error())
+			return nil, ErrInternal
+		}
+		if matchedOrderCount == 0 {
+			return nil, order.ErrOrderNotFound
+		}
+	}
+
+	// Status changes are stored by the state machine, the rest by the order operations.
+	const query = `
+	WITH timeline AS (
+		SELECT
+			id,
+			'event' AS source,
+			type,
+			actor,
+			employee_id,
+			details,
+			created_at
+		FROM order_event
+		WHERE order_id = $1
+
+		UNION ALL
+
+		SELECT
+			id,
+			'status_change' AS source,
+			event AS type,
+			actor,
+			employee_id,
+			jsonb_build_object('from', LOWER(from_status::TEXT), 'to', LOWER(to_status::TEXT)) AS details,
+			changed_at AS created_at
+		FROM order_status_change
+		WHERE order_id = $1
+	)
+	SELECT
+		timeline.source,
+		timeline.type,
+		timeline.actor,
+		timeline.employee_id,
+		COALESCE(employee.first_name || ' ' || employee.last_name, '') AS employee,
+		timeline.details,
+		timeline.created_at
+	FROM timeline
+	LEFT JOIN employee
+		ON employee.id = timeline.employee_id
+	ORDER BY
+		timeline.created_at ASC,
+		timeline.source ASC,
+		timeline.id ASC
+	`
+
+	var rows []struct {
+		Source     string    `db:"source"`
+		Type       string    `db:"type"`
+		Actor      string    `db:"actor"`
+		EmployeeId *int64    `db:"employee_id"`
+		Employee   string    `db:"employee"`
+		Details    []byte    `db:"details"`
+		CreatedAt  time.Time `db:"created_at"`
+	}
+
+	if err := pdb.Db.Select(&rows, query, orderId); err != nil {
+		slog.Error("Failed to get order history", "error", err, "order_id", orderId)
+		return nil, ErrInternal
+	}
+
+	history := make([]order.HistoryEvent, 0, len(rows))
+	for _, row := range rows {
+		eventType := order.HistoryEventType(row.Type)
+		if row.Source == "status_change" {
+			eventType = order.Event(row.Type).HistoryType()
+		}
+
+		history = append(history, order.HistoryEvent{
+			Type:       eventType,
+			Actor:      row.Actor,
+			EmployeeId: row.EmployeeId,
+			Employee:   row.Employee,
+			Details:    row.Details,
+			CreatedAt:  row.CreatedAt,
+		})
+	}
+
+	return history, nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// refund.RefundRepo implementation ----------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetPendingRefunds() ([]refund.Refund, error) {
+	// Latest payment per order or reservation, depending on the column it's formatted with.
+	paymentQuery := `
+	SELECT DISTINCT ON (%[1]s)
+		%[1]s,
+		COALESCE(stripe_payment_intent_id, '') AS stripe_payment_intent_id,
+		COALESCE(payment_method::TEXT, '') AS payment_method
+	FROM payment
+	WHERE %[1]s IS NOT NULL
+	ORDER BY
+		%[1]s,
+		(status = 'COMPLETED') DESC,
+		updated_at DESC,
+		id DESC
+	`
+
+	orderQuery := fmt.Sprintf(`
+	SELECT
+		od.id AS id,
+		od.id AS order_id,
+		0 AS reservation_id,
+		'order' AS refund_type,
+		rd.reason AS reason,
+		od.created_at AS requested_at,
+		CAST(ROUND(odetail.total) AS BIGINT) AS amount_cents,
+		COALESCE(p.stripe_payment_intent_id, '') AS stripe_payment_intent_id,
+		COALESCE(p.payment_method::TEXT, '') AS payment_method
+	FROM order_data od
+	JOIN order_detail odetail
+		ON odetail.id = od.id
+	JOIN refund_data rd
+		ON rd.order_id = od.id
+	LEFT JOIN (%s) p
+		ON p.order_id = od.id
+	WHERE od.status = 'REFUND_PENDING'
+	`, fmt.Sprintf(paymentQuery, "order_id"))
+
+	reservationQuery := fmt.Sprintf(`
+	SELECT
+		a.id AS id,
+		0 AS order_id,
+		a.id AS reservation_id,
+		'reservation' AS refund_type,
+		rrd.reason AS reason,
+		COALESCE(ab.created_at, a.appointment_at, NOW()) AS requested_at,
+		CAST(ROUND(sl.price) AS BIGINT) AS amount_cents,
+		COALESCE(p.stripe_payment_intent_id, '') AS stripe_payment_intent_id,
+		COALESCE(p.payment_method::TEXT, '') AS payment_method
+	FROM appointment a
+	JOIN service_location sl
+		ON a.service_location_id = sl.id
+	LEFT JOIN (
+		SELECT appointment_id, MAX(created_at) AS created_at
+		FROM appointment_bill
+		GROUP BY appointment_id
+	) ab
+		ON ab.appointment_id = a.id
+	JOIN reservation_refund_data rrd
+		ON rrd.appointment_id = a.id
+	LEFT JOIN (%s) p
+		ON p.reservation_id = a.id
+	WHERE a.status = 'REFUND_PENDING'
+	`, fmt.Sprintf(paymentQuery, "reservation_id"))
+
+	combinedQuery := orderQuery + " UNION ALL " + reservationQuery + " ORDER BY requested_at DESC"
+
+	var rows []struct {
+		ID                    int64     `db:"id"`
+		OrderID               int64     `db:"order_id"`
+		ReservationID         int64     `db:"reservation_id"`
+		RefundType            string    `db:"refund_type"`
+		Reason                string    `db:"reason"`
+		RequestedAt           time.Time `db:"requested_at"`
+		AmountCents           int64     `db:"amount_cents"`
+		StripePaymentIntentID string    `db:"stripe_payment_intent_id"`
+		PaymentMethod         string    `db:"payment_method"`
+	}
+
+	if err := pdb.Db.Select(&rows, combinedQuery); err != nil {
+		slog.Error("Failed to get pending refunds", "error", err)
+		return nil, ErrInternal
+	}
+
+	refunds := make([]refund.Refund, 0, len(rows))
+	for _, row := range rows {
+		refundItem := refund.Refund{
+			ID:                    uint32(row.ID),
+			RefundType:            row.RefundType,
+			Amount:                float64(row.AmountCents) / 100.0,
+			AmountCents:           row.AmountCents,
+			Reason:                row.Reason,
+			Status:                refund.StatusPending,
+			RequestedAt:           row.RequestedAt,
+			StripePaymentIntentID: row.StripePaymentIntentID,
+			PaymentMethod:         strings.ToLower(row.PaymentMethod),
+		}
+
+		if row.OrderID > 0 {
+			refundItem.OrderID = uint32(row.OrderID)
+		}
+		if row.ReservationID > 0 {
+			refundItem.ReservationID = uint32(row.ReservationID)
+		}
+
+		refunds = append(refunds, refundItem)
+	}
+
+	return refunds, nil
+}
+
+func (pdb PostgresDb) GetRefundByID(id uint32) (*refund.Refund, error) {
+	// Latest payment per order or reservation, depending on the column it's formatted with.
+	paymentQuery := `
+	SELECT DISTINCT ON (%[1]s)
+		%[1]s,
+		COALESCE(stripe_payment_intent_id, '') AS stripe_payment_intent_id,
+		COALESCE(payment_method::TEXT, '') AS payment_method
+	FROM payment
+	WHERE %[1]s IS NOT NULL
+	ORDER BY
+		%[1]s,
+		(status = 'COMPLETED') DESC,
+		updated_at DESC,
+		id DESC
+	`
+
+	orderQuery := fmt.Sprintf(`
+	SELECT
+		od.id AS id,
+		od.id AS order_id,
+		0 AS reservation_id,
+		'order' AS refund_type,
+		rd.reason AS reason,
+		od.created_at AS requested_at,
+		CAST(ROUND(odetail.total) AS BIGINT) AS amount_cents,
+		COALESCE(p.stripe_payment_intent_id, '') AS stripe_payment_intent_id,
+		COALESCE(p.payment_method::TEXT, '') AS payment_method
+	FROM order_data od
+	JOIN order_detail odetail
+		ON odetail.id = od.id
+	JOIN refund_data rd
+		ON rd.order_id = od.id
+	LEFT JOIN (%s) p
+		ON p.order_id = od.id
+	WHERE od.id = $1
+		AND od.status = 'REFUND_PENDING'
+	LIMIT 1
+	`, fmt.Sprintf(paymentQuery, "order_id"))
+
+	reservationQuery := fmt.Sprintf(`
+	SELECT
+		a.id AS id,
+		0 AS order_id,
+		a.id AS reservation_id,
+		'reservation' AS refund_type,
+		rrd.reason AS reason,
+		COALESCE(ab.created_at, a.appointment_at, NOW()) AS requested_at,
+		CAST(ROUND(sl.price) AS BIGINT) AS amount_cents,
+		COALESCE(p.stripe_payment_intent_id, '') AS stripe_payment_intent_id,
+		COALESCE(p.payment_method::TEXT, '') AS payment_method
+	FROM appointment a
+	JOIN service_location sl
+		ON a.service_location_id = sl.id
+	LEFT JOIN (
+		SELECT appointment_id, MAX(created_at) AS created_at
+		FROM appointment_bill
+		GROUP BY appointment_id
+	) ab
+		ON ab.appointment_id = a.id
+	JOIN reservation_refund_data rrd
+		ON rrd.appointment_id = a.id
+	LEFT JOIN (%s) p
+		ON p.reservation_id = a.id
+	WHERE a.id = $1
+		AND a.status = 'REFUND_PENDING'
+	LIMIT 1
+	`, fmt.Sprintf(paymentQuery, "reservation_id"))
+
+	var row struct {
+		ID                    int64     `db:"id"`
+		OrderID               int64     `db:"order_id"`
+		ReservationID         int64     `db:"reservation_id"`
+		RefundType            string    `db:"refund_type"`
+		Reason                string    `db:"reason"`
+		RequestedAt           time.Time `db:"requested_at"`
+		AmountCents           int64     `db:"amount_cents"`
+		StripePaymentIntentID string    `db:"stripe_payment_intent_id"`
+		PaymentMethod         string    `db:"payment_method"`
+	}
+
+	err := pdb.Db.Get(&row, orderQuery, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = pdb.Db.Get(&row, reservationQuery, id)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, errors.New("refund not found")
+				}
+				slog.Error("Failed to get reservation refund by ID", "error", err, "id", id)
+				return nil, ErrInternal
+			}
+		} else {
+			slog.Error("Failed to get order refund by ID", "error", err, "order_id", id)
+			return nil, ErrInternal
+		}
+	}
+
+	refundItem := &refund.Refund{
+		ID:                    uint32(row.ID),
+		RefundType:            row.RefundType,
+		Amount:                float64(row.AmountCents) / 100.0,
+		AmountCents:           row.AmountCents,
+		Reason:                row.Reason,
+		Status:                refund.StatusPending,
+		RequestedAt:           row.RequestedAt,
+		StripePaymentIntentID: row.StripePaymentIntentID,
+		PaymentMethod:         strings.ToLower(row.PaymentMethod),
+	}
+
+	if row.OrderID > 0 {
+		refundItem.OrderID = uint32(row.OrderID)
+	}
+	if row.ReservationID > 0 {
+		refundItem.ReservationID = uint32(row.ReservationID)
+	}
+
+	return refundItem, nil
+}
+
+func (pdb PostgresDb) UpdateRefundStatus(id uint32, status refund.RefundStatus, stripeRefundID string, actor string) (*refund.Refund, error) {
+	refundRecord, err := pdb.GetRefundByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrInternal
+	}
+
+	rollback := func(err error) (*refund.Refund, error) {
+		_ = transaction.Rollback()
+		return nil, err
+	}
+
+	isOrder := refundRecord.RefundType == "order"
+
+	switch status {
+	case refund.StatusDisapproved:
+		{
+			if isOrder {
+				err := transitionOrder(transaction, int64(id), order.EventDenyRefund, actor, orderTransitionPayload{})
+				if err != nil {
+					return rollback(err)
+				}
+			} else {
+				const updateAppointment = `
+				UPDATE appointment
+				SET status = 'COMPLETED', version = version + 1
+				WHERE id = $1 AND status = 'REFUND_PENDING'
+				`
+				res, err := transaction.Exec(updateAppointment, id)
+				if err != nil {
+					slog.Error("Failed to update appointment status for disapproved refund", "error", err, "appointment_id", id)
+					return rollback(ErrInternal)
+				}
+				rows, err := res.RowsAffected()
+				if err != nil || rows != 1 {
+					slog.Error("Unexpected rows affected when disapproving reservation refund", "rows", rows, "error", err, "appointment_id", id)
+					return rollback(errors.New("refund not found"))
+				}
+
+				const deleteRefundData = `DELETE FROM reservation_refund_data WHERE appointment_id = $1`
+				if _, err := transaction.Exec(deleteRefundData, id); err != nil {
+					slog.Error("Failed to delete reservation_refund_data for disapproved refund", "error", err, "appointment_id", id)
+					return rollback(ErrInternal)
+				}
+			}
+		}
+	case refund.StatusCompleted:
+		{
+			if isOrder {
+				err := transitionOrder(transaction, int64(id), order.EventApproveRefund, actor, orderTransitionPayload{})
+				if err != nil {
+					return rollback(err)
+				}
+			} else {
+				const updateAppointment = `
+				UPDATE appointment
+				SET status = 'REFUNDED', version = version + 1
+				WHERE id = $1 AND status = 'REFUND_PENDING'
+				`
+				res, err := transaction.Exec(updateAppointment, id)
+				if err != nil {
+					slog.Error("Failed to update appointment status for completed refund", "error", err, "appointment_id", id)
+					return rollback(ErrInternal)
+				}
+				rows, err := res.RowsAffected()
+				if err != nil || rows != 1 {
+					slog.Error("Unexpected rows affected when completing reservation refund", "rows", rows, "error", err, "appointment_id", id)
+					return rollback(errors.New("refund not found"))
+				}
+
+				const deleteRefundData = `DELETE FROM reservation_refund_data WHERE appointment_id = $1`
+				if _, err := transaction.Exec(deleteRefundData, id); err != nil {
+					slog.Error("Failed to delete reservation_refund_data for completed refund", "error", err, "appointment_id", id)
+					return rollback(ErrInternal)
+				}
+			}
+		}
+	default:
+		{
+			if isOrder {
+				const ensurePending = `
+				SELECT id
+				FROM order_data
+				WHERE id = $1 AND status = 'REFUND_PENDING'
+				LIMIT 1
+				`
+				var orderID int64
+				if err := transaction.Get(&orderID, ensurePending, id); err != nil {
+					if errors.Is(err, sql.ErrNoRows) {
+						return rollback(errors.New("refund not found"))
+					}
+					slog.Error("Failed to verify refund status update", "error", err, "order_id", id)
+					return rollback(ErrInternal)
+				}
+			} else {
+				const ensurePending = `
+				SELECT id
+				FROM appointment
+				WHERE id = $1 AND status = 'REFUND_PENDING'
+				LIMIT 1
+				`
+				var appointmentID int64
+				if err := transaction.Get(&appointmentID, ensurePending, id); err != nil {
+					if errors.Is(err, sql.ErrNoRows) {
+						return rollback(errors.New("refund not found"))
+					}
+					slog.Error("Failed to verify reservation refund status update", "error", err, "appointment_id", id)
+					return rollback(ErrInternal)
+				}
+			}
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error("Failed to commit refund status update", "error", err, "id", id)
+		return nil, ErrInternal
+	}
+
+	result := &refund.Refund{
+		ID:             id,
+		RefundType:     refundRecord.RefundType,
+		Status:         status,
+		StripeRefundID: stripeRefundID,
+	}
+
+	if isOrder {
+		result.OrderID = id
+	} else {
+		result.ReservationID = id
+	}
+
+	return result, nil
+}
+
+func (pdb PostgresDb) GetOrderItems(orderId int64) ([]order.Item, error) {
+	return getOrderItems(pdb.Db, orderId)
+}
+
+func getOrderItems(queryer sqlx.Queryer, orderId int64) ([]order.Item, error) {
+	const query = `
+	SELECT
+		id,
+		item_id,
+		quantity,
+		note,
+		sent_at,
+		LOWER(prep_status::TEXT) AS prep_status,
+		pricing_rule_id,
+		pricing_rule_name,
+		LOWER(price_adjustment_type::TEXT) AS price_adjustment_type,
+		price_adjustment
+	FROM order_item
+	WHERE order_id = $1
+	`
+	var itemsDetails []struct {
+		Id                  int64                 `db:"id"`
+		ItemId              int64                 `db:"item_id"`
+		Quantity            order.Quantity        `db:"quantity"`
+		Note                string                `db:"note"`
+		SentAt              *time.Time            `db:"sent_at"`
+		PrepStatus          string                `db:"prep_status"`
+		PricingRuleId       *int64                `db:"pricing_rule_id"`
+		PricingRuleName     *string               `db:"pricing_rule_name"`
+		PriceAdjustmentType *order.AdjustmentType `db:"price_adjustment_type"`
+		PriceAdjustment     *int64                `db:"price_adjustment"`
+	}
+
+	err := sqlx.Select(queryer, &itemsDetails, query, orderId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []order.Item{}, ErrInternal
+	}
+
+	items := make([]order.Item, len(itemsDetails))
+	for i := range itemsDetails {
+		items[i].Id = itemsDetails[i].Id
+		items[i].Quantity = itemsDetails[i].Quantity
+		items[i].Note = &itemsDetails[i].Note
+		items[i].SentAt = itemsDetails[i].SentAt
+		items[i].PrepStatus = itemsDetails[i].PrepStatus
+		if itemsDetails[i].PricingRuleName != nil {
+			items[i].PricingRule = &order.AppliedPricingRule{
+				Id:             itemsDetails[i].PricingRuleId,
+				Name:           *itemsDetails[i].PricingRuleName,
+				AdjustmentType: *itemsDetails[i].PriceAdjustmentType,
+				Adjustment:     *itemsDetails[i].PriceAdjustment,
+			}
+		}
+		items[i].SelectedVariations = []order.Variation{}
+		items[i].Product.Variations = []order.Variation{}
+		items[i].Product.Categories = []string{}
+
+		// order_item_detail has the prices the lines were added with
+		const productQuery = `
+		SELECT
+			item_id AS id,
+			item_name AS name,
+			price_per_unit,
+			(vat * 100)::BIGINT AS vat,
+			LOWER(unit::TEXT) AS unit
+		FROM order_item_detail
+		WHERE order_item_id = $1
+		LIMIT 1
+		`
+
+		err = sqlx.Get(queryer, &items[i].Product, productQuery, itemsDetails[i].Id)
+		if err != nil {
+			slog.Error(err.Error())
+			return []order.Item{}, ErrInternal
+		}
+
+		const selectedVariationQuery = `
+		SELECT
+			variation_id AS id,
+			variation_name AS name,
+			price_difference
+		FROM order_item_detail
+		WHERE
+			order_item_id = $1
+			AND variation_id IS NOT NULL
+		`
+
+		err = sqlx.Select(queryer, &items[i].SelectedVariations, selectedVariationQuery, itemsDetails[i].Id)
+		if err != nil {
+			slog.Error(err.Error())
+			return []order.Item{}, ErrInternal
+		}
+
+		items[i].Modifiers, err = getOrderItemModifiers(queryer, itemsDetails[i].Id)
+		if err != nil {
+			return []order.Item{}, err
+		}
+
+		items[i].Components, err = getOrderItemComponents(queryer, itemsDetails[i].Id)
+		if err != nil {
+			return []order.Item{}, err
+		}
+	}
+
+	return items, nil
+}
+
+// queryer is either the DB or the transaction the line belongs to.
+func getOrderItemModifiers(queryer sqlx.Queryer, orderItemId int64) ([]order.Modifier, error) {
+	const query = `
+	SELECT group_name, name, price_difference
+	FROM order_item_modifier
+	WHERE order_item_id = $1
+	ORDER BY id
+	`
+
+	modifiers := []order.Modifier{}
+	err := sqlx.Select(queryer, &modifiers, query, orderItemId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []order.Modifier{}, ErrInternal
+	}
+
+	return modifiers, nil
+}
+
+// Sorted ids of the variations, for comparing selections.
+func selectedVariationIds(variations []order.Variation) []int64 {
+	ids := make([]int64, 0, len(variations))
+	for _, variation := range variations {
+		ids = append(ids, variation.Id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Components are the same choice if they fill the same slot with the same product.
+func sameComponent(a order.Component, b order.Component) bool {
+	return a.ProductId == b.ProductId && a.SlotId != nil && b.SlotId != nil && *a.SlotId == *b.SlotId
+}
+
+// queryer is either the DB or the transaction the line belongs to.
+// Allocated prices are the share of each component in the unit price of the line.
+func getOrderItemComponents(queryer sqlx.Queryer, orderItemId int64) ([]order.Component, error) {
+	const query = `
+	SELECT
+		allocation.slot_id,
+		allocation.slot_name,
+		allocation.item_id,
+		allocation.item_name,
+		allocation.upcharge::BIGINT AS upcharge,
+		(allocation.vat * 100)::BIGINT AS vat,
+		ROUND(line.gross * allocation.share)::BIGINT AS allocated_price
+	FROM order_item_component_allocation allocation
+	JOIN order_item_total line
+		ON line.order_item_id = allocation.order_item_id
+	WHERE allocation.order_item_id = $1
+	ORDER BY allocation.component_id
+	`
+
+	components := []order.Component{}
+	err := sqlx.Select(queryer, &components, query, orderItemId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []order.Component{}, ErrInternal
+	}
+
+	return components, nil
+}
+
+// Checks that the components fill every slot of the product with one of the slot's options.
+// Products without slots take no components, unknown products are left for the insert to fail.
+// Errors name the slot that isn't filled right.
+func checkItemComponents(queryer sqlx.Queryer, productId int64, components []order.Component) error {
+	const query = `
+	SELECT id, name
+	FROM bundle_slot
+	WHERE bundle_id = $1
+	ORDER BY position, id
+	`
+	const optionQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM bundle_slot_option
+		JOIN item
+			ON item.id = bundle_slot_option.item_id
+			AND item.status = 'ACTIVE'
+		WHERE
+			bundle_slot_option.slot_id = $1
+			AND bundle_slot_option.item_id = $2
+	)
+	`
+
+	var slots []struct {
+		Id   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	if err := sqlx.Select(queryer, &slots, query, productId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	chosen := map[int64]int64{}
+	for _, component := range components {
+		if component.SlotId == nil {
+			return order.ErrInvalidComponents
+		}
+		if _, ok := chosen[*component.SlotId]; ok {
+			return fmt.Errorf("%w: slot %d is chosen more than once", order.ErrInvalidComponents, *component.SlotId)
+		}
+		chosen[*component.SlotId] = component.ProductId
+	}
+
+	for _, slot := range slots {
+		productId, ok := chosen[slot.Id]
+		if !ok {
+			return fmt.Errorf("%w: choose one of %q", order.ErrInvalidComponents, slot.Name)
+		}
+		delete(chosen, slot.Id)
+
+		var exists bool
+		if err := sqlx.Get(queryer, &exists, optionQuery, slot.Id, productId); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if !exists {
+			return fmt.Errorf("%w: product %d is not an option of %q", order.ErrInvalidComponents, productId, slot.Name)
+		}
+	}
+	for slotId := range chosen {
+		return fmt.Errorf("%w: slot %d is not a slot of the product", order.ErrInvalidComponents, slotId)
+	}
+
+	return nil
+}
+
+// Replaces the components of the line, names and prices are filled in by snapshot_order_item_component.
+func setOrderItemComponents(transaction *sqlx.Tx, orderItemId int64, components []order.Component) error {
+	const nukeComponentsStatement = `
+	DELETE FROM order_item_component
+	WHERE order_item_id = $1
+	`
+	_, err := transaction.Exec(nukeComponentsStatement, orderItemId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	for _, component := range components {
+		const insertComponentStatement = `
+		INSERT INTO order_item_component (order_item_id, slot_id, item_id)
+			VALUES ($1, $2, $3)
+		`
+		_, err = transaction.Exec(insertComponentStatement, orderItemId, component.SlotId, component.ProductId)
+		if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+	}
+
+	return nil
+}
+
+func getVariationGroups(queryer sqlx.Queryer, productId int64) ([]order.VariationGroup, error) {
+	const query = `
+	SELECT
+		id,
+		name,
+		min_selected > 0 AS required,
+		min_selected,
+		max_selected,
+		position
+	FROM variation_group
+	WHERE item_id = $1
+	ORDER BY
+		position,
+		name
+	`
+
+	groups := []order.VariationGroup{}
+	err := sqlx.Select(queryer, &groups, query, productId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []order.VariationGroup{}, ErrInternal
+	}
+
+	return groups, nil
+}
+
+// Checks the selected variations against the variation groups of the product and returns the selection.
+// With defaults, groups that have nothing selected get their active default variations first.
+// Archived variations are only accepted if they are in kept, the variations the line already has.
+// Unknown products are left for the insert to fail.
+func checkItemVariations(queryer sqlx.Queryer, productId int64, selected []order.Variation, kept []int64, withDefaults bool) ([]order.Variation, error) {
+	groups, err := getVariationGroups(queryer, productId)
+	if err != nil {
+		return []order.Variation{}, err
+	}
+
+	// Archived variations are included, existing lines may still have them
+	const query = `
+	SELECT
+		id,
+		name,
+		price_difference,
+		group_id,
+		is_default,
+		status = 'ACTIVE' AS active
+	FROM item_variation
+	WHERE item_id = $1
+	ORDER BY id
+	`
+
+	var variations []struct {
+		order.Variation
+		Active bool `db:"active"`
+	}
+	err = sqlx.Select(queryer, &variations, query, productId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []order.Variation{}, ErrInternal
+	}
+
+	indexes := make(map[int64]int, len(variations))
+	for i, variation := range variations {
+		indexes[variation.Id] = i
+	}
+
+	groupIds := make([]*int64, 0, len(selected))
+	selectedGroups := map[int64]bool{}
+	seen := map[int64]bool{}
+	for _, variation := range selected {
+		i, ok := indexes[variation.Id]
+		if !ok {
+			return []order.Variation{}, fmt.Errorf("%w: variation %d is not a variation of the product", order.ErrInvalidVariations, variation.Id)
+		}
+		if seen[variation.Id] {
+			return []order.Variation{}, fmt.Errorf("%w: %q is selected more than once", order.ErrInvalidVariations, variations[i].Name)
+		}
+		if !variations[i].Active && !slices.Contains(kept, variation.Id) {
+			return []order.Variation{}, fmt.Errorf("%w: %q is no longer available", order.ErrInvalidVariations, variations[i].Name)
+		}
+		seen[variation.Id] = true
+
+		groupIds = append(groupIds, variations[i].GroupId)
+		if variations[i].GroupId != nil {
+			selectedGroups[*variations[i].GroupId] = true
+		}
+	}
+
+	if withDefaults {
+		selected = slices.Clone(selected)
+		for _, variation := range variations {
+			if variation.Default && variation.Active && variation.GroupId != nil && !selectedGroups[*variation.GroupId] {
+				selected = append(selected, variation.Variation)
+				groupIds = append(groupIds, variation.GroupId)
+			}
+		}
+	}
+
+	if err := order.CheckVariationSelection(groups, groupIds); err != nil {
+		return []order.Variation{}, err
+	}
+
+	return selected, nil
+}
+
+// Checks that every quantity can be sold in the unit of its product.
+// Unknown products are skipped, they fail when the line is inserted.
+func checkItemQuantities(queryer sqlx.Queryer, items []order.Item) error {
+	const query = `
+	SELECT LOWER(unit::TEXT)
+	FROM item
+	WHERE id = $1
+	`
+
+	for _, item := range items {
+		var unit order.Unit
+		err := sqlx.Get(queryer, &unit, query, item.Product.Id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+
+		if !unit.Allows(item.Quantity) {
+			return order.ErrInvalidQuantity
+		}
+	}
+
+	return nil
+}
+
+// The product has to be of the order's business and available at its location.
+// Orders without a location take the business of the employee who created them.
+func checkItemAvailable(queryer sqlx.Queryer, orderId int64, productId int64) error {
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM order_data
+		JOIN employee
+			ON employee.id = order_data.employee_id
+		LEFT JOIN location
+			ON location.id = order_data.location_id
+		JOIN item
+			ON item.id = $2
+			AND item.business_id = COALESCE(location.business_id, employee.business_id)
+		WHERE
+			order_data.id = $1
+			AND (
+				order_data.location_id IS NULL
+				OR EXISTS (
+					SELECT 1
+					FROM item_location
+					WHERE
+						item_location.item_id = item.id
+						AND item_location.location_id = order_data.location_id
+						AND item_location.available
+				)
+			)
+	)
+	`
+
+	var available bool
+	if err := sqlx.Get(queryer, &available, query, orderId, productId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if !available {
+		return order.ErrNotAvailable
+	}
+
+	return nil
+}
+
+// The price list has to belong to the business of the order's location.
+func checkPriceList(queryer sqlx.Queryer, locationId *int64, priceListId int64) error {
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM price_list
+		JOIN location
+			ON location.business_id = price_list.business_id
+		WHERE
+			price_list.id = $1
+			AND location.id = $2
+	)
+	`
+
+	var exists bool
+	if err := sqlx.Get(queryer, &exists, query, priceListId, locationId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if !exists {
+		return order.ErrPriceListNotFound
+	}
+
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// order.ProductRepo implimentation ----------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetProducts(filter order.ProductFilter) ([]order.Product, error) {
+	var filteredProducts []order.Product
+	{
+		const query = `
+		SELECT 
+			item.id,
+			item.name,
+			item_price(item.id, item_location.location_id, $4) AS price_per_unit,
+			(vat * 100)::BIGINT AS vat,
+			LOWER(unit::TEXT) AS unit,
+			COALESCE(sku, '') AS sku
+		FROM item
+		JOIN item_location
+			ON item_location.item_id = item.id
+			AND item_location.location_id = $1
+			AND item_location.available
+		WHERE 
+			item.status = 'ACTIVE'
+			AND ($2::text IS NULL OR EXISTS (
+				SELECT 1
+				FROM item_category
+				JOIN category
+					ON category.id = item_category.category_id
+				WHERE
+					item_category.item_id = item.id
+					AND category.name = $2::text
+			))
+			AND ($3::text IS NULL OR item.sku = $3::text)
+		ORDER BY
+			item.name ASC
+		`
+
+		err := pdb.Db.Select(&filteredProducts, query, filter.LocationId, filter.Category, filter.Sku, filter.PriceListId)
+		if err != nil {
+			slog.Error(err.Error())
+			return []order.Product{}, ErrInternal
+		}
+	}
+
+	for i := range filteredProducts {
+		if err := getProductDetails(pdb.Db, &filteredProducts[i]); err != nil {
+			return []order.Product{}, err
+		}
+	}
+
+	return filteredProducts, nil
+}
+
+// Fills in the categories, active variations and their groups, VAT rates, image and bundle slots of the product.
+func getProductDetails(queryer sqlx.Queryer, p *order.Product) error {
+	{
+		const query = `
+		SELECT category.name
+		FROM item_category
+		JOIN category
+			ON item_category.item_id = $1
+			AND category.id = category_id
+		`
+
+		err := sqlx.Select(queryer, &p.Categories, query, p.Id)
+		if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+	}
+	{
+		const query = `
+		SELECT id, name, price_difference, group_id, is_default
+		FROM item_variation
+		WHERE
+			item_id = $1
+			AND status = 'ACTIVE'
+		`
+
+		err := sqlx.Select(queryer, &p.Variations, query, p.Id)
+		if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+	}
+	{
+		groups, err := getVariationGroups(queryer, p.Id)
+		if err != nil {
+			return err
+		}
+		p.VariationGroups = groups
+	}
+	{
+		const query = `
+		SELECT LOWER(fulfillment_type::TEXT) AS fulfillment_type, (vat * 100)::BIGINT AS vat
+		FROM item_vat
+		WHERE item_id = $1
+		`
+
+		var rates []struct {
+			FulfillmentType order.FulfillmentType `db:"fulfillment_type"`
+			Vat             int64                 `db:"vat"`
+		}
+		err := sqlx.Select(queryer, &rates, query, p.Id)
+		if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+
+		p.VatRates = map[order.FulfillmentType]int64{}
+		for _, rate := range rates {
+			p.VatRates[rate.FulfillmentType] = rate.Vat
+		}
+	}
+	{
+		const query = `
+		SELECT image_url, thumbnail_url
+		FROM item_image
+		WHERE item_id = $1
+		`
+
+		var image struct {
+			ImageUrl     string `db:"image_url"`
+			ThumbnailUrl string `db:"thumbnail_url"`
+		}
+		err := sqlx.Get(queryer, &image, query, p.Id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if err == nil {
+			p.ImageUrl, p.ThumbnailUrl = &image.ImageUrl, &image.ThumbnailUrl
+		}
+	}
+
+	slots, err := getBundleSlots(queryer, p.Id)
+	if err != nil {
+		return err
+	}
+	p.Slots = slots
+
+	return nil
+}
+
+func (pdb PostgresDb) GetCategories(locationId int64) ([]string, error) {
+	categories := []string{}
+
+	query := `
+	SELECT DISTINCT(category.name)
+	FROM item_location
+	JOIN item_category
+		ON item_category.item_id = item_location.item_id
+	JOIN category
+		ON category.id = item_category.category_id
+	WHERE
+		item_location.location_id = $1
+		AND item_location.available
+	ORDER BY category.name
+	`
+
+	err := pdb.Db.Select(&categories, query, locationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []string{}, ErrInternal
+	}
+
+	return categories, nil
+}
+
+func (pdb PostgresDb) GetDefaultVat(locationId int64) (int64, error) {
+	var vat int64
+	query := `
+	SELECT (country.vat * 100)::BIGINT AS vat
+	FROM location
+	JOIN country
+	ON country.code = location.country_code
+	WHERE location.id = $1
+	LIMIT 1
+	`
+	err := pdb.Db.Get(&vat, query, locationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return vat, nil
+}
+
+func (pdb PostgresDb) SetVat(locationId int64, itemId int64, newVat int64, effectiveDate *time.Time) error {
+	if effectiveDate != nil {
+		return scheduleVatChange(pdb.Db, itemId, product.PriceChangeUpdate{
+			LocationId:    locationId,
+			EffectiveDate: *effectiveDate,
+			Vat:           &newVat,
+		})
+	}
+
+	setVatStatement := `
+	UPDATE item
+	SET vat = ($3::DECIMAL(6, 2) * 0.01::DECIMAL(6, 2))::DECIMAL(4, 2)
+	WHERE
+		id = $2
+		AND business_id = (SELECT business_id FROM location WHERE id = $1)
+	`
+	err := pdb.Db.QueryRow(setVatStatement, locationId, itemId, newVat).Err()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) SetFulfillmentVat(locationId int64, itemId int64, fulfillment order.FulfillmentType, newVat *int64, effectiveDate *time.Time) error {
+	if effectiveDate != nil {
+		return scheduleVatChange(pdb.Db, itemId, product.PriceChangeUpdate{
+			LocationId:      locationId,
+			EffectiveDate:   *effectiveDate,
+			Vat:             newVat,
+			FulfillmentType: &fulfillment,
+		})
+	}
+
+	if newVat == nil {
+		const statement = `
+		DELETE FROM item_vat
+		USING item
+		WHERE
+			item_vat.item_id = item.id
+			AND item.id = $2
+			AND item.business_id = (SELECT business_id FROM location WHERE id = $1)
+			AND item_vat.fulfillment_type = $3::fulfillment_type
+		`
+		_, err := pdb.Db.Exec(statement, locationId, itemId, fulfillment.DbValue())
+		if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		return nil
+	}
+
+	const statement = `
+	INSERT INTO item_vat (item_id, fulfillment_type, vat)
+		SELECT id, $3::fulfillment_type, ($4::DECIMAL(6, 2) * 0.01::DECIMAL(6, 2))::DECIMAL(4, 2)
+		FROM item
+		WHERE
+			id = $2
+			AND business_id = (SELECT business_id FROM location WHERE id = $1)
+	ON CONFLICT (item_id, fulfillment_type) DO UPDATE
+		SET vat = EXCLUDED.vat
+	`
+	_, err := pdb.Db.Exec(statement, locationId, itemId, fulfillment.DbValue(), *newVat)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+// Scheduled VAT changes are price changes of the catalog, only the date error is reported separately.
+func scheduleVatChange(db *sqlx.DB, itemId int64, change product.PriceChangeUpdate) error {
+	_, err := schedulePriceChange(db, itemId, change)
+	if errors.Is(err, product.ErrEffectiveDatePast) {
+		return order.ErrEffectiveDatePast
+	} else if err != nil {
+		return ErrInternal
+	}
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// reservation.ReservationRepo implementation ------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetReservations(filter reservation.ReservationFilter) ([]reservation.Reservation, error) {
+	var statusFilter *string
+	if filter.Status != nil {
+		mapped := mapApiReservationStatusToAppointment(*filter.Status)
+		if mapped == "" {
+			return []reservation.Reservation{}, nil
+		}
+		statusFilter = &mapped
+	}
+
+	search := ""
+	if filter.Search != nil {
+		search = strings.TrimSpace(*filter.Search)
+	}
+
+	const query = `
+	SELECT 
+		a.id,
+		a.customer_name,
+		a.customer_phone,
+		a.actioned_by,
+		a.appointment_at,
+		a.status,
+		a.version,
+		sl.service_id
+	FROM appointment a
+	JOIN service_location sl
+		ON a.service_location_id = sl.id
+	LEFT JOIN service s
+		ON sl.service_id = s.id
+	LEFT JOIN employee e
+		ON a.actioned_by = e.id
+	WHERE 
+		($1::appointment_status IS NULL OR a.status = $1::appointment_status)
+		AND ($2::timestamp IS NULL OR a.appointment_at >= $2::timestamp)
+		AND ($3::timestamp IS NULL OR a.appointment_at <= $3::timestamp)
+		AND (
+			$4 = '' OR
+			a.customer_name ILIKE '%' || $4 || '%' OR
+			a.customer_phone ILIKE '%' || $4 || '%' OR
+			s.name ILIKE '%' || $4 || '%' OR
+			e.first_name ILIKE '%' || $4 || '%' OR
+			e.last_name ILIKE '%' || $4 || '%' OR
+			CAST(a.id AS TEXT) ILIKE '%' || $4 || '%'
+		)
+	ORDER BY
+		a.id DESC
+	`
+
+	rows := []struct {
+		Id            int32     `db:"id"`
+		CustomerName  string    `db:"customer_name"`
+		CustomerPhone string    `db:"customer_phone"`
+		StaffId       int32     `db:"actioned_by"`
+		ServiceId     int32     `db:"service_id"`
+		Datetime      time.Time `db:"appointment_at"`
+		Status        string    `db:"status"`
+		Version       int64     `db:"version"`
+	}{}
+
+	err := pdb.Db.Select(&rows, query, statusFilter, filter.From, filter.To, search)
+	if err != nil {
+		slog.Error(err.Error())
+		return []reservation.Reservation{}, ErrInternal
+	}
+
+	reservations := make([]reservation.Reservation, 0, len(rows))
+	for _, row := range rows {
+		reservations = append(reservations, reservation.Reservation{
+			Id:            strconv.FormatInt(int64(row.Id), 10),
+			CustomerName:  row.CustomerName,
+			CustomerPhone: row.CustomerPhone,
+			StaffId:       strconv.FormatInt(int64(row.StaffId), 10),
+			ServiceId:     strconv.FormatInt(int64(row.ServiceId), 10),
+			Datetime:      row.Datetime,
+			Status:        mapAppointmentStatusToApi(row.Status),
+			Version:       row.Version,
+		})
+	}
+
+	return reservations, nil
+}
+
+func (pdb PostgresDb) GetReservationCounts(filter reservation.ReservationFilter) (reservation.ReservationCounts, error) {
+	const query = `
+	SELECT 
+		status,
+		COUNT(*) AS count
+	FROM appointment
+	WHERE 
+		($1::timestamp IS NULL OR appointment_at >= $1::timestamp)
+		AND ($2::timestamp IS NULL OR appointment_at <= $2::timestamp)
+	GROUP BY status
+	`
+
+	rows := []struct {
+		Status string `db:"status"`
+		Count  int    `db:"count"`
+	}{}
+
+	err := pdb.Db.Select(&rows, query, filter.From, filter.To)
+	if err != nil {
+		slog.Error(err.Error())
+		return reservation.ReservationCounts{}, ErrInternal
+	}
+
+	counts := reservation.ReservationCounts{}
+	for _, row := range rows {
+		counts.All += row.Count
+		switch mapAppointmentStatusToApi(row.Status) {
+		case "pending":
+			counts.Pending += row.Count
+		case "confirmed":
+			counts.Confirmed += row.Count
+		case "completed":
+			counts.Completed += row.Count
+		case "cancelled":
+			counts.Cancelled += row.Count
+		case "refund_pending":
+			counts.RefundPending += row.Count
+		}
+	}
+
+	return counts, nil
+}
+
+func (pdb PostgresDb) GetReservationItems(reservationId int32) ([]reservation.Service, error) {
+	return getReservationItems(pdb.Db, reservationId)
+}
+
+// queryer is either the DB or a transaction. Unknown reservations have no items.
+func getReservationItems(queryer sqlx.Queryer, reservationId int32) ([]reservation.Service, error) {
+	const query = `
+	SELECT 
+		s.id          AS service_id,
+		s.name        AS service_name,
+		s.duration_mins,
+		sl.price      AS price
+	FROM appointment a
+	JOIN service_location sl
+		ON a.service_location_id = sl.id
+	JOIN service s
+		ON sl.service_id = s.id
+	WHERE a.id = $1
+	LIMIT 1
+	`
+
+	var row struct {
+		ServiceId    int32  `db:"service_id"`
+		ServiceName  string `db:"service_name"`
+		DurationMins int    `db:"duration_mins"`
+		Price        int64  `db:"price"`
+	}
+
+	err := sqlx.Get(queryer, &row, query, reservationId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return []reservation.Service{}, nil
+		}
+		slog.Error(err.Error())
+		return []reservation.Service{}, ErrInternal
+	}
+
+	return []reservation.Service{
 		{
-			if isOrder {
-				const updateOrder = `
-				UPDATE order_data
-				SET status = 'CLOSED'
-				WHERE id = $1 AND status = 'REFUND_PENDING'
+			Id:       strconv.FormatInt(int64(row.ServiceId), 10),
+			Name:     row.ServiceName,
+			NameKey:  row.ServiceName,
+			Duration: row.DurationMins,
+			Price:    int(row.Price),
+		},
+	}, nil
+}
+
+func (pdb PostgresDb) GetReservationItemsWithVersion(reservationId int32) ([]reservation.Service, int64, error) {
+	// Both reads see the same snapshot, so the version matches the items
+	transaction, err := pdb.Db.BeginTxx(context.Background(), &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, 0, ErrInternal
+	}
+	defer func() { _ = transaction.Rollback() }()
+
+	const query = `
+	SELECT version
+	FROM appointment
+	WHERE id = $1
+	`
+
+	var version int64
+	err = transaction.Get(&version, query, reservationId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, reservation.ErrReservationNotFound
+		}
+		slog.Error(err.Error())
+		return nil, 0, ErrInternal
+	}
+
+	items, err := getReservationItems(transaction, reservationId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return items, version, nil
+}
+
+func (pdb PostgresDb) CreateReservation(res reservation.Reservation) (int32, error) {
+	serviceId, err := strconv.ParseInt(res.ServiceId, 10, 32)
+	if err != nil {
+		return 0, ErrInternal
+	}
+
+	// Pick a service_location for the given service (cheapest ID).
+	var serviceLocationId int32
+	{
+		const query = `
+		SELECT id
+		FROM service_location
+		WHERE service_id = $1
+		ORDER BY id
+		LIMIT 1
+		`
+		err := pdb.Db.Get(&serviceLocationId, query, serviceId)
+		if err != nil {
+			slog.Error(err.Error())
+			return 0, ErrInternal
+		}
+	}
+
+	// Resolve staff/employee; fallback to any employee linked to the service.
+	var actionedBy int32
+	staffIdStr := strings.TrimSpace(res.StaffId)
+	if staffIdStr != "" && !strings.EqualFold(staffIdStr, "anyone") {
+		if staffId, err := strconv.ParseInt(staffIdStr, 10, 32); err == nil {
+			actionedBy = int32(staffId)
+		} else {
+			return 0, ErrInternal
+		}
+	} else {
+		const query = `
+		SELECT employee_id
+		FROM service_employee
+		WHERE service_location_id = $1
+		ORDER BY employee_id
+		LIMIT 1
+		`
+		err := pdb.Db.Get(&actionedBy, query, serviceLocationId)
+		if err != nil {
+			slog.Error(err.Error())
+			return 0, ErrInternal
+		}
+	}
+
+	status := mapApiReservationStatusToAppointment(res.Status)
+	if status == "" {
+		status = "PENDING"
+	}
+
+	const insertQuery = `
+	INSERT INTO appointment (
+		id,
+		service_location_id,
+		actioned_by,
+		customer_name,
+		customer_phone,
+		appointment_at,
+		status
+	)
+	SELECT
+		COALESCE(MAX(id), 0) + 1,
+		$1, $2, $3, $4, $5, $6
+	FROM appointment
+	RETURNING id
+	`
+
+	var newId int32
+	err = pdb.Db.Get(&newId, insertQuery,
+		serviceLocationId,
+		actionedBy,
+		res.CustomerName,
+		res.CustomerPhone,
+		res.Datetime,
+		status,
+	)
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return newId, nil
+}
+
+// Updates a reservation and returns its new version.
+// If version is not nil, the reservation is updated only if it's still at that version.
+func (pdb PostgresDb) UpdateReservation(id int32, version *int64, res reservation.ReservationUpdate) (int64, error) {
+	var serviceLocationId *int32
+	if res.ServiceId != nil {
+		serviceId, err := strconv.ParseInt(strings.TrimSpace(*res.ServiceId), 10, 32)
+		if err != nil {
+			return 0, ErrInternal
+		}
+
+		const query = `
+		SELECT id
+		FROM service_location
+		WHERE service_id = $1
+		ORDER BY id
+		LIMIT 1
+		`
+		var slId int32
+		err = pdb.Db.Get(&slId, query, int32(serviceId))
+		if err != nil {
+			slog.Error(err.Error())
+			return 0, ErrInternal
+		}
+		serviceLocationId = &slId
+	}
+
+	var actionedBy *int32
+	if res.StaffId != nil {
+		staffIdStr := strings.TrimSpace(*res.StaffId)
+		if staffIdStr != "" && !strings.EqualFold(staffIdStr, "anyone") {
+			staffId, err := strconv.ParseInt(staffIdStr, 10, 32)
+			if err != nil {
+				return 0, ErrInternal
+			}
+			empId := int32(staffId)
+			actionedBy = &empId
+		} else {
+			// If "anyone" (or blank) is requested, pick an employee that can perform the current/new service.
+			finalServiceLocationId := int32(0)
+			if serviceLocationId != nil {
+				finalServiceLocationId = *serviceLocationId
+			} else {
+				const query = `
+				SELECT service_location_id
+				FROM appointment
+				WHERE id = $1
+				LIMIT 1
 				`
-				res, err := transaction.Exec(updateOrder, id)
-				if err != nil {
-					slog.Error("Failed to update order status for disapproved refund", "error", err, "order_id", id)
-					return rollback(ErrInternal)
-				}
-				rows, err := res.RowsAffected()
-				if err != nil || rows != 1 {
-					slog.Error("Unexpected rows affected when disapproving refund", "rows", rows, "error", err, "order_id", id)
-					return rollback(errors.New("refund not found"))
+				if err := pdb.Db.Get(&finalServiceLocationId, query, id); err != nil {
+					slog.Error(err.Error())
+					return 0, ErrInternal
 				}
+			}
+
+			const pickQuery = `
+			SELECT employee_id
+			FROM service_employee
+			WHERE service_location_id = $1
+			ORDER BY employee_id
+			LIMIT 1
+			`
+			var empId int32
+			if err := pdb.Db.Get(&empId, pickQuery, finalServiceLocationId); err != nil {
+				slog.Error(err.Error())
+				return 0, ErrInternal
+			}
+			actionedBy = &empId
+		}
+	}
+
+	var status *string
+	if res.Status != nil {
+		mapped := mapApiReservationStatusToAppointment(*res.Status)
+		if mapped == "" {
+			return 0, ErrInternal
+		}
+		status = &mapped
+	}
+
+	query := `
+	UPDATE appointment
+	SET
+		service_location_id = COALESCE($2, service_location_id),
+		actioned_by         = COALESCE($3, actioned_by),
+		customer_name       = COALESCE($4, customer_name),
+		customer_phone      = COALESCE($5, customer_phone),
+		appointment_at      = COALESCE($6, appointment_at),
+		status 		        = COALESCE($7::appointment_status, status),
+		version             = version + 1
+	WHERE
+		id = $1
+		AND ($8::integer IS NULL OR version = $8::integer)
+	RETURNING version
+	`
+
+	newVersion := int64(0)
+	err := pdb.Db.Get(&newVersion, query,
+		id,
+		serviceLocationId,
+		actionedBy,
+		res.CustomerName,
+		res.CustomerPhone,
+		res.Datetime,
+		status,
+		version,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either there is no such reservation or it was modified in the meantime.
+		if _, err := pdb.GetReservation(id); err != nil {
+			return 0, err
+		}
+		return 0, reservation.ErrVersionMismatch
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return newVersion, nil
+}
+
+func (pdb PostgresDb) GetReservation(id int32) (reservation.Reservation, error) {
+	const query = `
+	SELECT
+		a.id,
+		a.customer_name,
+		a.customer_phone,
+		a.actioned_by,
+		a.appointment_at,
+		a.status,
+		a.version,
+		sl.service_id
+	FROM appointment a
+	JOIN service_location sl
+		ON a.service_location_id = sl.id
+	WHERE a.id = $1
+	`
+
+	row := struct {
+		Id            int32     `db:"id"`
+		CustomerName  string    `db:"customer_name"`
+		CustomerPhone string    `db:"customer_phone"`
+		StaffId       int32     `db:"actioned_by"`
+		ServiceId     int32     `db:"service_id"`
+		Datetime      time.Time `db:"appointment_at"`
+		Status        string    `db:"status"`
+		Version       int64     `db:"version"`
+	}{}
+
+	err := pdb.Db.Get(&row, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return reservation.Reservation{}, reservation.ErrReservationNotFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		return reservation.Reservation{}, ErrInternal
+	}
+
+	return reservation.Reservation{
+		Id:            strconv.FormatInt(int64(row.Id), 10),
+		CustomerName:  row.CustomerName,
+		CustomerPhone: row.CustomerPhone,
+		StaffId:       strconv.FormatInt(int64(row.StaffId), 10),
+		ServiceId:     strconv.FormatInt(int64(row.ServiceId), 10),
+		Datetime:      row.Datetime,
+		Status:        mapAppointmentStatusToApi(row.Status),
+		Version:       row.Version,
+	}, nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// payment.OrderTotalProvider implementation -------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetOrderTotal(orderID int64) (int64, string, error) {
+	const query = `
+	SELECT 
+		CAST(ROUND(total_with_tip) AS BIGINT) AS total_cents,
+		currency
+	FROM order_detail
+	WHERE id = $1
+	LIMIT 1
+	`
+
+	var row struct {
+		TotalCents int64  `db:"total_cents"`
+		Currency   string `db:"currency"`
+	}
+
+	err := pdb.Db.Get(&row, query, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", payment.ErrInternal
+		}
+		slog.Error(err.Error())
+		return 0, "", payment.ErrInternal
+	}
+
+	return row.TotalCents, strings.ToLower(row.Currency), nil
+}
+
+func (pdb PostgresDb) GetOrderItemsForPayment(orderID int64) ([]payment.OrderItem, error) {
+	// order_item_total has the price and VAT rate that apply to the order, including its fulfillment type
+	const query = `
+	SELECT 
+		CONCAT(t.item_name, 
+			COALESCE(' (' || variations.names || ')', ''),
+			COALESCE(' <' || components.names || '>', ''),
+			COALESCE(' [' || modifiers.names || ']', ''),
+			COALESCE(' {' || oi.pricing_rule_name || '}', ''),
+			CASE 
+				WHEN oi.note <> '' 
+				THEN CONCAT(' - ', oi.note) 
+				ELSE '' 
+			END
+		) AS name,
+		t.quantity,
+		LOWER(t.unit::TEXT) AS unit,
+		CAST(GREATEST(ROUND(t.gross), 0) AS BIGINT) AS price_cents,
+		CAST(GREATEST(t.total, 0) AS BIGINT) AS total_cents,
+		t.vat::TEXT AS vat_rate
+	FROM order_item_total t
+	JOIN order_item oi ON oi.id = t.order_item_id
+	LEFT JOIN LATERAL (
+		SELECT STRING_AGG(variation_name, ', ') AS names
+		FROM order_item_detail
+		WHERE
+			order_item_id = t.order_item_id
+			AND variation_id IS NOT NULL
+	) variations ON TRUE
+	LEFT JOIN LATERAL (
+		SELECT STRING_AGG(name, ', ' ORDER BY id) AS names
+		FROM order_item_modifier
+		WHERE order_item_id = t.order_item_id
+	) modifiers ON TRUE
+	LEFT JOIN LATERAL (
+		SELECT STRING_AGG(item_name, ', ' ORDER BY component_id) AS names
+		FROM order_item_component_detail
+		WHERE order_item_id = t.order_item_id
+	) components ON TRUE
+	WHERE t.order_id = $1
+	ORDER BY t.order_item_id
+	`
+
+	var rows []struct {
+		Name       string         `db:"name"`
+		Quantity   order.Quantity `db:"quantity"`
+		Unit       order.Unit     `db:"unit"`
+		PriceCents int64          `db:"price_cents"`
+		TotalCents int64          `db:"total_cents"`
+		VatRate    string         `db:"vat_rate"`
+	}
+
+	err := pdb.Db.Select(&rows, query, orderID)
+	if err != nil {
+		slog.Error("Failed to get order items for payment", "error", err, "order_id", orderID)
+		return nil, ErrInternal
+	}
+
+	items := make([]payment.OrderItem, len(rows))
+	for i, row := range rows {
+		items[i] = payment.OrderItem{
+			Name:     row.Name,
+			Quantity: int(row.Quantity.Whole()),
+			Price:    row.PriceCents,
+			VatRate:  row.VatRate,
+		}
+
+		// Stripe only takes whole quantities, so a fractional line is sold once at its rounded total
+		if !row.Quantity.IsWhole() {
+			items[i].Name = fmt.Sprintf("%s (%s %s × %d.%02d)",
+				row.Name, row.Quantity, row.Unit, row.PriceCents/100, row.PriceCents%100)
+			items[i].Quantity = 1
+			items[i].Price = row.TotalCents
+		}
+	}
+
+	return items, nil
+}
+
+func (pdb PostgresDb) GetReservationTotal(reservationID int32) (int64, string, error) {
+	const query = `
+	SELECT 
+		CAST(ROUND(sl.price) AS BIGINT) AS total_cents,
+		c.currency
+	FROM appointment a
+	JOIN service_location sl
+		ON a.service_location_id = sl.id
+	JOIN location l
+		ON sl.location_id = l.id
+	JOIN country c
+		ON l.country_code = c.code
+	WHERE a.id = $1
+	`
+
+	var row struct {
+		TotalCents int64  `db:"total_cents"`
+		Currency   string `db:"currency"`
+	}
+
+	err := pdb.Db.Get(&row, query, reservationID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", payment.ErrInternal
+		}
+		slog.Error(err.Error())
+		return 0, "", payment.ErrInternal
+	}
+
+	return row.TotalCents, strings.ToLower(row.Currency), nil
+}
+
+// GetReservationItemsForPayment returns reservation items formatted for the payment service
+func (pdb PostgresDb) GetReservationItemsForPayment(reservationID int32) ([]payment.OrderItem, error) {
+	const query = `
+	SELECT 
+		s.name AS name,
+		1 AS quantity,
+		CAST(ROUND(sl.price) AS BIGINT) AS price_cents
+	FROM appointment a
+	JOIN service_location sl
+		ON a.service_location_id = sl.id
+	JOIN service s
+		ON sl.service_id = s.id
+	WHERE a.id = $1
+	LIMIT 1
+	`
+
+	var row struct {
+		Name       string `db:"name"`
+		Quantity   int    `db:"quantity"`
+		PriceCents int64  `db:"price_cents"`
+	}
+
+	err := pdb.Db.Get(&row, query, reservationID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return []payment.OrderItem{}, nil
+		}
+		slog.Error("Failed to get reservation items for payment", "error", err, "reservation_id", reservationID)
+		return nil, ErrInternal
+	}
+
+	return []payment.OrderItem{
+		{
+			Name:     row.Name,
+			Quantity: row.Quantity,
+			Price:    row.PriceCents,
+		},
+	}, nil
+}
+
+func (pdb PostgresDb) MarkReservationCompleted(reservationID int32) error {
+	const query = `
+	UPDATE appointment
+	SET status = 'COMPLETED', version = version + 1
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(query, reservationID)
+	if err != nil {
+		slog.Error("Failed to mark reservation as completed", "error", err, "reservation_id", reservationID)
+		return ErrInternal
+	}
+
+	_, _ = res.RowsAffected()
+
+	return nil
+}
+
+func (pdb PostgresDb) GetOrderStatus(orderID int64) (string, error) {
+	const query = `
+	SELECT status
+	FROM order_data
+	WHERE id = $1
+	LIMIT 1
+	`
+
+	var status string
+	err := pdb.Db.Get(&status, query, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", order.ErrOrderNotFound
+		}
+		slog.Error(err.Error())
+		return "", ErrInternal
+	}
+
+	return strings.ToLower(status), nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// payment.PaymentRepo implementation --------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) CreatePayment(pmt payment.Payment) (int64, error) {
+	const query = `
+	INSERT INTO payment (order_id, reservation_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status)
+	VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4::currency, $5::payment_method, $6, $7, $8::payment_status)
+	RETURNING id
+	`
+
+	var paymentID int64
+	err := pdb.Db.QueryRow(
+		query,
+		pmt.OrderID,
+		pmt.ReservationID,
+		pmt.AmountCents,
+		strings.ToUpper(pmt.Currency),
+		strings.ToUpper(pmt.PaymentMethod),
+		pmt.StripeSessionID,
+		pmt.StripePaymentIntentID,
+		strings.ToUpper(pmt.Status),
+	).Scan(&paymentID)
+
+	if err != nil {
+		slog.Error("Failed to create payment", "error", err)
+		return 0, ErrInternal
+	}
+
+	return paymentID, nil
+}
+
+func (pdb PostgresDb) UpdatePaymentStatus(sessionID string, status string) error {
+	const query = `
+	UPDATE payment
+	SET status = $1::payment_status, updated_at = CURRENT_TIMESTAMP
+	WHERE stripe_session_id = $2
+	`
+
+	result, err := pdb.Db.Exec(query, strings.ToUpper(status), sessionID)
+	if err != nil {
+		slog.Error("Failed to update payment status", "error", err, "session_id", sessionID)
+		return ErrInternal
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		slog.Error("Failed to check rows affected", "error", err)
+		return ErrInternal
+	}
+
+	if rowsAffected == 0 {
+		return payment.ErrPaymentNotFound
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) UpdatePaymentIntentID(sessionID string, paymentIntentID string) error {
+	const query = `
+	UPDATE payment
+	SET stripe_payment_intent_id = $1, updated_at = CURRENT_TIMESTAMP
+	WHERE stripe_session_id = $2
+	`
+
+	result, err := pdb.Db.Exec(query, paymentIntentID, sessionID)
+	if err != nil {
+		slog.Error("Failed to update payment intent ID", "error", err, "session_id", sessionID)
+		return ErrInternal
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		slog.Error("Failed to check rows affected", "error", err)
+		return ErrInternal
+	}
+
+	if rowsAffected == 0 {
+		return payment.ErrPaymentNotFound
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) GetPaymentBySessionID(sessionID string) (*payment.Payment, error) {
+	const query = `
+	SELECT id, COALESCE(order_id, 0) AS order_id, COALESCE(reservation_id, 0) AS reservation_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status, created_at, updated_at
+	FROM payment
+	WHERE stripe_session_id = $1
+	LIMIT 1
+	`
+
+	var pmt payment.Payment
+	err := pdb.Db.Get(&pmt, query, sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, payment.ErrPaymentNotFound
+		}
+		slog.Error("Failed to get payment by session ID", "error", err, "session_id", sessionID)
+		return nil, ErrInternal
+	}
+
+	// Convert enum values to lowercase for consistency with JSON responses
+	pmt.Status = strings.ToLower(pmt.Status)
+	pmt.PaymentMethod = strings.ToLower(pmt.PaymentMethod)
+	pmt.Currency = strings.ToLower(pmt.Currency)
+
+	return &pmt, nil
+}
+
+func (pdb PostgresDb) GetPaymentByOrderID(orderID int64) (*payment.Payment, error) {
+	const query = `
+	SELECT id, COALESCE(order_id, 0) AS order_id, COALESCE(reservation_id, 0) AS reservation_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status, created_at, updated_at
+	FROM payment
+	WHERE order_id = $1
+	ORDER BY created_at DESC
+	LIMIT 1
+	`
+
+	var pmt payment.Payment
+	err := pdb.Db.Get(&pmt, query, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, payment.ErrPaymentNotFound
+		}
+		slog.Error("Failed to get payment by order ID", "error", err, "order_id", orderID)
+		return nil, ErrInternal
+	}
+
+	// Convert enum values to lowercase for consistency with JSON responses
+	pmt.Status = strings.ToLower(pmt.Status)
+	pmt.PaymentMethod = strings.ToLower(pmt.PaymentMethod)
+	pmt.Currency = strings.ToLower(pmt.Currency)
+
+	return &pmt, nil
+}
+
+func (pdb PostgresDb) GetPaymentByPaymentIntentID(paymentIntentID string) (*payment.Payment, error) {
+	const query = `
+	SELECT id, COALESCE(order_id, 0) AS order_id, COALESCE(reservation_id, 0) AS reservation_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status, created_at, updated_at
+	FROM payment
+	WHERE stripe_payment_intent_id = $1
+	LIMIT 1
+	`
+
+	var pmt payment.Payment
+	err := pdb.Db.Get(&pmt, query, paymentIntentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, payment.ErrPaymentNotFound
+		}
+		slog.Error("Failed to get payment by payment intent ID", "error", err, "payment_intent_id", paymentIntentID)
+		return nil, ErrInternal
+	}
+
+	// Convert enum values to lowercase for consistency with JSON responses
+	pmt.Status = strings.ToLower(pmt.Status)
+	pmt.PaymentMethod = strings.ToLower(pmt.PaymentMethod)
+	pmt.Currency = strings.ToLower(pmt.Currency)
+
+	return &pmt, nil
+}
+
+func (pdb PostgresDb) GetPendingStripePayments(orderID int64) ([]payment.Payment, error) {
+	const query = `
+	SELECT id, COALESCE(order_id, 0) AS order_id, COALESCE(reservation_id, 0) AS reservation_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status, created_at, updated_at
+	FROM payment
+	WHERE
+		order_id = $1
+		AND payment_method = 'STRIPE'
+		AND status = 'PENDING'
+		AND stripe_session_id IS NOT NULL
+	ORDER BY created_at DESC
+	`
+
+	payments := []payment.Payment{}
+	err := pdb.Db.Select(&payments, query, orderID)
+	if err != nil {
+		slog.Error("Failed to get pending stripe payments", "error", err, "order_id", orderID)
+		return nil, ErrInternal
+	}
+
+	for i := range payments {
+		payments[i].Status = strings.ToLower(payments[i].Status)
+		payments[i].PaymentMethod = strings.ToLower(payments[i].PaymentMethod)
+		payments[i].Currency = strings.ToLower(payments[i].Currency)
+	}
+
+	return payments, nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// reservation.ServiceRepo implementation ----------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetServices() ([]reservation.Service, error) {
+	const query = `
+	SELECT
+		s.id            AS service_id,
+		s.name          AS service_name,
+		s.duration_mins AS duration_mins,
+		MIN(sl.price)   AS price
+	FROM service s
+	JOIN service_location sl
+		ON sl.service_id = s.id
+	GROUP BY s.id, s.name, s.duration_mins
+	ORDER BY s.id
+	`
+
+	rows := []struct {
+		ServiceId int32  `db:"service_id"`
+		Name      string `db:"service_name"`
+		Duration  int    `db:"duration_mins"`
+		Price     int64  `db:"price"`
+	}{}
+
+	err := pdb.Db.Select(&rows, query)
+	if err != nil {
+		slog.Error(err.Error())
+		return []reservation.Service{}, ErrInternal
+	}
+
+	services := make([]reservation.Service, 0, len(rows))
+	for _, row := range rows {
+		services = append(services, reservation.Service{
+			Id:       strconv.FormatInt(int64(row.ServiceId), 10),
+			Name:     row.Name,
+			NameKey:  row.Name,
+			Duration: row.Duration,
+			Price:    int(row.Price),
+		})
+	}
+
+	return services, nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// reservation.StaffRepo implementation ------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetStaff() ([]reservation.Staff, error) {
+	const query = `
+	WITH roles AS (
+		SELECT 
+			er.employee_id,
+			MIN(r.name) AS role_name
+		FROM employee_role er
+		JOIN role r
+			ON er.role_id = r.id
+		GROUP BY er.employee_id
+	)
+	SELECT
+		e.id,
+		e.first_name,
+		e.last_name,
+		COALESCE(roles.role_name, '') AS role_name,
+		ARRAY_AGG(DISTINCT sl.service_id) AS service_ids
+	FROM employee e
+	JOIN service_employee se
+		ON e.id = se.employee_id
+	JOIN service_location sl
+		ON se.service_location_id = sl.id
+	LEFT JOIN roles
+		ON roles.employee_id = e.id
+	GROUP BY e.id, e.first_name, e.last_name, roles.role_name
+	ORDER BY e.id
+	`
+
+	rows := []struct {
+		Id         int32         `db:"id"`
+		FirstName  string        `db:"first_name"`
+		LastName   string        `db:"last_name"`
+		RoleName   string        `db:"role_name"`
+		ServiceIds pq.Int64Array `db:"service_ids"`
+	}{}
+
+	err := pdb.Db.Select(&rows, query)
+	if err != nil {
+		slog.Error(err.Error())
+		return []reservation.Staff{}, ErrInternal
+	}
+
+	serviceSet := map[string]struct{}{}
+	staff := make([]reservation.Staff, 0, len(rows)+1)
+
+	for _, row := range rows {
+		serviceIds := make([]string, len(row.ServiceIds))
+		for i, id := range row.ServiceIds {
+			serviceIds[i] = strconv.FormatInt(id, 10)
+			serviceSet[serviceIds[i]] = struct{}{}
+		}
+
+		role := row.RoleName
+		if role == "" {
+			role = "Staff"
+		}
+
+		staff = append(staff, reservation.Staff{
+			Id:       strconv.FormatInt(int64(row.Id), 10),
+			Name:     strings.TrimSpace(fmt.Sprintf("%s %s", row.FirstName, row.LastName)),
+			Role:     role,
+			Services: serviceIds,
+		})
+	}
+
+	if len(serviceSet) > 0 {
+		allServices := make([]string, 0, len(serviceSet))
+		for id := range serviceSet {
+			allServices = append(allServices, id)
+		}
+		sort.Strings(allServices)
+
+		staff = append([]reservation.Staff{
+			{
+				Id:       "anyone",
+				Name:     "Anyone",
+				Role:     "Any",
+				Services: allServices,
+			},
+		}, staff...)
+	}
+
+	return staff, nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// Reservation helpers -----------------------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func mapAppointmentStatusToApi(status string) string {
+	switch strings.ToUpper(status) {
+	case "PENDING", "RESERVED":
+		return "pending"
+	case "SERVING":
+		return "confirmed"
+	case "COMPLETED", "PAID":
+		return "completed"
+	case "CANCELLED", "CANCELED":
+		return "cancelled"
+	case "REFUND_PENDING":
+		return "refund_pending"
+	case "REFUNDED":
+		return "refunded"
+	default:
+		return strings.ToLower(status)
+	}
+}
+
+func mapApiReservationStatusToAppointment(status string) string {
+	trimmed := strings.TrimSpace(status)
+	if trimmed == "" {
+		return ""
+	}
+
+	upper := strings.ToUpper(trimmed)
+	switch upper {
+	// Accept DB enum values directly.
+	case "PENDING", "COMPLETED", "CANCELLED", "REFUND_PENDING", "REFUNDED":
+		return upper
+
+	// API / UI values (and common variants).
+	case "CONFIRMED":
+		return "PENDING"
+	case "CANCELED":
+		return "CANCELLED"
+
+	// Legacy values from older appointment_status enums.
+	case "RESERVED", "SERVING":
+		return "PENDING"
+	case "PAID":
+		return "COMPLETED"
+	}
+
+	return ""
+}
+
+// -------------------------------------------------------------------------------------------------
+// reservation.ReservationRepo - Refund methods ---------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) CreateReservationRefundRequest(reservationId int32, refundData reservation.RefundData) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	{
+		const appointmentStatusStatement = `
+		UPDATE appointment
+		SET status = 'REFUND_PENDING', version = version + 1
+		WHERE
+			id = $1
+			AND status = 'COMPLETED'
+		`
+
+		res, err := transaction.Exec(appointmentStatusStatement, reservationId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			slog.Error("error checking rows affected when setting reservation refund pending", "error", err)
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+		if rows != 1 {
+			slog.Warn("reservation not updated - might already be in REFUND_PENDING status", "rows", rows, "reservation_id", reservationId)
+			_ = transaction.Rollback()
+			return nil
+		}
+	}
+	{
+		const refundDataStatement = `
+		INSERT INTO reservation_refund_data (appointment_id, name, phone, email, reason)
+			VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (appointment_id) DO NOTHING
+		`
+
+		_, err = transaction.Exec(
+			refundDataStatement,
+			reservationId,
+			refundData.Name,
+			refundData.Phone,
+			refundData.Email,
+			refundData.Reason,
+		)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	_ = transaction.Commit()
+
+	return nil
+}
+
+// Prob will delete, needed for testing
+func (pdb PostgresDb) CancelReservationRefundRequest(reservationId int32) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	{
+		const appointmentStatusStatement = `
+		UPDATE appointment
+		SET status = 'COMPLETED', version = version + 1
+		WHERE
+			id = $1
+			AND status = 'REFUND_PENDING'
+		`
+
+		res, err := transaction.Exec(appointmentStatusStatement, reservationId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+		rows, err := res.RowsAffected()
+		if err != nil || rows != 1 {
+			slog.Error("unexpected rows affected when canceling reservation refund", "rows", rows, "error", err)
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+	{
+		const deleteRefundDataStatement = `
+		DELETE FROM reservation_refund_data WHERE appointment_id = $1
+		`
+
+		_, err = transaction.Exec(deleteRefundDataStatement, reservationId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	_ = transaction.Commit()
+
+	return nil
+}
+
+// payment.OrderTipProvider implementation ---------------------------------------------------------
+func (pdb PostgresDb) GetOrderTipCents(orderID int64) (int64, error) {
+	const query = `
+	SELECT CAST(ROUND(tip) AS BIGINT) AS tip_cents
+	FROM order_detail
+	WHERE id = $1
+	LIMIT 1
+	`
+	var tipCents int64
+	if err := pdb.Db.Get(&tipCents, query, orderID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+	return tipCents, nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// idempotency.Store implementation ----------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+// Claims that are still in progress after this long are treated as abandoned (e.g. the server crashed).
+const abandonedIdempotencyClaim = time.Minute
+
+func (pdb PostgresDb) Claim(username, key, requestHash string) (*idempotency.Response, error) {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, ErrInternal
+	}
+
+	{
+		// Expired keys are claimed again as if they were never used
+		const claimStatement = `
+		INSERT INTO idempotency_key (username, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (username, key) DO UPDATE
+		SET
+			request_hash = EXCLUDED.request_hash,
+			status_code  = NULL,
+			headers      = '{}',
+			body         = '',
+			created_at   = NOW(),
+			expires_at   = EXCLUDED.expires_at
+		WHERE idempotency_key.expires_at < NOW()
+		`
+		res, err := transaction.Exec(claimStatement, username, key, requestHash, abandonedIdempotencyClaim.Seconds())
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return nil, ErrInternal
+		}
+		if rows, _ := res.RowsAffected(); rows == 1 {
+			if err := transaction.Commit(); err != nil {
+				slog.Error(err.Error())
+				return nil, ErrInternal
+			}
+			return nil, nil
+		}
+	}
+
+	existing := struct {
+		RequestHash string `db:"request_hash"`
+		StatusCode  *int   `db:"status_code"`
+		Headers     []byte `db:"headers"`
+		Body        []byte `db:"body"`
+	}{}
+	{
+		const query = `
+		SELECT request_hash, status_code, headers, body
+		FROM idempotency_key
+		WHERE username = $1 AND key = $2
+		`
+		err := transaction.Get(&existing, query, username, key)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return nil, ErrInternal
+		}
+	}
+
+	_ = transaction.Rollback()
+
+	if existing.RequestHash != requestHash {
+		return nil, idempotency.ErrKeyReused
+	}
+	if existing.StatusCode == nil {
+		return nil, idempotency.ErrInProgress
+	}
+
+	response := &idempotency.Response{
+		StatusCode: *existing.StatusCode,
+		Header:     http.Header{},
+		Body:       existing.Body,
+	}
+	if err := json.Unmarshal(existing.Headers, &response.Header); err != nil {
+		slog.Error(err.Error())
+		return nil, ErrInternal
+	}
+
+	return response, nil
+}
+
+func (pdb PostgresDb) Complete(username, key string, response idempotency.Response, retention time.Duration) error {
+	headers, err := json.Marshal(response.Header)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	const statement = `
+	UPDATE idempotency_key
+	SET
+		status_code = $3,
+		headers     = $4,
+		body        = $5,
+		expires_at  = NOW() + $6 * INTERVAL '1 second'
+	WHERE username = $1 AND key = $2
+	`
+	_, err = pdb.Db.Exec(statement, username, key, response.StatusCode, headers, response.Body, retention.Seconds())
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) Release(username, key string) error {
+	const statement = `
+	DELETE FROM idempotency_key
+	WHERE username = $1 AND key = $2 AND status_code IS NULL
+	`
+	_, err := pdb.Db.Exec(statement, username, key)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) DeleteExpired() error {
+	const statement = `
+	DELETE FROM idempotency_key
+	WHERE expires_at < NOW()
+	`
+	_, err := pdb.Db.Exec(statement)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// table.TableRepo implementation ------------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetFloorPlans(locationId int64) ([]table.FloorPlan, error) {
+	floorPlans := []table.FloorPlan{}
+	{
+		const query = `
+		SELECT id, location_id, name
+		FROM floor_plan
+		WHERE location_id = $1
+		ORDER BY name
+		`
+
+		err := pdb.Db.Select(&floorPlans, query, locationId)
+		if err != nil {
+			slog.Error(err.Error())
+			return []table.FloorPlan{}, ErrInternal
+		}
+	}
+
+	// A table merged into another one shares its orders and status,
+	// unless the other table was freed in the meantime.
+	const query = `
+	WITH open_orders AS (
+		SELECT
+			table_id,
+			ARRAY_AGG(id ORDER BY id)   AS order_ids,
+			COALESCE(SUM(party_size), 0) AS party_size
+		FROM order_data
+		WHERE status = 'OPEN' AND table_id IS NOT NULL
+		GROUP BY table_id
+	)
+	SELECT
+		t.id,
+		t.floor_plan_id,
+		t.name,
+		t.seats,
+		t.area,
+		CASE WHEN po.table_id IS NOT NULL THEN t.merged_into_id END AS merged_into_id,
+		CASE
+			WHEN po.table_id IS NULL AND oo.table_id IS NULL THEN 'free'
+			WHEN po.table_id IS NOT NULL AND p.bill_requested THEN 'bill_requested'
+			WHEN po.table_id IS NULL AND t.bill_requested THEN 'bill_requested'
+			ELSE 'occupied'
+		END AS status,
+		COALESCE(po.order_ids, oo.order_ids, '{}')  AS open_orders,
+		COALESCE(po.party_size, oo.party_size, 0)   AS party_size
+	FROM dining_table t
+	JOIN floor_plan fp
+		ON fp.id = t.floor_plan_id
+	LEFT JOIN open_orders oo
+		ON oo.table_id = t.id
+	LEFT JOIN dining_table p
+		ON p.id = t.merged_into_id
+	LEFT JOIN open_orders po
+		ON po.table_id = p.id
+	WHERE fp.location_id = $1
+	ORDER BY
+		t.floor_plan_id,
+		t.name
+	`
+
+	rows := []struct {
+		Id          int64         `db:"id"`
+		FloorPlanId int64         `db:"floor_plan_id"`
+		Name        string        `db:"name"`
+		Seats       uint16        `db:"seats"`
+		Area        string        `db:"area"`
+		MergedInto  *int64        `db:"merged_into_id"`
+		Status      string        `db:"status"`
+		OpenOrders  pq.Int64Array `db:"open_orders"`
+		PartySize   uint16        `db:"party_size"`
+	}{}
+
+	err := pdb.Db.Select(&rows, query, locationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []table.FloorPlan{}, ErrInternal
+	}
+
+	for i := range floorPlans {
+		floorPlans[i].Tables = []table.Table{}
+		for _, row := range rows {
+			if row.FloorPlanId != floorPlans[i].Id {
+				continue
+			}
+			floorPlans[i].Tables = append(floorPlans[i].Tables, table.Table{
+				Id:          row.Id,
+				FloorPlanId: row.FloorPlanId,
+				Name:        row.Name,
+				Seats:       row.Seats,
+				Area:        row.Area,
+				Status:      table.Status(row.Status),
+				MergedInto:  row.MergedInto,
+				OpenOrders:  []int64(row.OpenOrders),
+				PartySize:   row.PartySize,
+			})
+		}
+	}
+
+	return floorPlans, nil
+}
+
+func (pdb PostgresDb) GetFloorPlanLocation(floorPlanId int64) (int64, error) {
+	const query = `
+	SELECT location_id
+	FROM floor_plan
+	WHERE id = $1
+	`
+
+	var locationId int64
+	err := pdb.Db.Get(&locationId, query, floorPlanId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, table.ErrFloorPlanNotFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return locationId, nil
+}
+
+func (pdb PostgresDb) GetTableLocation(tableId int64) (int64, error) {
+	return tableLocation(pdb.Db, tableId)
+}
+
+func (pdb PostgresDb) CreateFloorPlan(locationId int64, name string) (int64, error) {
+	const statement = `
+	INSERT INTO floor_plan (location_id, name)
+		VALUES ($1, $2)
+	RETURNING id
+	`
+
+	var id int64
+	err := pdb.Db.Get(&id, statement, locationId, name)
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return id, nil
+}
+
+func (pdb PostgresDb) CreateTable(floorPlanId int64, t table.TableUpdate) (int64, error) {
+	area := ""
+	if t.Area != nil {
+		area = *t.Area
+	}
+
+	const statement = `
+	INSERT INTO dining_table (floor_plan_id, name, seats, area)
+		SELECT id, $2, $3, $4
+		FROM floor_plan
+		WHERE id = $1
+	RETURNING id
+	`
+
+	var id int64
+	err := pdb.Db.Get(&id, statement, floorPlanId, t.Name, t.Seats, area)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, table.ErrFloorPlanNotFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return id, nil
+}
+
+func (pdb PostgresDb) UpdateTable(tableId int64, t table.TableUpdate) error {
+	const statement = `
+	UPDATE dining_table
+	SET
+		name  = COALESCE($2, name),
+		seats = COALESCE($3, seats),
+		area  = COALESCE($4, area)
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, tableId, t.Name, t.Seats, t.Area)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return table.ErrTableNotFound
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) DeleteTable(tableId int64) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	if _, err := lockTable(transaction, tableId); err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	occupied, err := tableHasOpenOrders(transaction, tableId)
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+	if occupied {
+		_ = transaction.Rollback()
+		return table.ErrTableNotFree
+	}
+
+	const statement = `
+	DELETE FROM dining_table
+	WHERE id = $1
+	`
+	if _, err := transaction.Exec(statement, tableId); err != nil {
+		slog.Error(err.Error())
+		_ = transaction.Rollback()
+		return ErrInternal
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) AssignOrder(tableId int64, orderId int64, username string) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	if err := seatOrder(transaction, orderId, tableId, username); err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) RequestBill(tableId int64) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	seatedAt, err := resolveTable(transaction, tableId)
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	occupied, err := tableHasOpenOrders(transaction, seatedAt)
+	if err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+	if !occupied {
+		_ = transaction.Rollback()
+		return table.ErrTableFree
+	}
+
+	const statement = `
+	UPDATE dining_table
+	SET bill_requested = TRUE
+	WHERE id = $1
+	`
+	if _, err := transaction.Exec(statement, seatedAt); err != nil {
+		slog.Error(err.Error())
+		_ = transaction.Rollback()
+		return ErrInternal
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) TransferTable(fromTableId int64, toTableId int64, username string) error {
+	return pdb.moveTable(fromTableId, toTableId, username, false)
+}
+
+func (pdb PostgresDb) MergeTables(fromTableId int64, toTableId int64, username string) error {
+	return pdb.moveTable(fromTableId, toTableId, username, true)
+}
+
+// Moves the open orders of one table to another.
+// A transfer needs a free target table, a merge keeps the source table linked to the target.
+func (pdb PostgresDb) moveTable(fromTableId int64, toTableId int64, username string, merge bool) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	rollback := func(err error) error {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	from, err := resolveTable(transaction, fromTableId)
+	if err != nil {
+		return rollback(err)
+	}
+	to, err := resolveTable(transaction, toTableId)
+	if err != nil {
+		return rollback(err)
+	}
+	if from == to {
+		return rollback(table.ErrSameTable)
+	}
+
+	fromLocation, err := tableLocation(transaction, from)
+	if err != nil {
+		return rollback(err)
+	}
+	toLocation, err := tableLocation(transaction, to)
+	if err != nil {
+		return rollback(err)
+	}
+	// The orders are priced by their location, so they can't be moved to another one
+	if fromLocation != toLocation {
+		return rollback(table.ErrTableLocation)
+	}
+
+	fromOccupied, err := tableHasOpenOrders(transaction, from)
+	if err != nil {
+		return rollback(err)
+	}
+	toOccupied, err := tableHasOpenOrders(transaction, to)
+	if err != nil {
+		return rollback(err)
+	}
+
+	if merge {
+		if !fromOccupied && !toOccupied {
+			return rollback(table.ErrTableFree)
+		}
+	} else {
+		if !fromOccupied {
+			return rollback(table.ErrTableFree)
+		}
+		if toOccupied {
+			return rollback(table.ErrTableNotFree)
+		}
+	}
+
+	orderIds := []int64{}
+	{
+		const statement = `
+		UPDATE order_data
+		SET
+			table_id = $2,
+			version  = version + 1
+		WHERE table_id = $1 AND status = 'OPEN'
+		RETURNING id
+		`
+		if err := transaction.Select(&orderIds, statement, from, to); err != nil {
+			slog.Error(err.Error())
+			return rollback(ErrInternal)
+		}
+	}
+
+	for _, orderId := range orderIds {
+		err := recordOrderEvent(transaction, orderId, username, order.HistoryTableChanged, map[string]any{
+			"fromTableId": from,
+			"toTableId":   to,
+			"merged":      merge,
+		})
+		if err != nil {
+			return rollback(err)
+		}
+	}
+
+	{
+		// A transferred table keeps its bill request, merged tables keep the request of the target.
+		const statement = `
+		UPDATE dining_table
+		SET
+			bill_requested = CASE WHEN $3::boolean THEN bill_requested ELSE (SELECT bill_requested FROM dining_table WHERE id = $1) END
+		WHERE id = $2
+		`
+		if _, err := transaction.Exec(statement, from, to, merge); err != nil {
+			slog.Error(err.Error())
+			return rollback(ErrInternal)
+		}
+	}
+	{
+		// Tables merged into the source follow it to the target.
+		const statement = `
+		UPDATE dining_table
+		SET
+			merged_into_id = CASE WHEN id = $1 AND NOT $3::boolean THEN NULL ELSE $2 END,
+			bill_requested = FALSE
+		WHERE id = $1 OR merged_into_id = $1
+		`
+		if _, err := transaction.Exec(statement, from, to, merge); err != nil {
+			slog.Error(err.Error())
+			return rollback(ErrInternal)
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+// Seats the open order at the table, or at the table it's merged into.
+func seatOrder(transaction *sqlx.Tx, orderId int64, tableId int64, username string) error {
+	seatedAt, err := resolveTable(transaction, tableId)
+	if err != nil {
+		return err
+	}
+
+	locationId, err := tableLocation(transaction, seatedAt)
+	if err != nil {
+		return err
+	}
+
+	var previous *int64
+	{
+		const query = `
+		SELECT status, table_id, location_id
+		FROM order_data
+		WHERE id = $1
+		FOR UPDATE
+		`
+		var current struct {
+			Status     string `db:"status"`
+			TableId    *int64 `db:"table_id"`
+			LocationId *int64 `db:"location_id"`
+		}
+		err := transaction.Get(&current, query, orderId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return table.ErrOrderNotFound
+		} else if err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if order.ParseStatus(current.Status) != order.StatusOpen {
+			return table.ErrOrderNotOpen
+		}
+		// Orders without a location are not seated either, they would be priced without the location's prices
+		if current.LocationId == nil || *current.LocationId != locationId {
+			return table.ErrOrderLocation
+		}
+		if current.TableId != nil && *current.TableId == seatedAt {
+			return nil
+		}
+		previous = current.TableId
+	}
+
+	occupied, err := tableHasOpenOrders(transaction, seatedAt)
+	if err != nil {
+		return err
+	}
+	if !occupied {
+		// New guests, the bill request of the previous ones doesn't apply
+		const statement = `
+		UPDATE dining_table
+		SET bill_requested = FALSE
+		WHERE id = $1
+		`
+		if _, err := transaction.Exec(statement, seatedAt); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+	}
+
+	{
+		const statement = `
+		UPDATE order_data
+		SET
+			table_id = $2,
+			version  = version + 1
+		WHERE id = $1
+		`
+		if _, err := transaction.Exec(statement, orderId, seatedAt); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+	}
+
+	return recordOrderEvent(transaction, orderId, username, order.HistoryTableChanged, map[string]any{
+		"fromTableId": previous,
+		"toTableId":   seatedAt,
+	})
+}
+
+func tableLocation(queryer sqlx.Queryer, tableId int64) (int64, error) {
+	const query = `
+	SELECT floor_plan.location_id
+	FROM dining_table
+	JOIN floor_plan
+		ON floor_plan.id = dining_table.floor_plan_id
+	WHERE dining_table.id = $1
+	`
+
+	var locationId int64
+	err := sqlx.Get(queryer, &locationId, query, tableId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, table.ErrTableNotFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return locationId, nil
+}
+
+type lockedTable struct {
+	Id         int64  `db:"id"`
+	MergedInto *int64 `db:"merged_into_id"`
+}
+
+func lockTable(transaction *sqlx.Tx, tableId int64) (lockedTable, error) {
+	const query = `
+	SELECT id, merged_into_id
+	FROM dining_table
+	WHERE id = $1
+	FOR UPDATE
+	`
+
+	var t lockedTable
+	err := transaction.Get(&t, query, tableId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lockedTable{}, table.ErrTableNotFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		return lockedTable{}, ErrInternal
+	}
+
+	return t, nil
+}
+
+// Returns the table where orders of the given table are seated.
+// A merge is dropped once the table it was merged into has no open orders.
+func resolveTable(transaction *sqlx.Tx, tableId int64) (int64, error) {
+	t, err := lockTable(transaction, tableId)
+	if err != nil {
+		return 0, err
+	}
+	if t.MergedInto == nil {
+		return t.Id, nil
+	}
+
+	if _, err := lockTable(transaction, *t.MergedInto); err != nil {
+		return 0, err
+	}
+	occupied, err := tableHasOpenOrders(transaction, *t.MergedInto)
+	if err != nil {
+		return 0, err
+	}
+	if occupied {
+		return *t.MergedInto, nil
+	}
+
+	const statement = `
+	UPDATE dining_table
+	SET merged_into_id = NULL
+	WHERE id = $1
+	`
+	if _, err := transaction.Exec(statement, t.Id); err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return t.Id, nil
+}
+
+func tableHasOpenOrders(transaction *sqlx.Tx, tableId int64) (bool, error) {
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM order_data
+		WHERE table_id = $1 AND status = 'OPEN'
+	)
+	`
+
+	var occupied bool
+	if err := transaction.Get(&occupied, query, tableId); err != nil {
+		slog.Error(err.Error())
+		return false, ErrInternal
+	}
+
+	return occupied, nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// kitchen.KitchenRepo implementation --------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetStations(locationId int64) ([]kitchen.Station, error) {
+	const query = `
+	SELECT
+		s.id,
+		s.location_id,
+		s.name,
+		ARRAY(
+			SELECT category.name
+			FROM kitchen_station_category sc
+			JOIN category
+				ON category.id = sc.category_id
+			WHERE sc.station_id = s.id
+			ORDER BY category.name
+		) AS categories
+	FROM kitchen_station s
+	WHERE s.location_id = $1
+	ORDER BY s.name
+	`
+
+	rows := []struct {
+		Id         int64          `db:"id"`
+		LocationId int64          `db:"location_id"`
+		Name       string         `db:"name"`
+		Categories pq.StringArray `db:"categories"`
+	}{}
+	err := pdb.Db.Select(&rows, query, locationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []kitchen.Station{}, ErrInternal
+	}
+
+	stations := make([]kitchen.Station, len(rows))
+	for i, row := range rows {
+		stations[i] = kitchen.Station{
+			Id:         row.Id,
+			LocationId: row.LocationId,
+			Name:       row.Name,
+			Categories: []string(row.Categories),
+		}
+	}
+
+	return stations, nil
+}
+
+func (pdb PostgresDb) GetStation(stationId int64) (kitchen.Station, error) {
+	const query = `
+	SELECT id, location_id, name
+	FROM kitchen_station
+	WHERE id = $1
+	`
+
+	var station kitchen.Station
+	err := pdb.Db.Get(&station, query, stationId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return kitchen.Station{}, kitchen.ErrStationNotFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		return kitchen.Station{}, ErrInternal
+	}
+	station.Categories = []string{}
+
+	return station, nil
+}
+
+func (pdb PostgresDb) CreateStation(locationId int64, name string, categories []string) (int64, error) {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	stationId := int64(0)
+	{
+		const statement = `
+		INSERT INTO kitchen_station (location_id, name)
+			VALUES ($1, $2)
+		RETURNING id
+		`
+		err := transaction.Get(&stationId, statement, locationId, name)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return 0, ErrInternal
+		}
+	}
+
+	if len(categories) > 0 {
+		const statement = `
+		INSERT INTO kitchen_station_category (station_id, category_id)
+			SELECT $1, category.id
+			FROM category
+			JOIN location
+				ON location.business_id = category.business_id
+			WHERE
+				category.name = ANY($2)
+				AND location.id = $3
+		`
+		res, err := transaction.Exec(statement, stationId, pq.StringArray(categories), locationId)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return 0, ErrInternal
+		}
+
+		slices.Sort(categories)
+		if rows, _ := res.RowsAffected(); rows != int64(len(slices.Compact(categories))) {
+			_ = transaction.Rollback()
+			return 0, kitchen.ErrCategoryNotFound
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return stationId, nil
+}
+
+func (pdb PostgresDb) DeleteStation(stationId int64) error {
+	const statement = `
+	DELETE FROM kitchen_station
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, stationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return kitchen.ErrStationNotFound
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) GetTickets(stationId int64) ([]kitchen.Ticket, error) {
+	if _, err := pdb.GetStation(stationId); err != nil {
+		return []kitchen.Ticket{}, err
+	}
+
+	// Lines of cancelled orders are taken off the screens, paid orders still have to be cooked.
+	// Bundle lines go to the stations of the bundle and of its components. A station only sees
+	// the components that are routed to it, unless the bundle itself is.
+	const query = `
+	SELECT
+		o.id AS order_id,
+		t.name AS table_name,
+		o.customer_label,
+		oi.id AS order_item_id,
+		oi.item_name,
+		oi.quantity,
+		oi.note,
+		oi.prep_status,
+		oi.sent_at,
+		ARRAY(
+			SELECT variation_name
+			FROM order_item_variation
+			WHERE order_item_id = oi.id
+			ORDER BY variation_name
+		) AS variations,
+		ARRAY(
+			SELECT c.item_name
+			FROM order_item_component c
+			WHERE
+				c.order_item_id = oi.id
+				AND EXISTS (
+					SELECT 1
+					FROM kitchen_station_item ksi
+					WHERE
+						ksi.station_id = $1
+						AND ksi.location_id = COALESCE(o.location_id, ksi.location_id)
+						AND ksi.item_id IN (oi.item_id, c.item_id)
+				)
+			ORDER BY c.id
+		) AS components
+	FROM order_item oi
+	JOIN order_data o
+		ON o.id = oi.order_id
+	LEFT JOIN dining_table t
+		ON t.id = o.table_id
+	WHERE
+		EXISTS (
+			SELECT 1
+			FROM kitchen_station_item ksi
+			WHERE
+				ksi.station_id = $1
+				AND ksi.location_id = COALESCE(o.location_id, ksi.location_id)
+				AND (
+					ksi.item_id = oi.item_id
+					OR ksi.item_id IN (
+						SELECT item_id
+						FROM order_item_component
+						WHERE order_item_id = oi.id
+					)
+				)
+		)
+		AND oi.sent_at IS NOT NULL
+		AND oi.prep_status <> 'SERVED'
+		AND o.status IN ('OPEN', 'CLOSED')
+	ORDER BY
+		oi.sent_at,
+		o.id,
+		oi.id
+	`
+
+	rows := []struct {
+		OrderId       int64          `db:"order_id"`
+		TableName     *string        `db:"table_name"`
+		CustomerLabel string         `db:"customer_label"`
+		OrderItemId   int64          `db:"order_item_id"`
+		Name          string         `db:"item_name"`
+		Quantity      order.Quantity `db:"quantity"`
+		Note          string         `db:"note"`
+		Status        string         `db:"prep_status"`
+		SentAt        time.Time      `db:"sent_at"`
+		Variations    pq.StringArray `db:"variations"`
+		Components    pq.StringArray `db:"components"`
+	}{}
+	err := pdb.Db.Select(&rows, query, stationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []kitchen.Ticket{}, ErrInternal
+	}
+
+	orderItemIds := make([]int64, len(rows))
+	for i, row := range rows {
+		orderItemIds[i] = row.OrderItemId
+	}
+	modifiers := map[int64][]order.Modifier{}
+	{
+		const query = `
+		SELECT order_item_id, group_name, name, price_difference
+		FROM order_item_modifier
+		WHERE order_item_id = ANY($1)
+		ORDER BY id
+		`
+
+		modifierRows := []struct {
+			OrderItemId int64 `db:"order_item_id"`
+			order.Modifier
+		}{}
+		err := pdb.Db.Select(&modifierRows, query, pq.Int64Array(orderItemIds))
+		if err != nil {
+			slog.Error(err.Error())
+			return []kitchen.Ticket{}, ErrInternal
+		}
+		for _, row := range modifierRows {
+			modifiers[row.OrderItemId] = append(modifiers[row.OrderItemId], row.Modifier)
+		}
+	}
+
+	// Rows are ordered by the time they were sent, so the first line of an order opens its ticket
+	tickets := []kitchen.Ticket{}
+	ticketIndex := map[int64]int{}
+	for _, row := range rows {
+		itemModifiers, ok := modifiers[row.OrderItemId]
+		if !ok {
+			itemModifiers = []order.Modifier{}
+		}
+
+		i, ok := ticketIndex[row.OrderId]
+		if !ok {
+			i = len(tickets)
+			ticketIndex[row.OrderId] = i
+			tickets = append(tickets, kitchen.Ticket{
+				OrderId:       row.OrderId,
+				TableName:     row.TableName,
+				CustomerLabel: row.CustomerLabel,
+				SentAt:        row.SentAt,
+				Items:         []kitchen.TicketItem{},
+			})
+		}
+
+		tickets[i].Items = append(tickets[i].Items, kitchen.TicketItem{
+			OrderItemId: row.OrderItemId,
+			Name:        row.Name,
+			Quantity:    row.Quantity,
+			Variations:  []string(row.Variations),
+			Components:  []string(row.Components),
+			Note:        row.Note,
+			Modifiers:   itemModifiers,
+			Status:      kitchen.ParsePrepStatus(row.Status),
+			SentAt:      row.SentAt,
+		})
+	}
+
+	return tickets, nil
+}
+
+func (pdb PostgresDb) SendOrder(orderId int64, username string) ([]kitchen.ItemUpdate, error) {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return []kitchen.ItemUpdate{}, ErrInternal
+	}
+
+	{
+		// Orders belong to the business of the employee who created them
+		const query = `
+		SELECT o.status
+		FROM order_data o
+		JOIN employee seller
+			ON seller.id = o.employee_id
+		JOIN employee e
+			ON e.business_id = seller.business_id
+		WHERE o.id = $1 AND e.username = $2
+		FOR UPDATE OF o
+		`
+		var status string
+		err := transaction.Get(&status, query, orderId, username)
+		if err != nil {
+			_ = transaction.Rollback()
+			if errors.Is(err, sql.ErrNoRows) {
+				return []kitchen.ItemUpdate{}, kitchen.ErrOrderNotFound
+			}
+			slog.Error(err.Error())
+			return []kitchen.ItemUpdate{}, ErrInternal
+		}
+		if s := order.ParseStatus(status); s != order.StatusOpen && s != order.StatusClosed {
+			_ = transaction.Rollback()
+			return []kitchen.ItemUpdate{}, kitchen.ErrOrderNotActive
+		}
+	}
+
+	const statement = `
+	UPDATE order_item
+	SET
+		sent_at                = NOW(),
+		prep_status            = 'NEW',
+		prep_status_changed_at = NOW()
+	WHERE
+		order_id = $1
+		AND sent_at IS NULL
+	RETURNING id
+	`
+
+	sentIds := []int64{}
+	err = transaction.Select(&sentIds, statement, orderId)
+	if err != nil {
+		slog.Error(err.Error())
+		_ = transaction.Rollback()
+		return []kitchen.ItemUpdate{}, ErrInternal
+	}
+
+	items := make([]kitchen.ItemUpdate, 0, len(sentIds))
+	for _, id := range sentIds {
+		item, err := getKitchenItemUpdate(transaction, id)
+		if err != nil {
+			_ = transaction.Rollback()
+			return []kitchen.ItemUpdate{}, err
+		}
+		if err := recordKitchenEvent(transaction, kitchen.EventItemSent, item); err != nil {
+			_ = transaction.Rollback()
+			return []kitchen.ItemUpdate{}, err
+		}
+		items = append(items, item)
+	}
+
+	if len(sentIds) > 0 {
+		err = recordOrderEvent(transaction, orderId, username, order.HistorySentToKitchen, map[string]any{
+			"orderItemIds": sentIds,
+		})
+		if err != nil {
+			_ = transaction.Rollback()
+			return []kitchen.ItemUpdate{}, err
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return []kitchen.ItemUpdate{}, ErrInternal
+	}
+
+	return items, nil
+}
+
+func (pdb PostgresDb) SetItemStatus(orderItemId int64, username string, status kitchen.PrepStatus) (kitchen.ItemUpdate, error) {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return kitchen.ItemUpdate{}, ErrInternal
+	}
+
+	var current struct {
+		OrderId int64      `db:"order_id"`
+		Status  string     `db:"prep_status"`
+		SentAt  *time.Time `db:"sent_at"`
+	}
+	{
+		const query = `
+		SELECT oi.order_id, oi.prep_status, oi.sent_at
+		FROM order_item oi
+		JOIN order_data o
+			ON o.id = oi.order_id
+		JOIN employee seller
+			ON seller.id = o.employee_id
+		JOIN employee e
+			ON e.business_id = seller.business_id
+		WHERE oi.id = $1 AND e.username = $2
+		FOR UPDATE OF oi
+		`
+		err := transaction.Get(&current, query, orderItemId, username)
+		if err != nil {
+			_ = transaction.Rollback()
+			if errors.Is(err, sql.ErrNoRows) {
+				return kitchen.ItemUpdate{}, kitchen.ErrItemNotFound
+			}
+			slog.Error(err.Error())
+			return kitchen.ItemUpdate{}, ErrInternal
+		}
+	}
+	if current.SentAt == nil {
+		_ = transaction.Rollback()
+		return kitchen.ItemUpdate{}, kitchen.ErrItemNotSent
+	}
+	previous := kitchen.ParsePrepStatus(current.Status)
+	if !previous.CanMoveTo(status) {
+		_ = transaction.Rollback()
+		return kitchen.ItemUpdate{}, kitchen.ErrInvalidStatusChange
+	}
+
+	{
+		const statement = `
+		UPDATE order_item
+		SET
+			prep_status            = $2::prep_status,
+			prep_status_changed_at = NOW()
+		WHERE id = $1
+		`
+		_, err := transaction.Exec(statement, orderItemId, status.DbValue())
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return kitchen.ItemUpdate{}, ErrInternal
+		}
+	}
+
+	err = recordOrderEvent(transaction, current.OrderId, username, order.HistoryPrepChanged, map[string]any{
+		"orderItemId": orderItemId,
+		"from":        previous,
+		"to":          status,
+	})
+	if err != nil {
+		_ = transaction.Rollback()
+		return kitchen.ItemUpdate{}, err
+	}
+
+	item, err := getKitchenItemUpdate(transaction, orderItemId)
+	if err != nil {
+		_ = transaction.Rollback()
+		return kitchen.ItemUpdate{}, err
+	}
+	if err := recordKitchenEvent(transaction, kitchen.EventStatusChanged, item); err != nil {
+		_ = transaction.Rollback()
+		return kitchen.ItemUpdate{}, err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return kitchen.ItemUpdate{}, ErrInternal
+	}
+
+	return item, nil
+}
+
+func getKitchenItemUpdate(transaction *sqlx.Tx, orderItemId int64) (kitchen.ItemUpdate, error) {
+	const query = `
+	SELECT
+		oi.order_id,
+		oi.id AS order_item_id,
+		oi.item_name,
+		oi.quantity,
+		oi.prep_status,
+		-- Orders without a location belong to the location of the item, if it's sold at only one
+		COALESCE(
+			o.location_id,
+			(
+				SELECT MIN(location_id)
+				FROM item_location
+				WHERE item_id = oi.item_id
+				HAVING COUNT(*) = 1
+			),
+			0
+		) AS location_id,
+		ARRAY(
+			SELECT DISTINCT station_id
+			FROM kitchen_station_item ksi
+			WHERE
+				(
+					ksi.item_id = oi.item_id
+					OR ksi.item_id IN (
+						SELECT item_id
+						FROM order_item_component
+						WHERE order_item_id = oi.id
+					)
+				)
+				AND ksi.location_id = COALESCE(o.location_id, ksi.location_id)
+			ORDER BY station_id
+		) AS station_ids
+	FROM order_item oi
+	JOIN order_data o
+		ON o.id = oi.order_id
+	WHERE oi.id = $1
+	`
+
+	var row struct {
+		OrderId     int64          `db:"order_id"`
+		OrderItemId int64          `db:"order_item_id"`
+		Name        string         `db:"item_name"`
+		Quantity    order.Quantity `db:"quantity"`
+		Status      string         `db:"prep_status"`
+		LocationId  int64          `db:"location_id"`
+		StationIds  pq.Int64Array  `db:"station_ids"`
+	}
+	err := transaction.Get(&row, query, orderItemId)
+	if err != nil {
+		slog.Error(err.Error())
+		return kitchen.ItemUpdate{}, ErrInternal
+	}
+
+	return kitchen.ItemUpdate{
+		OrderId:     row.OrderId,
+		OrderItemId: row.OrderItemId,
+		Name:        row.Name,
+		Quantity:    row.Quantity,
+		Status:      kitchen.ParsePrepStatus(row.Status),
+		LocationId:  row.LocationId,
+		StationIds:  []int64(row.StationIds),
+	}, nil
+}
+
+// Kitchen events go through app_event like the rest, so the screens connected to any server get them.
+func recordKitchenEvent(execer sqlx.Execer, eventType kitchen.EventType, item kitchen.ItemUpdate) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	const statement = `
+	INSERT INTO app_event (business_id, location_id, type, data)
+		SELECT
+			employee.business_id,
+			NULLIF($2, 0),
+			$3,
+			$4::jsonb
+		FROM order_data
+		JOIN employee
+			ON employee.id = order_data.employee_id
+		WHERE order_data.id = $1
+	`
+
+	_, err = execer.Exec(statement, item.OrderId, item.LocationId, string(eventType.AppEventType()), string(data))
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// events.EventRepo implementation -----------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetEventsAfter(businessId int64, afterId int64, limit uint64) ([]events.Event, error) {
+	cursor := eventCursor{}
+	{
+		const query = `
+		SELECT (xid::TEXT)::BIGINT AS xid, id
+		FROM app_event
+		WHERE id = $1 AND business_id = $2
+		`
+
+		err := pdb.Db.Get(&cursor, query, afterId, businessId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return []events.Event{}, events.ErrEventExpired
+		} else if err != nil {
+			slog.Error(err.Error())
+			return []events.Event{}, ErrInternal
+		}
+	}
+
+	rows, err := getEventsAfter(pdb.Db, &businessId, cursor, limit)
+	if err != nil {
+		return []events.Event{}, err
+	}
+
+	appEvents := make([]events.Event, 0, len(rows))
+	for _, row := range rows {
+		appEvents = append(appEvents, row.Event)
+	}
+
+	return appEvents, nil
+}
+
+func (pdb PostgresDb) GetLastEventId(businessId int64) (int64, error) {
+	cursor, err := getLastEventCursor(pdb.Db, &businessId)
+	if err != nil {
+		return 0, err
+	}
+
+	return cursor.Id, nil
+}
+
+// Position of an event in the stream. Events are streamed in the order of the transactions that
+// wrote them, and only once every older transaction has finished, so no event can later appear
+// before an event that was already streamed.
+type eventCursor struct {
+	Xid int64 `db:"xid"`
+	Id  int64 `db:"id"`
+}
+
+type appEventRow struct {
+	events.Event
+	Xid int64 `db:"xid"`
+}
+
+// Events of all businesses are returned if businessId is nil.
+func getEventsAfter(queryer sqlx.Queryer, businessId *int64, after eventCursor, limit uint64) ([]appEventRow, error) {
+	const query = `
+	SELECT id, business_id, location_id, type, data, (xid::TEXT)::BIGINT AS xid
+	FROM app_event
+	WHERE
+		($1::bigint IS NULL OR business_id = $1::bigint)
+		AND (xid, id) > (($2::TEXT)::xid8, $3)
+		AND xid < pg_snapshot_xmin(pg_current_snapshot())
+	ORDER BY xid, id
+	LIMIT $4
+	`
+
+	rows := []appEventRow{}
+	err := sqlx.Select(queryer, &rows, query, businessId, after.Xid, after.Id, limit)
+	if err != nil {
+		slog.Error(err.Error())
+		return []appEventRow{}, ErrInternal
+	}
+
+	return rows, nil
+}
+
+// The zero cursor is returned if there are no events.
+func getLastEventCursor(queryer sqlx.Queryer, businessId *int64) (eventCursor, error) {
+	const query = `
+	SELECT (xid::TEXT)::BIGINT AS xid, id
+	FROM app_event
+	WHERE
+		($1::bigint IS NULL OR business_id = $1::bigint)
+		AND xid < pg_snapshot_xmin(pg_current_snapshot())
+	ORDER BY xid DESC, id DESC
+	LIMIT 1
+	`
+
+	cursor := eventCursor{}
+	err := sqlx.Get(queryer, &cursor, query, businessId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error(err.Error())
+		return eventCursor{}, ErrInternal
+	}
+
+	return cursor, nil
+}
+
+// -------------------------------------------------------------------------------------------------
+// product.CatalogRepo implementation --------------------------------------------------------------
+// -------------------------------------------------------------------------------------------------
+
+func (pdb PostgresDb) GetProductBusiness(productId int64) (int64, error) {
+	const query = `
+	SELECT business_id
+	FROM item
+	WHERE id = $1
+	`
+	return getCatalogBusiness(pdb.Db, query, productId, product.ErrProductNotFound)
+}
+
+func (pdb PostgresDb) GetVariationBusiness(variationId int64) (int64, error) {
+	const query = `
+	SELECT item.business_id
+	FROM item_variation
+	JOIN item
+		ON item.id = item_variation.item_id
+	WHERE item_variation.id = $1
+	`
+	return getCatalogBusiness(pdb.Db, query, variationId, product.ErrVariationNotFound)
+}
+
+func (pdb PostgresDb) GetVariationGroupBusiness(groupId int64) (int64, error) {
+	const query = `
+	SELECT item.business_id
+	FROM variation_group
+	JOIN item
+		ON item.id = variation_group.item_id
+	WHERE variation_group.id = $1
+	`
+	return getCatalogBusiness(pdb.Db, query, groupId, product.ErrGroupNotFound)
+}
+
+func (pdb PostgresDb) GetBundleSlotBusiness(slotId int64) (int64, error) {
+	const query = `
+	SELECT item.business_id
+	FROM bundle_slot
+	JOIN item
+		ON item.id = bundle_slot.bundle_id
+	WHERE bundle_slot.id = $1
+	`
+	return getCatalogBusiness(pdb.Db, query, slotId, product.ErrSlotNotFound)
+}
+
+func (pdb PostgresDb) GetPricingRuleBusiness(pricingRuleId int64) (int64, error) {
+	const query = `
+	SELECT business_id
+	FROM pricing_rule
+	WHERE id = $1
+	`
+	return getCatalogBusiness(pdb.Db, query, pricingRuleId, product.ErrPricingRuleNotFound)
+}
+
+func (pdb PostgresDb) GetPriceListBusiness(priceListId int64) (int64, error) {
+	const query = `
+	SELECT business_id
+	FROM price_list
+	WHERE id = $1
+	`
+	return getCatalogBusiness(pdb.Db, query, priceListId, product.ErrPriceListNotFound)
+}
+
+func (pdb PostgresDb) GetPriceChangeBusiness(priceChangeId int64) (int64, error) {
+	const query = `
+	SELECT item.business_id
+	FROM item_price_change
+	JOIN item
+		ON item.id = item_price_change.item_id
+	WHERE item_price_change.id = $1
+	`
+	return getCatalogBusiness(pdb.Db, query, priceChangeId, product.ErrPriceChangeNotFound)
+}
+
+// Returns notFound if the query has no rows.
+func getCatalogBusiness(queryer sqlx.Queryer, query string, id int64, notFound error) (int64, error) {
+	var businessId int64
+	err := sqlx.Get(queryer, &businessId, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, notFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return businessId, nil
+}
+
+func (pdb PostgresDb) GetCategoryBusiness(categoryId int64) (int64, error) {
+	const query = `
+	SELECT business_id
+	FROM category
+	WHERE id = $1
+	`
+	return getCatalogBusiness(pdb.Db, query, categoryId, product.ErrCategoryNotFound)
+}
+
+func (pdb PostgresDb) CreateProduct(p product.ProductUpdate) (int64, error) {
+	const statement = `
+	WITH created AS (
+		INSERT INTO item (name, business_id, price_per_unit, vat, unit, sku)
+			SELECT $2, business_id, $3, ($4::DECIMAL(6, 2) * 0.01::DECIMAL(6, 2))::DECIMAL(4, 2), $5::measure_unit, NULLIF($6, '')
+			FROM location
+			WHERE id = $1
+		RETURNING id
+	), listed AS (
+		INSERT INTO item_location (item_id, location_id)
+			SELECT id, $1
+			FROM created
+	)
+	SELECT id
+	FROM created
+	`
+
+	var id int64
+	err := pdb.Db.Get(&id, statement, p.LocationId, p.Name, p.BasePrice, p.Vat, p.Unit.DbValue(), p.Sku)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, product.ErrLocationNotFound
+	} else if conflict := productConflict(err); conflict != nil {
+		return 0, conflict
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return id, nil
+}
+
+// Order lines keep the prices they were added with.
+func (pdb PostgresDb) UpdateProduct(productId int64, p product.ProductUpdate) error {
+	var unit *string
+	if p.Unit != nil {
+		dbUnit := p.Unit.DbValue()
+		unit = &dbUnit
+	}
+
+	const statement = `
+	UPDATE item
+	SET
+		name           = COALESCE($2, name),
+		price_per_unit = COALESCE($3, price_per_unit),
+		vat            = COALESCE(($4::DECIMAL(6, 2) * 0.01::DECIMAL(6, 2))::DECIMAL(4, 2), vat),
+		unit           = COALESCE($5::measure_unit, unit),
+		sku            = CASE WHEN $6::TEXT IS NULL THEN sku ELSE NULLIF($6::TEXT, '') END
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, productId, p.Name, p.BasePrice, p.Vat, unit, p.Sku)
+	if conflict := productConflict(err); conflict != nil {
+		return conflict
+	} else if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrProductNotFound
+	}
+
+	return nil
+}
+
+// Maps unique violations on item to the product errors, other errors give nil.
+func productConflict(err error) error {
+	switch violatedUniqueConstraint(err) {
+	case "unique_item_name":
+		return product.ErrProductExists
+	case "unique_item_sku":
+		return product.ErrSkuExists
+	}
+	return nil
+}
+
+// Archived products are hidden from the catalog, order lines that use them are left as they are.
+func (pdb PostgresDb) ArchiveProduct(productId int64) error {
+	const statement = `
+	UPDATE item
+	SET status = 'ARCHIVED'
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, productId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrProductNotFound
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) CreateVariation(productId int64, variation product.VariationUpdate) (int64, error) {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	if variation.GroupId != nil && *variation.GroupId > 0 {
+		if err := checkVariationGroupOfProduct(transaction, productId, *variation.GroupId); err != nil {
+			_ = transaction.Rollback()
+			return 0, err
+		}
+	}
 
-				const deleteRefundData = `DELETE FROM refund_data WHERE order_id = $1`
-				if _, err := transaction.Exec(deleteRefundData, id); err != nil {
-					slog.Error("Failed to delete refund_data for disapproved refund", "error", err, "order_id", id)
-					return rollback(ErrInternal)
-				}
-			} else {
-				const updateAppointment = `
-				UPDATE appointment
-				SET status = 'COMPLETED'
-				WHERE id = $1 AND status = 'REFUND_PENDING'
-				`
-				res, err := transaction.Exec(updateAppointment, id)
-				if err != nil {
-					slog.Error("Failed to update appointment status for disapproved refund", "error", err, "appointment_id", id)
-					return rollback(ErrInternal)
-				}
-				rows, err := res.RowsAffected()
-				if err != nil || rows != 1 {
-					slog.Error("Unexpected rows affected when disapproving reservation refund", "rows", rows, "error", err, "appointment_id", id)
-					return rollback(errors.New("refund not found"))
-				}
+	const statement = `
+	INSERT INTO item_variation (item_id, name, price_difference, group_id, is_default)
+		SELECT id, $2, $3, NULLIF($4, 0), COALESCE($5, FALSE)
+		FROM item
+		WHERE id = $1
+	RETURNING id
+	`
 
-				const deleteRefundData = `DELETE FROM reservation_refund_data WHERE appointment_id = $1`
-				if _, err := transaction.Exec(deleteRefundData, id); err != nil {
-					slog.Error("Failed to delete reservation_refund_data for disapproved refund", "error", err, "appointment_id", id)
-					return rollback(ErrInternal)
-				}
-			}
+	var id int64
+	err = transaction.Get(&id, statement, productId, variation.Name, variation.PriceModifier, variation.GroupId, variation.Default)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = transaction.Rollback()
+		return 0, product.ErrProductNotFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		_ = transaction.Rollback()
+		return 0, ErrInternal
+	}
+
+	if variation.GroupId != nil && *variation.GroupId > 0 {
+		if err := checkVariationGroupDefaults(transaction, *variation.GroupId); err != nil {
+			_ = transaction.Rollback()
+			return 0, err
 		}
-	case refund.StatusCompleted:
-		{
-			if isOrder {
-				const updateOrder = `
-				UPDATE order_data
-				SET status = 'REFUNDED'
-				WHERE id = $1 AND status = 'REFUND_PENDING'
-				`
-				res, err := transaction.Exec(updateOrder, id)
-				if err != nil {
-					slog.Error("Failed to update order status for completed refund", "error", err, "order_id", id)
-					return rollback(ErrInternal)
-				}
-				rows, err := res.RowsAffected()
-				if err != nil || rows != 1 {
-					slog.Error("Unexpected rows affected when completing refund", "rows", rows, "error", err, "order_id", id)
-					return rollback(errors.New("refund not found"))
-				}
+	}
 
-				const deleteRefundData = `DELETE FROM refund_data WHERE order_id = $1`
-				if _, err := transaction.Exec(deleteRefundData, id); err != nil {
-					slog.Error("Failed to delete refund_data for completed refund", "error", err, "order_id", id)
-					return rollback(ErrInternal)
-				}
-			} else {
-				const updateAppointment = `
-				UPDATE appointment
-				SET status = 'REFUNDED'
-				WHERE id = $1 AND status = 'REFUND_PENDING'
-				`
-				res, err := transaction.Exec(updateAppointment, id)
-				if err != nil {
-					slog.Error("Failed to update appointment status for completed refund", "error", err, "appointment_id", id)
-					return rollback(ErrInternal)
-				}
-				rows, err := res.RowsAffected()
-				if err != nil || rows != 1 {
-					slog.Error("Unexpected rows affected when completing reservation refund", "rows", rows, "error", err, "appointment_id", id)
-					return rollback(errors.New("refund not found"))
-				}
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
 
-				const deleteRefundData = `DELETE FROM reservation_refund_data WHERE appointment_id = $1`
-				if _, err := transaction.Exec(deleteRefundData, id); err != nil {
-					slog.Error("Failed to delete reservation_refund_data for completed refund", "error", err, "appointment_id", id)
-					return rollback(ErrInternal)
-				}
-			}
+	return id, nil
+}
+
+func (pdb PostgresDb) UpdateVariation(variationId int64, variation product.VariationUpdate) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	if variation.GroupId != nil && *variation.GroupId > 0 {
+		const query = `
+		SELECT item_id
+		FROM item_variation
+		WHERE id = $1
+		`
+
+		var productId int64
+		err := transaction.Get(&productId, query, variationId)
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = transaction.Rollback()
+			return product.ErrVariationNotFound
+		} else if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
 		}
-	default:
-		{
-			if isOrder {
-				const ensurePending = `
-				SELECT id
-				FROM order_data
-				WHERE id = $1 AND status = 'REFUND_PENDING'
-				LIMIT 1
-				`
-				var orderID int64
-				if err := transaction.Get(&orderID, ensurePending, id); err != nil {
-					if errors.Is(err, sql.ErrNoRows) {
-						return rollback(errors.New("refund not found"))
-					}
-					slog.Error("Failed to verify refund status update", "error", err, "order_id", id)
-					return rollback(ErrInternal)
-				}
-			} else {
-				const ensurePending = `
-				SELECT id
-				FROM appointment
-				WHERE id = $1 AND status = 'REFUND_PENDING'
-				LIMIT 1
-				`
-				var appointmentID int64
-				if err := transaction.Get(&appointmentID, ensurePending, id); err != nil {
-					if errors.Is(err, sql.ErrNoRows) {
-						return rollback(errors.New("refund not found"))
-					}
-					slog.Error("Failed to verify reservation refund status update", "error", err, "appointment_id", id)
-					return rollback(ErrInternal)
-				}
-			}
+
+		if err := checkVariationGroupOfProduct(transaction, productId, *variation.GroupId); err != nil {
+			_ = transaction.Rollback()
+			return err
+		}
+	}
+
+	const statement = `
+	UPDATE item_variation
+	SET
+		name             = COALESCE($2, name),
+		price_difference = COALESCE($3, price_difference),
+		group_id         = CASE WHEN $4::INTEGER IS NULL THEN group_id ELSE NULLIF($4, 0) END,
+		is_default       = COALESCE($5, is_default)
+	WHERE id = $1
+	RETURNING group_id
+	`
+
+	var groupId *int64
+	err = transaction.Get(&groupId, statement, variationId, variation.Name, variation.PriceModifier, variation.GroupId, variation.Default)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = transaction.Rollback()
+		return product.ErrVariationNotFound
+	} else if err != nil {
+		slog.Error(err.Error())
+		_ = transaction.Rollback()
+		return ErrInternal
+	}
+
+	if groupId != nil {
+		if err := checkVariationGroupDefaults(transaction, *groupId); err != nil {
+			_ = transaction.Rollback()
+			return err
 		}
 	}
 
-	if err := transaction.Commit(); err != nil {
-		slog.Error("Failed to commit refund status update", "error", err, "id", id)
-		return nil, ErrInternal
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) ArchiveVariation(variationId int64) error {
+	const statement = `
+	UPDATE item_variation
+	SET status = 'ARCHIVED'
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, variationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrVariationNotFound
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) GetVariationGroups(productId int64) ([]order.VariationGroup, error) {
+	if err := checkProductExists(pdb.Db, productId); err != nil {
+		return []order.VariationGroup{}, err
+	}
+
+	return getVariationGroups(pdb.Db, productId)
+}
+
+func (pdb PostgresDb) CreateVariationGroup(productId int64, group product.VariationGroupUpdate) (int64, error) {
+	if err := checkProductExists(pdb.Db, productId); err != nil {
+		return 0, err
+	}
+
+	const statement = `
+	INSERT INTO variation_group (item_id, name, min_selected, max_selected, position)
+		VALUES ($1, $2, $3, $4, $5)
+	RETURNING id
+	`
+
+	var id int64
+	err := pdb.Db.Get(&id, statement, productId, group.Name, group.MinSelected, group.MaxSelected, group.Position)
+	if isUniqueViolation(err) {
+		return 0, product.ErrGroupExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return id, nil
+}
+
+func (pdb PostgresDb) UpdateVariationGroup(groupId int64, group product.VariationGroupUpdate) error {
+	transaction, err := pdb.Db.Beginx()
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	const statement = `
+	UPDATE variation_group
+	SET
+		name         = $2,
+		min_selected = $3,
+		max_selected = $4,
+		position     = $5
+	WHERE id = $1
+	`
+
+	res, err := transaction.Exec(statement, groupId, group.Name, group.MinSelected, group.MaxSelected, group.Position)
+	if isUniqueViolation(err) {
+		_ = transaction.Rollback()
+		return product.ErrGroupExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		_ = transaction.Rollback()
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		_ = transaction.Rollback()
+		return product.ErrGroupNotFound
+	}
+
+	if err := checkVariationGroupDefaults(transaction, groupId); err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) DeleteVariationGroup(groupId int64) error {
+	const statement = `
+	DELETE FROM variation_group
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, groupId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrGroupNotFound
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) GetAllCategories(businessId int64) ([]product.Category, error) {
+	const query = `
+	SELECT id, name
+	FROM category
+	WHERE business_id = $1
+	ORDER BY name
+	`
+
+	categories := []product.Category{}
+	err := pdb.Db.Select(&categories, query, businessId)
+	if err != nil {
+		slog.Error(err.Error())
+		return []product.Category{}, ErrInternal
+	}
+
+	return categories, nil
+}
+
+func (pdb PostgresDb) CreateCategory(businessId int64, name string) (int64, error) {
+	if err := checkBusinessExists(pdb.Db, businessId); err != nil {
+		return 0, err
+	}
+
+	const statement = `
+	INSERT INTO category (business_id, name)
+		VALUES ($1, $2)
+	RETURNING id
+	`
+
+	var id int64
+	err := pdb.Db.Get(&id, statement, businessId, name)
+	if isUniqueViolation(err) {
+		return 0, product.ErrCategoryExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return id, nil
+}
+
+func (pdb PostgresDb) RenameCategory(categoryId int64, name string) error {
+	const statement = `
+	UPDATE category
+	SET name = $2
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, categoryId, name)
+	if isUniqueViolation(err) {
+		return product.ErrCategoryExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrCategoryNotFound
+	}
+
+	return nil
+}
+
+// Products are taken out of the category. Categories of kitchen stations are kept,
+// a station without categories would get every item.
+func (pdb PostgresDb) DeleteCategory(categoryId int64) error {
+	const statement = `
+	DELETE FROM category
+	WHERE
+		id = $1
+		AND NOT EXISTS (
+			SELECT 1
+			FROM kitchen_station_category
+			WHERE category_id = $1
+		)
+	`
+
+	res, err := pdb.Db.Exec(statement, categoryId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 1 {
+		return nil
+	}
+
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM category
+		WHERE id = $1
+	)
+	`
+	var exists bool
+	if err := pdb.Db.Get(&exists, query, categoryId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
 	}
+	if exists {
+		return product.ErrCategoryInUse
+	}
+	return product.ErrCategoryNotFound
+}
 
-	result := &refund.Refund{
-		ID:             id,
-		RefundType:     refundRecord.RefundType,
-		Status:         status,
-		StripeRefundID: stripeRefundID,
+func (pdb PostgresDb) AddToCategory(productId int64, categoryId int64) error {
+	{
+		const query = `
+		SELECT
+			EXISTS (SELECT 1 FROM item WHERE id = $1) AS product_exists,
+			EXISTS (
+				SELECT 1
+				FROM category
+				JOIN item
+					ON item.business_id = category.business_id
+				WHERE
+					category.id = $2
+					AND item.id = $1
+			) AS category_exists
+		`
+		var exists struct {
+			Product  bool `db:"product_exists"`
+			Category bool `db:"category_exists"`
+		}
+		if err := pdb.Db.Get(&exists, query, productId, categoryId); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if !exists.Product {
+			return product.ErrProductNotFound
+		}
+		if !exists.Category {
+			return product.ErrCategoryNotFound
+		}
 	}
 
-	if isOrder {
-		result.OrderID = id
-	} else {
-		result.ReservationID = id
+	const statement = `
+	INSERT INTO item_category (item_id, category_id)
+		VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`
+
+	_, err := pdb.Db.Exec(statement, productId, categoryId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
 	}
 
-	return result, nil
+	return nil
 }
 
-func (pdb PostgresDb) GetOrderItems(orderId int64) ([]order.Item, error) {
-	const query = `
-	SELECT id, item_id, quantity
-	FROM order_item
-	WHERE order_id = $1
+// Removing a product from a category it's not in is not an error.
+func (pdb PostgresDb) RemoveFromCategory(productId int64, categoryId int64) error {
+	const statement = `
+	DELETE FROM item_category
+	WHERE
+		item_id = $1
+		AND category_id = $2
 	`
-	var itemsDetails []struct {
-		Id       int64  `db:"id"`
-		ItemId   int64  `db:"item_id"`
-		Quantity uint16 `db:"quantity"`
-	}
 
-	err := pdb.Db.Select(&itemsDetails, query, orderId)
+	_, err := pdb.Db.Exec(statement, productId, categoryId)
 	if err != nil {
 		slog.Error(err.Error())
-		return []order.Item{}, ErrInternal
+		return ErrInternal
 	}
 
-	items := make([]order.Item, len(itemsDetails))
-	for i := range itemsDetails {
-		items[i].Id = itemsDetails[i].Id
-		items[i].Quantity = itemsDetails[i].Quantity
-		items[i].SelectedVariations = []order.Variation{}
-		items[i].Product.Variations = []order.Variation{}
-		items[i].Product.Categories = []string{}
+	return nil
+}
 
-		const productQuery = `
-		SELECT id, name, price_per_unit
-		FROM item
-		WHERE id = $1
-		LIMIT 1
+func (pdb PostgresDb) FindByBarcode(locationId int64, code string) (product.BarcodeMatch, error) {
+	var row struct {
+		order.Product
+		VariationId *int64 `db:"variation_id"`
+	}
+	{
+		const query = `
+		SELECT
+			item.id,
+			item.name,
+			item_price(item.id, item_location.location_id, NULL) AS price_per_unit,
+			(item.vat * 100)::BIGINT AS vat,
+			LOWER(item.unit::TEXT) AS unit,
+			COALESCE(item.sku, '') AS sku,
+			item_barcode.variation_id
+		FROM item_barcode
+		JOIN item
+			ON item.id = item_barcode.item_id
+		JOIN item_location
+			ON item_location.item_id = item.id
+			AND item_location.location_id = $1
+			AND item_location.available
+		LEFT JOIN item_variation
+			ON item_variation.id = item_barcode.variation_id
+		WHERE
+			item_barcode.business_id = (SELECT business_id FROM location WHERE id = $1)
+			AND item_barcode.code = $2
+			AND item.status = 'ACTIVE'
+			AND (item_variation.id IS NULL OR item_variation.status = 'ACTIVE')
 		`
-
-		err = pdb.Db.Get(&items[i].Product, productQuery, itemsDetails[i].ItemId)
-		if err != nil {
+		err := pdb.Db.Get(&row, query, locationId, code)
+		if errors.Is(err, sql.ErrNoRows) {
+			return product.BarcodeMatch{}, product.ErrBarcodeNotFound
+		} else if err != nil {
 			slog.Error(err.Error())
-			return []order.Item{}, ErrInternal
+			return product.BarcodeMatch{}, ErrInternal
 		}
+	}
 
-		const selectedVariationQuery = `
-		SELECT item_variation.id, item_variation.name, price_difference
-		FROM order_item
-		JOIN order_item_variation
-			ON order_item.id = order_item_variation.order_item_id
-		JOIN item_variation
-			ON order_item_variation.variation_id = item_variation.id
-		WHERE 
-			order_item.order_id = $1
-			AND order_item.item_id = $2;
-		`
+	match := product.BarcodeMatch{
+		Product:            row.Product,
+		SelectedVariations: []order.Variation{},
+	}
+	if err := getProductDetails(pdb.Db, &match.Product); err != nil {
+		return product.BarcodeMatch{}, err
+	}
 
-		err = pdb.Db.Select(&items[i].SelectedVariations, selectedVariationQuery, orderId, itemsDetails[i].ItemId)
-		if err != nil {
-			slog.Error(err.Error())
-			return []order.Item{}, ErrInternal
+	if row.VariationId != nil {
+		for _, variation := range match.Product.Variations {
+			if variation.Id == *row.VariationId {
+				match.SelectedVariations = append(match.SelectedVariations, variation)
+			}
 		}
 	}
 
-	return items, nil
+	return match, nil
 }
 
-// -------------------------------------------------------------------------------------------------
-// order.ProductRepo implimentation ----------------------------------------------------------------
-// -------------------------------------------------------------------------------------------------
+func (pdb PostgresDb) GetBarcodes(productId int64) ([]product.Barcode, error) {
+	const query = `
+	SELECT code, variation_id
+	FROM item_barcode
+	WHERE item_id = $1
+	ORDER BY id
+	`
 
-func (pdb PostgresDb) GetProducts(filter order.ProductFilter) ([]order.Product, error) {
-	var filteredProducts []order.Product
+	barcodes := []product.Barcode{}
+	if err := pdb.Db.Select(&barcodes, query, productId); err != nil {
+		slog.Error(err.Error())
+		return []product.Barcode{}, ErrInternal
+	}
+
+	return barcodes, nil
+}
+
+func (pdb PostgresDb) AddBarcode(productId int64, barcode product.Barcode) (int64, error) {
 	{
 		const query = `
-		SELECT 
-			item.id,
-			item.name,
-			price_per_unit,
-			(vat * 100)::BIGINT AS vat
-		FROM item
-		JOIN item_category
-			ON item.id = item_id
-		JOIN category
-			ON category.id = category_id
-		WHERE 
-			item.status = 'ACTIVE'
-			AND item.location_id = $1
-			AND ($2::text IS NULL OR category.name = $2::text)
-		ORDER BY
-			item.name ASC
+		SELECT
+			EXISTS (SELECT 1 FROM item WHERE id = $1) AS product_exists,
+			$2::INTEGER IS NULL OR EXISTS (
+				SELECT 1
+				FROM item_variation
+				WHERE
+					id = $2
+					AND item_id = $1
+			) AS variation_exists
 		`
-
-		err := pdb.Db.Select(&filteredProducts, query, filter.LocationId, filter.Category)
-		if err != nil {
+		var exists struct {
+			Product   bool `db:"product_exists"`
+			Variation bool `db:"variation_exists"`
+		}
+		if err := pdb.Db.Get(&exists, query, productId, barcode.VariationId); err != nil {
 			slog.Error(err.Error())
-			return []order.Product{}, ErrInternal
+			return 0, ErrInternal
+		}
+		if !exists.Product {
+			return 0, product.ErrProductNotFound
+		}
+		if !exists.Variation {
+			return 0, product.ErrVariationNotFound
 		}
 	}
-	{
-		const query = `
-		SELECT category.name
-		FROM item_category
-		JOIN category
-			ON item_category.item_id = $1
-			AND category.id = category_id
-		`
 
-		for i := range filteredProducts {
-			err := pdb.Db.Select(&filteredProducts[i].Categories, query, filteredProducts[i].Id)
-			if err != nil {
-				slog.Error(err.Error())
-				return []order.Product{}, ErrInternal
-			}
-		}
+	const statement = `
+	INSERT INTO item_barcode (business_id, item_id, variation_id, code)
+		SELECT business_id, id, $2, $3
+		FROM item
+		WHERE id = $1
+	RETURNING id
+	`
+
+	var id int64
+	err := pdb.Db.Get(&id, statement, productId, barcode.VariationId, barcode.Code)
+	if isUniqueViolation(err) {
+		return 0, product.ErrBarcodeExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return id, nil
+}
+
+func (pdb PostgresDb) RemoveBarcode(productId int64, code string) error {
+	const statement = `
+	DELETE FROM item_barcode
+	WHERE
+		item_id = $1
+		AND code = $2
+	`
+
+	res, err := pdb.Db.Exec(statement, productId, code)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrBarcodeNotFound
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) GetProductLocations(productId int64) ([]product.ProductLocation, error) {
+	if err := checkProductExists(pdb.Db, productId); err != nil {
+		return []product.ProductLocation{}, err
+	}
+
+	const query = `
+	SELECT
+		location_id,
+		available,
+		price_per_unit AS price
+	FROM item_location
+	WHERE item_id = $1
+	ORDER BY location_id
+	`
+
+	locations := []product.ProductLocation{}
+	if err := pdb.Db.Select(&locations, query, productId); err != nil {
+		slog.Error(err.Error())
+		return []product.ProductLocation{}, ErrInternal
 	}
+
+	return locations, nil
+}
+
+func (pdb PostgresDb) SetProductLocation(productId int64, location product.ProductLocation) error {
 	{
 		const query = `
-		SELECT id, name, price_difference
-		FROM item_variation
-		WHERE item_id = $1
+		SELECT
+			EXISTS (SELECT 1 FROM item WHERE id = $1) AS product_exists,
+			EXISTS (
+				SELECT 1
+				FROM location
+				JOIN item
+					ON item.business_id = location.business_id
+				WHERE
+					location.id = $2
+					AND item.id = $1
+			) AS location_exists
 		`
+		var exists struct {
+			Product  bool `db:"product_exists"`
+			Location bool `db:"location_exists"`
+		}
+		if err := pdb.Db.Get(&exists, query, productId, location.LocationId); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if !exists.Product {
+			return product.ErrProductNotFound
+		}
+		if !exists.Location {
+			return product.ErrLocationNotFound
+		}
+	}
 
-		for i := range filteredProducts {
-			err := pdb.Db.Select(&filteredProducts[i].Variations, query, filteredProducts[i].Id)
-			if err != nil {
-				slog.Error(err.Error())
-				return []order.Product{}, ErrInternal
-			}
+	const statement = `
+	INSERT INTO item_location (item_id, location_id, available, price_per_unit)
+		VALUES ($1, $2, $3, $4)
+	ON CONFLICT (item_id, location_id) DO UPDATE
+	SET
+		available = EXCLUDED.available,
+		price_per_unit = EXCLUDED.price_per_unit
+	`
+
+	_, err := pdb.Db.Exec(statement, productId, location.LocationId, location.Available, location.Price)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+// Open orders at the location keep the product, it just can't be added anymore.
+func (pdb PostgresDb) RemoveProductLocation(productId int64, locationId int64) error {
+	const statement = `
+	DELETE FROM item_location
+	WHERE
+		item_id = $1
+		AND location_id = $2
+	`
+
+	res, err := pdb.Db.Exec(statement, productId, locationId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		if err := checkProductExists(pdb.Db, productId); err != nil {
+			return err
 		}
+		return product.ErrNotSoldAtLocation
 	}
 
-	return filteredProducts, nil
+	return nil
 }
 
-func (pdb PostgresDb) GetCategories(locationId int64) ([]string, error) {
-	categories := []string{}
+func (pdb PostgresDb) GetPriceLists(businessId int64) ([]product.PriceList, error) {
+	if err := checkBusinessExists(pdb.Db, businessId); err != nil {
+		return []product.PriceList{}, err
+	}
 
-	query := `
-	SELECT DISTINCT(category.name)
-	FROM item
-	JOIN item_category
-		ON item_category.item_id = item.id
-	JOIN category
-		ON category.id = item_category.category_id
-	WHERE item.location_id = $1
-	ORDER BY category.name
+	const query = `
+	SELECT id, name
+	FROM price_list
+	WHERE business_id = $1
+	ORDER BY name
+	`
+
+	priceLists := []product.PriceList{}
+	if err := pdb.Db.Select(&priceLists, query, businessId); err != nil {
+		slog.Error(err.Error())
+		return []product.PriceList{}, ErrInternal
+	}
+
+	return priceLists, nil
+}
+
+func (pdb PostgresDb) CreatePriceList(businessId int64, name string) (int64, error) {
+	if err := checkBusinessExists(pdb.Db, businessId); err != nil {
+		return 0, err
+	}
+
+	const statement = `
+	INSERT INTO price_list (business_id, name)
+		VALUES ($1, $2)
+	RETURNING id
+	`
+
+	var id int64
+	err := pdb.Db.Get(&id, statement, businessId, name)
+	if isUniqueViolation(err) {
+		return 0, product.ErrPriceListExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return id, nil
+}
+
+func (pdb PostgresDb) RenamePriceList(priceListId int64, name string) error {
+	const statement = `
+	UPDATE price_list
+	SET name = $2
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, priceListId, name)
+	if isUniqueViolation(err) {
+		return product.ErrPriceListExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrPriceListNotFound
+	}
+
+	return nil
+}
+
+// Lines keep the prices they were added with, but open orders still price new lines by the list,
+// so they block the delete.
+func (pdb PostgresDb) DeletePriceList(priceListId int64) error {
+	const statement = `
+	DELETE FROM price_list
+	WHERE
+		id = $1
+		AND NOT EXISTS (
+			SELECT 1
+			FROM order_data
+			WHERE
+				price_list_id = $1
+				AND status = 'OPEN'
+		)
+	`
+
+	res, err := pdb.Db.Exec(statement, priceListId)
+	if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 1 {
+		return nil
+	}
+
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM price_list
+		WHERE id = $1
+	)
+	`
+	var exists bool
+	if err := pdb.Db.Get(&exists, query, priceListId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if exists {
+		return product.ErrPriceListInUse
+	}
+	return product.ErrPriceListNotFound
+}
+
+func (pdb PostgresDb) GetPriceListItems(priceListId int64) ([]product.PriceListItem, error) {
+	if err := checkPriceListExists(pdb.Db, priceListId); err != nil {
+		return []product.PriceListItem{}, err
+	}
+
+	const query = `
+	SELECT
+		item.id AS item_id,
+		item.name,
+		price_list_item.price_per_unit AS price
+	FROM price_list_item
+	JOIN item
+		ON item.id = price_list_item.item_id
+	WHERE price_list_item.price_list_id = $1
+	ORDER BY item.name
 	`
 
-	err := pdb.Db.Select(&categories, query, locationId)
-	if err != nil {
+	items := []product.PriceListItem{}
+	if err := pdb.Db.Select(&items, query, priceListId); err != nil {
 		slog.Error(err.Error())
-		return []string{}, ErrInternal
+		return []product.PriceListItem{}, ErrInternal
 	}
 
-	return categories, nil
+	return items, nil
 }
 
-func (pdb PostgresDb) GetDefaultVat(locationId int64) (int64, error) {
-	var vat int64
-	query := `
-	SELECT (country.vat * 100)::BIGINT AS vat
-	FROM location
-	JOIN country
-	ON country.code = location.country_code
-	WHERE location.id = $1
-	LIMIT 1
+func (pdb PostgresDb) SetPriceListItem(priceListId int64, productId int64, price int64) error {
+	{
+		const query = `
+		SELECT
+			EXISTS (SELECT 1 FROM price_list WHERE id = $1) AS price_list_exists,
+			EXISTS (
+				SELECT 1
+				FROM item
+				JOIN price_list
+					ON price_list.business_id = item.business_id
+				WHERE
+					item.id = $2
+					AND price_list.id = $1
+			) AS product_exists
+		`
+		var exists struct {
+			PriceList bool `db:"price_list_exists"`
+			Product   bool `db:"product_exists"`
+		}
+		if err := pdb.Db.Get(&exists, query, priceListId, productId); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if !exists.PriceList {
+			return product.ErrPriceListNotFound
+		}
+		if !exists.Product {
+			return product.ErrProductNotFound
+		}
+	}
+
+	const statement = `
+	INSERT INTO price_list_item (price_list_id, item_id, price_per_unit)
+		VALUES ($1, $2, $3)
+	ON CONFLICT (price_list_id, item_id) DO UPDATE
+	SET price_per_unit = EXCLUDED.price_per_unit
 	`
-	err := pdb.Db.Get(&vat, query, locationId)
+
+	_, err := pdb.Db.Exec(statement, priceListId, productId, price)
 	if err != nil {
 		slog.Error(err.Error())
-		return 0, ErrInternal
+		return ErrInternal
 	}
 
-	return vat, nil
+	return nil
 }
 
-func (pdb PostgresDb) SetVat(locationId int64, itemId int64, newVat int64) error {
-	setVatStatement := `
-	UPDATE item
-	SET vat = ($3::DECIMAL(6, 2) * 0.01::DECIMAL(6, 2))::DECIMAL(4, 2)
+// Removing a product that is not on the price list is not an error.
+func (pdb PostgresDb) RemovePriceListItem(priceListId int64, productId int64) error {
+	if err := checkPriceListExists(pdb.Db, priceListId); err != nil {
+		return err
+	}
+
+	const statement = `
+	DELETE FROM price_list_item
 	WHERE
-		id = $2
-		AND location_id = $1
+		price_list_id = $1
+		AND item_id = $2
 	`
-	err := pdb.Db.QueryRow(setVatStatement, locationId, itemId, newVat).Err()
+
+	_, err := pdb.Db.Exec(statement, priceListId, productId)
 	if err != nil {
 		slog.Error(err.Error())
 		return ErrInternal
@@ -1059,1011 +6829,1688 @@ func (pdb PostgresDb) SetVat(locationId int64, itemId int64, newVat int64) error
 	return nil
 }
 
-// -------------------------------------------------------------------------------------------------
-// reservation.ReservationRepo implementation ------------------------------------------------------
-// -------------------------------------------------------------------------------------------------
-
-func (pdb PostgresDb) GetReservations(filter reservation.ReservationFilter) ([]reservation.Reservation, error) {
-	var statusFilter *string
-	if filter.Status != nil {
-		mapped := mapApiReservationStatusToAppointment(*filter.Status)
-		if mapped == "" {
-			return []reservation.Reservation{}, nil
-		}
-		statusFilter = &mapped
-	}
-
-	search := ""
-	if filter.Search != nil {
-		search = strings.TrimSpace(*filter.Search)
+func (pdb PostgresDb) GetPriceChanges(productId int64) ([]product.PriceChange, error) {
+	if err := checkProductExists(pdb.Db, productId); err != nil {
+		return []product.PriceChange{}, err
 	}
 
 	const query = `
-	SELECT 
-		a.id,
-		a.customer_name,
-		a.customer_phone,
-		a.actioned_by,
-		a.appointment_at,
-		a.status,
-		sl.service_id
-	FROM appointment a
-	JOIN service_location sl
-		ON a.service_location_id = sl.id
-	LEFT JOIN service s
-		ON sl.service_id = s.id
-	LEFT JOIN employee e
-		ON a.actioned_by = e.id
-	WHERE 
-		($1::appointment_status IS NULL OR a.status = $1::appointment_status)
-		AND ($2::timestamp IS NULL OR a.appointment_at >= $2::timestamp)
-		AND ($3::timestamp IS NULL OR a.appointment_at <= $3::timestamp)
-		AND (
-			$4 = '' OR
-			a.customer_name ILIKE '%' || $4 || '%' OR
-			a.customer_phone ILIKE '%' || $4 || '%' OR
-			s.name ILIKE '%' || $4 || '%' OR
-			e.first_name ILIKE '%' || $4 || '%' OR
-			e.last_name ILIKE '%' || $4 || '%' OR
-			CAST(a.id AS TEXT) ILIKE '%' || $4 || '%'
-		)
-	ORDER BY
-		a.id DESC
+	SELECT
+		id,
+		location_id,
+		TO_CHAR(effective_date, 'YYYY-MM-DD') AS effective_date,
+		effective_at,
+		price_per_unit AS price,
+		(vat * 100)::BIGINT AS vat,
+		LOWER(fulfillment_type::TEXT) AS fulfillment_type,
+		location_only,
+		applied_at
+	FROM item_price_change
+	WHERE item_id = $1
+	ORDER BY effective_at, id
 	`
 
-	rows := []struct {
-		Id            int32     `db:"id"`
-		CustomerName  string    `db:"customer_name"`
-		CustomerPhone string    `db:"customer_phone"`
-		StaffId       int32     `db:"actioned_by"`
-		ServiceId     int32     `db:"service_id"`
-		Datetime      time.Time `db:"appointment_at"`
-		Status        string    `db:"status"`
-	}{}
-
-	err := pdb.Db.Select(&rows, query, statusFilter, filter.From, filter.To, search)
-	if err != nil {
+	changes := []product.PriceChange{}
+	if err := pdb.Db.Select(&changes, query, productId); err != nil {
 		slog.Error(err.Error())
-		return []reservation.Reservation{}, ErrInternal
+		return []product.PriceChange{}, ErrInternal
 	}
 
-	reservations := make([]reservation.Reservation, 0, len(rows))
-	for _, row := range rows {
-		reservations = append(reservations, reservation.Reservation{
-			Id:            strconv.FormatInt(int64(row.Id), 10),
-			CustomerName:  row.CustomerName,
-			CustomerPhone: row.CustomerPhone,
-			StaffId:       strconv.FormatInt(int64(row.StaffId), 10),
-			ServiceId:     strconv.FormatInt(int64(row.ServiceId), 10),
-			Datetime:      row.Datetime,
-			Status:        mapAppointmentStatusToApi(row.Status),
-		})
-	}
+	return changes, nil
+}
 
-	return reservations, nil
+func (pdb PostgresDb) SchedulePriceChange(productId int64, change product.PriceChangeUpdate) (int64, error) {
+	return schedulePriceChange(pdb.Db, productId, change)
 }
 
-func (pdb PostgresDb) GetReservationCounts(filter reservation.ReservationFilter) (reservation.ReservationCounts, error) {
-	const query = `
-	SELECT 
-		status,
-		COUNT(*) AS count
-	FROM appointment
-	WHERE 
-		($1::timestamp IS NULL OR appointment_at >= $1::timestamp)
-		AND ($2::timestamp IS NULL OR appointment_at <= $2::timestamp)
-	GROUP BY status
+// Changes that are being applied are locked, cancelling one waits until it's done and then fails.
+func (pdb PostgresDb) CancelPriceChange(priceChangeId int64) error {
+	const statement = `
+	DELETE FROM item_price_change
+	WHERE
+		id = $1
+		AND applied_at IS NULL
 	`
 
-	rows := []struct {
-		Status string `db:"status"`
-		Count  int    `db:"count"`
-	}{}
-
-	err := pdb.Db.Select(&rows, query, filter.From, filter.To)
+	res, err := pdb.Db.Exec(statement, priceChangeId)
 	if err != nil {
 		slog.Error(err.Error())
-		return reservation.ReservationCounts{}, ErrInternal
+		return ErrInternal
 	}
-
-	counts := reservation.ReservationCounts{}
-	for _, row := range rows {
-		counts.All += row.Count
-		switch mapAppointmentStatusToApi(row.Status) {
-		case "pending":
-			counts.Pending += row.Count
-		case "confirmed":
-			counts.Confirmed += row.Count
-		case "completed":
-			counts.Completed += row.Count
-		case "cancelled":
-			counts.Cancelled += row.Count
-		case "refund_pending":
-			counts.RefundPending += row.Count
-		}
+	if rows, _ := res.RowsAffected(); rows == 1 {
+		return nil
 	}
 
-	return counts, nil
-}
-
-func (pdb PostgresDb) GetReservationItems(reservationId int32) ([]reservation.Service, error) {
 	const query = `
-	SELECT 
-		s.id          AS service_id,
-		s.name        AS service_name,
-		s.duration_mins,
-		sl.price      AS price
-	FROM appointment a
-	JOIN service_location sl
-		ON a.service_location_id = sl.id
-	JOIN service s
-		ON sl.service_id = s.id
-	WHERE a.id = $1
-	LIMIT 1
+	SELECT EXISTS (
+		SELECT 1
+		FROM item_price_change
+		WHERE id = $1
+	)
 	`
+	var exists bool
+	if err := pdb.Db.Get(&exists, query, priceChangeId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if exists {
+		return product.ErrPriceChangeApplied
+	}
+	return product.ErrPriceChangeNotFound
+}
 
-	var row struct {
-		ServiceId    int32  `db:"service_id"`
-		ServiceName  string `db:"service_name"`
-		DurationMins int    `db:"duration_mins"`
-		Price        int64  `db:"price"`
+func (pdb PostgresDb) GetPriceHistory(productId int64, from *time.Time, to *time.Time) ([]product.PriceHistoryEntry, error) {
+	if err := checkProductExists(pdb.Db, productId); err != nil {
+		return []product.PriceHistoryEntry{}, err
 	}
 
-	err := pdb.Db.Get(&row, query, reservationId)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return []reservation.Service{}, nil
-		}
+	const query = `
+	SELECT
+		location_id,
+		price_list_id,
+		LOWER(fulfillment_type::TEXT) AS fulfillment_type,
+		price_per_unit AS price,
+		(vat * 100)::BIGINT AS vat,
+		changed_at,
+		price_change_id
+	FROM item_price_history
+	WHERE
+		item_id = $1
+		AND ($2::TIMESTAMPTZ IS NULL OR changed_at >= $2::TIMESTAMPTZ)
+		AND ($3::TIMESTAMPTZ IS NULL OR changed_at <= $3::TIMESTAMPTZ)
+	ORDER BY changed_at, id
+	`
+
+	history := []product.PriceHistoryEntry{}
+	if err := pdb.Db.Select(&history, query, productId, from, to); err != nil {
 		slog.Error(err.Error())
-		return []reservation.Service{}, ErrInternal
+		return []product.PriceHistoryEntry{}, ErrInternal
 	}
 
-	return []reservation.Service{
-		{
-			Id:       strconv.FormatInt(int64(row.ServiceId), 10),
-			Name:     row.ServiceName,
-			NameKey:  row.ServiceName,
-			Duration: row.DurationMins,
-			Price:    int(row.Price),
-		},
-	}, nil
+	return history, nil
 }
 
-func (pdb PostgresDb) CreateReservation(res reservation.Reservation) (int32, error) {
-	serviceId, err := strconv.ParseInt(res.ServiceId, 10, 32)
-	if err != nil {
+func (pdb PostgresDb) ApplyPriceChanges() (int64, error) {
+	const statement = `
+	SELECT apply_item_price_changes()
+	`
+
+	var applied int64
+	if err := pdb.Db.Get(&applied, statement); err != nil {
+		slog.Error(err.Error())
 		return 0, ErrInternal
 	}
 
-	// Pick a service_location for the given service (cheapest ID).
-	var serviceLocationId int32
-	{
-		const query = `
-		SELECT id
-		FROM service_location
-		WHERE service_id = $1
-		ORDER BY id
-		LIMIT 1
-		`
-		err := pdb.Db.Get(&serviceLocationId, query, serviceId)
-		if err != nil {
-			slog.Error(err.Error())
-			return 0, ErrInternal
-		}
+	return applied, nil
+}
+
+// Rule as stored in the DB, weekdays are an array of the weekday enum.
+type pricingRuleRow struct {
+	product.PricingRule
+	Weekdays pq.StringArray `db:"weekdays"`
+}
+
+func (pdb PostgresDb) GetPricingRules(businessId int64) ([]product.PricingRule, error) {
+	if err := checkBusinessExists(pdb.Db, businessId); err != nil {
+		return []product.PricingRule{}, err
 	}
 
-	// Resolve staff/employee; fallback to any employee linked to the service.
-	var actionedBy int32
-	staffIdStr := strings.TrimSpace(res.StaffId)
-	if staffIdStr != "" && !strings.EqualFold(staffIdStr, "anyone") {
-		if staffId, err := strconv.ParseInt(staffIdStr, 10, 32); err == nil {
-			actionedBy = int32(staffId)
-		} else {
-			return 0, ErrInternal
-		}
-	} else {
-		const query = `
-		SELECT employee_id
-		FROM service_employee
-		WHERE service_location_id = $1
-		ORDER BY employee_id
-		LIMIT 1
-		`
-		err := pdb.Db.Get(&actionedBy, query, serviceLocationId)
-		if err != nil {
-			slog.Error(err.Error())
-			return 0, ErrInternal
-		}
+	const query = `
+	SELECT
+		id,
+		business_id,
+		name,
+		location_id,
+		item_id,
+		category_id,
+		ARRAY(SELECT LOWER(day::TEXT) FROM UNNEST(weekdays) AS day) AS weekdays,
+		TO_CHAR(start_time, 'HH24:MI') AS start_time,
+		TO_CHAR(end_time, 'HH24:MI') AS end_time,
+		LOWER(adjustment_type::TEXT) AS adjustment_type,
+		adjustment,
+		priority,
+		active
+	FROM pricing_rule
+	WHERE business_id = $1
+	ORDER BY priority DESC, id DESC
+	`
+
+	var rows []pricingRuleRow
+	if err := pdb.Db.Select(&rows, query, businessId); err != nil {
+		slog.Error(err.Error())
+		return []product.PricingRule{}, ErrInternal
 	}
 
-	status := mapApiReservationStatusToAppointment(res.Status)
-	if status == "" {
-		status = "PENDING"
+	rules := make([]product.PricingRule, len(rows))
+	for i, row := range rows {
+		rules[i] = row.PricingRule
+		rules[i].Weekdays = []string(row.Weekdays)
 	}
 
-	const insertQuery = `
-	INSERT INTO appointment (
-		id,
-		service_location_id,
-		actioned_by,
-		customer_name,
-		customer_phone,
-		appointment_at,
-		status
+	return rules, nil
+}
+
+func (pdb PostgresDb) CreatePricingRule(rule product.PricingRule) (int64, error) {
+	if err := checkBusinessExists(pdb.Db, rule.BusinessId); err != nil {
+		return 0, err
+	}
+	if err := checkPricingRuleScope(pdb.Db, rule.BusinessId, rule); err != nil {
+		return 0, err
+	}
+
+	const statement = `
+	INSERT INTO pricing_rule (
+		business_id, name, location_id, item_id, category_id, weekdays,
+		start_time, end_time, adjustment_type, adjustment, priority, active
 	)
-	SELECT
-		COALESCE(MAX(id), 0) + 1,
-		$1, $2, $3, $4, $5, $6
-	FROM appointment
+		VALUES ($1, $2, $3, $4, $5, UPPER($6::TEXT)::weekday[], $7::TIME, $8::TIME, $9::price_adjustment_type, $10, $11, $12)
 	RETURNING id
 	`
 
-	var newId int32
-	err = pdb.Db.Get(&newId, insertQuery,
-		serviceLocationId,
-		actionedBy,
-		res.CustomerName,
-		res.CustomerPhone,
-		res.Datetime,
-		status,
+	var id int64
+	err := pdb.Db.Get(
+		&id,
+		statement,
+		rule.BusinessId,
+		rule.Name,
+		rule.LocationId,
+		rule.ProductId,
+		rule.CategoryId,
+		pq.StringArray(rule.Weekdays),
+		rule.StartTime,
+		rule.EndTime,
+		rule.AdjustmentType.DbValue(),
+		rule.Adjustment,
+		rule.Priority,
+		rule.Active,
 	)
-	if err != nil {
+	if isUniqueViolation(err) {
+		return 0, product.ErrPricingRuleExists
+	} else if err != nil {
 		slog.Error(err.Error())
 		return 0, ErrInternal
 	}
 
-	return newId, nil
+	return id, nil
 }
 
-func (pdb PostgresDb) UpdateReservation(id int32, res reservation.ReservationUpdate) error {
-	var serviceLocationId *int32
-	if res.ServiceId != nil {
-		serviceId, err := strconv.ParseInt(strings.TrimSpace(*res.ServiceId), 10, 32)
-		if err != nil {
-			return ErrInternal
-		}
-
+func (pdb PostgresDb) UpdatePricingRule(pricingRuleId int64, rule product.PricingRule) error {
+	var businessId int64
+	{
 		const query = `
-		SELECT id
-		FROM service_location
-		WHERE service_id = $1
-		ORDER BY id
-		LIMIT 1
+		SELECT business_id
+		FROM pricing_rule
+		WHERE id = $1
 		`
-		var slId int32
-		err = pdb.Db.Get(&slId, query, int32(serviceId))
-		if err != nil {
+		err := pdb.Db.Get(&businessId, query, pricingRuleId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return product.ErrPricingRuleNotFound
+		} else if err != nil {
 			slog.Error(err.Error())
 			return ErrInternal
 		}
-		serviceLocationId = &slId
-	}
-
-	var actionedBy *int32
-	if res.StaffId != nil {
-		staffIdStr := strings.TrimSpace(*res.StaffId)
-		if staffIdStr != "" && !strings.EqualFold(staffIdStr, "anyone") {
-			staffId, err := strconv.ParseInt(staffIdStr, 10, 32)
-			if err != nil {
-				return ErrInternal
-			}
-			empId := int32(staffId)
-			actionedBy = &empId
-		} else {
-			// If "anyone" (or blank) is requested, pick an employee that can perform the current/new service.
-			finalServiceLocationId := int32(0)
-			if serviceLocationId != nil {
-				finalServiceLocationId = *serviceLocationId
-			} else {
-				const query = `
-				SELECT service_location_id
-				FROM appointment
-				WHERE id = $1
-				LIMIT 1
-				`
-				if err := pdb.Db.Get(&finalServiceLocationId, query, id); err != nil {
-					slog.Error(err.Error())
-					return ErrInternal
-				}
-			}
-
-			const pickQuery = `
-			SELECT employee_id
-			FROM service_employee
-			WHERE service_location_id = $1
-			ORDER BY employee_id
-			LIMIT 1
-			`
-			var empId int32
-			if err := pdb.Db.Get(&empId, pickQuery, finalServiceLocationId); err != nil {
-				slog.Error(err.Error())
-				return ErrInternal
-			}
-			actionedBy = &empId
-		}
 	}
-
-	var status *string
-	if res.Status != nil {
-		mapped := mapApiReservationStatusToAppointment(*res.Status)
-		if mapped == "" {
-			return ErrInternal
-		}
-		status = &mapped
+	if err := checkPricingRuleScope(pdb.Db, businessId, rule); err != nil {
+		return err
 	}
 
-	query := `
-	UPDATE appointment
+	const statement = `
+	UPDATE pricing_rule
 	SET
-		service_location_id = COALESCE($2, service_location_id),
-		actioned_by         = COALESCE($3, actioned_by),
-		customer_name       = COALESCE($4, customer_name),
-		customer_phone      = COALESCE($5, customer_phone),
-		appointment_at      = COALESCE($6, appointment_at),
-		status 		        = COALESCE($7::appointment_status, status)
+		name            = $2,
+		location_id     = $3,
+		item_id         = $4,
+		category_id     = $5,
+		weekdays        = UPPER($6::TEXT)::weekday[],
+		start_time      = $7::TIME,
+		end_time        = $8::TIME,
+		adjustment_type = $9::price_adjustment_type,
+		adjustment      = $10,
+		priority        = $11,
+		active          = $12
 	WHERE id = $1
 	`
 
-	_, err := pdb.Db.Exec(query,
-		id,
-		serviceLocationId,
-		actionedBy,
-		res.CustomerName,
-		res.CustomerPhone,
-		res.Datetime,
-		status,
+	res, err := pdb.Db.Exec(
+		statement,
+		pricingRuleId,
+		rule.Name,
+		rule.LocationId,
+		rule.ProductId,
+		rule.CategoryId,
+		pq.StringArray(rule.Weekdays),
+		rule.StartTime,
+		rule.EndTime,
+		rule.AdjustmentType.DbValue(),
+		rule.Adjustment,
+		rule.Priority,
+		rule.Active,
 	)
-	if err != nil {
+	if isUniqueViolation(err) {
+		return product.ErrPricingRuleExists
+	} else if err != nil {
 		slog.Error(err.Error())
 		return ErrInternal
 	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrPricingRuleNotFound
+	}
 
 	return nil
 }
 
-// -------------------------------------------------------------------------------------------------
-// payment.OrderTotalProvider implementation -------------------------------------------------------
-// -------------------------------------------------------------------------------------------------
-
-func (pdb PostgresDb) GetOrderTotal(orderID int64) (int64, string, error) {
-	const query = `
-	SELECT 
-		CAST(ROUND(total_with_tip) AS BIGINT) AS total_cents,
-		currency
-	FROM order_detail
+// Order lines that got the rule keep its name and adjustment.
+func (pdb PostgresDb) DeletePricingRule(pricingRuleId int64) error {
+	const statement = `
+	DELETE FROM pricing_rule
 	WHERE id = $1
-	LIMIT 1
 	`
 
-	var row struct {
-		TotalCents int64  `db:"total_cents"`
-		Currency   string `db:"currency"`
-	}
-
-	err := pdb.Db.Get(&row, query, orderID)
+	res, err := pdb.Db.Exec(statement, pricingRuleId)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return 0, "", payment.ErrInternal
-		}
 		slog.Error(err.Error())
-		return 0, "", payment.ErrInternal
+		return ErrInternal
 	}
-
-	return row.TotalCents, strings.ToLower(row.Currency), nil
-}
-
-func (pdb PostgresDb) GetOrderItemsForPayment(orderID int64) ([]payment.OrderItem, error) {
-	const query = `
-	SELECT 
-		CONCAT(item.name, 
-			CASE 
-				WHEN COUNT(iv.name) > 0 
-				THEN CONCAT(' (', STRING_AGG(iv.name, ', '), ')') 
-				ELSE '' 
-			END
-		) AS name,
-		oi.quantity,
-		CAST(GREATEST(ROUND(
-			(item.price_per_unit + COALESCE(SUM(iv.price_difference), 0) - oi.discount)
-		), 0) AS BIGINT) AS price_cents
-	FROM order_item oi
-	JOIN item ON oi.item_id = item.id
-	LEFT JOIN order_item_variation oiv ON oiv.order_item_id = oi.id
-	LEFT JOIN item_variation iv ON oiv.variation_id = iv.id
-	WHERE oi.order_id = $1
-	GROUP BY oi.id, item.name, item.price_per_unit, oi.quantity, oi.discount
-	ORDER BY oi.id
-	`
-
-	var rows []struct {
-		Name       string `db:"name"`
-		Quantity   int    `db:"quantity"`
-		PriceCents int64  `db:"price_cents"`
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrPricingRuleNotFound
 	}
 
-	err := pdb.Db.Select(&rows, query, orderID)
-	if err != nil {
-		slog.Error("Failed to get order items for payment", "error", err, "order_id", orderID)
-		return nil, ErrInternal
-	}
+	return nil
+}
 
-	items := make([]payment.OrderItem, len(rows))
-	for i, row := range rows {
-		items[i] = payment.OrderItem{
-			Name:     row.Name,
-			Quantity: row.Quantity,
-			Price:    row.PriceCents,
-		}
+func (pdb PostgresDb) GetBundleSlots(productId int64) ([]order.BundleSlot, error) {
+	if err := checkProductExists(pdb.Db, productId); err != nil {
+		return []order.BundleSlot{}, err
 	}
 
-	return items, nil
+	return getBundleSlots(pdb.Db, productId)
 }
 
-func (pdb PostgresDb) GetReservationTotal(reservationID int32) (int64, string, error) {
-	const query = `
-	SELECT 
-		CAST(ROUND(sl.price) AS BIGINT) AS total_cents,
-		c.currency
-	FROM appointment a
-	JOIN service_location sl
-		ON a.service_location_id = sl.id
-	JOIN location l
-		ON sl.location_id = l.id
-	JOIN country c
-		ON l.country_code = c.code
-	WHERE a.id = $1
-	`
+// Slots of the product with their active options, empty if the product is not a bundle.
+func getBundleSlots(queryer sqlx.Queryer, productId int64) ([]order.BundleSlot, error) {
+	slots := []order.BundleSlot{}
+	{
+		const query = `
+		SELECT id, name, position
+		FROM bundle_slot
+		WHERE bundle_id = $1
+		ORDER BY
+			position,
+			name
+		`
 
-	var row struct {
-		TotalCents int64  `db:"total_cents"`
-		Currency   string `db:"currency"`
+		err := sqlx.Select(queryer, &slots, query, productId)
+		if err != nil {
+			slog.Error(err.Error())
+			return []order.BundleSlot{}, ErrInternal
+		}
 	}
 
-	err := pdb.Db.Get(&row, query, reservationID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return 0, "", payment.ErrInternal
+	for i := range slots {
+		const query = `
+		SELECT
+			item.id AS item_id,
+			item.name,
+			bundle_slot_option.upcharge
+		FROM bundle_slot_option
+		JOIN item
+			ON item.id = bundle_slot_option.item_id
+		WHERE
+			bundle_slot_option.slot_id = $1
+			AND item.status = 'ACTIVE'
+		ORDER BY
+			bundle_slot_option.upcharge,
+			item.name
+		`
+
+		slots[i].Options = []order.BundleOption{}
+		err := sqlx.Select(queryer, &slots[i].Options, query, slots[i].Id)
+		if err != nil {
+			slog.Error(err.Error())
+			return []order.BundleSlot{}, ErrInternal
 		}
-		slog.Error(err.Error())
-		return 0, "", payment.ErrInternal
 	}
 
-	return row.TotalCents, strings.ToLower(row.Currency), nil
+	return slots, nil
 }
 
-// GetReservationItemsForPayment returns reservation items formatted for the payment service
-func (pdb PostgresDb) GetReservationItemsForPayment(reservationID int32) ([]payment.OrderItem, error) {
-	const query = `
-	SELECT 
-		s.name AS name,
-		1 AS quantity,
-		CAST(ROUND(sl.price) AS BIGINT) AS price_cents
-	FROM appointment a
-	JOIN service_location sl
-		ON a.service_location_id = sl.id
-	JOIN service s
-		ON sl.service_id = s.id
-	WHERE a.id = $1
-	LIMIT 1
+func (pdb PostgresDb) CreateBundleSlot(productId int64, slot product.BundleSlotUpdate) (int64, error) {
+	{
+		const query = `
+		SELECT
+			EXISTS (SELECT 1 FROM item WHERE id = $1) AS product_exists,
+			EXISTS (SELECT 1 FROM bundle_slot_option WHERE item_id = $1) AS is_option
+		`
+		var exists struct {
+			Product  bool `db:"product_exists"`
+			IsOption bool `db:"is_option"`
+		}
+		if err := pdb.Db.Get(&exists, query, productId); err != nil {
+			slog.Error(err.Error())
+			return 0, ErrInternal
+		}
+		if !exists.Product {
+			return 0, product.ErrProductNotFound
+		}
+		if exists.IsOption {
+			return 0, product.ErrNestedBundle
+		}
+	}
+
+	const statement = `
+	INSERT INTO bundle_slot (bundle_id, name, position)
+		VALUES ($1, $2, $3)
+	RETURNING id
 	`
 
-	var row struct {
-		Name       string `db:"name"`
-		Quantity   int    `db:"quantity"`
-		PriceCents int64  `db:"price_cents"`
+	var id int64
+	err := pdb.Db.Get(&id, statement, productId, slot.Name, slot.Position)
+	if isUniqueViolation(err) {
+		return 0, product.ErrSlotExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
 	}
 
-	err := pdb.Db.Get(&row, query, reservationID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return []payment.OrderItem{}, nil
-		}
-		slog.Error("Failed to get reservation items for payment", "error", err, "reservation_id", reservationID)
-		return nil, ErrInternal
+	return id, nil
+}
+
+func (pdb PostgresDb) UpdateBundleSlot(slotId int64, slot product.BundleSlotUpdate) error {
+	const statement = `
+	UPDATE bundle_slot
+	SET
+		name     = $2,
+		position = $3
+	WHERE id = $1
+	`
+
+	res, err := pdb.Db.Exec(statement, slotId, slot.Name, slot.Position)
+	if isUniqueViolation(err) {
+		return product.ErrSlotExists
+	} else if err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrSlotNotFound
 	}
 
-	return []payment.OrderItem{
-		{
-			Name:     row.Name,
-			Quantity: row.Quantity,
-			Price:    row.PriceCents,
-		},
-	}, nil
+	return nil
 }
 
-func (pdb PostgresDb) MarkReservationCompleted(reservationID int32) error {
-	const query = `
-	UPDATE appointment
-	SET status = 'COMPLETED'
+func (pdb PostgresDb) DeleteBundleSlot(slotId int64) error {
+	const statement = `
+	DELETE FROM bundle_slot
 	WHERE id = $1
 	`
 
-	res, err := pdb.Db.Exec(query, reservationID)
+	res, err := pdb.Db.Exec(statement, slotId)
 	if err != nil {
-		slog.Error("Failed to mark reservation as completed", "error", err, "reservation_id", reservationID)
+		slog.Error(err.Error())
 		return ErrInternal
 	}
-
-	_, _ = res.RowsAffected()
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return product.ErrSlotNotFound
+	}
 
 	return nil
 }
 
-// -------------------------------------------------------------------------------------------------
-// payment.PaymentRepo implementation --------------------------------------------------------------
-// -------------------------------------------------------------------------------------------------
+func (pdb PostgresDb) SetBundleOption(slotId int64, productId int64, upcharge int64) error {
+	{
+		const query = `
+		SELECT
+			EXISTS (SELECT 1 FROM bundle_slot WHERE id = $1) AS slot_exists,
+			EXISTS (
+				SELECT 1
+				FROM item
+				JOIN bundle_slot
+					ON bundle_slot.id = $1
+				JOIN item bundle
+					ON bundle.id = bundle_slot.bundle_id
+					AND bundle.business_id = item.business_id
+				WHERE item.id = $2
+			) AS product_exists,
+			EXISTS (SELECT 1 FROM bundle_slot WHERE bundle_id = $2) AS is_bundle
+		`
+		var exists struct {
+			Slot     bool `db:"slot_exists"`
+			Product  bool `db:"product_exists"`
+			IsBundle bool `db:"is_bundle"`
+		}
+		if err := pdb.Db.Get(&exists, query, slotId, productId); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if !exists.Slot {
+			return product.ErrSlotNotFound
+		}
+		if !exists.Product {
+			return product.ErrProductNotFound
+		}
+		if exists.IsBundle {
+			return product.ErrNestedBundle
+		}
+	}
 
-func (pdb PostgresDb) CreatePayment(pmt payment.Payment) (int64, error) {
-	const query = `
-	INSERT INTO payment (order_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status)
-	VALUES ($1, $2, $3::currency, $4::payment_method, $5, $6, $7::payment_status)
-	RETURNING id
+	const statement = `
+	INSERT INTO bundle_slot_option (slot_id, item_id, upcharge)
+		VALUES ($1, $2, $3)
+	ON CONFLICT (slot_id, item_id) DO UPDATE
+	SET upcharge = EXCLUDED.upcharge
 	`
 
-	var paymentID int64
-	err := pdb.Db.QueryRow(
-		query,
-		pmt.OrderID,
-		pmt.AmountCents,
-		strings.ToUpper(pmt.Currency),
-		strings.ToUpper(pmt.PaymentMethod),
-		pmt.StripeSessionID,
-		pmt.StripePaymentIntentID,
-		strings.ToUpper(pmt.Status),
-	).Scan(&paymentID)
-
+	_, err := pdb.Db.Exec(statement, slotId, productId, upcharge)
 	if err != nil {
-		slog.Error("Failed to create payment", "error", err)
-		return 0, ErrInternal
+		slog.Error(err.Error())
+		return ErrInternal
 	}
 
-	return paymentID, nil
+	return nil
 }
 
-func (pdb PostgresDb) UpdatePaymentStatus(sessionID string, status string) error {
-	const query = `
-	UPDATE payment
-	SET status = $1::payment_status, updated_at = CURRENT_TIMESTAMP
-	WHERE stripe_session_id = $2
+// Removing a product that is not an option of the slot is not an error.
+// Open orders keep the option until their line is changed.
+func (pdb PostgresDb) RemoveBundleOption(slotId int64, productId int64) error {
+	const statement = `
+	DELETE FROM bundle_slot_option
+	WHERE
+		slot_id = $1
+		AND item_id = $2
 	`
 
-	result, err := pdb.Db.Exec(query, strings.ToUpper(status), sessionID)
+	res, err := pdb.Db.Exec(statement, slotId, productId)
 	if err != nil {
-		slog.Error("Failed to update payment status", "error", err, "session_id", sessionID)
+		slog.Error(err.Error())
 		return ErrInternal
 	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		const query = `
+		SELECT EXISTS (
+			SELECT 1
+			FROM bundle_slot
+			WHERE id = $1
+		)
+		`
+		var exists bool
+		if err := pdb.Db.Get(&exists, query, slotId); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
+		if !exists {
+			return product.ErrSlotNotFound
+		}
+	}
 
-	rowsAffected, err := result.RowsAffected()
+	return nil
+}
+
+// Uploads that stored the files but didn't reference them yet have this long to do it.
+const imageDeletionDelay = time.Hour
+
+func (pdb PostgresDb) KeepImages(keys []string) error {
+	// Waits for a sweep that is deleting the files, so they are stored again after it
+	const statement = `
+	DELETE FROM image_deletion
+	WHERE key = ANY($1::TEXT[])
+	`
+	if _, err := pdb.Db.Exec(statement, pq.StringArray(keys)); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+
+	return nil
+}
+
+func (pdb PostgresDb) SetProductImage(productId int64, image *product.ProductImage) error {
+	transaction, err := pdb.Db.Beginx()
 	if err != nil {
-		slog.Error("Failed to check rows affected", "error", err)
+		slog.Error(err.Error())
 		return ErrInternal
 	}
 
-	if rowsAffected == 0 {
-		return payment.ErrPaymentNotFound
+	{
+		// Locks the product, so concurrent uploads for it don't both see the same previous image
+		const query = `
+		SELECT id
+		FROM item
+		WHERE id = $1
+		FOR UPDATE
+		`
+		var id int64
+		err := transaction.Get(&id, query, productId)
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = transaction.Rollback()
+			return product.ErrProductNotFound
+		} else if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	var previous pq.StringArray
+	{
+		const statement = `
+		DELETE FROM item_image
+		WHERE item_id = $1
+		RETURNING ARRAY[image_key, thumbnail_key]
+		`
+		err := transaction.Get(&previous, statement, productId)
+		if errors.Is(err, sql.ErrNoRows) && image == nil {
+			_ = transaction.Rollback()
+			return product.ErrImageNotFound
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	if image != nil {
+		const statement = `
+		INSERT INTO item_image (item_id, image_key, thumbnail_key, image_url, thumbnail_url)
+			VALUES ($1, $2, $3, $4, $5)
+		`
+		_, err := transaction.Exec(statement, productId, image.ImageKey, image.ThumbnailKey, image.ImageUrl, image.ThumbnailUrl)
+		if err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return ErrInternal
+		}
+	}
+
+	if err := discardImages(transaction, previous); err != nil {
+		_ = transaction.Rollback()
+		return err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
 	}
 
 	return nil
 }
 
-func (pdb PostgresDb) UpdatePaymentIntentID(sessionID string, paymentIntentID string) error {
-	const query = `
-	UPDATE payment
-	SET stripe_payment_intent_id = $1, updated_at = CURRENT_TIMESTAMP
-	WHERE stripe_session_id = $2
+func (pdb PostgresDb) DiscardImages(keys []string) error {
+	return discardImages(pdb.Db, keys)
+}
+
+// execer is either the DB or a transaction.
+func discardImages(execer sqlx.Execer, keys []string) error {
+	const statement = `
+	INSERT INTO image_deletion (key, delete_after)
+		SELECT key, NOW() + $2::interval
+		FROM UNNEST($1::TEXT[]) AS key
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM item_image
+			WHERE key IN (image_key, thumbnail_key)
+		)
+	ON CONFLICT (key) DO UPDATE
+	SET delete_after = EXCLUDED.delete_after
 	`
+	if _, err := execer.Exec(statement, pq.StringArray(keys), imageDeletionDelay.String()); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
 
-	result, err := pdb.Db.Exec(query, paymentIntentID, sessionID)
+	return nil
+}
+
+// Files are deleted while their rows are locked, so an upload of the same content waits in KeepImages
+// and stores the files again after they are gone.
+func (pdb PostgresDb) DeleteUnusedImages(deleteFile func(key string) error) (int64, error) {
+	transaction, err := pdb.Db.Beginx()
 	if err != nil {
-		slog.Error("Failed to update payment intent ID", "error", err, "session_id", sessionID)
-		return ErrInternal
+		slog.Error(err.Error())
+		return 0, ErrInternal
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	// Keys that got used again are dropped without deleting anything
+	keys := []string{}
+	{
+		const query = `
+		SELECT key
+		FROM image_deletion
+		WHERE delete_after < NOW()
+		ORDER BY delete_after
+		LIMIT 1000
+		FOR UPDATE SKIP LOCKED
+		`
+		if err := transaction.Select(&keys, query); err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return 0, ErrInternal
+		}
+	}
+
+	unused, err := getUnusedImages(transaction, keys)
 	if err != nil {
-		slog.Error("Failed to check rows affected", "error", err)
-		return ErrInternal
+		_ = transaction.Rollback()
+		return 0, err
 	}
 
-	if rowsAffected == 0 {
-		return payment.ErrPaymentNotFound
+	deleted := int64(0)
+	done := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if slices.Contains(unused, key) {
+			// Tried again on the next sweep
+			if err := deleteFile(key); err != nil {
+				slog.Error("failed to delete image", "key", key, "error", err)
+				continue
+			}
+			deleted++
+		}
+		done = append(done, key)
 	}
 
-	return nil
+	{
+		const statement = `
+		DELETE FROM image_deletion
+		WHERE key = ANY($1::TEXT[])
+		`
+		if _, err := transaction.Exec(statement, pq.StringArray(done)); err != nil {
+			slog.Error(err.Error())
+			_ = transaction.Rollback()
+			return 0, ErrInternal
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return deleted, nil
 }
 
-func (pdb PostgresDb) GetPaymentBySessionID(sessionID string) (*payment.Payment, error) {
+func getUnusedImages(queryer sqlx.Queryer, keys []string) ([]string, error) {
 	const query = `
-	SELECT id, order_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status, created_at, updated_at
-	FROM payment
-	WHERE stripe_session_id = $1
-	LIMIT 1
+	SELECT key
+	FROM UNNEST($1::TEXT[]) AS key
+	WHERE NOT EXISTS (
+		SELECT 1
+		FROM item_image
+		WHERE key IN (image_key, thumbnail_key)
+	)
 	`
+	unused := []string{}
+	if err := sqlx.Select(queryer, &unused, query, pq.StringArray(keys)); err != nil {
+		slog.Error(err.Error())
+		return []string{}, ErrInternal
+	}
 
-	var pmt payment.Payment
-	err := pdb.Db.Get(&pmt, query, sessionID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, payment.ErrPaymentNotFound
+	return unused, nil
+}
+
+func (pdb PostgresDb) ExportCatalog(locationId int64) ([]product.CatalogRow, error) {
+	if err := checkLocationExists(pdb.Db, locationId); err != nil {
+		return []product.CatalogRow{}, err
+	}
+
+	var items []struct {
+		Id         int64          `db:"id"`
+		Name       string         `db:"name"`
+		Sku        string         `db:"sku"`
+		Price      int64          `db:"price"`
+		Vat        int64          `db:"vat"`
+		Unit       order.Unit     `db:"unit"`
+		Available  bool           `db:"available"`
+		Categories pq.StringArray `db:"categories"`
+	}
+	{
+		const query = `
+		SELECT
+			item.id,
+			item.name,
+			COALESCE(item.sku, '') AS sku,
+			COALESCE(item_location.price_per_unit, item.price_per_unit)::BIGINT AS price,
+			(item.vat * 100)::BIGINT AS vat,
+			LOWER(item.unit::TEXT) AS unit,
+			item_location.available,
+			ARRAY(
+				SELECT category.name
+				FROM item_category
+				JOIN category
+					ON category.id = item_category.category_id
+				WHERE item_category.item_id = item.id
+				ORDER BY category.name
+			) AS categories
+		FROM item
+		JOIN item_location
+			ON item_location.item_id = item.id
+			AND item_location.location_id = $1
+		WHERE item.status = 'ACTIVE'
+		ORDER BY item.name
+		`
+		if err := pdb.Db.Select(&items, query, locationId); err != nil {
+			slog.Error(err.Error())
+			return []product.CatalogRow{}, ErrInternal
 		}
-		slog.Error("Failed to get payment by session ID", "error", err, "session_id", sessionID)
-		return nil, ErrInternal
 	}
 
-	// Convert enum values to lowercase for consistency with JSON responses
-	pmt.Status = strings.ToLower(pmt.Status)
-	pmt.PaymentMethod = strings.ToLower(pmt.PaymentMethod)
-	pmt.Currency = strings.ToLower(pmt.Currency)
+	rows := make([]product.CatalogRow, len(items))
+	for i, item := range items {
+		rows[i] = product.CatalogRow{
+			Name:            item.Name,
+			Sku:             item.Sku,
+			Price:           item.Price,
+			Vat:             item.Vat,
+			Unit:            item.Unit,
+			Available:       &item.Available,
+			Categories:      []string(item.Categories),
+			Variations:      []product.CatalogVariation{},
+			VatRates:        map[order.FulfillmentType]int64{},
+			Barcodes:        []string{},
+			VariationGroups: []product.CatalogVariationGroup{},
+			PriceLists:      map[string]int64{},
+			Slots:           []product.CatalogSlot{},
+		}
 
-	return &pmt, nil
-}
+		const barcodeQuery = `
+		SELECT variation_id, code
+		FROM item_barcode
+		WHERE item_id = $1
+		ORDER BY id
+		`
+		var barcodes []struct {
+			VariationId *int64 `db:"variation_id"`
+			Code        string `db:"code"`
+		}
+		if err := pdb.Db.Select(&barcodes, barcodeQuery, item.Id); err != nil {
+			slog.Error(err.Error())
+			return []product.CatalogRow{}, ErrInternal
+		}
 
-func (pdb PostgresDb) GetPaymentByOrderID(orderID int64) (*payment.Payment, error) {
-	const query = `
-	SELECT id, order_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status, created_at, updated_at
-	FROM payment
-	WHERE order_id = $1
-	ORDER BY created_at DESC
-	LIMIT 1
-	`
+		groups, err := getVariationGroups(pdb.Db, item.Id)
+		if err != nil {
+			return []product.CatalogRow{}, err
+		}
+		for _, group := range groups {
+			rows[i].VariationGroups = append(rows[i].VariationGroups, product.CatalogVariationGroup{
+				Name:        group.Name,
+				MinSelected: group.MinSelected,
+				MaxSelected: group.MaxSelected,
+			})
+		}
+
+		// Variations keep the order they were created in, so an import of the export creates them the same way
+		const variationQuery = `
+		SELECT
+			item_variation.id,
+			item_variation.name,
+			item_variation.price_difference,
+			COALESCE(variation_group.name, '') AS group_name,
+			item_variation.is_default
+		FROM item_variation
+		LEFT JOIN variation_group
+			ON variation_group.id = item_variation.group_id
+		WHERE
+			item_variation.item_id = $1
+			AND item_variation.status = 'ACTIVE'
+		ORDER BY item_variation.id
+		`
+		var variations []struct {
+			Id              int64  `db:"id"`
+			Name            string `db:"name"`
+			PriceDifference uint64 `db:"price_difference"`
+			Group           string `db:"group_name"`
+			Default         bool   `db:"is_default"`
+		}
+		if err := pdb.Db.Select(&variations, variationQuery, item.Id); err != nil {
+			slog.Error(err.Error())
+			return []product.CatalogRow{}, ErrInternal
+		}
+		for _, variation := range variations {
+			codes := []string{}
+			for _, barcode := range barcodes {
+				if barcode.VariationId != nil && *barcode.VariationId == variation.Id {
+					codes = append(codes, barcode.Code)
+				}
+			}
+			rows[i].Variations = append(rows[i].Variations, product.CatalogVariation{
+				Name:          variation.Name,
+				PriceModifier: variation.PriceDifference,
+				Barcodes:      codes,
+				Group:         variation.Group,
+				Default:       variation.Default,
+			})
+		}
+		for _, barcode := range barcodes {
+			if barcode.VariationId == nil {
+				rows[i].Barcodes = append(rows[i].Barcodes, barcode.Code)
+			}
+		}
+
+		const vatQuery = `
+		SELECT LOWER(fulfillment_type::TEXT) AS fulfillment_type, (vat * 100)::BIGINT AS vat
+		FROM item_vat
+		WHERE item_id = $1
+		`
+		var rates []struct {
+			FulfillmentType order.FulfillmentType `db:"fulfillment_type"`
+			Vat             int64                 `db:"vat"`
+		}
+		if err := pdb.Db.Select(&rates, vatQuery, item.Id); err != nil {
+			slog.Error(err.Error())
+			return []product.CatalogRow{}, ErrInternal
+		}
+		for _, rate := range rates {
+			rows[i].VatRates[rate.FulfillmentType] = rate.Vat
+		}
+
+		const priceListQuery = `
+		SELECT price_list.name, price_list_item.price_per_unit::BIGINT AS price
+		FROM price_list_item
+		JOIN price_list
+			ON price_list.id = price_list_item.price_list_id
+		WHERE price_list_item.item_id = $1
+		`
+		var prices []struct {
+			Name  string `db:"name"`
+			Price int64  `db:"price"`
+		}
+		if err := pdb.Db.Select(&prices, priceListQuery, item.Id); err != nil {
+			slog.Error(err.Error())
+			return []product.CatalogRow{}, ErrInternal
+		}
+		for _, price := range prices {
+			rows[i].PriceLists[price.Name] = price.Price
+		}
 
-	var pmt payment.Payment
-	err := pdb.Db.Get(&pmt, query, orderID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, payment.ErrPaymentNotFound
+		slots, err := getBundleSlots(pdb.Db, item.Id)
+		if err != nil {
+			return []product.CatalogRow{}, err
+		}
+		for _, slot := range slots {
+			options := make([]product.CatalogOption, len(slot.Options))
+			for j, option := range slot.Options {
+				options[j] = product.CatalogOption{Product: option.Name, Upcharge: option.Upcharge}
+			}
+			rows[i].Slots = append(rows[i].Slots, product.CatalogSlot{Name: slot.Name, Options: options})
 		}
-		slog.Error("Failed to get payment by order ID", "error", err, "order_id", orderID)
-		return nil, ErrInternal
 	}
 
-	// Convert enum values to lowercase for consistency with JSON responses
-	pmt.Status = strings.ToLower(pmt.Status)
-	pmt.PaymentMethod = strings.ToLower(pmt.PaymentMethod)
-	pmt.Currency = strings.ToLower(pmt.Currency)
-
-	return &pmt, nil
+	return rows, nil
 }
 
-func (pdb PostgresDb) GetPaymentByPaymentIntentID(paymentIntentID string) (*payment.Payment, error) {
-	const query = `
-	SELECT id, order_id, amount, currency, payment_method, stripe_session_id, stripe_payment_intent_id, status, created_at, updated_at
-	FROM payment
-	WHERE stripe_payment_intent_id = $1
-	LIMIT 1
-	`
-
-	var pmt payment.Payment
-	err := pdb.Db.Get(&pmt, query, paymentIntentID)
+func (pdb PostgresDb) ImportCatalog(locationId int64, rows []product.CatalogRow, apply bool) ([]product.ImportRowResult, error) {
+	transaction, err := pdb.Db.Beginx()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, payment.ErrPaymentNotFound
-		}
-		slog.Error("Failed to get payment by payment intent ID", "error", err, "payment_intent_id", paymentIntentID)
-		return nil, ErrInternal
+		slog.Error(err.Error())
+		return []product.ImportRowResult{}, ErrInternal
 	}
 
-	// Convert enum values to lowercase for consistency with JSON responses
-	pmt.Status = strings.ToLower(pmt.Status)
-	pmt.PaymentMethod = strings.ToLower(pmt.PaymentMethod)
-	pmt.Currency = strings.ToLower(pmt.Currency)
+	if err := checkLocationExists(transaction, locationId); err != nil {
+		_ = transaction.Rollback()
+		return []product.ImportRowResult{}, err
+	}
 
-	return &pmt, nil
-}
+	// Every row runs in its own savepoint, so a failing row doesn't hide the errors of the next ones
+	results := make([]product.ImportRowResult, len(rows))
+	itemIds := make([]int64, len(rows))
+	failed := false
+	importRow := func(i int, apply func() error) error {
+		if _, err := transaction.Exec(`SAVEPOINT import_row`); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
 
-// -------------------------------------------------------------------------------------------------
-// reservation.ServiceRepo implementation ----------------------------------------------------------
-// -------------------------------------------------------------------------------------------------
+		err := apply()
+		if err == nil {
+			return nil
+		}
+		if _, err := transaction.Exec(`ROLLBACK TO SAVEPOINT import_row`); err != nil {
+			slog.Error(err.Error())
+			return ErrInternal
+		}
 
-func (pdb PostgresDb) GetServices() ([]reservation.Service, error) {
-	const query = `
-	SELECT
-		s.id            AS service_id,
-		s.name          AS service_name,
-		s.duration_mins AS duration_mins,
-		MIN(sl.price)   AS price
-	FROM service s
-	JOIN service_location sl
-		ON sl.service_id = s.id
-	GROUP BY s.id, s.name, s.duration_mins
-	ORDER BY s.id
-	`
+		var rowErr importRowError
+		switch {
+		case errors.As(err, &rowErr):
+			results[i].Errors = append(results[i].Errors, rowErr.Error())
+		case errors.Is(err, product.ErrTooManyDefaults), errors.Is(err, product.ErrNestedBundle):
+			results[i].Errors = append(results[i].Errors, err.Error())
+		case isUniqueViolation(err):
+			results[i].Errors = append(results[i].Errors, "name, SKU or barcode is already used by another product")
+		default:
+			slog.Error(err.Error(), "row", i+1)
+			results[i].Errors = append(results[i].Errors, "row could not be saved")
+		}
+		failed = true
+		return nil
+	}
 
-	rows := []struct {
-		ServiceId int32  `db:"service_id"`
-		Name      string `db:"service_name"`
-		Duration  int    `db:"duration_mins"`
-		Price     int64  `db:"price"`
-	}{}
+	for i, row := range rows {
+		results[i] = product.ImportRowResult{Row: i + 1, Name: row.Name, Errors: []string{}}
 
-	err := pdb.Db.Select(&rows, query)
-	if err != nil {
-		slog.Error(err.Error())
-		return []reservation.Service{}, ErrInternal
+		err := importRow(i, func() error {
+			var err error
+			results[i].Action, itemIds[i], err = importCatalogRow(transaction, locationId, row)
+			return err
+		})
+		if err != nil {
+			_ = transaction.Rollback()
+			return []product.ImportRowResult{}, err
+		}
 	}
 
-	services := make([]reservation.Service, 0, len(rows))
-	for _, row := range rows {
-		services = append(services, reservation.Service{
-			Id:       strconv.FormatInt(int64(row.ServiceId), 10),
-			Name:     row.Name,
-			NameKey:  row.Name,
-			Duration: row.Duration,
-			Price:    int(row.Price),
+	// Options of bundles can be products of later rows, so slots are imported after every product
+	for i, row := range rows {
+		if itemIds[i] == 0 || len(results[i].Errors) > 0 {
+			continue
+		}
+
+		err := importRow(i, func() error {
+			return importCatalogSlots(transaction, itemIds[i], row.Slots)
 		})
+		if err != nil {
+			_ = transaction.Rollback()
+			return []product.ImportRowResult{}, err
+		}
 	}
 
-	return services, nil
-}
+	if !apply || failed {
+		_ = transaction.Rollback()
+		return results, nil
+	}
 
-// -------------------------------------------------------------------------------------------------
-// reservation.StaffRepo implementation ------------------------------------------------------------
-// -------------------------------------------------------------------------------------------------
+	if err := transaction.Commit(); err != nil {
+		slog.Error(err.Error())
+		return []product.ImportRowResult{}, ErrInternal
+	}
 
-func (pdb PostgresDb) GetStaff() ([]reservation.Staff, error) {
-	const query = `
-	WITH roles AS (
-		SELECT 
-			er.employee_id,
-			MIN(r.name) AS role_name
-		FROM employee_role er
-		JOIN role r
-			ON er.role_id = r.id
-		GROUP BY er.employee_id
-	)
-	SELECT
-		e.id,
-		e.first_name,
-		e.last_name,
-		COALESCE(roles.role_name, '') AS role_name,
-		ARRAY_AGG(DISTINCT sl.service_id) AS service_ids
-	FROM employee e
-	JOIN service_employee se
-		ON e.id = se.employee_id
-	JOIN service_location sl
-		ON se.service_location_id = sl.id
-	LEFT JOIN roles
-		ON roles.employee_id = e.id
-	GROUP BY e.id, e.first_name, e.last_name, roles.role_name
-	ORDER BY e.id
-	`
+	return results, nil
+}
 
-	rows := []struct {
-		Id         int32         `db:"id"`
-		FirstName  string        `db:"first_name"`
-		LastName   string        `db:"last_name"`
-		RoleName   string        `db:"role_name"`
-		ServiceIds pq.Int64Array `db:"service_ids"`
-	}{}
+// Error of an import row that is reported as it is.
+type importRowError string
 
-	err := pdb.Db.Select(&rows, query)
-	if err != nil {
-		slog.Error(err.Error())
-		return []reservation.Staff{}, ErrInternal
+func (e importRowError) Error() string {
+	return string(e)
+}
+
+// Upserts the product by SKU (or name, if no product has the SKU), sets its price and availability
+// at the location and replaces its categories, variations, variation groups, barcodes and price list prices.
+// A row without a SKU keeps the existing one. Variations missing from the row are archived, order lines
+// keep referencing them. The name, VAT and unit of existing products are the same at every location
+// of the business, so rows can't change them.
+func importCatalogRow(transaction *sqlx.Tx, locationId int64, row product.CatalogRow) (product.ImportAction, int64, error) {
+	var item struct {
+		Id    int64      `db:"id"`
+		Name  string     `db:"name"`
+		Price int64      `db:"price"`
+		Vat   int64      `db:"vat"`
+		Unit  order.Unit `db:"unit"`
 	}
+	created := false
+	{
+		const query = `
+		SELECT
+			id,
+			name,
+			price_per_unit::BIGINT AS price,
+			(vat * 100)::BIGINT AS vat,
+			LOWER(unit::TEXT) AS unit
+		FROM item
+		WHERE
+			business_id = (SELECT business_id FROM location WHERE id = $1)
+			AND (sku = NULLIF($2, '') OR name = $3)
+		ORDER BY (sku IS NOT DISTINCT FROM NULLIF($2, '')) DESC
+		LIMIT 1
+		FOR UPDATE
+		`
+		const updateStatement = `
+		UPDATE item
+		SET
+			sku    = COALESCE(NULLIF($2, ''), sku),
+			status = 'ACTIVE'
+		WHERE id = $1
+		`
+		const insertStatement = `
+		INSERT INTO item (name, business_id, sku, price_per_unit, vat, unit)
+			SELECT $2, business_id, NULLIF($3, ''), $4, ($5::DECIMAL(6, 2) * 0.01::DECIMAL(6, 2))::DECIMAL(4, 2), $6::measure_unit
+			FROM location
+			WHERE id = $1
+		RETURNING id
+		`
+		// The price is an override at the location when it differs from the price of the product
+		const listStatement = `
+		INSERT INTO item_location (item_id, location_id, available, price_per_unit)
+			VALUES ($1, $2, $3, NULLIF($4::BIGINT, $5::BIGINT))
+		ON CONFLICT (item_id, location_id) DO UPDATE
+			SET
+				available      = EXCLUDED.available,
+				price_per_unit = EXCLUDED.price_per_unit
+		`
 
-	serviceSet := map[string]struct{}{}
-	staff := make([]reservation.Staff, 0, len(rows)+1)
+		err := transaction.Get(&item, query, locationId, row.Sku, row.Name)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			created = true
+			item.Price = row.Price
+			err = transaction.Get(&item.Id, insertStatement, locationId, row.Name, row.Sku, row.Price, row.Vat, row.Unit.DbValue())
+		case err == nil:
+			if item.Name != row.Name || item.Vat != row.Vat || item.Unit != row.Unit {
+				return "", 0, errBusinessWideChange
+			}
+			_, err = transaction.Exec(updateStatement, item.Id, row.Sku)
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err := transaction.Exec(listStatement, item.Id, locationId, *row.Available, row.Price, item.Price); err != nil {
+			return "", 0, err
+		}
+	}
 
-	for _, row := range rows {
-		serviceIds := make([]string, len(row.ServiceIds))
-		for i, id := range row.ServiceIds {
-			serviceIds[i] = strconv.FormatInt(id, 10)
-			serviceSet[serviceIds[i]] = struct{}{}
+	{
+		const query = `
+		SELECT LOWER(fulfillment_type::TEXT) AS fulfillment_type, (vat * 100)::BIGINT AS vat
+		FROM item_vat
+		WHERE item_id = $1
+		`
+		const insertStatement = `
+		INSERT INTO item_vat (item_id, fulfillment_type, vat)
+			VALUES ($1, $2::fulfillment_type, ($3::DECIMAL(6, 2) * 0.01::DECIMAL(6, 2))::DECIMAL(4, 2))
+		`
+		if created {
+			for fulfillment, vat := range row.VatRates {
+				if _, err := transaction.Exec(insertStatement, item.Id, fulfillment.DbValue(), vat); err != nil {
+					return "", 0, err
+				}
+			}
+		} else {
+			var rates []struct {
+				FulfillmentType order.FulfillmentType `db:"fulfillment_type"`
+				Vat             int64                 `db:"vat"`
+			}
+			if err := transaction.Select(&rates, query, item.Id); err != nil {
+				return "", 0, err
+			}
+			if len(rates) != len(row.VatRates) {
+				return "", 0, errBusinessWideChange
+			}
+			for _, rate := range rates {
+				if vat, ok := row.VatRates[rate.FulfillmentType]; !ok || vat != rate.Vat {
+					return "", 0, errBusinessWideChange
+				}
+			}
 		}
+	}
 
-		role := row.RoleName
-		if role == "" {
-			role = "Staff"
+	{
+		const createStatement = `
+		INSERT INTO category (business_id, name)
+			SELECT business_id, UNNEST($2::TEXT[])
+			FROM item
+			WHERE id = $1
+		ON CONFLICT (business_id, name) DO NOTHING
+		`
+		const clearStatement = `
+		DELETE FROM item_category
+		WHERE item_id = $1
+		`
+		const assignStatement = `
+		INSERT INTO item_category (item_id, category_id)
+			SELECT item.id, category.id
+			FROM category
+			JOIN item
+				ON item.business_id = category.business_id
+			WHERE
+				item.id = $1
+				AND category.name = ANY($2::TEXT[])
+		`
+		categories := pq.StringArray(row.Categories)
+		if _, err := transaction.Exec(createStatement, item.Id, categories); err != nil {
+			return "", 0, err
+		}
+		if _, err := transaction.Exec(clearStatement, item.Id); err != nil {
+			return "", 0, err
 		}
+		if _, err := transaction.Exec(assignStatement, item.Id, categories); err != nil {
+			return "", 0, err
+		}
+	}
 
-		staff = append(staff, reservation.Staff{
-			Id:       strconv.FormatInt(int64(row.Id), 10),
-			Name:     strings.TrimSpace(fmt.Sprintf("%s %s", row.FirstName, row.LastName)),
-			Role:     role,
-			Services: serviceIds,
-		})
+	// Variations of deleted groups are kept without a group
+	groupIds := map[string]int64{}
+	{
+		const upsertStatement = `
+		INSERT INTO variation_group (item_id, name, min_selected, max_selected, position)
+			VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (item_id, name) DO UPDATE
+			SET
+				min_selected = EXCLUDED.min_selected,
+				max_selected = EXCLUDED.max_selected,
+				position     = EXCLUDED.position
+		RETURNING id
+		`
+		const deleteStatement = `
+		DELETE FROM variation_group
+		WHERE
+			item_id = $1
+			AND NOT (name = ANY($2::TEXT[]))
+		`
+
+		names := make(pq.StringArray, len(row.VariationGroups))
+		for i, group := range row.VariationGroups {
+			names[i] = group.Name
+
+			var id int64
+			err := transaction.Get(&id, upsertStatement, item.Id, group.Name, group.MinSelected, group.MaxSelected, i)
+			if err != nil {
+				return "", 0, err
+			}
+			groupIds[group.Name] = id
+		}
+		if _, err := transaction.Exec(deleteStatement, item.Id, names); err != nil {
+			return "", 0, err
+		}
 	}
 
-	if len(serviceSet) > 0 {
-		allServices := make([]string, 0, len(serviceSet))
-		for id := range serviceSet {
-			allServices = append(allServices, id)
+	var variationIds []int64
+	{
+		const updateStatement = `
+		UPDATE item_variation
+		SET
+			price_difference = $3,
+			group_id         = $4,
+			is_default       = $5,
+			status           = 'ACTIVE'
+		WHERE
+			item_id = $1
+			AND name = $2
+		RETURNING id
+		`
+		const insertStatement = `
+		INSERT INTO item_variation (item_id, name, price_difference, group_id, is_default)
+			VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+		`
+		const archiveStatement = `
+		UPDATE item_variation
+		SET status = 'ARCHIVED'
+		WHERE
+			item_id = $1
+			AND status = 'ACTIVE'
+			AND NOT (name = ANY($2::TEXT[]))
+		`
+
+		names := make(pq.StringArray, len(row.Variations))
+		variationIds = make([]int64, len(row.Variations))
+		for i, variation := range row.Variations {
+			names[i] = variation.Name
+
+			var groupId *int64
+			if id, ok := groupIds[variation.Group]; ok {
+				groupId = &id
+			}
+			err := transaction.Get(&variationIds[i], updateStatement, item.Id, variation.Name, variation.PriceModifier, groupId, variation.Default)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = transaction.Get(&variationIds[i], insertStatement, item.Id, variation.Name, variation.PriceModifier, groupId, variation.Default)
+			}
+			if err != nil {
+				return "", 0, err
+			}
 		}
-		sort.Strings(allServices)
+		if _, err := transaction.Exec(archiveStatement, item.Id, names); err != nil {
+			return "", 0, err
+		}
+		for _, groupId := range groupIds {
+			if err := checkVariationGroupDefaults(transaction, groupId); err != nil {
+				return "", 0, err
+			}
+		}
+	}
 
-		staff = append([]reservation.Staff{
-			{
-				Id:       "anyone",
-				Name:     "Anyone",
-				Role:     "Any",
-				Services: allServices,
-			},
-		}, staff...)
+	{
+		const clearStatement = `
+		DELETE FROM item_barcode
+		WHERE item_id = $1
+		`
+		const insertStatement = `
+		INSERT INTO item_barcode (business_id, item_id, variation_id, code)
+			SELECT business_id, id, $2, UNNEST($3::TEXT[])
+			FROM item
+			WHERE id = $1
+		`
+		if _, err := transaction.Exec(clearStatement, item.Id); err != nil {
+			return "", 0, err
+		}
+		if _, err := transaction.Exec(insertStatement, item.Id, nil, pq.StringArray(row.Barcodes)); err != nil {
+			return "", 0, err
+		}
+		for i, variation := range row.Variations {
+			codes := pq.StringArray(variation.Barcodes)
+			if _, err := transaction.Exec(insertStatement, item.Id, variationIds[i], codes); err != nil {
+				return "", 0, err
+			}
+		}
 	}
 
-	return staff, nil
-}
+	{
+		const createStatement = `
+		INSERT INTO price_list (business_id, name)
+			SELECT business_id, UNNEST($2::TEXT[])
+			FROM item
+			WHERE id = $1
+		ON CONFLICT (business_id, name) DO NOTHING
+		`
+		const clearStatement = `
+		DELETE FROM price_list_item
+		USING price_list
+		WHERE
+			price_list_item.item_id = $1
+			AND price_list.id = price_list_item.price_list_id
+			AND NOT (price_list.name = ANY($2::TEXT[]))
+		`
+		const setStatement = `
+		INSERT INTO price_list_item (price_list_id, item_id, price_per_unit)
+			SELECT price_list.id, item.id, $3
+			FROM item
+			JOIN price_list
+				ON price_list.business_id = item.business_id
+				AND price_list.name = $2
+			WHERE item.id = $1
+		ON CONFLICT (price_list_id, item_id) DO UPDATE
+			SET price_per_unit = EXCLUDED.price_per_unit
+		`
 
-// -------------------------------------------------------------------------------------------------
-// Reservation helpers -----------------------------------------------------------------------------
-// -------------------------------------------------------------------------------------------------
+		names := make(pq.StringArray, 0, len(row.PriceLists))
+		for name := range row.PriceLists {
+			names = append(names, name)
+		}
+		if _, err := transaction.Exec(createStatement, item.Id, names); err != nil {
+			return "", 0, err
+		}
+		if _, err := transaction.Exec(clearStatement, item.Id, names); err != nil {
+			return "", 0, err
+		}
+		for name, price := range row.PriceLists {
+			if _, err := transaction.Exec(setStatement, item.Id, name, price); err != nil {
+				return "", 0, err
+			}
+		}
+	}
 
-func mapAppointmentStatusToApi(status string) string {
-	switch strings.ToUpper(status) {
-	case "PENDING", "RESERVED":
-		return "pending"
-	case "SERVING":
-		return "confirmed"
-	case "COMPLETED", "PAID":
-		return "completed"
-	case "CANCELLED", "CANCELED":
-		return "cancelled"
-	case "REFUND_PENDING":
-		return "refund_pending"
-	case "REFUNDED":
-		return "refunded"
-	default:
-		return strings.ToLower(status)
+	if created {
+		return product.ImportCreate, item.Id, nil
 	}
+	return product.ImportUpdate, item.Id, nil
 }
 
-func mapApiReservationStatusToAppointment(status string) string {
-	trimmed := strings.TrimSpace(status)
-	if trimmed == "" {
-		return ""
-	}
+var errBusinessWideChange = importRowError("name, VAT, VAT rates and unit of the product are the same at every location, change them on the product")
 
-	upper := strings.ToUpper(trimmed)
-	switch upper {
-	// Accept DB enum values directly.
-	case "PENDING", "COMPLETED", "CANCELLED", "REFUND_PENDING", "REFUNDED":
-		return upper
+// Replaces the slots of the bundle and their options. Options are products of the business by name.
+func importCatalogSlots(transaction *sqlx.Tx, bundleId int64, slots []product.CatalogSlot) error {
+	const upsertStatement = `
+	INSERT INTO bundle_slot (bundle_id, name, position)
+		VALUES ($1, $2, $3)
+	ON CONFLICT (bundle_id, name) DO UPDATE
+		SET position = EXCLUDED.position
+	RETURNING id
+	`
+	const deleteStatement = `
+	DELETE FROM bundle_slot
+	WHERE
+		bundle_id = $1
+		AND NOT (name = ANY($2::TEXT[]))
+	`
+	const clearStatement = `
+	DELETE FROM bundle_slot_option
+	WHERE slot_id = $1
+	`
+	const optionStatement = `
+	INSERT INTO bundle_slot_option (slot_id, item_id, upcharge)
+		SELECT $1, option.id, $4
+		FROM item bundle
+		JOIN item option
+			ON option.business_id = bundle.business_id
+			AND option.name = $3
+		WHERE bundle.id = $2
+	`
+	// Options can't be bundles and bundles can't be options
+	const nestedQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM bundle_slot_option
+		JOIN bundle_slot
+			ON bundle_slot.id = bundle_slot_option.slot_id
+		WHERE
+			(bundle_slot.bundle_id = $1 AND EXISTS (
+				SELECT 1
+				FROM bundle_slot nested
+				WHERE nested.bundle_id = bundle_slot_option.item_id
+			))
+			OR (bundle_slot_option.item_id = $1 AND EXISTS (
+				SELECT 1
+				FROM bundle_slot own
+				WHERE own.bundle_id = $1
+			))
+	)
+	`
 
-	// API / UI values (and common variants).
-	case "CONFIRMED":
-		return "PENDING"
-	case "CANCELED":
-		return "CANCELLED"
+	names := make(pq.StringArray, len(slots))
+	for i, slot := range slots {
+		names[i] = slot.Name
 
-	// Legacy values from older appointment_status enums.
-	case "RESERVED", "SERVING":
-		return "PENDING"
-	case "PAID":
-		return "COMPLETED"
+		var slotId int64
+		if err := transaction.Get(&slotId, upsertStatement, bundleId, slot.Name, i); err != nil {
+			return err
+		}
+		if _, err := transaction.Exec(clearStatement, slotId); err != nil {
+			return err
+		}
+		for _, option := range slot.Options {
+			res, err := transaction.Exec(optionStatement, slotId, bundleId, option.Product, option.Upcharge)
+			if err != nil {
+				return err
+			}
+			if rows, _ := res.RowsAffected(); rows == 0 {
+				return importRowError(fmt.Sprintf("bundle option '%s' is not a product of the business", option.Product))
+			}
+		}
+	}
+	if _, err := transaction.Exec(deleteStatement, bundleId, names); err != nil {
+		return err
 	}
 
-	return ""
+	var nested bool
+	if err := transaction.Get(&nested, nestedQuery, bundleId); err != nil {
+		return err
+	}
+	if nested {
+		return product.ErrNestedBundle
+	}
+
+	return nil
 }
 
-// -------------------------------------------------------------------------------------------------
-// reservation.ReservationRepo - Refund methods ---------------------------------------------------
-// -------------------------------------------------------------------------------------------------
+// queryer is either the DB or a transaction.
+func checkLocationExists(queryer sqlx.Queryer, locationId int64) error {
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM location
+		WHERE id = $1
+	)
+	`
 
-func (pdb PostgresDb) CreateReservationRefundRequest(reservationId int32, refundData reservation.RefundData) error {
-	transaction, err := pdb.Db.Beginx()
-	if err != nil {
+	var exists bool
+	if err := sqlx.Get(queryer, &exists, query, locationId); err != nil {
 		slog.Error(err.Error())
 		return ErrInternal
 	}
+	if !exists {
+		return product.ErrLocationNotFound
+	}
+
+	return nil
+}
 
+// Midnight of the effective date in the time zone of the location has to be in the future,
+// otherwise the change would be applied right away without anyone noticing the date was wrong.
+func schedulePriceChange(db *sqlx.DB, productId int64, change product.PriceChangeUpdate) (int64, error) {
+	effectiveDate := change.EffectiveDate.Format(time.DateOnly)
 	{
-		const appointmentStatusStatement = `
-		UPDATE appointment
-		SET status = 'REFUND_PENDING'
-		WHERE
-			id = $1
-			AND status = 'COMPLETED'
+		const query = `
+		SELECT
+			EXISTS (SELECT 1 FROM item WHERE id = $1) AS product_exists,
+			EXISTS (
+				SELECT 1
+				FROM location
+				JOIN item
+					ON item.business_id = location.business_id
+				WHERE
+					location.id = $2
+					AND item.id = $1
+			) AS location_exists,
+			NOT $4 OR EXISTS (
+				SELECT 1
+				FROM item_location
+				WHERE
+					item_id = $1
+					AND location_id = $2
+			) AS sold_at_location,
+			COALESCE((
+				SELECT ($3::DATE::TIMESTAMP AT TIME ZONE time_zone) > NOW()
+				FROM location
+				WHERE id = $2
+			), FALSE) AS in_future
 		`
-
-		res, err := transaction.Exec(appointmentStatusStatement, reservationId)
-		if err != nil {
+		var check struct {
+			Product  bool `db:"product_exists"`
+			Location bool `db:"location_exists"`
+			Sold     bool `db:"sold_at_location"`
+			InFuture bool `db:"in_future"`
+		}
+		if err := db.Get(&check, query, productId, change.LocationId, effectiveDate, change.LocationOnly); err != nil {
 			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
+			return 0, ErrInternal
 		}
-		rows, err := res.RowsAffected()
-		if err != nil {
-			slog.Error("error checking rows affected when setting reservation refund pending", "error", err)
-			_ = transaction.Rollback()
-			return ErrInternal
+		if !check.Product {
+			return 0, product.ErrProductNotFound
 		}
-		if rows != 1 {
-			slog.Warn("reservation not updated - might already be in REFUND_PENDING status", "rows", rows, "reservation_id", reservationId)
-			_ = transaction.Rollback()
-			return nil
+		if !check.Location {
+			return 0, product.ErrLocationNotFound
+		}
+		if !check.Sold {
+			return 0, product.ErrNotSoldAtLocation
+		}
+		if !check.InFuture {
+			return 0, product.ErrEffectiveDatePast
 		}
 	}
-	{
-		const refundDataStatement = `
-		INSERT INTO reservation_refund_data (appointment_id, name, phone, email, reason)
-			VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT (appointment_id) DO NOTHING
-		`
 
-		_, err = transaction.Exec(
-			refundDataStatement,
-			reservationId,
-			refundData.Name,
-			refundData.Phone,
-			refundData.Email,
-			refundData.Reason,
-		)
-		if err != nil {
-			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
-		}
+	var fulfillment *string
+	if change.FulfillmentType != nil {
+		dbFulfillment := change.FulfillmentType.DbValue()
+		fulfillment = &dbFulfillment
 	}
 
-	_ = transaction.Commit()
+	const statement = `
+	INSERT INTO item_price_change (item_id, location_id, effective_date, effective_at, price_per_unit, vat, fulfillment_type, location_only)
+		SELECT
+			$1,
+			id,
+			$3::DATE,
+			$3::DATE::TIMESTAMP AT TIME ZONE time_zone,
+			$4,
+			($5::DECIMAL(6, 2) * 0.01::DECIMAL(6, 2))::DECIMAL(4, 2),
+			$6::fulfillment_type,
+			$7
+		FROM location
+		WHERE id = $2
+	RETURNING id
+	`
+
+	var id int64
+	err := db.Get(&id, statement, productId, change.LocationId, effectiveDate, change.Price, change.Vat, fulfillment, change.LocationOnly)
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, ErrInternal
+	}
+
+	return id, nil
+}
+
+// The location, product and category of a rule have to belong to its business.
+func checkPricingRuleScope(queryer sqlx.Queryer, businessId int64, rule product.PricingRule) error {
+	const query = `
+	SELECT
+		$2::INTEGER IS NULL OR EXISTS (
+			SELECT 1
+			FROM location
+			WHERE
+				id = $2
+				AND business_id = $1
+		) AS location_exists,
+		$3::INTEGER IS NULL OR EXISTS (
+			SELECT 1
+			FROM item
+			WHERE
+				id = $3
+				AND business_id = $1
+		) AS product_exists,
+		$4::INTEGER IS NULL OR EXISTS (
+			SELECT 1
+			FROM category
+			WHERE
+				id = $4
+				AND business_id = $1
+		) AS category_exists
+	`
+
+	var exists struct {
+		Location bool `db:"location_exists"`
+		Product  bool `db:"product_exists"`
+		Category bool `db:"category_exists"`
+	}
+	if err := sqlx.Get(queryer, &exists, query, businessId, rule.LocationId, rule.ProductId, rule.CategoryId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if !exists.Location {
+		return product.ErrLocationNotFound
+	}
+	if !exists.Product {
+		return product.ErrProductNotFound
+	}
+	if !exists.Category {
+		return product.ErrCategoryNotFound
+	}
 
 	return nil
 }
 
-// Prob will delete, needed for testing
-func (pdb PostgresDb) CancelReservationRefundRequest(reservationId int32) error {
-	transaction, err := pdb.Db.Beginx()
-	if err != nil {
+func checkProductExists(queryer sqlx.Queryer, productId int64) error {
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM item
+		WHERE id = $1
+	)
+	`
+
+	var exists bool
+	if err := sqlx.Get(queryer, &exists, query, productId); err != nil {
 		slog.Error(err.Error())
 		return ErrInternal
 	}
+	if !exists {
+		return product.ErrProductNotFound
+	}
 
-	{
-		const appointmentStatusStatement = `
-		UPDATE appointment
-		SET status = 'COMPLETED'
+	return nil
+}
+
+// Variations can only be in the groups of their own product.
+func checkVariationGroupOfProduct(queryer sqlx.Queryer, productId int64, groupId int64) error {
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM variation_group
 		WHERE
 			id = $1
-			AND status = 'REFUND_PENDING'
-		`
+			AND item_id = $2
+	)
+	`
 
-		res, err := transaction.Exec(appointmentStatusStatement, reservationId)
-		if err != nil {
-			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
-		}
-		rows, err := res.RowsAffected()
-		if err != nil || rows != 1 {
-			slog.Error("unexpected rows affected when canceling reservation refund", "rows", rows, "error", err)
-			_ = transaction.Rollback()
-			return ErrInternal
-		}
+	var exists bool
+	if err := sqlx.Get(queryer, &exists, query, groupId, productId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if !exists {
+		return product.ErrGroupNotFound
 	}
-	{
-		const deleteRefundDataStatement = `
-		DELETE FROM reservation_refund_data WHERE appointment_id = $1
-		`
 
-		_, err = transaction.Exec(deleteRefundDataStatement, reservationId)
-		if err != nil {
-			slog.Error(err.Error())
-			_ = transaction.Rollback()
-			return ErrInternal
-		}
+	return nil
+}
+
+// Active default variations have to fit in the maximum of their group, otherwise every new line would be rejected.
+func checkVariationGroupDefaults(queryer sqlx.Queryer, groupId int64) error {
+	const query = `
+	SELECT
+		variation_group.max_selected IS NULL
+		OR variation_group.max_selected >= (
+			SELECT COUNT(*)
+			FROM item_variation
+			WHERE
+				item_variation.group_id = variation_group.id
+				AND item_variation.is_default
+				AND item_variation.status = 'ACTIVE'
+		)
+	FROM variation_group
+	WHERE id = $1
+	`
+
+	var fits bool
+	if err := sqlx.Get(queryer, &fits, query, groupId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if !fits {
+		return product.ErrTooManyDefaults
 	}
 
-	_ = transaction.Commit()
+	return nil
+}
+
+func checkBusinessExists(queryer sqlx.Queryer, businessId int64) error {
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM business
+		WHERE id = $1
+	)
+	`
+
+	var exists bool
+	if err := sqlx.Get(queryer, &exists, query, businessId); err != nil {
+		slog.Error(err.Error())
+		return ErrInternal
+	}
+	if !exists {
+		return product.ErrBusinessNotFound
+	}
 
 	return nil
 }
 
-// payment.OrderTipProvider implementation ---------------------------------------------------------
-func (pdb PostgresDb) GetOrderTipCents(orderID int64) (int64, error) {
+func checkPriceListExists(queryer sqlx.Queryer, priceListId int64) error {
 	const query = `
-	SELECT CAST(ROUND(tip) AS BIGINT) AS tip_cents
-	FROM order_detail
-	WHERE id = $1
-	LIMIT 1
+	SELECT EXISTS (
+		SELECT 1
+		FROM price_list
+		WHERE id = $1
+	)
 	`
-	var tipCents int64
-	if err := pdb.Db.Get(&tipCents, query, orderID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return 0, nil
-		}
+
+	var exists bool
+	if err := sqlx.Get(queryer, &exists, query, priceListId); err != nil {
 		slog.Error(err.Error())
-		return 0, ErrInternal
+		return ErrInternal
 	}
-	return tipCents, nil
+	if !exists {
+		return product.ErrPriceListNotFound
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	return violatedUniqueConstraint(err) != ""
+}
+
+// Name of the unique constraint the error is about, or an empty string.
+func violatedUniqueConstraint(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return pqErr.Constraint
+	}
+	return ""
 }
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+
+	"dreampos/internal/config"
+	"dreampos/internal/events"
+)
+
+const (
+	eventChannel = "app_event"
+	// How long events are kept for clients that reconnect.
+	eventRetention = 24 * time.Hour
+	// Events read at once from the table.
+	eventBatch = 1000
+	// How often held back events are read again while older transactions are still running.
+	eventRetryInterval = time.Second
+)
+
+// PostgresListener receives the events published by the database triggers.
+type PostgresListener struct {
+	Db       PostgresDb
+	Listener *pq.Listener
+}
+
+// MustCreatePostgresListener opens the connection for the notifications, the events are read through db.
+func MustCreatePostgresListener(config config.Config, db PostgresDb) PostgresListener {
+	listener := pq.NewListener(dataSourceName(config), 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Error("event listener connection problem", "event", event, "error", err)
+		}
+	})
+	if err := listener.Listen(eventChannel); err != nil {
+		panic(err)
+	}
+
+	return PostgresListener{
+		Db:       db,
+		Listener: listener,
+	}
+}
+
+// Listen calls publish for every event until the listener is closed.
+// Notifications only wake the listener up, the events are read from the table in stream order,
+// so events committed late or while the connection was down are not skipped.
+func (l PostgresListener) Listen(publish func(events.Event)) {
+	cursor, err := getLastEventCursor(l.Db.Db, nil)
+	if err != nil {
+		slog.Error("failed to read the last event, publishing every retained event", "error", err)
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+	// Set while committed events are held back by older running transactions
+	var retry <-chan time.Time
+
+	for {
+		select {
+		case _, ok := <-l.Listener.Notify:
+			if !ok {
+				return
+			}
+			// nil is sent after the connection was re-established, reading the table catches up
+		case <-retry:
+		case <-ping.C:
+			if err := l.Listener.Ping(); err != nil {
+				slog.Error("event listener ping failed", "error", err)
+			}
+			continue
+		case <-cleanup.C:
+			const statement = `
+			DELETE FROM app_event
+			WHERE created_at < NOW() - $1::interval
+			`
+			if _, err := l.Db.Db.Exec(statement, eventRetention.String()); err != nil {
+				slog.Error(err.Error())
+			}
+			continue
+		}
+
+		var pending bool
+		cursor, pending = l.publishAfter(cursor, publish)
+		retry = nil
+		if pending {
+			retry = time.After(eventRetryInterval)
+		}
+	}
+}
+
+// Publishes the events after the cursor and returns the cursor of the last published event.
+// pending is true if committed events are still held back by older running transactions.
+func (l PostgresListener) publishAfter(cursor eventCursor, publish func(events.Event)) (eventCursor, bool) {
+	for {
+		batch, err := getEventsAfter(l.Db.Db, nil, cursor, eventBatch)
+		if err != nil {
+			return cursor, true
+		}
+		for _, row := range batch {
+			publish(row.Event)
+			cursor = eventCursor{Xid: row.Xid, Id: row.Id}
+		}
+		if len(batch) < eventBatch {
+			break
+		}
+	}
+
+	const query = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM app_event
+		WHERE (xid, id) > (($1::TEXT)::xid8, $2)
+	)
+	`
+	pending := false
+	if err := l.Db.Db.Get(&pending, query, cursor.Xid, cursor.Id); err != nil {
+		slog.Error(err.Error())
+		return cursor, true
+	}
+
+	return cursor, pending
+}
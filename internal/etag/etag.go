@@ -0,0 +1,37 @@
+// Package etag converts row versions to HTTP entity tags and back.
+package etag
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Format returns a strong entity tag for the version, e.g. "3".
+func Format(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ParseIfMatch parses the value of an If-Match header.
+// Returns nil version for "*" (any version matches) and ok = false
+// if the header is missing or is not a tag produced by Format.
+func ParseIfMatch(header string) (version *int64, ok bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil, false
+	}
+	if header == "*" {
+		return nil, true
+	}
+
+	header = strings.TrimPrefix(header, "W/")
+	if len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		return nil, false
+	}
+
+	parsed, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || parsed <= 0 {
+		return nil, false
+	}
+
+	return &parsed, true
+}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// Subscribers that don't keep up are disconnected, the client reconnects
+// with Last-Event-ID and the missed events are read from the database.
+const subscriberBuffer = 64
+
+// Broker delivers the events received by this server to its open streams.
+type Broker struct {
+	mutex       sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	businessId int64
+	events     chan Event
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[*subscriber]struct{}{}}
+}
+
+// Subscribe returns the events of the business and a function that has to be called
+// when the subscriber is done. The channel is closed if the subscriber falls behind.
+func (b *Broker) Subscribe(businessId int64) (<-chan Event, func()) {
+	s := &subscriber{
+		businessId: businessId,
+		events:     make(chan Event, subscriberBuffer),
+	}
+
+	b.mutex.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mutex.Unlock()
+
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if _, ok := b.subscribers[s]; ok {
+			delete(b.subscribers, s)
+			close(s.events)
+		}
+	}
+	return s.events, unsubscribe
+}
+
+func (b *Broker) Publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscribers {
+		if s.businessId != event.BusinessId {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			slog.Warn("event subscriber is too slow, disconnecting", "business_id", s.businessId, "event_id", event.Id)
+			delete(b.subscribers, s)
+			close(s.events)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package events
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"dreampos/internal/auth"
+	"dreampos/internal/sse"
+)
+
+// Clients that missed more events, or whose last event is no longer retained, get a reset event
+// and should reload their lists.
+const maxReplayedEvents = 500
+
+type EventController struct {
+	EventRepo EventRepo
+	Broker    *Broker
+}
+
+func (c EventController) Routes() http.Handler {
+	router := chi.NewRouter()
+
+	router.Get("/", c.stream)
+
+	return router
+}
+
+// Streams the events of the user's business. Optional query param locationId narrows the
+// stream to one location. Events without a location are sent to every location.
+func (c EventController) stream(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	business, err := c.EventRepo.GetBusinessInfo(user.Username)
+	if err != nil {
+		http.Error(w, "failed to get business info", http.StatusInternalServerError)
+		return
+	}
+
+	locationIds := make([]int64, 0, len(business.Locations))
+	for _, location := range business.Locations {
+		locationIds = append(locationIds, location.Id)
+	}
+	if paramString := r.URL.Query().Get("locationId"); paramString != "" {
+		locationId, err := strconv.ParseInt(paramString, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid param 'locationId'.", http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(locationIds, locationId) {
+			http.Error(w, ErrLocationNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		locationIds = []int64{locationId}
+	}
+
+	// EventSource sends the header when it reconnects, the query param is for the first connection.
+	lastEventId := int64(-1)
+	{
+		paramString := r.Header.Get("Last-Event-ID")
+		if paramString == "" {
+			paramString = r.URL.Query().Get("lastEventId")
+		}
+		if paramString != "" {
+			lastEventId, err = strconv.ParseInt(paramString, 10, 64)
+			if err != nil || lastEventId < 0 {
+				http.Error(w, "invalid last event id", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// Subscribed before the missed events are read, so nothing falls in between.
+	live, unsubscribe := c.Broker.Subscribe(business.Id)
+	defer unsubscribe()
+
+	var missed []Event
+	// Set if the client has to reload because the missed events can't be replayed
+	resetId := int64(-1)
+	if lastEventId >= 0 {
+		missed, err = c.EventRepo.GetEventsAfter(business.Id, lastEventId, maxReplayedEvents)
+		if errors.Is(err, ErrEventExpired) {
+			resetId, err = c.EventRepo.GetLastEventId(business.Id)
+		}
+		if err != nil {
+			http.Error(w, "failed to get events", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(missed) == maxReplayedEvents {
+		resetId = missed[len(missed)-1].Id
+	}
+
+	inScope := func(event Event) bool {
+		return event.LocationId == nil || slices.Contains(locationIds, *event.LocationId)
+	}
+
+	out := make(chan sse.Event)
+	go func() {
+		defer close(out)
+
+		send := func(event sse.Event) bool {
+			select {
+			case out <- event:
+				return true
+			case <-r.Context().Done():
+				return false
+			}
+		}
+
+		// Events that were read from the database can come again from the broker
+		replayed := map[int64]bool{}
+		if resetId >= 0 {
+			for _, event := range missed {
+				replayed[event.Id] = true
+			}
+			// Without events in the business the client's id is kept and it gets reset again on reconnect
+			id := ""
+			if resetId > 0 {
+				id = strconv.FormatInt(resetId, 10)
+			}
+			if !send(sse.Event{Id: id, Type: "reset", Data: map[string]any{}}) {
+				return
+			}
+			missed = nil
+		}
+		for _, event := range missed {
+			replayed[event.Id] = true
+			if !inScope(event) {
+				continue
+			}
+			if !send(toSse(event)) {
+				return
+			}
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event, ok := <-live:
+				if !ok {
+					return
+				}
+				if replayed[event.Id] || !inScope(event) {
+					continue
+				}
+				if !send(toSse(event)) {
+					return
+				}
+			}
+		}
+	}()
+
+	sse.Serve(w, r, out)
+}
+
+func toSse(event Event) sse.Event {
+	return sse.Event{
+		Id:   strconv.FormatInt(event.Id, 10),
+		Type: string(event.Type),
+		Data: event,
+	}
+}
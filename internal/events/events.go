@@ -0,0 +1,43 @@
+// Package events pushes order and reservation changes to the clients over Server-Sent Events.
+// Events are written by database triggers and fanned out to every server over Postgres LISTEN/NOTIFY.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+
+	"dreampos/internal/auth"
+)
+
+type Type string
+
+const (
+	OrderCreated       Type = "order.created"
+	OrderUpdated       Type = "order.updated"
+	OrderClosed        Type = "order.closed"
+	ReservationCreated Type = "reservation.created"
+	ReservationUpdated Type = "reservation.updated"
+	// The kitchen stores its events with a "kitchen." type, they are delivered to the screens by kitchen.Hub.
+)
+
+type Event struct {
+	Id         int64           `json:"id"         db:"id"`
+	BusinessId int64           `json:"businessId" db:"business_id"`
+	LocationId *int64          `json:"locationId" db:"location_id"`
+	Type       Type            `json:"type"       db:"type"`
+	Data       json.RawMessage `json:"data"       db:"data"`
+}
+
+var (
+	ErrLocationNotFound = errors.New("location not found")
+	ErrEventExpired     = errors.New("event is no longer retained")
+)
+
+type EventRepo interface {
+	// Returns at most limit events of the business that were streamed after the given event, in stream order.
+	// Returns ErrEventExpired if the event is no longer retained.
+	GetEventsAfter(businessId int64, afterId int64, limit uint64) ([]Event, error)
+	// Returns the id of the last streamed event of the business, 0 if there are none.
+	GetLastEventId(businessId int64) (int64, error)
+	GetBusinessInfo(username string) (auth.BusinessInfo, error)
+}
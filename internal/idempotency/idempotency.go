@@ -0,0 +1,51 @@
+// Package idempotency lets clients safely retry non idempotent requests
+// by sending the same Idempotency-Key header.
+package idempotency
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const HeaderKey = "Idempotency-Key"
+
+var (
+	// The key was already used for a request with a different body.
+	ErrKeyReused = errors.New("idempotency key was used for a different request")
+	// The first request with the key has not finished yet.
+	ErrInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// Response stored for the first request with a key.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+type Store interface {
+	// Claims the key for the user. Returns nil response if the key was claimed
+	// and the request should be handled, or the stored response if it should be replayed.
+	// Expired keys are treated as unused.
+	Claim(username, key, requestHash string) (*Response, error)
+	// Stores the response for a previously claimed key, it's kept for retention.
+	Complete(username, key string, response Response, retention time.Duration) error
+	// Frees a previously claimed key so the request can be retried.
+	Release(username, key string) error
+	// Deletes the expired keys.
+	DeleteExpired() error
+}
+
+// Deletes expired keys from the store every interval, never returns.
+func RunCleanup(store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := store.DeleteExpired(); err != nil {
+			slog.Error("failed to delete expired idempotency keys", "error", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"dreampos/internal/auth"
+)
+
+const maxKeyLength = 255
+
+// Headers that are stored and sent back when a response is replayed.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+type Middleware struct {
+	Store     Store
+	Retention time.Duration
+}
+
+// Handler replays the stored response for requests with an already used Idempotency-Key.
+// Requests without the header are passed through unchanged.
+// Must be used after the auth middleware, keys are scoped per user.
+func (m Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(w, "idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := r.Context().Value("user").(auth.User)
+		if !ok || user.Username == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		stored, err := m.Store.Claim(user.Username, key, requestHash)
+		if errors.Is(err, ErrKeyReused) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else if errors.Is(err, ErrInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		if stored != nil {
+			for name, values := range stored.Header {
+				for _, value := range values {
+					w.Header().Add(name, value)
+				}
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		// Server errors are not stored so the client can retry with the same key.
+		if recorder.statusCode >= http.StatusInternalServerError {
+			if err := m.Store.Release(user.Username, key); err != nil {
+				slog.Error("failed to release idempotency key", "error", err)
+			}
+			return
+		}
+
+		header := http.Header{}
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+		response := Response{
+			StatusCode: recorder.statusCode,
+			Header:     header,
+			Body:       recorder.body.Bytes(),
+		}
+		if err := m.Store.Complete(user.Username, key, response, m.Retention); err != nil {
+			slog.Error("failed to store idempotent response", "error", err)
+		}
+	})
+}
+
+// Writes the response through and keeps a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,355 @@
+package kitchen
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"dreampos/internal/auth"
+	"dreampos/internal/sse"
+)
+
+type KitchenController struct {
+	KitchenRepo KitchenRepo
+	Hub         *Hub
+}
+
+func (c KitchenController) Routes() http.Handler {
+	router := chi.NewRouter()
+
+	manage := router.With(auth.RequirePermission(auth.PermissionManageCatalog))
+
+	router.Get("/station", c.stations)
+	manage.Post("/station", c.createStation)
+	manage.Delete("/station/{stationId:^[0-9]{1,10}$}", c.deleteStation)
+	router.Get("/station/{stationId:^[0-9]{1,10}$}/ticket", c.tickets)
+	router.Get("/station/{stationId:^[0-9]{1,10}$}/stream", c.stationStream)
+	router.Get("/ready/stream", c.readyStream)
+	router.Post("/order/{orderId:^[0-9]{1,10}$}/send", c.sendOrder)
+	router.Post("/item/{orderItemId:^[0-9]{1,10}$}/status", c.setItemStatus)
+
+	return router
+}
+
+func (c KitchenController) stations(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	locationId, err := strconv.ParseInt(r.URL.Query().Get("locationId"), 10, 64)
+	if err != nil {
+		http.Error(w, "failed to get location id", http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
+
+	stations, err := c.KitchenRepo.GetStations(locationId)
+	if err != nil {
+		http.Error(w, "failed to get kitchen stations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stations); err != nil {
+		http.Error(w, "failed to get kitchen stations", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c KitchenController) createStation(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	var body struct {
+		LocationId int64    `json:"locationId"`
+		Name       string   `json:"name"`
+		Categories []string `json:"categories"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid kitchen station", http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.LocationId <= 0 || body.Name == "" || len(body.Name) > 64 {
+		http.Error(w, "location id and name (max 64 characters) are required", http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, body.LocationId) {
+		return
+	}
+
+	id, err := c.KitchenRepo.CreateStation(body.LocationId, body.Name, body.Categories)
+	if errors.Is(err, ErrCategoryNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to create kitchen station", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]any{"id": id}); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c KitchenController) deleteStation(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	stationId, err := strconv.ParseInt(r.PathValue("stationId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkStation(w, r, stationId) {
+		return
+	}
+
+	err = c.KitchenRepo.DeleteStation(stationId)
+	if errors.Is(err, ErrStationNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to delete kitchen station", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c KitchenController) tickets(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	stationId, err := strconv.ParseInt(r.PathValue("stationId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkStation(w, r, stationId) {
+		return
+	}
+
+	tickets, err := c.KitchenRepo.GetTickets(stationId)
+	if errors.Is(err, ErrStationNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to get tickets", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tickets); err != nil {
+		http.Error(w, "failed to get tickets", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Streams sent and bumped lines of the station. Screens load the tickets first
+// and apply the events on top of them.
+func (c KitchenController) stationStream(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	stationId, err := strconv.ParseInt(r.PathValue("stationId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkStation(w, r, stationId) {
+		return
+	}
+
+	c.stream(w, r, func(event Event) bool {
+		return slices.Contains(event.Item.StationIds, stationId)
+	})
+}
+
+// Streams lines of the location that became ready, so the cashier can call the customer.
+func (c KitchenController) readyStream(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	locationId, err := strconv.ParseInt(r.URL.Query().Get("locationId"), 10, 64)
+	if err != nil {
+		http.Error(w, "failed to get location id", http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
+
+	c.stream(w, r, func(event Event) bool {
+		return event.Type == EventStatusChanged &&
+			event.Item.Status == PrepReady &&
+			event.Item.LocationId == locationId
+	})
+}
+
+func (c KitchenController) stream(w http.ResponseWriter, r *http.Request, filter func(Event) bool) {
+	events, unsubscribe := c.Hub.Subscribe(filter)
+	defer unsubscribe()
+
+	out := make(chan sse.Event)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				select {
+				case out <- sse.Event{Type: string(event.Type), Data: event.Item}:
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+	}()
+
+	sse.Serve(w, r, out)
+}
+
+func (c KitchenController) sendOrder(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	items, err := c.KitchenRepo.SendOrder(orderId, user.Username)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrOrderNotActive) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to send order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c KitchenController) setItemStatus(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderItemId, err := strconv.ParseInt(r.PathValue("orderItemId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var body struct {
+		Status PrepStatus `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	body.Status = PrepStatus(strings.ToLower(strings.TrimSpace(string(body.Status))))
+	if !body.Status.Valid() {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+
+	item, err := c.KitchenRepo.SetItemStatus(orderItemId, user.Username, body.Status)
+	if errors.Is(err, ErrItemNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrItemNotSent) || errors.Is(err, ErrInvalidStatusChange) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to change status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(item); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Writes an error and returns false if the location is not one of the user's business.
+func (c KitchenController) checkLocation(w http.ResponseWriter, r *http.Request, locationId int64) bool {
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	business, err := c.KitchenRepo.GetBusinessInfo(user.Username)
+	if err != nil {
+		http.Error(w, "failed to get business info", http.StatusInternalServerError)
+		return false
+	}
+
+	for _, location := range business.Locations {
+		if location.Id == locationId {
+			return true
+		}
+	}
+	http.Error(w, ErrLocationNotFound.Error(), http.StatusNotFound)
+	return false
+}
+
+// Writes an error and returns false if the station doesn't exist or is not at a location of the user's business.
+func (c KitchenController) checkStation(w http.ResponseWriter, r *http.Request, stationId int64) bool {
+	station, err := c.KitchenRepo.GetStation(stationId)
+	if errors.Is(err, ErrStationNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	} else if err != nil {
+		http.Error(w, "failed to get kitchen station", http.StatusInternalServerError)
+		return false
+	}
+
+	return c.checkLocation(w, r, station.LocationId)
+}
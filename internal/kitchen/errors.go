@@ -0,0 +1,14 @@
+package kitchen
+
+import "errors"
+
+var (
+	ErrStationNotFound     = errors.New("kitchen station not found")
+	ErrCategoryNotFound    = errors.New("category not found")
+	ErrItemNotFound        = errors.New("order item not found")
+	ErrItemNotSent         = errors.New("order item was not sent to the kitchen")
+	ErrInvalidStatusChange = errors.New("preparation status can only move forward")
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrOrderNotActive      = errors.New("order is cancelled or refunded")
+	ErrLocationNotFound    = errors.New("location not found")
+)
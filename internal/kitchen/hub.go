@@ -0,0 +1,106 @@
+package kitchen
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"dreampos/internal/events"
+)
+
+type EventType string
+
+const (
+	EventItemSent      EventType = "item_sent"
+	EventStatusChanged EventType = "status_changed"
+)
+
+// Kitchen events are stored as app events of this type prefix, so every server gets them.
+const appEventPrefix = "kitchen."
+
+// Type of the app event that carries the kitchen event.
+func (t EventType) AppEventType() events.Type {
+	return events.Type(appEventPrefix + string(t))
+}
+
+type Event struct {
+	Type EventType
+	Item ItemUpdate
+}
+
+// Subscribers that don't keep up are disconnected, screens reload their tickets on reconnect.
+const subscriberBuffer = 64
+
+// Hub delivers the kitchen events received by this server to its open streams.
+type Hub struct {
+	mutex       sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	events chan Event
+	filter func(Event) bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: map[*subscriber]struct{}{}}
+}
+
+// Subscribe returns the events matching the filter and a function that has to be called
+// when the subscriber is done. The channel is closed if the subscriber falls behind.
+func (h *Hub) Subscribe(filter func(Event) bool) (<-chan Event, func()) {
+	s := &subscriber{
+		events: make(chan Event, subscriberBuffer),
+		filter: filter,
+	}
+
+	h.mutex.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mutex.Unlock()
+
+	unsubscribe := func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		if _, ok := h.subscribers[s]; ok {
+			delete(h.subscribers, s)
+			close(s.events)
+		}
+	}
+	return s.events, unsubscribe
+}
+
+// PublishAppEvent publishes the kitchen events among the app events, other events are ignored.
+func (h *Hub) PublishAppEvent(event events.Event) {
+	eventType, ok := strings.CutPrefix(string(event.Type), appEventPrefix)
+	if !ok {
+		return
+	}
+
+	var item ItemUpdate
+	if err := json.Unmarshal(event.Data, &item); err != nil {
+		slog.Error("invalid kitchen event", "event_id", event.Id, "error", err)
+		return
+	}
+
+	h.Publish(Event{Type: EventType(eventType), Item: item})
+}
+
+func (h *Hub) Publish(event Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for s := range h.subscribers {
+		if !s.filter(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			slog.Warn("kitchen subscriber is too slow, disconnecting", "type", event.Type, "order_item_id", event.Item.OrderItemId)
+			delete(h.subscribers, s)
+			close(s.events)
+		}
+	}
+}
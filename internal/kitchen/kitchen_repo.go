@@ -0,0 +1,20 @@
+package kitchen
+
+import "dreampos/internal/auth"
+
+type KitchenRepo interface {
+	GetBusinessInfo(username string) (auth.BusinessInfo, error)
+	GetStations(locationId int64) ([]Station, error)
+	GetStation(stationId int64) (Station, error)
+	CreateStation(locationId int64, name string, categories []string) (int64, error)
+	DeleteStation(stationId int64) error
+	// Tickets are ordered by the time they were sent, oldest first.
+	GetTickets(stationId int64) ([]Ticket, error)
+	// Sends all lines of the order that were not sent yet and returns them.
+	// Orders of other businesses than the user's are not found.
+	// An EventItemSent is published to every server for each line.
+	SendOrder(orderId int64, username string) ([]ItemUpdate, error)
+	// Lines of other businesses than the user's are not found.
+	// An EventStatusChanged is published to every server.
+	SetItemStatus(orderItemId int64, username string, status PrepStatus) (ItemUpdate, error)
+}
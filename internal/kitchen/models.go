@@ -0,0 +1,85 @@
+package kitchen
+
+import (
+	"strings"
+	"time"
+
+	"dreampos/internal/order"
+)
+
+// Preparation status of an order line, lines only move forward.
+type PrepStatus string
+
+const (
+	PrepNew        PrepStatus = "new"
+	PrepInProgress PrepStatus = "in_progress"
+	PrepReady      PrepStatus = "ready"
+	PrepServed     PrepStatus = "served"
+)
+
+var prepStatusOrder = map[PrepStatus]int{
+	PrepNew:        0,
+	PrepInProgress: 1,
+	PrepReady:      2,
+	PrepServed:     3,
+}
+
+func (s PrepStatus) Valid() bool {
+	_, ok := prepStatusOrder[s]
+	return ok
+}
+
+// Cooks can skip statuses (e.g. bump a drink straight to ready), but not go back.
+func (s PrepStatus) CanMoveTo(next PrepStatus) bool {
+	return next.Valid() && prepStatusOrder[next] > prepStatusOrder[s]
+}
+
+// Converts DB enum value (e.g. IN_PROGRESS) to PrepStatus.
+func ParsePrepStatus(status string) PrepStatus {
+	return PrepStatus(strings.ToLower(status))
+}
+
+// Converts PrepStatus to DB enum value.
+func (s PrepStatus) DbValue() string {
+	return strings.ToUpper(string(s))
+}
+
+type Station struct {
+	Id         int64    `json:"id"         db:"id"`
+	LocationId int64    `json:"locationId" db:"location_id"`
+	Name       string   `json:"name"       db:"name"`
+	Categories []string `json:"categories"`
+}
+
+// Sent lines of one order that are routed to a station and not served yet.
+type Ticket struct {
+	OrderId       int64        `json:"orderId"`
+	TableName     *string      `json:"tableName"`
+	CustomerLabel string       `json:"customerLabel"`
+	SentAt        time.Time    `json:"sentAt"`
+	Items         []TicketItem `json:"items"`
+}
+
+type TicketItem struct {
+	OrderItemId int64            `json:"orderItemId"`
+	Name        string           `json:"name"`
+	Quantity    order.Quantity   `json:"quantity"`
+	Variations  []string         `json:"variations"`
+	Note        string           `json:"note"`
+	Modifiers   []order.Modifier `json:"modifiers"`
+	Status      PrepStatus       `json:"status"`
+	SentAt      time.Time        `json:"sentAt"`
+	// Products chosen for the slots of a bundle that the station has to prepare.
+	Components []string `json:"components"`
+}
+
+// Line that was sent or bumped, with the stations it's routed to.
+type ItemUpdate struct {
+	OrderId     int64          `json:"orderId"`
+	OrderItemId int64          `json:"orderItemId"`
+	Name        string         `json:"name"`
+	Quantity    order.Quantity `json:"quantity"`
+	Status      PrepStatus     `json:"status"`
+	LocationId  int64          `json:"locationId"`
+	StationIds  []int64        `json:"stationIds"`
+}
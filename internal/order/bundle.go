@@ -0,0 +1,31 @@
+package order
+
+// Slot of a bundle product (e.g. "drink" in a burger menu), filled with one of its options when ordered.
+type BundleSlot struct {
+	Id       int64          `json:"id"       db:"id"`
+	Name     string         `json:"name"     db:"name"`
+	Position int64          `json:"position" db:"position"`
+	Options  []BundleOption `json:"options"`
+}
+
+// Product that can be chosen for a slot. Upcharge is added to the price of the bundle.
+type BundleOption struct {
+	ProductId int64  `json:"productId" db:"item_id"`
+	Name      string `json:"name"      db:"name"`
+	Upcharge  int64  `json:"upcharge"  db:"upcharge"`
+}
+
+// Product chosen for a slot of a bundle line.
+// Only SlotId and ProductId are used when the order is modified, the rest is set by the server.
+type Component struct {
+	// Nil if the slot has been deleted since.
+	SlotId    *int64 `json:"slotId"    db:"slot_id"`
+	SlotName  string `json:"slotName"  db:"slot_name"`
+	ProductId int64  `json:"productId" db:"item_id"`
+	Name      string `json:"name"      db:"item_name"`
+	Upcharge  int64  `json:"upcharge"  db:"upcharge"`
+	Vat       int64  `json:"vat"       db:"vat"`
+	// Part of the unit price of the line that belongs to this component, for VAT.
+	// The allocated prices of a line add up to its unit price, give or take rounding.
+	AllocatedPrice int64 `json:"allocatedPrice" db:"allocated_price"`
+}
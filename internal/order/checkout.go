@@ -0,0 +1,8 @@
+package order
+
+// CheckoutExpirer closes any payment sessions that are still waiting
+// for the customer, so that an order can no longer be paid.
+// Returns ErrOrderAlreadyPaid if one of the sessions was already paid.
+type CheckoutExpirer interface {
+	ExpireOrderCheckouts(orderId int64) error
+}
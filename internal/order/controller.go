@@ -2,30 +2,55 @@ package order
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"dreampos/internal/auth"
+	"dreampos/internal/etag"
 )
 
 type OrderController struct {
 	OrderRepo   OrderRepo
 	ProductRepo ProductRepo
+	Checkouts   CheckoutExpirer
+	// Replays responses of retried requests, may be nil.
+	Idempotency func(http.Handler) http.Handler
 }
 
 func (c OrderController) Routes() http.Handler {
 	router := chi.NewRouter()
 
+	idempotent := router.With()
+	if c.Idempotency != nil {
+		idempotent = router.With(c.Idempotency)
+	}
+
 	router.Get("/", c.orders)
-	router.Post("/", c.createOrder)
-	router.Post("/{orderId:^[0-9]{1,10}$}", c.createOrder)
+	idempotent.Post("/", c.createOrder)
+	idempotent.Post("/{orderId:^[0-9]{1,10}$}", c.createOrder)
 	router.Patch("/{orderId:^[0-9]{1,10}$}", c.updateOrder)
 	router.Get("/{orderId:^[0-9]{1,10}$}", c.getOrder)
+	router.Get("/{orderId:^[0-9]{1,10}$}/history", c.getOrderHistory)
 	router.Post("/{orderId:^[0-9]{1,10}$}/ask-refund", c.askForRefund)
 	router.Delete("/{orderId:^[0-9]{1,10}$}/ask-refund/cancel", c.cancelRefundRequest)
+	router.Post("/{orderId:^[0-9]{1,10}$}/cancel", c.cancelOrder)
+	router.Post("/{orderId:^[0-9]{1,10}$}/hold", c.holdOrder)
+	router.Post("/{orderId:^[0-9]{1,10}$}/pickup", c.pickUpOrder)
+	router.Get("/held", c.heldOrders)
+	router.Post("/{orderId:^[0-9]{1,10}$}/merge", c.mergeOrder)
+	idempotent.Post("/{orderId:^[0-9]{1,10}$}/split", c.splitOrder)
+	router.Get("/counts", c.counts)
+	router.Get("/sales", c.sales)
+	router.Get("/export", c.export)
 	router.Get("/products", c.getProducts)
 
 	return router
@@ -36,71 +61,14 @@ func (c OrderController) orders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filter := OrderFilter{}
-	{
-		orderStatus := r.URL.Query().Get("orderStatus")
-		if orderStatus != "" && orderStatus != "all" {
-			filter.OrderStatus = &orderStatus
-		}
-	}
-	{
-		paramString := r.URL.Query().Get("from")
-		if paramString != "" {
-			orderFrom, err := time.Parse(time.DateOnly, paramString)
-			if err != nil {
-				http.Error(w, "invalid param 'from'.", http.StatusBadRequest)
-				return
-			}
-			filter.From = &orderFrom
-		}
-	}
-	{
-		paramString := r.URL.Query().Get("to")
-		if paramString != "" {
-			orderTo, err := time.Parse(time.DateOnly, paramString)
-			if err != nil {
-				http.Error(w, "invalid param 'to'.", http.StatusBadRequest)
-				return
-			}
-			orderTo = orderTo.Add(24 * time.Hour)
-			orderTo = orderTo.Add(-1 * time.Nanosecond)
-			filter.To = &orderTo
-		}
-	}
-	// Optional search by id
-	{
-		paramString := r.URL.Query().Get("id")
-		if paramString != "" {
-			if id, err := strconv.ParseInt(paramString, 10, 64); err == nil && id > 0 {
-				filter.Id = &id
-			} else {
-				http.Error(w, "invalid param 'id'.", http.StatusBadRequest)
-				return
-			}
-		}
-	}
-	// Pagination: limit and offset
-	{
-		paramString := r.URL.Query().Get("limit")
-		if paramString != "" {
-			if lim64, err := strconv.ParseUint(paramString, 10, 64); err == nil {
-				filter.Limit = &lim64
-			} else {
-				http.Error(w, "invalid param 'limit'.", http.StatusBadRequest)
-				return
-			}
-		}
+	filter, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	{
-		paramString := r.URL.Query().Get("offset")
-		if paramString != "" {
-			if off64, err := strconv.ParseUint(paramString, 10, 64); err == nil {
-				filter.Offset = &off64
-			} else {
-				http.Error(w, "invalid param 'offset'.", http.StatusBadRequest)
-				return
-			}
-		}
+	if filter.Limit == nil {
+		limit := defaultOrderPageSize
+		filter.Limit = &limit
 	}
 
 	orders, err := c.OrderRepo.GetOrders(filter)
@@ -109,12 +77,28 @@ func (c OrderController) orders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Counting is as slow as the search itself, so it's done only when asked for on the first page.
+	if filter.Cursor == nil && r.URL.Query().Get("withTotal") == "true" {
+		total, err := c.OrderRepo.CountOrders(filter)
+		if err != nil {
+			http.Error(w, "failed to send orders", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.FormatUint(total, 10))
+	}
+
+	if uint64(len(orders)) == *filter.Limit && len(orders) > 0 {
+		next := r.URL.Query()
+		next.Del("offset")
+		next.Set("cursor", strconv.FormatInt(orders[len(orders)-1].Id, 10))
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	if err = json.NewEncoder(w).Encode(orders); err != nil {
 		http.Error(w, "failed to send orders", http.StatusInternalServerError)
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
 }
 
 func (c OrderController) createOrder(w http.ResponseWriter, r *http.Request) {
@@ -133,9 +117,57 @@ func (c OrderController) createOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid order", http.StatusBadRequest)
 		return
 	}
+	if order.TableId != nil && *order.TableId <= 0 {
+		http.Error(w, "invalid table id", http.StatusBadRequest)
+		return
+	}
+	if order.PartySize != nil && *order.PartySize == 0 {
+		http.Error(w, "party size must be positive", http.StatusBadRequest)
+		return
+	}
+	if order.LocationId != nil && *order.LocationId <= 0 {
+		http.Error(w, "invalid location id", http.StatusBadRequest)
+		return
+	}
+	if msg := validateLabelAndNotes(order.CustomerLabel, order.Notes); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if order.CustomerPhone != nil && *order.CustomerPhone != "" && !phonePattern.MatchString(*order.CustomerPhone) {
+		http.Error(w, "customer phone must match +[3-15 digits]", http.StatusBadRequest)
+		return
+	}
+	if msg := validateItems(order.Items); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if addsPricedModifiers(order.Items, nil) && !user.HasPermission(auth.PermissionPriceModifiers) {
+		http.Error(w, "missing permission "+auth.PermissionPriceModifiers, http.StatusForbidden)
+		return
+	}
+	if order.PriceListId != nil && *order.PriceListId < 0 {
+		http.Error(w, "invalid price list id", http.StatusBadRequest)
+		return
+	}
+	if order.FulfillmentType != nil {
+		fulfillment := ParseFulfillmentType(string(*order.FulfillmentType))
+		if !fulfillment.Valid() {
+			http.Error(w, "invalid fulfillment type", http.StatusBadRequest)
+			return
+		}
+		order.FulfillmentType = &fulfillment
+	}
 
 	orderId, err := c.OrderRepo.CreateOrder(user.Username, order)
-	if err != nil {
+	if errors.Is(err, ErrTableNotFound) || errors.Is(err, ErrLocationNotFound) || errors.Is(err, ErrPriceListNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrInvalidQuantity) || errors.Is(err, ErrInvalidComponents) ||
+		errors.Is(err, ErrInvalidVariations) || errors.Is(err, ErrNotAvailable) ||
+		errors.Is(err, ErrLineNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, "failed to create order", http.StatusBadRequest)
 		return
 	}
@@ -163,18 +195,54 @@ func (c OrderController) getOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	items, err := c.OrderRepo.GetOrderItems(orderId)
-	if err != nil {
+	c.writeOrder(w, orderId, http.StatusOK)
+}
+
+// Writes the order items with the current version of the order as ETag.
+func (c OrderController) writeOrder(w http.ResponseWriter, orderId int64, status int) {
+	items, version, err := c.OrderRepo.GetOrderItemsWithVersion(orderId)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Format(version))
+	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(items); err != nil {
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
+}
 
-	w.WriteHeader(http.StatusOK)
+func (c OrderController) getOrderHistory(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	history, err := c.OrderRepo.GetOrderHistory(orderId)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to get order history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		http.Error(w, "failed to get order history", http.StatusInternalServerError)
+		return
+	}
 }
 
 func (c OrderController) updateOrder(w http.ResponseWriter, r *http.Request) {
@@ -182,25 +250,111 @@ func (c OrderController) updateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
+	version, ok := etag.ParseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		http.Error(w, "If-Match header is required", http.StatusPreconditionRequired)
+		return
+	}
+
 	var order Order
 	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
 		http.Error(w, "invalid order", http.StatusBadRequest)
 		return
 	}
+	if msg := validateLabelAndNotes(order.CustomerLabel, order.Notes); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if order.CustomerPhone != nil && *order.CustomerPhone != "" && !phonePattern.MatchString(*order.CustomerPhone) {
+		http.Error(w, "customer phone must match +[3-15 digits]", http.StatusBadRequest)
+		return
+	}
+	if msg := validateItems(order.Items); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if !user.HasPermission(auth.PermissionPriceModifiers) && addsPricedModifiers(order.Items, nil) {
+		// Lines are sent back with their modifiers, only the priced modifiers that weren't there need the permission.
+		// If the order changed in the meantime, the version check fails anyway.
+		current, _, err := c.OrderRepo.GetOrderItemsWithVersion(orderId)
+		if errors.Is(err, ErrOrderNotFound) {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "failed to modify order", http.StatusInternalServerError)
+			return
+		}
+		if addsPricedModifiers(order.Items, current) {
+			http.Error(w, "missing permission "+auth.PermissionPriceModifiers, http.StatusForbidden)
+			return
+		}
+	}
+	if order.PriceListId != nil && *order.PriceListId < 0 {
+		http.Error(w, "invalid price list id", http.StatusBadRequest)
+		return
+	}
+	if order.FulfillmentType != nil {
+		fulfillment := ParseFulfillmentType(string(*order.FulfillmentType))
+		if !fulfillment.Valid() {
+			http.Error(w, "invalid fulfillment type", http.StatusBadRequest)
+			return
+		}
+		order.FulfillmentType = &fulfillment
+	}
 
-	err = c.OrderRepo.ModifyOrder(orderId, order)
-	if err != nil {
+	// A checkout that is still open was started for the old total, the customer has to start a new one
+	if c.Checkouts != nil {
+		err := c.Checkouts.ExpireOrderCheckouts(orderId)
+		if errors.Is(err, ErrOrderAlreadyPaid) {
+			http.Error(w, "order is already paid", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "failed to expire checkout session", http.StatusBadGateway)
+			return
+		}
+	}
+
+	newVersion, err := c.OrderRepo.ModifyOrder(orderId, user.Username, version, order)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrVersionMismatch) {
+		// Send back the current state so the client can merge and retry.
+		c.writeOrder(w, orderId, http.StatusPreconditionFailed)
+		return
+	} else if errors.Is(err, ErrOrderNotOpen) {
+		http.Error(w, "only open orders can be modified", http.StatusConflict)
+		return
+	} else if errors.Is(err, ErrPaymentPending) || errors.Is(err, ErrLineAlreadySent) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if errors.Is(err, ErrInvalidQuantity) || errors.Is(err, ErrInvalidComponents) ||
+		errors.Is(err, ErrInvalidVariations) || errors.Is(err, ErrNotAvailable) ||
+		errors.Is(err, ErrLineNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if errors.Is(err, ErrPriceListNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "failed to modify order", http.StatusBadRequest)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Format(newVersion))
 	w.WriteHeader(http.StatusOK)
 	response := map[string]any{
 		"id":      orderId,
@@ -217,6 +371,12 @@ func (c OrderController) askForRefund(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
@@ -229,8 +389,14 @@ func (c OrderController) askForRefund(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = c.OrderRepo.CreateRefundRequest(orderId, refundData)
-	if err != nil {
+	err = c.OrderRepo.CreateRefundRequest(orderId, user.Username, refundData)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, "failed to create refund request", http.StatusInternalServerError)
 		return
 	}
@@ -243,14 +409,26 @@ func (c OrderController) cancelRefundRequest(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	err = c.OrderRepo.CancelRefundRequest(orderId)
-	if err != nil {
+	err = c.OrderRepo.CancelRefundRequest(orderId, user.Username)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, "failed to cancel refund request", http.StatusInternalServerError)
 		return
 	}
@@ -258,6 +436,365 @@ func (c OrderController) cancelRefundRequest(w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (c OrderController) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var cancellation Cancellation
+	if err := json.NewDecoder(r.Body).Decode(&cancellation); err != nil {
+		http.Error(w, "bad cancellation data", http.StatusBadRequest)
+		return
+	}
+
+	cancellation.Reason = CancelReason(strings.ToLower(strings.TrimSpace(string(cancellation.Reason))))
+	cancellation.Note = strings.TrimSpace(cancellation.Note)
+	if !cancellation.Reason.Valid() {
+		http.Error(w, "invalid cancel reason", http.StatusBadRequest)
+		return
+	}
+	if cancellation.Reason == CancelOther && cancellation.Note == "" {
+		http.Error(w, "note is required when reason is 'other'", http.StatusBadRequest)
+		return
+	}
+	if len(cancellation.Note) > 512 {
+		http.Error(w, "note is too long", http.StatusBadRequest)
+		return
+	}
+
+	// Refuse early so that checkouts of orders that can't be cancelled are left alone.
+	facts, err := c.OrderRepo.GetOrderFacts(orderId)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to cancel order", http.StatusInternalServerError)
+		return
+	}
+	if _, err := Next(facts, EventCancel); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// Checkout has to be closed first, otherwise the customer
+	// could still pay for the order after it was cancelled.
+	if c.Checkouts != nil {
+		err = c.Checkouts.ExpireOrderCheckouts(orderId)
+		if errors.Is(err, ErrOrderAlreadyPaid) {
+			http.Error(w, "order is already paid", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "failed to expire checkout session", http.StatusBadGateway)
+			return
+		}
+	}
+
+	err = c.OrderRepo.CancelOrder(orderId, user.Username, cancellation)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to cancel order", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c OrderController) holdOrder(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var hold Hold
+	if err := json.NewDecoder(r.Body).Decode(&hold); err != nil {
+		http.Error(w, "invalid hold", http.StatusBadRequest)
+		return
+	}
+	hold.TerminalId = strings.TrimSpace(hold.TerminalId)
+	if hold.TerminalId == "" || len(hold.TerminalId) > maxTerminalIdLength {
+		http.Error(w, "terminal id is required", http.StatusBadRequest)
+		return
+	}
+	if msg := validateLabelAndNotes(hold.CustomerLabel, hold.Notes); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = c.OrderRepo.HoldOrder(orderId, user.Username, hold)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrOrderNotOpen) {
+		http.Error(w, "only open orders can be held", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to hold order", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c OrderController) pickUpOrder(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var body struct {
+		TerminalId string `json:"terminalId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid pickup", http.StatusBadRequest)
+		return
+	}
+	body.TerminalId = strings.TrimSpace(body.TerminalId)
+	if body.TerminalId == "" || len(body.TerminalId) > maxTerminalIdLength {
+		http.Error(w, "terminal id is required", http.StatusBadRequest)
+		return
+	}
+
+	err = c.OrderRepo.PickUpOrder(orderId, user.Username, body.TerminalId)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrOrderNotHeld) {
+		http.Error(w, "order is not held or was picked up by another terminal", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to pick up order", http.StatusInternalServerError)
+		return
+	}
+
+	c.writeOrder(w, orderId, http.StatusOK)
+}
+
+func (c OrderController) heldOrders(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	filter := HeldOrderFilter{}
+	if terminalId := r.URL.Query().Get("terminalId"); terminalId != "" {
+		filter.TerminalId = &terminalId
+	}
+	{
+		paramString := r.URL.Query().Get("locationId")
+		if paramString != "" {
+			locationId, err := strconv.ParseInt(paramString, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid param 'locationId'.", http.StatusBadRequest)
+				return
+			}
+			filter.LocationId = &locationId
+		}
+	}
+	if filter.TerminalId == nil && filter.LocationId == nil {
+		http.Error(w, "terminalId or locationId is required", http.StatusBadRequest)
+		return
+	}
+
+	orders, err := c.OrderRepo.GetHeldOrders(filter)
+	if err != nil {
+		http.Error(w, "failed to get held orders", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(orders); err != nil {
+		http.Error(w, "failed to get held orders", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c OrderController) mergeOrder(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var body struct {
+		IntoOrderId int64 `json:"intoOrderId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.IntoOrderId <= 0 {
+		http.Error(w, "target order id is required", http.StatusBadRequest)
+		return
+	}
+
+	err = c.OrderRepo.MergeOrders(orderId, body.IntoOrderId, user.Username)
+	if !writeMergeError(w, err, "failed to merge orders") {
+		return
+	}
+
+	c.writeOrder(w, body.IntoOrderId, http.StatusOK)
+}
+
+func (c OrderController) splitOrder(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orderId, err := strconv.ParseInt(r.PathValue("orderId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var body struct {
+		Lines []SplitLine `json:"lines"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Lines) == 0 {
+		http.Error(w, "lines to split are required", http.StatusBadRequest)
+		return
+	}
+
+	newOrderId, err := c.OrderRepo.SplitOrder(orderId, user.Username, body.Lines)
+	if !writeMergeError(w, err, "failed to split order") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	response := map[string]any{
+		"id":      newOrderId,
+		"message": "order split",
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Writes the error response of a merge or split and returns false if there was an error.
+func writeMergeError(w http.ResponseWriter, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrOrderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrSameOrder), errors.Is(err, ErrInvalidSplit):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrOrderNotOpen), errors.Is(err, ErrPaymentPending), errors.Is(err, ErrInvalidTransition),
+		errors.Is(err, ErrFulfillmentDiffer), errors.Is(err, ErrLocationDiffer), errors.Is(err, ErrPriceListDiffer):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+	return false
+}
+
+// Trims line notes and modifiers and returns the reason why the items are not valid, or an empty string.
+func validateItems(items []Item) string {
+	for i := range items {
+		if items[i].Note != nil {
+			note := strings.TrimSpace(*items[i].Note)
+			if len(note) > maxItemNoteLength {
+				return fmt.Sprintf("item note is too long (max %d characters)", maxItemNoteLength)
+			}
+			items[i].Note = &note
+		}
+		if len(items[i].Modifiers) > maxModifiersPerItem {
+			return fmt.Sprintf("too many modifiers (max %d per item)", maxModifiersPerItem)
+		}
+		for j := range items[i].Modifiers {
+			modifier := &items[i].Modifiers[j]
+			modifier.Group = strings.TrimSpace(modifier.Group)
+			modifier.Name = strings.TrimSpace(modifier.Name)
+			if modifier.Name == "" || len(modifier.Name) > 64 || len(modifier.Group) > 64 {
+				return "modifier name is required (max 64 characters), group is optional (max 64 characters)"
+			}
+		}
+	}
+	return ""
+}
+
+var phonePattern = regexp.MustCompile(`^\+[0-9]{3,15}$`)
+
+// Modifiers are free text, so the ones that change the price need a permission.
+// Reports if the items have priced modifiers that the current lines with the same id don't have.
+func addsPricedModifiers(items []Item, current []Item) bool {
+	currentModifiers := map[int64][]Modifier{}
+	for _, item := range current {
+		currentModifiers[item.Id] = item.Modifiers
+	}
+
+	for _, item := range items {
+		for _, modifier := range item.Modifiers {
+			if modifier.PriceModifier > 0 && !slices.Contains(currentModifiers[item.Id], modifier) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the reason why the label or notes are not valid, or an empty string.
+func validateLabelAndNotes(label *string, notes *string) string {
+	if label != nil && len(*label) > maxCustomerLabelLength {
+		return fmt.Sprintf("customer label is too long (max %d characters)", maxCustomerLabelLength)
+	}
+	if notes != nil && len(*notes) > maxNotesLength {
+		return fmt.Sprintf("notes are too long (max %d characters)", maxNotesLength)
+	}
+	return ""
+}
+
 func (c OrderController) counts(w http.ResponseWriter, r *http.Request) {
 	if w == nil || r == nil {
 		return
@@ -303,6 +840,143 @@ func (c OrderController) counts(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (c OrderController) sales(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	filter := OrderFilter{}
+	{
+		paramString := r.URL.Query().Get("from")
+		if paramString != "" {
+			orderFrom, err := time.Parse(time.DateOnly, paramString)
+			if err != nil {
+				http.Error(w, "invalid param 'from'.", http.StatusBadRequest)
+				return
+			}
+			filter.From = &orderFrom
+		}
+	}
+	{
+		paramString := r.URL.Query().Get("to")
+		if paramString != "" {
+			orderTo, err := time.Parse(time.DateOnly, paramString)
+			if err != nil {
+				http.Error(w, "invalid param 'to'.", http.StatusBadRequest)
+				return
+			}
+			orderTo = orderTo.Add(24 * time.Hour)
+			orderTo = orderTo.Add(-1 * time.Nanosecond)
+			filter.To = &orderTo
+		}
+	}
+
+	sales, err := c.OrderRepo.GetSales(filter)
+	if err != nil {
+		http.Error(w, "failed to get sales", http.StatusInternalServerError)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(sales); err != nil {
+		http.Error(w, "failed to get sales", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c OrderController) export(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	filter, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := ExportFormat(r.URL.Query().Get("format"))
+	switch format {
+	case "":
+		format = ExportCsv
+	case ExportCsv, ExportNdjson:
+	default:
+		http.Error(w, "invalid param 'format'.", http.StatusBadRequest)
+		return
+	}
+
+	granularity := ExportGranularity(r.URL.Query().Get("granularity"))
+	switch granularity {
+	case "":
+		granularity = ExportByLine
+	case ExportByLine, ExportByOrder:
+	default:
+		http.Error(w, "invalid param 'granularity'.", http.StatusBadRequest)
+		return
+	}
+
+	contentType := "text/csv"
+	if format == ExportNdjson {
+		contentType = "application/x-ndjson"
+	}
+	filename := fmt.Sprintf("orders-%s-%s.%s", granularity, time.Now().Format(time.DateOnly), format)
+
+	flusher := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flusher = f.Flush
+	}
+	writer := newExportWriter(format, w, flusher)
+
+	// Headers are sent with the first row, errors after that can only cut the export short.
+	started := false
+	start := func(columns []string) error {
+		if started {
+			return nil
+		}
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		w.WriteHeader(http.StatusOK)
+		return writer.Header(columns)
+	}
+
+	if granularity == ExportByOrder {
+		err = c.OrderRepo.ExportOrders(filter, func(o ExportOrder) error {
+			if err := start(exportOrderHeader); err != nil {
+				return err
+			}
+			return writer.Write(o.csvRecord(), o)
+		})
+		if err == nil {
+			err = start(exportOrderHeader)
+		}
+	} else {
+		err = c.OrderRepo.ExportOrderLines(filter, func(l ExportLine) error {
+			if err := start(exportLineHeader); err != nil {
+				return err
+			}
+			return writer.Write(l.csvRecord(), l)
+		})
+		if err == nil {
+			err = start(exportLineHeader)
+		}
+	}
+
+	if err != nil {
+		if !started {
+			http.Error(w, "failed to export orders", http.StatusInternalServerError)
+			return
+		}
+		slog.Error("order export was cut short", "error", err)
+		return
+	}
+
+	if err := writer.Flush(); err != nil {
+		slog.Error("failed to flush order export", "error", err)
+	}
+}
+
 func (c OrderController) getProducts(w http.ResponseWriter, r *http.Request) {
 	if w == nil || r == nil {
 		return
@@ -319,6 +993,7 @@ func (c OrderController) getProducts(w http.ResponseWriter, r *http.Request) {
 
 	category := r.URL.Query().Get("category")
 	includes := r.URL.Query().Get("includes")
+	sku := r.URL.Query().Get("sku")
 
 	if category != "" && category != "all" {
 		filter.Category = &category
@@ -326,6 +1001,17 @@ func (c OrderController) getProducts(w http.ResponseWriter, r *http.Request) {
 	if includes != "" {
 		filter.Includes = &includes // TODO: maybe some checking
 	}
+	if sku != "" {
+		filter.Sku = &sku
+	}
+	if value := r.URL.Query().Get("priceListId"); value != "" {
+		priceListId, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid param 'priceListId'", http.StatusBadRequest)
+			return
+		}
+		filter.PriceListId = &priceListId
+	}
 
 	products, err := c.ProductRepo.GetProducts(filter)
 	if err != nil {
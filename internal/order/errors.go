@@ -0,0 +1,27 @@
+package order
+
+import "errors"
+
+var (
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrOrderNotOpen      = errors.New("order is not open")
+	ErrOrderAlreadyPaid  = errors.New("order already paid")
+	ErrVersionMismatch   = errors.New("order was modified by someone else")
+	ErrTableNotFound     = errors.New("table not found")
+	ErrOrderNotHeld      = errors.New("order is not held")
+	ErrPaymentPending    = errors.New("order has a pending card payment")
+	ErrSameOrder         = errors.New("order cannot be merged into itself")
+	ErrInvalidSplit      = errors.New("invalid order lines to split")
+	ErrInvalidQuantity   = errors.New("quantity is not valid for the unit of the product")
+	ErrLocationNotFound  = errors.New("location not found")
+	ErrPriceListNotFound = errors.New("price list not found")
+	ErrEffectiveDatePast = errors.New("effective date must be in the future at the location")
+	ErrInvalidComponents = errors.New("every slot of a bundle needs exactly one of its options")
+	ErrInvalidVariations = errors.New("invalid variations")
+	ErrLineAlreadySent   = errors.New("order line was already sent to the kitchen")
+	ErrFulfillmentDiffer = errors.New("orders have different fulfillment types")
+	ErrLocationDiffer    = errors.New("orders are at different locations")
+	ErrPriceListDiffer   = errors.New("orders have different price lists")
+	ErrNotAvailable      = errors.New("product is not available at the location of the order")
+	ErrLineNotFound      = errors.New("order line not found")
+)
@@ -0,0 +1,181 @@
+package order
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+type ExportFormat string
+
+const (
+	ExportCsv    ExportFormat = "csv"
+	ExportNdjson ExportFormat = "ndjson"
+)
+
+type ExportGranularity string
+
+const (
+	ExportByLine  ExportGranularity = "line"
+	ExportByOrder ExportGranularity = "order"
+)
+
+// One order line of the export.
+// Amounts are in cents, VAT rate is in percent (e.g. "21.00").
+// Quantity can have decimals for products sold by weight, volume or length.
+type ExportLine struct {
+	OrderId     int64     `json:"orderId"     db:"order_id"`
+	CreatedAt   time.Time `json:"createdAt"   db:"created_at"`
+	Status      string    `json:"status"      db:"status"`
+	Currency    string    `json:"currency"    db:"currency"`
+	OrderItemId int64     `json:"orderItemId" db:"order_item_id"`
+	ProductId   int64     `json:"productId"   db:"item_id"`
+	ProductName string    `json:"productName" db:"item_name"`
+	Quantity    Quantity  `json:"quantity"    db:"quantity"`
+	Unit        Unit      `json:"unit"        db:"unit"`
+	UnitPrice   int64     `json:"unitPrice"   db:"unit_price"`
+	VatRate     string    `json:"vatRate"     db:"vat_rate"`
+	Net         int64     `json:"net"         db:"net"`
+	Vat         int64     `json:"vat"         db:"vat"`
+	Gross       int64     `json:"gross"       db:"gross"`
+}
+
+// One order of the export, line amounts are summed up.
+// Amounts are in cents.
+type ExportOrder struct {
+	OrderId       int64     `json:"orderId"       db:"order_id"`
+	CreatedAt     time.Time `json:"createdAt"     db:"created_at"`
+	Status        string    `json:"status"        db:"status"`
+	Currency      string    `json:"currency"      db:"currency"`
+	Employee      string    `json:"employee"      db:"employee"`
+	Lines         int64     `json:"lines"         db:"lines"`
+	Net           int64     `json:"net"           db:"net"`
+	Vat           int64     `json:"vat"           db:"vat"`
+	Gross         int64     `json:"gross"         db:"gross"`
+	Discount      int64     `json:"discount"      db:"discount"`
+	ServiceCharge int64     `json:"serviceCharge" db:"service_charge"`
+	Total         int64     `json:"total"         db:"total"`
+	Tip           int64     `json:"tip"           db:"tip"`
+	TotalWithTip  int64     `json:"totalWithTip"  db:"total_with_tip"`
+}
+
+var exportLineHeader = []string{
+	"order_id", "created_at", "status", "currency", "order_item_id", "product_id", "product_name",
+	"quantity", "unit", "unit_price", "vat_rate", "net", "vat", "gross",
+}
+
+func (l ExportLine) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(l.OrderId, 10),
+		l.CreatedAt.Format(time.RFC3339),
+		l.Status,
+		l.Currency,
+		strconv.FormatInt(l.OrderItemId, 10),
+		strconv.FormatInt(l.ProductId, 10),
+		l.ProductName,
+		l.Quantity.String(),
+		string(l.Unit),
+		strconv.FormatInt(l.UnitPrice, 10),
+		l.VatRate,
+		strconv.FormatInt(l.Net, 10),
+		strconv.FormatInt(l.Vat, 10),
+		strconv.FormatInt(l.Gross, 10),
+	}
+}
+
+var exportOrderHeader = []string{
+	"order_id", "created_at", "status", "currency", "employee", "lines", "net", "vat", "gross",
+	"discount", "service_charge", "total", "tip", "total_with_tip",
+}
+
+func (o ExportOrder) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(o.OrderId, 10),
+		o.CreatedAt.Format(time.RFC3339),
+		o.Status,
+		o.Currency,
+		o.Employee,
+		strconv.FormatInt(o.Lines, 10),
+		strconv.FormatInt(o.Net, 10),
+		strconv.FormatInt(o.Vat, 10),
+		strconv.FormatInt(o.Gross, 10),
+		strconv.FormatInt(o.Discount, 10),
+		strconv.FormatInt(o.ServiceCharge, 10),
+		strconv.FormatInt(o.Total, 10),
+		strconv.FormatInt(o.Tip, 10),
+		strconv.FormatInt(o.TotalWithTip, 10),
+	}
+}
+
+// Rows are flushed every so often, so the export never sits in memory as a whole.
+const exportFlushEvery = 500
+
+type exportWriter interface {
+	Header(columns []string) error
+	Write(record []string, value any) error
+	Flush() error
+}
+
+func newExportWriter(format ExportFormat, w io.Writer, flusher func()) exportWriter {
+	if format == ExportNdjson {
+		return &ndjsonExportWriter{encoder: json.NewEncoder(w), flusher: flusher}
+	}
+	return &csvExportWriter{writer: csv.NewWriter(w), flusher: flusher}
+}
+
+type csvExportWriter struct {
+	writer  *csv.Writer
+	flusher func()
+	rows    int
+}
+
+func (e *csvExportWriter) Header(columns []string) error {
+	return e.writer.Write(columns)
+}
+
+func (e *csvExportWriter) Write(record []string, _ any) error {
+	if err := e.writer.Write(record); err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushEvery == 0 {
+		return e.Flush()
+	}
+	return nil
+}
+
+func (e *csvExportWriter) Flush() error {
+	e.writer.Flush()
+	e.flusher()
+	return e.writer.Error()
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+	flusher func()
+	rows    int
+}
+
+func (e *ndjsonExportWriter) Header(_ []string) error {
+	return nil
+}
+
+func (e *ndjsonExportWriter) Write(_ []string, value any) error {
+	if err := e.encoder.Encode(value); err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushEvery == 0 {
+		return e.Flush()
+	}
+	return nil
+}
+
+func (e *ndjsonExportWriter) Flush() error {
+	e.flusher()
+	return nil
+}
@@ -0,0 +1,130 @@
+package order
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOrderPageSize uint64 = 100
+	maxOrderPageSize     uint64 = 100
+)
+
+// Parses the order search query params shared by the order list endpoints.
+func parseOrderFilter(query url.Values) (OrderFilter, error) {
+	filter := OrderFilter{}
+
+	orderStatus := query.Get("orderStatus")
+	if orderStatus != "" && orderStatus != "all" {
+		filter.OrderStatus = &orderStatus
+	}
+
+	if paramString := query.Get("from"); paramString != "" {
+		orderFrom, err := time.Parse(time.DateOnly, paramString)
+		if err != nil {
+			return OrderFilter{}, invalidParam("from")
+		}
+		filter.From = &orderFrom
+	}
+	if paramString := query.Get("to"); paramString != "" {
+		orderTo, err := time.Parse(time.DateOnly, paramString)
+		if err != nil {
+			return OrderFilter{}, invalidParam("to")
+		}
+		orderTo = orderTo.Add(24 * time.Hour)
+		orderTo = orderTo.Add(-1 * time.Nanosecond)
+		filter.To = &orderTo
+	}
+
+	var err error
+	if filter.Id, err = parsePositiveInt(query, "id"); err != nil {
+		return OrderFilter{}, err
+	}
+	if filter.Cursor, err = parsePositiveInt(query, "cursor"); err != nil {
+		return OrderFilter{}, err
+	}
+	if filter.ProductId, err = parsePositiveInt(query, "productId"); err != nil {
+		return OrderFilter{}, err
+	}
+	if filter.EmployeeId, err = parsePositiveInt(query, "employeeId"); err != nil {
+		return OrderFilter{}, err
+	}
+	if filter.LocationId, err = parsePositiveInt(query, "locationId"); err != nil {
+		return OrderFilter{}, err
+	}
+
+	if paramString := query.Get("paymentMethod"); paramString != "" {
+		switch paramString {
+		case "stripe", "cash", "card":
+			method := strings.ToUpper(paramString)
+			filter.PaymentMethod = &method
+		default:
+			return OrderFilter{}, invalidParam("paymentMethod")
+		}
+	}
+
+	for _, param := range []struct {
+		name  string
+		value **int64
+	}{
+		{"minTotal", &filter.MinTotal},
+		{"maxTotal", &filter.MaxTotal},
+	} {
+		paramString := query.Get(param.name)
+		if paramString == "" {
+			continue
+		}
+		cents, err := strconv.ParseInt(paramString, 10, 64)
+		if err != nil || cents < 0 {
+			return OrderFilter{}, invalidParam(param.name)
+		}
+		*param.value = &cents
+	}
+	if filter.MinTotal != nil && filter.MaxTotal != nil && *filter.MinTotal > *filter.MaxTotal {
+		return OrderFilter{}, invalidParam("minTotal")
+	}
+
+	if paramString := strings.TrimSpace(query.Get("customerPhone")); paramString != "" {
+		filter.CustomerPhone = &paramString
+	}
+
+	// Pagination: limit and offset (or cursor)
+	if paramString := query.Get("limit"); paramString != "" {
+		limit, err := strconv.ParseUint(paramString, 10, 64)
+		if err != nil || limit == 0 {
+			return OrderFilter{}, invalidParam("limit")
+		}
+		limit = min(limit, maxOrderPageSize)
+		filter.Limit = &limit
+	}
+	if paramString := query.Get("offset"); paramString != "" {
+		offset, err := strconv.ParseUint(paramString, 10, 64)
+		if err != nil {
+			return OrderFilter{}, invalidParam("offset")
+		}
+		filter.Offset = &offset
+	}
+
+	return filter, nil
+}
+
+func parsePositiveInt(query url.Values, name string) (*int64, error) {
+	paramString := query.Get(name)
+	if paramString == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.ParseInt(paramString, 10, 64)
+	if err != nil || value <= 0 {
+		return nil, invalidParam(name)
+	}
+
+	return &value, nil
+}
+
+func invalidParam(name string) error {
+	return fmt.Errorf("invalid param '%s'.", name)
+}
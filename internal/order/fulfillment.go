@@ -0,0 +1,31 @@
+package order
+
+import "strings"
+
+// How the order gets to the customer. Items can have a different VAT rate for each type.
+type FulfillmentType string
+
+const (
+	FulfillmentDineIn   FulfillmentType = "dine_in"
+	FulfillmentTakeaway FulfillmentType = "takeaway"
+	FulfillmentDelivery FulfillmentType = "delivery"
+	FulfillmentPickup   FulfillmentType = "pickup"
+)
+
+func (f FulfillmentType) Valid() bool {
+	switch f {
+	case FulfillmentDineIn, FulfillmentTakeaway, FulfillmentDelivery, FulfillmentPickup:
+		return true
+	}
+	return false
+}
+
+// Converts DB enum value (e.g. DINE_IN) to FulfillmentType.
+func ParseFulfillmentType(fulfillment string) FulfillmentType {
+	return FulfillmentType(strings.ToLower(fulfillment))
+}
+
+// Converts FulfillmentType to DB enum value.
+func (f FulfillmentType) DbValue() string {
+	return strings.ToUpper(string(f))
+}
@@ -0,0 +1,35 @@
+package order
+
+import "time"
+
+// Payload for parking an order so the terminal can serve the next customer.
+// The order stays at its location, it's listed among the held orders there.
+type Hold struct {
+	CustomerLabel *string `json:"customerLabel"`
+	Notes         *string `json:"notes"`
+	TerminalId    string  `json:"terminalId"`
+}
+
+type HeldOrder struct {
+	Id            int64     `json:"id"            db:"id"`
+	CustomerLabel string    `json:"customerLabel" db:"customer_label"`
+	Notes         string    `json:"notes"         db:"notes"`
+	Total         float64   `json:"total"         db:"total"`
+	CreatedAt     time.Time `json:"createdAt"     db:"created_at"`
+	HeldAt        time.Time `json:"heldAt"        db:"held_at"`
+	TerminalId    string    `json:"terminalId"    db:"held_terminal"`
+	LocationId    *int64    `json:"locationId"    db:"location_id"`
+}
+
+// Options for filtering held orders.
+// If a filter field should be ignored, it should be set to nil pointer.
+type HeldOrderFilter struct {
+	TerminalId *string
+	LocationId *int64
+}
+
+const (
+	maxCustomerLabelLength = 64
+	maxNotesLength         = 512
+	maxTerminalIdLength    = 64
+)
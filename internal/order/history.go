@@ -0,0 +1,63 @@
+package order
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type HistoryEventType string
+
+const (
+	HistoryCreated         HistoryEventType = "created"
+	HistoryItemAdded       HistoryEventType = "item_added"
+	HistoryItemChanged     HistoryEventType = "item_changed"
+	HistoryTipSet          HistoryEventType = "tip_set"
+	HistoryTableChanged    HistoryEventType = "table_changed"
+	HistoryLabelChanged    HistoryEventType = "label_changed"
+	HistoryFulfillmentSet  HistoryEventType = "fulfillment_changed"
+	HistoryPriceListSet    HistoryEventType = "price_list_changed"
+	HistoryHeld            HistoryEventType = "held"
+	HistoryPickedUp        HistoryEventType = "picked_up"
+	HistoryMerged          HistoryEventType = "merged"
+	HistorySplit           HistoryEventType = "split"
+	HistorySentToKitchen   HistoryEventType = "sent_to_kitchen"
+	HistoryPrepChanged     HistoryEventType = "prep_status_changed"
+	HistoryCheckoutStarted HistoryEventType = "checkout_started"
+	HistoryPaid            HistoryEventType = "paid"
+	HistoryCancelled       HistoryEventType = "cancelled"
+	HistoryRefundRequested HistoryEventType = "refund_requested"
+	HistoryRefundCancelled HistoryEventType = "refund_cancelled"
+	HistoryRefundApproved  HistoryEventType = "refund_approved"
+	HistoryRefundDenied    HistoryEventType = "refund_denied"
+	HistoryStatusChanged   HistoryEventType = "status_changed"
+)
+
+// Single entry of the order timeline.
+// Actor is the username of the employee or the name of the system (e.g. "stripe").
+type HistoryEvent struct {
+	Type       HistoryEventType `json:"type"`
+	Actor      string           `json:"actor"`
+	EmployeeId *int64           `json:"employeeId"`
+	Employee   string           `json:"employee"`
+	Details    json.RawMessage  `json:"details"`
+	CreatedAt  time.Time        `json:"createdAt"`
+}
+
+// Timeline entry that is recorded for a status change caused by the event.
+func (e Event) HistoryType() HistoryEventType {
+	switch e {
+	case EventPay:
+		return HistoryPaid
+	case EventCancel:
+		return HistoryCancelled
+	case EventRequestRefund:
+		return HistoryRefundRequested
+	case EventCancelRefund:
+		return HistoryRefundCancelled
+	case EventApproveRefund:
+		return HistoryRefundApproved
+	case EventDenyRefund:
+		return HistoryRefundDenied
+	}
+	return HistoryStatusChanged
+}
@@ -6,19 +6,40 @@ type Order struct {
 	Items    []Item `json:"items"`
 	Tip      int64  `json:"tip"`
 	Currency string `json:"currency"`
+	// Only used when the order is created, see table.TableController for seating existing orders.
+	TableId   *int64  `json:"tableId,omitempty"`
+	PartySize *uint16 `json:"partySize,omitempty"`
+	// Optional, nil keeps the current value.
+	CustomerLabel *string `json:"customerLabel,omitempty"`
+	Notes         *string `json:"notes,omitempty"`
+	// Optional, nil keeps the current value and an empty string removes the phone.
+	CustomerPhone *string `json:"customerPhone,omitempty"`
+	// Optional, nil keeps the current value. New orders are dine-in.
+	FulfillmentType *FulfillmentType `json:"fulfillmentType,omitempty"`
+	// Only used when the order is created. Items are priced for this location.
+	LocationId *int64 `json:"locationId,omitempty"`
+	// Optional, nil keeps the current value and 0 removes the price list.
+	// Needs the location of the order, lists of other businesses are not found.
+	PriceListId *int64 `json:"priceListId,omitempty"`
 }
 
 type OrderSummary struct {
-	Id        int64     `json:"id"        db:"id"`
-	Total     float64   `json:"total"     db:"total"`
-	CreatedAt time.Time `json:"createdAt" db:"created_at"`
-	Status    string    `json:"status"    db:"status"`
+	Id              int64           `json:"id"              db:"id"`
+	Total           float64         `json:"total"           db:"total"`
+	CreatedAt       time.Time       `json:"createdAt"       db:"created_at"`
+	Status          string          `json:"status"          db:"status"`
+	CustomerLabel   string          `json:"customerLabel"   db:"customer_label"`
+	Held            bool            `json:"held"            db:"held"`
+	FulfillmentType FulfillmentType `json:"fulfillmentType" db:"fulfillment_type"`
 }
 
 type Variation struct {
 	Id            int64  `json:"id"            db:"id"`
 	Name          string `json:"name"          db:"name"`
 	PriceModifier uint64 `json:"priceModifier" db:"price_difference"`
+	// Only set in the variations of a product. GroupId is nil for variations without a group.
+	GroupId *int64 `json:"groupId" db:"group_id"`
+	Default bool   `json:"default" db:"is_default"`
 }
 
 type Product struct {
@@ -26,8 +47,18 @@ type Product struct {
 	Name       	string		`json:"name"       db:"name"`
 	BasePrice  	int64     	`json:"basePrice"  db:"price_per_unit"`
 	Vat			int64		`json:"vat"        db:"vat"`
+	Unit		Unit		`json:"unit"       db:"unit"`
+	Sku			string		`json:"sku"        db:"sku"`
 	Categories 	[]string	`json:"categories"`
 	Variations 	[]Variation	`json:"variations"`
+	VariationGroups	[]VariationGroup	`json:"variationGroups"`
+	// VAT rates that differ from Vat, by fulfillment type.
+	VatRates	map[FulfillmentType]int64	`json:"vatRates"`
+	// Null if the product has no image.
+	ImageUrl	*string		`json:"imageUrl"`
+	ThumbnailUrl	*string		`json:"thumbnailUrl"`
+	// Empty unless the product is a bundle.
+	Slots		[]BundleSlot	`json:"slots"`
 }
 
 type OrderCounts struct {
@@ -36,13 +67,48 @@ type OrderCounts struct {
 	Closed        uint64 `json:"closed"`
 	RefundPending uint64 `json:"refund_pending"`
 	Refunded      uint64 `json:"refunded"`
+	Cancelled     uint64 `json:"cancelled"`
+}
+
+// Totals of orders that count as sales.
+// Open, refunded and cancelled orders are not included.
+type OrderSales struct {
+	Orders uint64 `json:"orders" db:"orders"`
+	Total  int64  `json:"total"  db:"total"`
+	Tips   int64  `json:"tips"   db:"tips"`
 }
 
 type Item struct {
 	Id                 int64       `json:"id"`
 	Product            Product     `json:"product"`
 	SelectedVariations []Variation `json:"selectedVariations"`
-	Quantity           uint16      `json:"quantity"`
+	Quantity           Quantity    `json:"quantity"`
+	// Free-text instructions for the kitchen, e.g. "no onions".
+	// nil keeps the current note.
+	Note *string `json:"note"`
+	// nil keeps the current modifiers, empty list removes them.
+	Modifiers []Modifier `json:"modifiers"`
+	// Set by the kitchen, ignored when the order is modified.
+	SentAt     *time.Time `json:"sentAt"`
+	PrepStatus string     `json:"prepStatus"`
+	// Chosen when the line is added or its product is changed, ignored when the order is modified.
+	PricingRule *AppliedPricingRule `json:"pricingRule"`
+	// One per slot for bundle products. nil keeps the current components
+	// unless the line is new or its product is changed.
+	Components []Component `json:"components"`
+}
+
+const (
+	maxItemNoteLength   = 256
+	maxModifiersPerItem = 20
+)
+
+// Free-text addition to an order line that is not a predefined variation,
+// e.g. "extra hot" in group "spice level". Price is added to the unit price of the line.
+type Modifier struct {
+	Group         string `json:"group"         db:"group_name"`
+	Name          string `json:"name"          db:"name"`
+	PriceModifier uint64 `json:"priceModifier" db:"price_difference"`
 }
 
 type RefundData struct {
@@ -51,3 +117,35 @@ type RefundData struct {
 	Email  string `json:"email"`
 	Reason string `json:"reason"`
 }
+
+type CancelReason string
+
+const (
+	CancelCustomerLeft  CancelReason = "customer_left"
+	CancelOrderMistake  CancelReason = "order_mistake"
+	CancelDuplicate     CancelReason = "duplicate"
+	CancelPaymentFailed CancelReason = "payment_failed"
+	CancelOther         CancelReason = "other"
+	// Set by the system when the order is merged into another one, can't be chosen by the user.
+	CancelMerged CancelReason = "merged"
+)
+
+func (r CancelReason) Valid() bool {
+	switch r {
+	case CancelCustomerLeft, CancelOrderMistake, CancelDuplicate, CancelPaymentFailed, CancelOther:
+		return true
+	}
+	return false
+}
+
+type Cancellation struct {
+	Reason CancelReason `json:"reason"`
+	Note   string       `json:"note"`
+}
+
+// Line of an order that is moved to a new order.
+// Quantity can be less than the quantity of the line, the rest stays on the original order.
+type SplitLine struct {
+	OrderItemId int64    `json:"orderItemId"`
+	Quantity    Quantity `json:"quantity"`
+}
@@ -4,12 +4,38 @@ import "time"
 
 type OrderRepo interface {
 	GetOrders(filter OrderFilter) ([]OrderSummary, error)
+	// Counts orders matching the filter, pagination fields are ignored.
+	CountOrders(filter OrderFilter) (uint64, error)
 	GetOrderCounts(filter OrderFilter) (OrderCounts, error)
 	CreateOrder(username string, order Order) (int64, error)
-	ModifyOrder(orderId int64, order Order) error
-	CreateRefundRequest(orderId int64, refundData RefundData) error
-	CancelRefundRequest(orderId int64) error
+	// Returns the new version of the order. If version is not nil,
+	// ErrVersionMismatch is returned when the order is at a different version.
+	ModifyOrder(orderId int64, username string, version *int64, order Order) (int64, error)
+	CreateRefundRequest(orderId int64, username string, refundData RefundData) error
+	CancelRefundRequest(orderId int64, username string) error
+	CancelOrder(orderId int64, username string, cancellation Cancellation) error
+	GetSales(filter OrderFilter) (OrderSales, error)
 	GetOrderItems(orderId int64) ([]Item, error)
+	// Returns the items together with the version of the order they were read at.
+	GetOrderItemsWithVersion(orderId int64) ([]Item, int64, error)
+	GetOrderFacts(orderId int64) (OrderFacts, error)
+	HoldOrder(orderId int64, username string, hold Hold) error
+	// Takes a held order off the list. Only one terminal can pick up the order,
+	// everyone else gets ErrOrderNotHeld.
+	PickUpOrder(orderId int64, username string, terminalId string) error
+	GetHeldOrders(filter HeldOrderFilter) ([]HeldOrder, error)
+	// Moves all lines, tip, discount and service charge of the first order into the second one
+	// and cancels the first order. Both orders must be open, have no pending Stripe payments
+	// and have the same fulfillment type, location and price list.
+	MergeOrders(fromOrderId int64, intoOrderId int64, username string) error
+	// Moves the given lines (or part of their quantity) into a new order and returns its id.
+	// The order must be open and have no pending Stripe payments.
+	SplitOrder(orderId int64, username string, lines []SplitLine) (int64, error)
+	GetOrderHistory(orderId int64) ([]HistoryEvent, error)
+	// Export methods call fn for every row as it's read from the database,
+	// oldest order first. Pagination fields of the filter are ignored.
+	ExportOrderLines(filter OrderFilter, fn func(ExportLine) error) error
+	ExportOrders(filter OrderFilter, fn func(ExportOrder) error) error
 }
 
 // Options for filtering orders.
@@ -22,4 +48,15 @@ type OrderFilter struct {
 	Limit  *uint64 `db:"limit"`
 	Offset *uint64 `db:"offset"`
 	Id     *int64  `db:"id"`
+	// Keyset pagination, only orders with a smaller id than the cursor are returned.
+	Cursor        *int64  `db:"cursor"`
+	ProductId     *int64  `db:"product_id"`
+	EmployeeId    *int64  `db:"employee_id"`
+	PaymentMethod *string `db:"payment_method"`
+	// Order total range in cents, both ends inclusive.
+	MinTotal *int64 `db:"min_total"`
+	MaxTotal *int64 `db:"max_total"`
+	// Prefix of the customer phone of the order or of its refund request.
+	CustomerPhone *string `db:"customer_phone"`
+	LocationId    *int64  `db:"location_id"`
 }
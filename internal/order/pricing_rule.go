@@ -0,0 +1,42 @@
+package order
+
+import "strings"
+
+// How a pricing rule changes the unit price of a line.
+type AdjustmentType string
+
+const (
+	// In hundredths of a percent, e.g. -2000 is 20% off.
+	AdjustmentPercent AdjustmentType = "percent"
+	// In cents per unit.
+	AdjustmentFixed AdjustmentType = "fixed"
+)
+
+func (a AdjustmentType) Valid() bool {
+	switch a {
+	case AdjustmentPercent, AdjustmentFixed:
+		return true
+	}
+	return false
+}
+
+// Converts DB enum value (e.g. PERCENT) or any other casing to AdjustmentType.
+func ParseAdjustmentType(adjustment string) AdjustmentType {
+	return AdjustmentType(strings.ToLower(adjustment))
+}
+
+// Converts AdjustmentType to DB enum value.
+func (a AdjustmentType) DbValue() string {
+	return strings.ToUpper(string(a))
+}
+
+// Pricing rule (e.g. happy hour) that was in effect when the line was added.
+// The line keeps the adjustment even if the rule is changed or deleted later.
+type AppliedPricingRule struct {
+	// Nil if the rule has been deleted.
+	Id             *int64         `json:"id"             db:"pricing_rule_id"`
+	Name           string         `json:"name"           db:"pricing_rule_name"`
+	AdjustmentType AdjustmentType `json:"adjustmentType" db:"price_adjustment_type"`
+	// Negative adjustments lower the price.
+	Adjustment int64 `json:"adjustment" db:"price_adjustment"`
+}
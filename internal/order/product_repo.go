@@ -1,16 +1,25 @@
 package order
 
+import "time"
+
+// VAT changes with an effective date are scheduled for midnight of that date in the time zone
+// of the location, the ones without it are made right away.
 type ProductRepo interface {
 	GetProducts(filter ProductFilter) ([]Product, error)
 	GetCategories(locationId int64) ([]string, error)
 	GetDefaultVat(locationId int64) (int64, error)
-	SetVat(locationId int64, itemId int64, newVat int64) error
+	SetVat(locationId int64, itemId int64, newVat int64, effectiveDate *time.Time) error
+	// Sets the VAT rate of the item for one fulfillment type. A nil rate removes the override.
+	SetFulfillmentVat(locationId int64, itemId int64, fulfillment FulfillmentType, newVat *int64, effectiveDate *time.Time) error
 }
 
 // Options for filtering products.
 // If a filter field should be ignored, it should be set to nil pointer.
+// Prices are the ones at the location, or on the price list if it's set.
 type ProductFilter struct {
 	LocationId	int64
 	Category	*string
 	Includes	*string
+	Sku			*string
+	PriceListId	*int64
 }
@@ -0,0 +1,167 @@
+package order
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unit of measure a product is sold in.
+type Unit string
+
+const (
+	UnitPiece    Unit = "piece"
+	UnitKilogram Unit = "kg"
+	UnitGram     Unit = "g"
+	UnitLitre    Unit = "l"
+	UnitMetre    Unit = "m"
+)
+
+func (u Unit) Valid() bool {
+	switch u {
+	case UnitPiece, UnitKilogram, UnitGram, UnitLitre, UnitMetre:
+		return true
+	}
+	return false
+}
+
+// Number of decimals a quantity in this unit can have.
+func (u Unit) Precision() int {
+	switch u {
+	case UnitKilogram, UnitLitre:
+		return 3
+	case UnitMetre:
+		return 2
+	}
+	return 0
+}
+
+// Reports whether the quantity can be sold in this unit.
+func (u Unit) Allows(q Quantity) bool {
+	return q > 0 && q.Decimals() <= u.Precision()
+}
+
+// Converts DB enum value (e.g. KG) to Unit.
+func ParseUnit(unit string) Unit {
+	return Unit(strings.ToLower(unit))
+}
+
+// Converts Unit to DB enum value.
+func (u Unit) DbValue() string {
+	return strings.ToUpper(string(u))
+}
+
+// Quantity of an order line in thousandths of the unit, e.g. 1.25 kg is 1250.
+// It's a plain number in JSON (1.25) and a DECIMAL(10, 3) in the DB.
+type Quantity int64
+
+const (
+	quantityDecimals = 3
+	quantityScale    = 1000
+)
+
+var errQuantityFormat = errors.New("quantity must be a positive number with at most 7 digits and 3 decimals")
+
+// Quantity of whole units.
+func WholeQuantity(units int64) Quantity {
+	return Quantity(units * quantityScale)
+}
+
+// Number of decimals needed to write the quantity.
+func (q Quantity) Decimals() int {
+	fraction := int64(q) % quantityScale
+	decimals := quantityDecimals
+	for fraction != 0 && fraction%10 == 0 {
+		fraction /= 10
+		decimals--
+	}
+	if fraction == 0 {
+		return 0
+	}
+	return decimals
+}
+
+func (q Quantity) IsWhole() bool {
+	return q.Decimals() == 0
+}
+
+// Whole units of the quantity, the fraction is dropped.
+func (q Quantity) Whole() int64 {
+	return int64(q) / quantityScale
+}
+
+// Shortest decimal form, e.g. "2" or "1.25".
+func (q Quantity) String() string {
+	sign := ""
+	if q < 0 {
+		sign = "-"
+		q = -q
+	}
+	s := strconv.FormatInt(q.Whole(), 10)
+	if decimals := q.Decimals(); decimals > 0 {
+		fraction := fmt.Sprintf("%03d", int64(q)%quantityScale)
+		s += "." + fraction[:decimals]
+	}
+	return sign + s
+}
+
+func ParseQuantity(s string) (Quantity, error) {
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(s), ".")
+	fraction = strings.TrimRight(fraction, "0")
+	if whole == "" || len(whole) > 7 || len(fraction) > quantityDecimals {
+		return 0, errQuantityFormat
+	}
+
+	units, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return 0, errQuantityFormat
+	}
+	thousandths := uint64(0)
+	if fraction != "" {
+		thousandths, err = strconv.ParseUint(fraction+strings.Repeat("0", quantityDecimals-len(fraction)), 10, 64)
+		if err != nil {
+			return 0, errQuantityFormat
+		}
+	}
+
+	return Quantity(units*quantityScale + thousandths), nil
+}
+
+func (q Quantity) MarshalJSON() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+func (q *Quantity) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	parsed, err := ParseQuantity(string(data))
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
+
+func (q *Quantity) Scan(src any) error {
+	switch value := src.(type) {
+	case []byte:
+		parsed, err := ParseQuantity(string(value))
+		*q = parsed
+		return err
+	case string:
+		parsed, err := ParseQuantity(value)
+		*q = parsed
+		return err
+	case int64:
+		*q = WholeQuantity(value)
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into quantity", src)
+}
+
+func (q Quantity) Value() (driver.Value, error) {
+	return q.String(), nil
+}
@@ -0,0 +1,187 @@
+package order
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Quantity
+		wantErr bool
+	}{
+		{input: "1", want: 1000},
+		{input: "0", want: 0},
+		{input: "1.25", want: 1250},
+		{input: "0.001", want: 1},
+		{input: "2.500", want: 2500},
+		{input: "2.5000000", want: 2500},
+		{input: " 3 ", want: 3000},
+		{input: "9999999.999", want: 9999999999},
+		{input: "1.", want: 1000},
+		{input: "", wantErr: true},
+		{input: ".5", wantErr: true},
+		{input: "1.0005", wantErr: true},
+		{input: "10000000", wantErr: true},
+		{input: "-1", wantErr: true},
+		{input: "+1", wantErr: true},
+		{input: "1e3", wantErr: true},
+		{input: "1.2.3", wantErr: true},
+		{input: "1.-5", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseQuantity(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseQuantity(%q) = %d, want error", test.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseQuantity(%q) returned error %v", test.input, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseQuantity(%q) = %d, want %d", test.input, got, test.want)
+		}
+	}
+}
+
+func TestQuantityString(t *testing.T) {
+	tests := []struct {
+		quantity Quantity
+		want     string
+	}{
+		{quantity: 0, want: "0"},
+		{quantity: 1000, want: "1"},
+		{quantity: 1250, want: "1.25"},
+		{quantity: 1205, want: "1.205"},
+		{quantity: 1200, want: "1.2"},
+		{quantity: 1, want: "0.001"},
+		{quantity: 10, want: "0.01"},
+		{quantity: -1500, want: "-1.5"},
+	}
+
+	for _, test := range tests {
+		if got := test.quantity.String(); got != test.want {
+			t.Errorf("Quantity(%d).String() = %q, want %q", test.quantity, got, test.want)
+		}
+	}
+}
+
+// Quantities read from the DB and sent back by clients keep their exact value.
+func TestQuantityRoundTrip(t *testing.T) {
+	for _, quantity := range []Quantity{0, 1, 10, 999, 1000, 1250, 1205, 9999999999} {
+		data, err := json.Marshal(quantity)
+		if err != nil {
+			t.Fatalf("json.Marshal(%d) returned error %v", quantity, err)
+		}
+		var decoded Quantity
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("json.Unmarshal(%s) returned error %v", data, err)
+		}
+		if decoded != quantity {
+			t.Errorf("JSON round trip of %d = %d", quantity, decoded)
+		}
+
+		value, err := quantity.Value()
+		if err != nil {
+			t.Fatalf("Quantity(%d).Value() returned error %v", quantity, err)
+		}
+		var scanned Quantity
+		if err := scanned.Scan([]byte(value.(string))); err != nil {
+			t.Fatalf("Scan(%q) returned error %v", value, err)
+		}
+		if scanned != quantity {
+			t.Errorf("DB round trip of %d = %d", quantity, scanned)
+		}
+	}
+}
+
+func TestQuantityScan(t *testing.T) {
+	tests := []struct {
+		src     any
+		want    Quantity
+		wantErr bool
+	}{
+		{src: []byte("1.250"), want: 1250},
+		{src: "0.500", want: 500},
+		{src: int64(3), want: 3000},
+		{src: 1.5, wantErr: true},
+		{src: nil, wantErr: true},
+	}
+
+	for _, test := range tests {
+		var got Quantity
+		err := got.Scan(test.src)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("Scan(%v) = %d, want error", test.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Scan(%v) returned error %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Scan(%v) = %d, want %d", test.src, got, test.want)
+		}
+	}
+}
+
+func TestQuantityDecimals(t *testing.T) {
+	tests := []struct {
+		quantity Quantity
+		decimals int
+		whole    int64
+		isWhole  bool
+	}{
+		{quantity: 0, decimals: 0, whole: 0, isWhole: true},
+		{quantity: 2000, decimals: 0, whole: 2, isWhole: true},
+		{quantity: 2500, decimals: 1, whole: 2, isWhole: false},
+		{quantity: 2550, decimals: 2, whole: 2, isWhole: false},
+		{quantity: 2555, decimals: 3, whole: 2, isWhole: false},
+		{quantity: 999, decimals: 3, whole: 0, isWhole: false},
+	}
+
+	for _, test := range tests {
+		if got := test.quantity.Decimals(); got != test.decimals {
+			t.Errorf("Quantity(%d).Decimals() = %d, want %d", test.quantity, got, test.decimals)
+		}
+		if got := test.quantity.Whole(); got != test.whole {
+			t.Errorf("Quantity(%d).Whole() = %d, want %d", test.quantity, got, test.whole)
+		}
+		if got := test.quantity.IsWhole(); got != test.isWhole {
+			t.Errorf("Quantity(%d).IsWhole() = %t, want %t", test.quantity, got, test.isWhole)
+		}
+	}
+}
+
+func TestUnitAllows(t *testing.T) {
+	tests := []struct {
+		unit     Unit
+		quantity Quantity
+		want     bool
+	}{
+		{unit: UnitPiece, quantity: 1000, want: true},
+		{unit: UnitPiece, quantity: 1500, want: false},
+		{unit: UnitPiece, quantity: 0, want: false},
+		{unit: UnitGram, quantity: 250000, want: true},
+		{unit: UnitGram, quantity: 250500, want: false},
+		{unit: UnitKilogram, quantity: 1, want: true},
+		{unit: UnitKilogram, quantity: 1250, want: true},
+		{unit: UnitLitre, quantity: 333, want: true},
+		{unit: UnitMetre, quantity: 1250, want: true},
+		{unit: UnitMetre, quantity: 1255, want: false},
+		{unit: UnitKilogram, quantity: -1000, want: false},
+	}
+
+	for _, test := range tests {
+		if got := test.unit.Allows(test.quantity); got != test.want {
+			t.Errorf("Unit(%q).Allows(%s) = %t, want %t", test.unit, test.quantity, got, test.want)
+		}
+	}
+}
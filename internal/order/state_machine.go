@@ -0,0 +1,174 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Status string
+
+const (
+	StatusOpen          Status = "open"
+	StatusClosed        Status = "closed"
+	StatusRefundPending Status = "refund_pending"
+	StatusRefunded      Status = "refunded"
+	StatusCancelled     Status = "cancelled"
+)
+
+// Events that move an order from one status to another.
+type Event string
+
+const (
+	EventPay           Event = "pay"
+	EventCancel        Event = "cancel"
+	EventRequestRefund Event = "request_refund"
+	EventCancelRefund  Event = "cancel_refund"
+	EventApproveRefund Event = "approve_refund"
+	EventDenyRefund    Event = "deny_refund"
+)
+
+// Side effects that have to be applied in the same transaction as the status change.
+type Effect string
+
+const (
+	EffectStoreRefundData   Effect = "store_refund_data"
+	EffectClearRefundData   Effect = "clear_refund_data"
+	EffectStoreCancellation Effect = "store_cancellation"
+)
+
+// Everything the guards need to know about an order.
+// Amounts are in cents.
+type OrderFacts struct {
+	Status     Status
+	TotalCents int64
+	PaidCents  int64
+}
+
+// Guard returns a non nil error if the transition is not allowed.
+type Guard func(facts OrderFacts) error
+
+type transition struct {
+	to      Status
+	guards  []Guard
+	effects []Effect
+}
+
+var stateMachine = map[Status]map[Event]transition{
+	StatusOpen: {
+		EventPay: {
+			to:     StatusClosed,
+			guards: []Guard{requireFullPayment},
+		},
+		EventCancel: {
+			to:      StatusCancelled,
+			guards:  []Guard{requireNoPayment},
+			effects: []Effect{EffectStoreCancellation},
+		},
+	},
+	StatusClosed: {
+		EventRequestRefund: {
+			to:      StatusRefundPending,
+			effects: []Effect{EffectStoreRefundData},
+		},
+	},
+	StatusRefundPending: {
+		EventCancelRefund: {
+			to:      StatusClosed,
+			effects: []Effect{EffectClearRefundData},
+		},
+		EventApproveRefund: {
+			to:      StatusRefunded,
+			effects: []Effect{EffectClearRefundData},
+		},
+		EventDenyRefund: {
+			to:      StatusClosed,
+			effects: []Effect{EffectClearRefundData},
+		},
+	},
+}
+
+// Step is a checked transition that can be applied to an order.
+type Step struct {
+	From    Status
+	To      Status
+	Event   Event
+	Effects []Effect
+}
+
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// IllegalTransitionError is returned when the event is not allowed in the current status.
+type IllegalTransitionError struct {
+	From  Status
+	Event Event
+}
+
+func (e IllegalTransitionError) Error() string {
+	return fmt.Sprintf("%s: cannot %s an order that is %s", ErrInvalidTransition, e.Event, e.From)
+}
+
+func (e IllegalTransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// GuardError is returned when the event is allowed in the current status,
+// but the order does not satisfy one of the guards.
+type GuardError struct {
+	From   Status
+	Event  Event
+	Reason string
+}
+
+func (e GuardError) Error() string {
+	return fmt.Sprintf("%s: cannot %s an order that is %s: %s", ErrInvalidTransition, e.Event, e.From, e.Reason)
+}
+
+func (e GuardError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// Next checks if the event can be applied to the order and returns the resulting step.
+func Next(facts OrderFacts, event Event) (Step, error) {
+	t, ok := stateMachine[facts.Status][event]
+	if !ok {
+		return Step{}, IllegalTransitionError{From: facts.Status, Event: event}
+	}
+
+	for _, guard := range t.guards {
+		if err := guard(facts); err != nil {
+			return Step{}, GuardError{From: facts.Status, Event: event, Reason: err.Error()}
+		}
+	}
+
+	return Step{
+		From:    facts.Status,
+		To:      t.to,
+		Event:   event,
+		Effects: t.effects,
+	}, nil
+}
+
+// Converts DB enum value (e.g. REFUND_PENDING) to Status.
+func ParseStatus(status string) Status {
+	return Status(strings.ToLower(status))
+}
+
+// Converts Status to DB enum value.
+func (s Status) DbValue() string {
+	return strings.ToUpper(string(s))
+}
+
+func requireFullPayment(facts OrderFacts) error {
+	if facts.PaidCents < facts.TotalCents {
+		return fmt.Errorf("paid %d of %d", facts.PaidCents, facts.TotalCents)
+	}
+	return nil
+}
+
+func requireNoPayment(facts OrderFacts) error {
+	if facts.PaidCents > 0 {
+		return errors.New("order has completed payments")
+	}
+	return nil
+}
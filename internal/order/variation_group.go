@@ -0,0 +1,43 @@
+package order
+
+import "fmt"
+
+// Selection rules for the variations of a product, e.g. "size" with exactly one choice.
+// Variations without a group can be selected freely.
+type VariationGroup struct {
+	Id   int64  `json:"id"   db:"id"`
+	Name string `json:"name" db:"name"`
+	// Derived from MinSelected, groups with a minimum are required.
+	Required    bool  `json:"required"    db:"required"`
+	MinSelected int64 `json:"minSelected" db:"min_selected"`
+	// Nil if there is no limit.
+	MaxSelected *int64 `json:"maxSelected" db:"max_selected"`
+	Position    int64  `json:"position"    db:"position"`
+}
+
+// Checks that every group of the product has between its minimum and maximum selected.
+// groupIds has the group of every selected variation, nil for variations without a group.
+func CheckVariationSelection(groups []VariationGroup, groupIds []*int64) error {
+	counts := map[int64]int64{}
+	for _, groupId := range groupIds {
+		if groupId != nil {
+			counts[*groupId]++
+		}
+	}
+
+	for _, group := range groups {
+		count := counts[group.Id]
+		switch {
+		case count < group.MinSelected && group.MinSelected == 1:
+			return fmt.Errorf("%w: %q is required", ErrInvalidVariations, group.Name)
+		case count < group.MinSelected:
+			return fmt.Errorf("%w: choose at least %d of %q", ErrInvalidVariations, group.MinSelected, group.Name)
+		case group.MaxSelected != nil && count > *group.MaxSelected && *group.MaxSelected == 1:
+			return fmt.Errorf("%w: choose only one of %q", ErrInvalidVariations, group.Name)
+		case group.MaxSelected != nil && count > *group.MaxSelected:
+			return fmt.Errorf("%w: choose at most %d of %q", ErrInvalidVariations, *group.MaxSelected, group.Name)
+		}
+	}
+
+	return nil
+}
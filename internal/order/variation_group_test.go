@@ -0,0 +1,72 @@
+package order
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckVariationSelection(t *testing.T) {
+	one, two := int64(1), int64(2)
+	size := VariationGroup{Id: 1, Name: "size", Required: true, MinSelected: 1, MaxSelected: &one}
+	sides := VariationGroup{Id: 2, Name: "sides", Required: true, MinSelected: 2, MaxSelected: &two}
+	milk := VariationGroup{Id: 3, Name: "milk", MaxSelected: &one}
+	toppings := VariationGroup{Id: 4, Name: "toppings"}
+	groups := []VariationGroup{size, sides, milk, toppings}
+
+	id := func(groupId int64) *int64 {
+		return &groupId
+	}
+	tests := []struct {
+		name     string
+		groupIds []*int64
+		wantErr  bool
+	}{
+		{name: "minimum of every group", groupIds: []*int64{id(1), id(2), id(2)}},
+		{name: "optional groups and free variations", groupIds: []*int64{id(1), id(2), id(2), id(3), id(4), id(4), id(4), nil, nil}},
+		{name: "required group missing", groupIds: []*int64{id(2), id(2)}, wantErr: true},
+		{name: "below minimum", groupIds: []*int64{id(1), id(2)}, wantErr: true},
+		{name: "nothing selected", groupIds: []*int64{}, wantErr: true},
+		{name: "free variations don't count", groupIds: []*int64{nil, nil, id(2), id(2)}, wantErr: true},
+		{name: "above maximum of one", groupIds: []*int64{id(1), id(1), id(2), id(2)}, wantErr: true},
+		{name: "above maximum", groupIds: []*int64{id(1), id(2), id(2), id(2)}, wantErr: true},
+		{name: "optional group above maximum", groupIds: []*int64{id(1), id(2), id(2), id(3), id(3)}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := CheckVariationSelection(groups, test.groupIds)
+		if test.wantErr {
+			if !errors.Is(err, ErrInvalidVariations) {
+				t.Errorf("%s: CheckVariationSelection() = %v, want ErrInvalidVariations", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: CheckVariationSelection() returned error %v", test.name, err)
+		}
+	}
+}
+
+func TestCheckVariationSelectionMessages(t *testing.T) {
+	one, three := int64(1), int64(3)
+	tests := []struct {
+		group    VariationGroup
+		selected int
+		want     string
+	}{
+		{group: VariationGroup{Id: 1, Name: "size", MinSelected: 1}, selected: 0, want: `invalid variations: "size" is required`},
+		{group: VariationGroup{Id: 1, Name: "sides", MinSelected: 2}, selected: 1, want: `invalid variations: choose at least 2 of "sides"`},
+		{group: VariationGroup{Id: 1, Name: "milk", MaxSelected: &one}, selected: 2, want: `invalid variations: choose only one of "milk"`},
+		{group: VariationGroup{Id: 1, Name: "sauces", MaxSelected: &three}, selected: 4, want: `invalid variations: choose at most 3 of "sauces"`},
+	}
+
+	for _, test := range tests {
+		groupIds := make([]*int64, test.selected)
+		for i := range groupIds {
+			groupIds[i] = &test.group.Id
+		}
+		err := CheckVariationSelection([]VariationGroup{test.group}, groupIds)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("CheckVariationSelection() with %d of %q = %v, want %q", test.selected, test.group.Name, err, test.want)
+		}
+	}
+}
@@ -7,19 +7,36 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+
+	"dreampos/internal/auth"
+	"dreampos/internal/order"
 )
 
 type PaymentController struct {
-	Service *PaymentService
+	Service        *PaymentService
+	AuthMiddleware func(http.Handler) http.Handler
+	// Replays responses of retried requests, may be nil.
+	Idempotency func(http.Handler) http.Handler
 }
 
 // Routes sets up the payment routes
 func (c *PaymentController) Routes() chi.Router {
 	r := chi.NewRouter()
 
-	r.Post("/stripe/create-checkout-session", c.createStripeCheckoutSession)
-	r.Post("/stripe/create-reservation-checkout-session", c.createStripeReservationCheckoutSession)
-	r.Get("/stripe/verify/{sessionId}", c.verifyStripePayment)
+	// Webhook is called by Stripe, everything else by the employees
+	r.Group(func(r chi.Router) {
+		if c.AuthMiddleware != nil {
+			r.Use(c.AuthMiddleware)
+		}
+
+		if c.Idempotency != nil {
+			r.With(c.Idempotency).Post("/stripe/create-checkout-session", c.createStripeCheckoutSession)
+		} else {
+			r.Post("/stripe/create-checkout-session", c.createStripeCheckoutSession)
+		}
+		r.Post("/stripe/create-reservation-checkout-session", c.createStripeReservationCheckoutSession)
+		r.Get("/stripe/verify/{sessionId}", c.verifyStripePayment)
+	})
 	r.Post("/stripe/webhook", c.handleStripeWebhook)
 
 	return r
@@ -31,6 +48,11 @@ func (c *PaymentController) createStripeCheckoutSession(w http.ResponseWriter, r
 		return
 	}
 
+	username := ""
+	if user, ok := r.Context().Value("user").(auth.User); ok {
+		username = user.Username
+	}
+
 	var req StripeCheckoutRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
@@ -42,13 +64,21 @@ func (c *PaymentController) createStripeCheckoutSession(w http.ResponseWriter, r
 		return
 	}
 
-	response, err := c.Service.CreateStripeCheckoutSession(req)
+	response, err := c.Service.CreateStripeCheckoutSession(req, username)
 	if err != nil {
 		// Expose only safe erros to client
 		if errors.Is(err, ErrInvalidAmount) || errors.Is(err, ErrInvalidCurrency) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if errors.Is(err, order.ErrOrderNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, order.ErrOrderNotOpen) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		slog.Error("Failed to create checkout session",
 			"order_id", req.OrderID,
 			"error", err)
@@ -128,6 +158,11 @@ func (c *PaymentController) verifyStripePayment(w http.ResponseWriter, r *http.R
 			http.Error(w, "payment not completed", http.StatusBadRequest)
 			return
 		}
+		if errors.Is(err, ErrAmountMismatch) {
+			slog.Error("Paid amount doesn't match the checkout", "session_id", sessionID, "error", err)
+			http.Error(w, ErrAmountMismatch.Error(), http.StatusConflict)
+			return
+		}
 		slog.Error("Failed to verify payment",
 			"session_id", sessionID,
 			"error", err)
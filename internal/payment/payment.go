@@ -3,7 +3,8 @@ package payment
 // Payment transaction
 type Payment struct {
 	ID                    int64  `json:"id" db:"id"`
-	OrderID               int64  `json:"order_id" db:"order_id"`
+	OrderID               int64  `json:"order_id" db:"order_id"`                       // 0 for reservation payments
+	ReservationID         int64  `json:"reservation_id,omitempty" db:"reservation_id"` // 0 for order payments
 	AmountCents           int64  `json:"amountCents" db:"amount"`
 	Currency              string `json:"currency" db:"currency"`
 	PaymentMethod         string `json:"payment_method" db:"payment_method"` // "stripe", "cash", "card"
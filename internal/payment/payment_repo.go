@@ -8,4 +8,5 @@ type PaymentRepo interface {
 	GetPaymentBySessionID(sessionID string) (*Payment, error)
 	GetPaymentByOrderID(orderID int64) (*Payment, error)
 	GetPaymentByPaymentIntentID(paymentIntentID string) (*Payment, error)
+	GetPendingStripePayments(orderID int64) ([]Payment, error)
 }
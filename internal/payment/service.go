@@ -3,12 +3,13 @@ package payment
 import (
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/stripe/stripe-go/v81"
 	"github.com/stripe/stripe-go/v81/checkout/session"
+
+	"dreampos/internal/order"
 )
 
 var (
@@ -17,6 +18,7 @@ var (
 	ErrPaymentNotCompleted = errors.New("payment not completed")
 	ErrInvalidAmount       = errors.New("invalid amount")
 	ErrInvalidCurrency     = errors.New("invalid currency")
+	ErrAmountMismatch      = errors.New("paid amount doesn't match the checkout")
 )
 
 type PaymentService struct {
@@ -28,9 +30,11 @@ type PaymentService struct {
 	ReservationCancelURL  string
 	OrderTotals           OrderTotalProvider
 	OrderStatus           OrderStatusUpdater
+	OrderState            OrderStateProvider
 	PaymentRepo           PaymentRepo
 	OrderItems            OrderItemsProvider
 	OrderTip              OrderTipProvider
+	OrderEvents           OrderEventRecorder
 	ReservationTotals     ReservationTotalProvider
 	ReservationStatus     ReservationStatusUpdater
 	ReservationItems      ReservationItemsProvider
@@ -41,13 +45,22 @@ type OrderTotalProvider interface {
 }
 
 type OrderStatusUpdater interface {
-	MarkOrderClosed(orderID int64) error
+	// Closes the order if amountCents, the total locked in by the checkout, is fully paid.
+	MarkOrderClosed(orderID int64, amountCents int64) error
+}
+
+type OrderStateProvider interface {
+	GetOrderStatus(orderID int64) (string, error)
 }
 
 type OrderItemsProvider interface {
 	GetOrderItemsForPayment(orderID int64) ([]OrderItem, error)
 }
 
+type OrderEventRecorder interface {
+	RecordOrderEvent(orderID int64, actor string, eventType order.HistoryEventType, details any) error
+}
+
 type OrderTipProvider interface {
 	GetOrderTipCents(orderID int64) (int64, error)
 }
@@ -67,11 +80,12 @@ type ReservationItemsProvider interface {
 type OrderItem struct {
 	Name     string
 	Quantity int
-	Price    int64 // in cents
+	Price    int64  // in cents
+	VatRate  string // in percent, e.g. "21.00", empty if unknown
 }
 
 // Creates a Stripe Checkout session
-func (s *PaymentService) CreateStripeCheckoutSession(req StripeCheckoutRequest) (*StripeCheckoutResponse, error) {
+func (s *PaymentService) CreateStripeCheckoutSession(req StripeCheckoutRequest, username string) (*StripeCheckoutResponse, error) {
 	if s.OrderTotals == nil {
 		return nil, fmt.Errorf("%w: order total provider not configured", ErrInternal)
 	}
@@ -79,6 +93,16 @@ func (s *PaymentService) CreateStripeCheckoutSession(req StripeCheckoutRequest)
 		return nil, fmt.Errorf("%w: payment repository not configured", ErrInternal)
 	}
 
+	if s.OrderState != nil {
+		status, err := s.OrderState.GetOrderStatus(req.OrderID)
+		if err != nil {
+			return nil, err
+		}
+		if status != "open" {
+			return nil, order.ErrOrderNotOpen
+		}
+	}
+
 	amountCents, currency, err := s.OrderTotals.GetOrderTotal(req.OrderID)
 	if err != nil {
 		return nil, ErrInternal
@@ -102,13 +126,19 @@ func (s *PaymentService) CreateStripeCheckoutSession(req StripeCheckoutRequest)
 		if err == nil && len(orderItems) > 0 {
 			// Create line items for each order item
 			for _, item := range orderItems {
+				productData := &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+					Name: stripe.String(item.Name),
+				}
+				if item.VatRate != "" {
+					productData.Description = stripe.String(fmt.Sprintf("incl. VAT %s%%", item.VatRate))
+					productData.Metadata = map[string]string{"vat_rate": item.VatRate}
+				}
+
 				lineItems = append(lineItems, &stripe.CheckoutSessionLineItemParams{
 					PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-						Currency: stripe.String(stripeCurrency),
-						ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-							Name: stripe.String(item.Name),
-						},
-						UnitAmount: stripe.Int64(item.Price),
+						Currency:    stripe.String(stripeCurrency),
+						ProductData: productData,
+						UnitAmount:  stripe.Int64(item.Price),
 					},
 					Quantity: stripe.Int64(int64(item.Quantity)),
 				})
@@ -188,8 +218,20 @@ func (s *PaymentService) CreateStripeCheckoutSession(req StripeCheckoutRequest)
 	}
 	_, err = s.PaymentRepo.CreatePayment(payment)
 	if err != nil {
-		// Log error but don't fail the checkout session
-		fmt.Printf("Warning: failed to store payment record: %v\n", err)
+		// Without the payment record the session could never be verified
+		_, _ = session.Expire(sess.ID, nil)
+		return nil, err
+	}
+
+	if s.OrderEvents != nil {
+		err = s.OrderEvents.RecordOrderEvent(req.OrderID, username, order.HistoryCheckoutStarted, map[string]any{
+			"sessionId":   sess.ID,
+			"amountCents": amountCents,
+			"currency":    currency,
+		})
+		if err != nil {
+			fmt.Printf("Warning: failed to record checkout event: %v\n", err)
+		}
 	}
 
 	return &StripeCheckoutResponse{
@@ -302,9 +344,8 @@ func (s *PaymentService) CreateStripeReservationCheckoutSession(req StripeReserv
 		stripePaymentIntentID = sess.PaymentIntent.ID
 	}
 
-	// HACK: Store payment recod, for both reservation and order
 	payment := Payment{
-		OrderID:               int64(req.ReservationID),
+		ReservationID:         int64(req.ReservationID),
 		AmountCents:           amountCents,
 		Currency:              currency,
 		PaymentMethod:         "stripe",
@@ -316,8 +357,8 @@ func (s *PaymentService) CreateStripeReservationCheckoutSession(req StripeReserv
 	}
 	_, err = s.PaymentRepo.CreatePayment(payment)
 	if err != nil {
-		// Log error but don't fail the checkout session
-		fmt.Printf("Warning: failed to store payment record: %v\n", err)
+		_, _ = session.Expire(sess.ID, nil)
+		return nil, err
 	}
 
 	return &StripeCheckoutResponse{
@@ -326,12 +367,18 @@ func (s *PaymentService) CreateStripeReservationCheckoutSession(req StripeReserv
 	}, nil
 }
 
-// Verifies a completed Stripe payment
+// Verifies a completed Stripe payment and completes its order or reservation.
+// The payment record is created together with the checkout session, sessions without one are not accepted.
 func (s *PaymentService) VerifyStripePayment(sessionID string) (*Payment, error) {
 	if s.PaymentRepo == nil {
 		return nil, fmt.Errorf("%w: payment repository not configured", ErrInternal)
 	}
 
+	payment, err := s.PaymentRepo.GetPaymentBySessionID(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
 	stripe.Key = s.StripeSecretKey
 
 	params := &stripe.CheckoutSessionParams{}
@@ -341,106 +388,87 @@ func (s *PaymentService) VerifyStripePayment(sessionID string) (*Payment, error)
 		return nil, ErrPaymentNotFound
 	}
 
-	stripePaymentIntentID := ""
-	if sess.PaymentIntent != nil {
-		stripePaymentIntentID = sess.PaymentIntent.ID
-	}
-
 	if sess.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
 		return nil, ErrPaymentNotCompleted
 	}
+	// The order is closed for the amount of the checkout, so that has to be what was charged
+	if sess.AmountTotal != payment.AmountCents || !strings.EqualFold(string(sess.Currency), payment.Currency) {
+		return nil, fmt.Errorf("%w: charged %d %s, checkout was %d %s",
+			ErrAmountMismatch, sess.AmountTotal, sess.Currency, payment.AmountCents, payment.Currency)
+	}
 
-	// Get payment from database
-	payment, err := s.PaymentRepo.GetPaymentBySessionID(sessionID)
-	if err != nil {
-		if err == ErrPaymentNotFound {
-			payment = &Payment{
-				StripeSessionID:       sess.ID,
-				StripePaymentIntentID: stripePaymentIntentID,
-				AmountCents:           int64(sess.AmountTotal),
-				Currency:              string(sess.Currency),
-				PaymentMethod:         "stripe",
-				Status:                "completed",
-				UpdatedAt:             time.Now().Format(time.RFC3339),
-			}
+	// The success page can be loaded more than once
+	alreadyCompleted := payment.Status == "completed"
+	if !alreadyCompleted {
+		if err := s.PaymentRepo.UpdatePaymentStatus(sessionID, "completed"); err != nil {
+			return nil, err
+		}
+		payment.Status = "completed"
+		payment.UpdatedAt = time.Now().Format(time.RFC3339)
+	}
 
-			// Check metadata to determine if this is an order or reservation
-			if sess.Metadata != nil {
-				paymentType, hasType := sess.Metadata["type"]
-
-				if hasType && paymentType == "reservation" {
-					if reservationIDStr, ok := sess.Metadata["reservation_id"]; ok {
-						if parsed, err := strconv.ParseInt(reservationIDStr, 10, 64); err == nil {
-							payment.OrderID = parsed // Using OrderID field for reservation_id
-
-							if s.ReservationStatus != nil && parsed > 0 {
-								if err := s.ReservationStatus.MarkReservationCompleted(int32(parsed)); err != nil {
-									fmt.Printf("Warning: failed to mark reservation as completed: %v\n", err)
-								}
-							}
-						}
-					}
-				} else if orderID, ok := sess.Metadata["order_id"]; ok {
-					if parsed, err := strconv.ParseInt(orderID, 10, 64); err == nil {
-						payment.OrderID = parsed
-
-						if s.OrderStatus != nil && payment.OrderID > 0 {
-							if err := s.OrderStatus.MarkOrderClosed(payment.OrderID); err != nil {
-								fmt.Printf("Warning: failed to mark order as closed: %v\n", err)
-							}
-						}
-					}
-				}
-			}
+	// Persist payment intent ID for refund processing
+	if sess.PaymentIntent != nil && payment.StripePaymentIntentID != sess.PaymentIntent.ID {
+		if err := s.PaymentRepo.UpdatePaymentIntentID(sessionID, sess.PaymentIntent.ID); err != nil {
+			return nil, err
+		}
+		payment.StripePaymentIntentID = sess.PaymentIntent.ID
+	}
 
-			return payment, nil
+	if payment.ReservationID > 0 {
+		if s.ReservationStatus != nil {
+			if err := s.ReservationStatus.MarkReservationCompleted(int32(payment.ReservationID)); err != nil {
+				return nil, fmt.Errorf("failed to mark reservation %d as completed: %w", payment.ReservationID, err)
+			}
 		}
-		return nil, err
+	} else if s.OrderStatus != nil && payment.OrderID > 0 {
+		// The order may have changed since the checkout started, the customer paid the amount of the checkout
+		err := s.OrderStatus.MarkOrderClosed(payment.OrderID, payment.AmountCents)
+		if err != nil && !(alreadyCompleted && errors.Is(err, order.ErrInvalidTransition)) {
+			return nil, fmt.Errorf("failed to close order %d: %w", payment.OrderID, err)
+		}
+	}
+
+	return payment, nil
+}
+
+// Expires all pending Stripe checkout sessions of an order.
+// Implements order.CheckoutExpirer.
+func (s *PaymentService) ExpireOrderCheckouts(orderID int64) error {
+	if s.PaymentRepo == nil {
+		return fmt.Errorf("%w: payment repository not configured", ErrInternal)
 	}
 
-	// Update payment status in database
-	if payment.Status != "completed" {
-		err = s.PaymentRepo.UpdatePaymentStatus(sessionID, "completed")
+	pending, err := s.PaymentRepo.GetPendingStripePayments(orderID)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	stripe.Key = s.StripeSecretKey
+
+	for _, pmt := range pending {
+		sess, err := session.Get(pmt.StripeSessionID, nil)
 		if err != nil {
-			// Log error but continue
-			fmt.Printf("Warning: failed to update payment status: %v\n", err)
-		} else {
-			payment.Status = "completed"
-			payment.UpdatedAt = time.Now().Format(time.RFC3339)
+			return fmt.Errorf("%w: failed to get checkout session", ErrInternal)
 		}
-	}
 
-	// Persist payment intent ID for refund processing
-	if stripePaymentIntentID != "" && payment.StripePaymentIntentID != stripePaymentIntentID {
-		if err := s.PaymentRepo.UpdatePaymentIntentID(sessionID, stripePaymentIntentID); err != nil && err != ErrPaymentNotFound {
-			fmt.Printf("Warning: failed to update payment intent ID: %v\n", err)
-		} else {
-			payment.StripePaymentIntentID = stripePaymentIntentID
+		if sess.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid {
+			return order.ErrOrderAlreadyPaid
 		}
-	}
 
-	// Re-fetch session to check metadata for completing order/reservation
-	params2 := &stripe.CheckoutSessionParams{}
-	sess2, err := session.Get(sessionID, params2)
-	if err == nil && sess2.Metadata != nil {
-		paymentType, hasType := sess2.Metadata["type"]
-
-		if hasType && paymentType == "reservation" {
-			if reservationIDStr, ok := sess2.Metadata["reservation_id"]; ok {
-				if reservationID, err := strconv.ParseInt(reservationIDStr, 10, 32); err == nil && reservationID > 0 {
-					if s.ReservationStatus != nil {
-						if err := s.ReservationStatus.MarkReservationCompleted(int32(reservationID)); err != nil {
-							fmt.Printf("Warning: failed to mark reservation as completed: %v\n", err)
-						}
-					}
-				}
-			}
-		} else if s.OrderStatus != nil && payment.OrderID > 0 {
-			if err := s.OrderStatus.MarkOrderClosed(payment.OrderID); err != nil {
-				fmt.Printf("Warning: failed to mark order as closed: %v\n", err)
+		if sess.Status == stripe.CheckoutSessionStatusOpen {
+			if _, err := session.Expire(pmt.StripeSessionID, nil); err != nil {
+				return fmt.Errorf("%w: failed to expire checkout session", ErrInternal)
 			}
 		}
+
+		if err := s.PaymentRepo.UpdatePaymentStatus(pmt.StripeSessionID, "cancelled"); err != nil {
+			return err
+		}
 	}
 
-	return payment, nil
+	return nil
 }
@@ -0,0 +1,49 @@
+package product
+
+import "dreampos/internal/order"
+
+type Barcode struct {
+	Code        string `json:"code"        db:"code"`
+	VariationId *int64 `json:"variationId" db:"variation_id"`
+}
+
+// Product found by a barcode. The barcode of a variation selects that variation.
+type BarcodeMatch struct {
+	Product            order.Product     `json:"product"`
+	SelectedVariations []order.Variation `json:"selectedVariations"`
+}
+
+// NormalizeBarcode checks the check digit of an EAN-8, UPC-A, EAN-13 or GTIN-14 code.
+// UPC-A codes are returned as EAN-13 (with a leading 0) and GTIN-14 codes with a leading 0
+// without it, so every form of a code matches.
+func NormalizeBarcode(code string) (string, bool) {
+	switch len(code) {
+	case 8, 13, 14:
+	case 12:
+		code = "0" + code
+	default:
+		return "", false
+	}
+
+	// Digits are weighted 3 and 1 alternately, starting with 3 next to the check digit
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		digit := int(code[i] - '0')
+		if digit < 0 || digit > 9 {
+			return "", false
+		}
+		if (len(code)-2-i)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	if int(code[len(code)-1]-'0') != (10-sum%10)%10 {
+		return "", false
+	}
+	// The check digit is weighted from the right, so it stays valid without the leading 0
+	if len(code) == 14 && code[0] == '0' {
+		return code[1:], true
+	}
+	return code, true
+}
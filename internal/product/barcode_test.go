@@ -0,0 +1,50 @@
+package product
+
+import "testing"
+
+func TestNormalizeBarcode(t *testing.T) {
+	tests := []struct {
+		code   string
+		want   string
+		wantOk bool
+	}{
+		{code: "96385074", want: "96385074", wantOk: true},
+		{code: "96385075", wantOk: false},
+		{code: "4006381333931", want: "4006381333931", wantOk: true},
+		{code: "4006381333932", wantOk: false},
+		{code: "036000291452", want: "0036000291452", wantOk: true},
+		{code: "036000291453", wantOk: false},
+		{code: "0036000291452", want: "0036000291452", wantOk: true},
+		{code: "04006381333931", want: "4006381333931", wantOk: true},
+		{code: "00036000291452", want: "0036000291452", wantOk: true},
+		{code: "04006381333932", wantOk: false},
+		{code: "10012345678902", want: "10012345678902", wantOk: true},
+		{code: "10012345678903", wantOk: false},
+		{code: "", wantOk: false},
+		{code: "1234567", wantOk: false},
+		{code: "400638133393a", wantOk: false},
+		{code: "4006381-33931", wantOk: false},
+	}
+
+	for _, test := range tests {
+		got, ok := NormalizeBarcode(test.code)
+		if ok != test.wantOk {
+			t.Errorf("NormalizeBarcode(%q) ok = %t, want %t", test.code, ok, test.wantOk)
+			continue
+		}
+		if ok && got != test.want {
+			t.Errorf("NormalizeBarcode(%q) = %q, want %q", test.code, got, test.want)
+		}
+	}
+}
+
+// Every form of a code is stored and looked up the same way.
+func TestNormalizeBarcodeForms(t *testing.T) {
+	forms := []string{"036000291452", "0036000291452", "00036000291452"}
+	want, _ := NormalizeBarcode(forms[0])
+	for _, form := range forms[1:] {
+		if got, _ := NormalizeBarcode(form); got != want {
+			t.Errorf("NormalizeBarcode(%q) = %q, want %q", form, got, want)
+		}
+	}
+}
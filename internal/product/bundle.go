@@ -0,0 +1,8 @@
+package product
+
+// Slot of a bundle product as it is created or updated, see order.BundleSlot.
+// Slots are listed by position, then by name.
+type BundleSlotUpdate struct {
+	Name     string `json:"name"`
+	Position int64  `json:"position"`
+}
@@ -0,0 +1,652 @@
+package product
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"dreampos/internal/order"
+)
+
+type CatalogFormat string
+
+const (
+	CatalogCsv  CatalogFormat = "csv"
+	CatalogJson CatalogFormat = "json"
+)
+
+const (
+	maxImportRows  = 5000
+	maxImportBytes = 10 << 20
+
+	// Separates the items of list columns in CSV, e.g. "hot drinks|pastries".
+	catalogListSeparator = "|"
+	// Separates a name from its amount in CSV, e.g. "Large:50".
+	catalogValueSeparator = ":"
+	// Escapes separators and itself in names, e.g. "Tea\: hot" is the name "Tea: hot".
+	catalogEscape = `\`
+)
+
+// One product of a catalog import or export. Amounts are the same as in order.Product.
+// Price and availability are the ones at the location of the import or export, the rest is
+// the same at every location of the business. Lists and maps replace the ones the product had.
+type CatalogRow struct {
+	Name  string     `json:"name"`
+	Sku   string     `json:"sku"`
+	Price int64      `json:"price"`
+	Vat   int64      `json:"vat"`
+	Unit  order.Unit `json:"unit"`
+	// Nil is available.
+	Available  *bool                           `json:"available"`
+	Categories []string                        `json:"categories"`
+	Variations []CatalogVariation              `json:"variations"`
+	VatRates   map[order.FulfillmentType]int64 `json:"vatRates"`
+	Barcodes   []string                        `json:"barcodes"`
+	// Ordered by position.
+	VariationGroups []CatalogVariationGroup `json:"variationGroups"`
+	// Prices by price list name, missing price lists are created.
+	PriceLists map[string]int64 `json:"priceLists"`
+	// Slots of a bundle, ordered by position.
+	Slots []CatalogSlot `json:"slots"`
+}
+
+type CatalogVariation struct {
+	Name          string   `json:"name"`
+	PriceModifier uint64   `json:"priceModifier"`
+	Barcodes      []string `json:"barcodes"`
+	// Name of one of the variation groups of the row, empty if the variation has no group.
+	Group   string `json:"group"`
+	Default bool   `json:"default"`
+}
+
+// Groups with a minimum are required, see order.VariationGroup.
+type CatalogVariationGroup struct {
+	Name        string `json:"name"`
+	MinSelected int64  `json:"minSelected"`
+	// Nil if there is no limit.
+	MaxSelected *int64 `json:"maxSelected"`
+}
+
+type CatalogSlot struct {
+	Name    string          `json:"name"`
+	Options []CatalogOption `json:"options"`
+}
+
+// Options are products of the business by name, they can be in the same import.
+type CatalogOption struct {
+	Product  string `json:"product"`
+	Upcharge int64  `json:"upcharge"`
+}
+
+type ImportAction string
+
+const (
+	ImportCreate ImportAction = "create"
+	ImportUpdate ImportAction = "update"
+)
+
+// Outcome of one row. Rows are numbered from 1, the CSV header is not counted.
+type ImportRowResult struct {
+	Row    int          `json:"row"`
+	Name   string       `json:"name"`
+	Action ImportAction `json:"action,omitempty"`
+	Errors []string     `json:"errors"`
+}
+
+// Nothing is applied if any row has errors.
+type ImportReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Applied bool              `json:"applied"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// Lists of name and amount pairs are written as "name:amount", groups as "name:min:max" with an empty
+// max for no limit, grouped variations as "variation:group" and bundle options as "slot:product:upcharge".
+var catalogHeader = []string{
+	"name", "sku", "price", "vat", "unit", "available", "categories", "variations", "vat_rates", "barcodes",
+	"variation_barcodes", "variation_groups", "grouped_variations", "default_variations", "price_lists",
+	"bundle_slots", "bundle_options",
+}
+
+var errTooManyRows = fmt.Errorf("import has too many rows (max %d)", maxImportRows)
+
+// Parses the import. Rows that can't be parsed are returned with their errors,
+// errors of the file as a whole (e.g. missing columns) are returned as error.
+func parseCatalog(format CatalogFormat, r io.Reader) ([]CatalogRow, [][]string, error) {
+	if format == CatalogJson {
+		var rows []CatalogRow
+		if err := json.NewDecoder(r).Decode(&rows); err != nil {
+			return nil, nil, errors.New("invalid JSON catalog")
+		}
+		if len(rows) > maxImportRows {
+			return nil, nil, errTooManyRows
+		}
+		return rows, make([][]string, len(rows)), nil
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.New("invalid CSV catalog")
+	}
+
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range []string{"name", "price", "vat"} {
+		if _, ok := columns[column]; !ok {
+			return nil, nil, fmt.Errorf("missing column '%s'", column)
+		}
+	}
+
+	rows := []CatalogRow{}
+	rowErrors := [][]string{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, errors.New("invalid CSV catalog")
+		}
+		if len(rows) == maxImportRows {
+			return nil, nil, errTooManyRows
+		}
+
+		row, errs := parseCatalogRecord(record, columns)
+		rows = append(rows, row)
+		rowErrors = append(rowErrors, errs)
+	}
+
+	return rows, rowErrors, nil
+}
+
+func parseCatalogRecord(record []string, columns map[string]int) (CatalogRow, []string) {
+	field := func(column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	errs := []string{}
+	row := CatalogRow{
+		Name:            field("name"),
+		Sku:             field("sku"),
+		Unit:            order.Unit(field("unit")),
+		Categories:      splitCatalogNames(field("categories")),
+		Variations:      []CatalogVariation{},
+		VatRates:        map[order.FulfillmentType]int64{},
+		Barcodes:        splitCatalogNames(field("barcodes")),
+		VariationGroups: []CatalogVariationGroup{},
+		PriceLists:      map[string]int64{},
+		Slots:           []CatalogSlot{},
+	}
+
+	var err error
+	if row.Price, err = strconv.ParseInt(field("price"), 10, 64); err != nil {
+		errs = append(errs, "price must be a whole number of cents")
+	}
+	if row.Vat, err = strconv.ParseInt(field("vat"), 10, 64); err != nil {
+		errs = append(errs, "vat must be a whole number (hundredths of a percent)")
+	}
+	if available := field("available"); available != "" {
+		value, err := strconv.ParseBool(available)
+		if err != nil {
+			errs = append(errs, "available must be true or false")
+		}
+		row.Available = &value
+	}
+
+	for _, variation := range splitCatalogList(field("variations")) {
+		values, ok := splitCatalogValues(variation, 2)
+		priceModifier, err := strconv.ParseUint(values[1], 10, 64)
+		if !ok || err != nil {
+			errs = append(errs, fmt.Sprintf("variation '%s' must be written as name:price", variation))
+			continue
+		}
+		row.Variations = append(row.Variations, CatalogVariation{Name: values[0], PriceModifier: priceModifier, Barcodes: []string{}})
+	}
+	findVariation := func(name string) int {
+		return slices.IndexFunc(row.Variations, func(v CatalogVariation) bool { return v.Name == name })
+	}
+
+	for _, barcode := range splitCatalogList(field("variation_barcodes")) {
+		values, ok := splitCatalogValues(barcode, 2)
+		i := findVariation(values[0])
+		if !ok || i < 0 {
+			errs = append(errs, fmt.Sprintf("variation barcode '%s' must be written as variation:code of a listed variation", barcode))
+			continue
+		}
+		row.Variations[i].Barcodes = append(row.Variations[i].Barcodes, values[1])
+	}
+
+	for _, rate := range splitCatalogList(field("vat_rates")) {
+		values, ok := splitCatalogValues(rate, 2)
+		vat, err := strconv.ParseInt(values[1], 10, 64)
+		if !ok || err != nil {
+			errs = append(errs, fmt.Sprintf("vat rate '%s' must be written as fulfillment:vat", rate))
+			continue
+		}
+		row.VatRates[order.FulfillmentType(values[0])] = vat
+	}
+
+	for _, group := range splitCatalogList(field("variation_groups")) {
+		values, ok := splitCatalogValues(group, 3)
+		minSelected, err := strconv.ParseInt(values[1], 10, 64)
+		var maxSelected *int64
+		if values[2] != "" {
+			value, maxErr := strconv.ParseInt(values[2], 10, 64)
+			err = errors.Join(err, maxErr)
+			maxSelected = &value
+		}
+		if !ok || err != nil {
+			errs = append(errs, fmt.Sprintf("variation group '%s' must be written as name:min:max", group))
+			continue
+		}
+		row.VariationGroups = append(row.VariationGroups, CatalogVariationGroup{Name: values[0], MinSelected: minSelected, MaxSelected: maxSelected})
+	}
+
+	for _, grouped := range splitCatalogList(field("grouped_variations")) {
+		values, ok := splitCatalogValues(grouped, 2)
+		i := findVariation(values[0])
+		if !ok || i < 0 {
+			errs = append(errs, fmt.Sprintf("grouped variation '%s' must be written as variation:group of a listed variation", grouped))
+			continue
+		}
+		row.Variations[i].Group = values[1]
+	}
+
+	for _, name := range splitCatalogNames(field("default_variations")) {
+		i := findVariation(name)
+		if i < 0 {
+			errs = append(errs, fmt.Sprintf("default variation '%s' is not a listed variation", name))
+			continue
+		}
+		row.Variations[i].Default = true
+	}
+
+	for _, item := range splitCatalogList(field("price_lists")) {
+		values, ok := splitCatalogValues(item, 2)
+		price, err := strconv.ParseInt(values[1], 10, 64)
+		if !ok || err != nil {
+			errs = append(errs, fmt.Sprintf("price list price '%s' must be written as price list:price", item))
+			continue
+		}
+		row.PriceLists[values[0]] = price
+	}
+
+	for _, name := range splitCatalogNames(field("bundle_slots")) {
+		row.Slots = append(row.Slots, CatalogSlot{Name: name, Options: []CatalogOption{}})
+	}
+	for _, option := range splitCatalogList(field("bundle_options")) {
+		values, ok := splitCatalogValues(option, 3)
+		i := slices.IndexFunc(row.Slots, func(slot CatalogSlot) bool { return slot.Name == values[0] })
+		upcharge, err := strconv.ParseInt(values[2], 10, 64)
+		if !ok || i < 0 || err != nil {
+			errs = append(errs, fmt.Sprintf("bundle option '%s' must be written as slot:product:upcharge of a listed slot", option))
+			continue
+		}
+		row.Slots[i].Options = append(row.Slots[i].Options, CatalogOption{Product: values[1], Upcharge: upcharge})
+	}
+
+	return row, errs
+}
+
+// Normalizes the row and returns the reasons why it's not valid.
+func validateCatalogRow(row *CatalogRow) []string {
+	errs := []string{}
+
+	if row.Unit == "" {
+		row.Unit = order.UnitPiece
+	}
+	if row.Available == nil {
+		available := true
+		row.Available = &available
+	}
+	product := ProductUpdate{Name: &row.Name, BasePrice: &row.Price, Vat: &row.Vat, Unit: &row.Unit, Sku: &row.Sku}
+	if msg := validateProduct(&product); msg != "" {
+		errs = append(errs, msg)
+	} else {
+		row.Name, row.Unit, row.Sku = *product.Name, *product.Unit, *product.Sku
+	}
+
+	// Barcodes of the product and its variations together, a code can only be used once
+	codes := []string{}
+	normalize := func(barcodes []string) []string {
+		normalized := make([]string, len(barcodes))
+		for i, barcode := range barcodes {
+			code, ok := NormalizeBarcode(strings.TrimSpace(barcode))
+			if !ok {
+				errs = append(errs, fmt.Sprintf("invalid barcode '%s'", barcode))
+			}
+			normalized[i] = code
+		}
+		codes = append(codes, normalized...)
+		return normalized
+	}
+	row.Barcodes = normalize(row.Barcodes)
+
+	if row.Categories == nil {
+		row.Categories = []string{}
+	}
+	for i := range row.Categories {
+		row.Categories[i] = strings.TrimSpace(row.Categories[i])
+		if msg := validateCategoryName(row.Categories[i]); msg != "" {
+			errs = append(errs, msg)
+		}
+	}
+	if hasDuplicates(row.Categories) {
+		errs = append(errs, "categories must not repeat")
+	}
+
+	if row.VariationGroups == nil {
+		row.VariationGroups = []CatalogVariationGroup{}
+	}
+	groups := make([]string, len(row.VariationGroups))
+	for i := range row.VariationGroups {
+		group := VariationGroupUpdate{
+			Name:        row.VariationGroups[i].Name,
+			MinSelected: row.VariationGroups[i].MinSelected,
+			MaxSelected: row.VariationGroups[i].MaxSelected,
+		}
+		if msg := validateVariationGroup(&group); msg != "" {
+			errs = append(errs, msg)
+		}
+		row.VariationGroups[i].Name = group.Name
+		groups[i] = group.Name
+	}
+	if hasDuplicates(groups) {
+		errs = append(errs, "variation group names must not repeat")
+	}
+
+	if row.Variations == nil {
+		row.Variations = []CatalogVariation{}
+	}
+	names := make([]string, len(row.Variations))
+	defaults := map[string]int64{}
+	for i := range row.Variations {
+		variation := VariationUpdate{Name: &row.Variations[i].Name}
+		if msg := validateVariation(&variation); msg != "" {
+			errs = append(errs, msg)
+		} else {
+			row.Variations[i].Name = *variation.Name
+		}
+		names[i] = row.Variations[i].Name
+		row.Variations[i].Barcodes = normalize(row.Variations[i].Barcodes)
+
+		row.Variations[i].Group = strings.TrimSpace(row.Variations[i].Group)
+		if group := row.Variations[i].Group; group != "" && !slices.Contains(groups, group) {
+			errs = append(errs, fmt.Sprintf("variation '%s' is in group '%s' that is not listed", names[i], group))
+		} else if row.Variations[i].Default {
+			defaults[group]++
+		}
+	}
+	if hasDuplicates(names) {
+		errs = append(errs, "variation names must not repeat")
+	}
+	for _, group := range row.VariationGroups {
+		if group.MaxSelected != nil && defaults[group.Name] > *group.MaxSelected {
+			errs = append(errs, ErrTooManyDefaults.Error())
+		}
+	}
+	if hasDuplicates(codes) {
+		errs = append(errs, "barcodes must not repeat")
+	}
+
+	rates := make(map[order.FulfillmentType]int64, len(row.VatRates))
+	for fulfillment, vat := range row.VatRates {
+		fulfillment = order.ParseFulfillmentType(string(fulfillment))
+		if !fulfillment.Valid() {
+			errs = append(errs, fmt.Sprintf("invalid fulfillment type '%s'", fulfillment))
+		} else if vat < 0 || vat > 9999 {
+			errs = append(errs, "vat rates must be between 0 and 9999 (hundredths of a percent)")
+		}
+		rates[fulfillment] = vat
+	}
+	row.VatRates = rates
+
+	prices := make(map[string]int64, len(row.PriceLists))
+	for name, price := range row.PriceLists {
+		name = strings.TrimSpace(name)
+		if msg := validatePriceListName(name); msg != "" {
+			errs = append(errs, msg)
+		} else if price <= 0 {
+			errs = append(errs, "price list prices must be positive")
+		}
+		prices[name] = price
+	}
+	row.PriceLists = prices
+
+	if row.Slots == nil {
+		row.Slots = []CatalogSlot{}
+	}
+	slots := make([]string, len(row.Slots))
+	for i := range row.Slots {
+		slot := &row.Slots[i]
+		slot.Name = strings.TrimSpace(slot.Name)
+		if msg := validateBundleSlot(BundleSlotUpdate{Name: slot.Name}); msg != "" {
+			errs = append(errs, msg)
+		}
+		slots[i] = slot.Name
+
+		if slot.Options == nil {
+			slot.Options = []CatalogOption{}
+		}
+		options := make([]string, len(slot.Options))
+		for j := range slot.Options {
+			slot.Options[j].Product = strings.TrimSpace(slot.Options[j].Product)
+			switch {
+			case slot.Options[j].Product == "":
+				errs = append(errs, "bundle options need a product name")
+			case slot.Options[j].Product == row.Name:
+				errs = append(errs, ErrNestedBundle.Error())
+			case slot.Options[j].Upcharge < 0:
+				errs = append(errs, "upcharge can't be negative")
+			}
+			options[j] = slot.Options[j].Product
+		}
+		if hasDuplicates(options) {
+			errs = append(errs, fmt.Sprintf("options of slot '%s' must not repeat", slot.Name))
+		}
+	}
+	if hasDuplicates(slots) {
+		errs = append(errs, "bundle slot names must not repeat")
+	}
+
+	return errs
+}
+
+func (row CatalogRow) csvRecord() []string {
+	variations := make([]string, len(row.Variations))
+	variationBarcodes := []string{}
+	grouped := []string{}
+	defaults := []string{}
+	for i, variation := range row.Variations {
+		name := escapeCatalogName(variation.Name)
+		variations[i] = name + catalogValueSeparator + strconv.FormatUint(variation.PriceModifier, 10)
+		for _, code := range variation.Barcodes {
+			variationBarcodes = append(variationBarcodes, name+catalogValueSeparator+code)
+		}
+		if variation.Group != "" {
+			grouped = append(grouped, name+catalogValueSeparator+escapeCatalogName(variation.Group))
+		}
+		if variation.Default {
+			defaults = append(defaults, name)
+		}
+	}
+
+	rates := make([]string, 0, len(row.VatRates))
+	for fulfillment, vat := range row.VatRates {
+		rates = append(rates, string(fulfillment)+catalogValueSeparator+strconv.FormatInt(vat, 10))
+	}
+	slices.Sort(rates)
+
+	groups := make([]string, len(row.VariationGroups))
+	for i, group := range row.VariationGroups {
+		maxSelected := ""
+		if group.MaxSelected != nil {
+			maxSelected = strconv.FormatInt(*group.MaxSelected, 10)
+		}
+		groups[i] = escapeCatalogName(group.Name) + catalogValueSeparator + strconv.FormatInt(group.MinSelected, 10) +
+			catalogValueSeparator + maxSelected
+	}
+
+	prices := make([]string, 0, len(row.PriceLists))
+	for name, price := range row.PriceLists {
+		prices = append(prices, escapeCatalogName(name)+catalogValueSeparator+strconv.FormatInt(price, 10))
+	}
+	slices.Sort(prices)
+
+	slots := make([]string, len(row.Slots))
+	options := []string{}
+	for i, slot := range row.Slots {
+		slots[i] = escapeCatalogName(slot.Name)
+		for _, option := range slot.Options {
+			options = append(options, slots[i]+catalogValueSeparator+escapeCatalogName(option.Product)+
+				catalogValueSeparator+strconv.FormatInt(option.Upcharge, 10))
+		}
+	}
+
+	categories := make([]string, len(row.Categories))
+	for i, category := range row.Categories {
+		categories[i] = escapeCatalogName(category)
+	}
+
+	available := row.Available == nil || *row.Available
+	return []string{
+		row.Name,
+		row.Sku,
+		strconv.FormatInt(row.Price, 10),
+		strconv.FormatInt(row.Vat, 10),
+		string(row.Unit),
+		strconv.FormatBool(available),
+		strings.Join(categories, catalogListSeparator),
+		strings.Join(variations, catalogListSeparator),
+		strings.Join(rates, catalogListSeparator),
+		strings.Join(row.Barcodes, catalogListSeparator),
+		strings.Join(variationBarcodes, catalogListSeparator),
+		strings.Join(groups, catalogListSeparator),
+		strings.Join(grouped, catalogListSeparator),
+		strings.Join(defaults, catalogListSeparator),
+		strings.Join(prices, catalogListSeparator),
+		strings.Join(slots, catalogListSeparator),
+		strings.Join(options, catalogListSeparator),
+	}
+}
+
+func writeCatalog(format CatalogFormat, w io.Writer, rows []CatalogRow) error {
+	if format == CatalogJson {
+		return json.NewEncoder(w).Encode(rows)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(catalogHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := writer.Write(row.csvRecord()); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Splits a list column at the separators that are not escaped. The items are trimmed but not unescaped,
+// so they can be split into their values.
+func splitCatalogList(field string) []string {
+	if field == "" {
+		return []string{}
+	}
+	items := splitEscaped(field, catalogListSeparator)
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// Splits a list column of names, e.g. categories.
+func splitCatalogNames(field string) []string {
+	names := splitCatalogList(field)
+	for i := range names {
+		names[i] = unescapeCatalogName(names[i])
+	}
+	return names
+}
+
+// Splits an item of a list column into n unescaped values. Separators that are not escaped in
+// the first value are kept, so files that were written without escaping still work.
+// There are always n values, ok is false if the item has less.
+func splitCatalogValues(item string, n int) ([]string, bool) {
+	parts := splitEscaped(item, catalogValueSeparator)
+	if len(parts) < n {
+		return make([]string, n), false
+	}
+
+	values := append([]string{strings.Join(parts[:len(parts)-n+1], catalogValueSeparator)}, parts[len(parts)-n+1:]...)
+	for i := range values {
+		values[i] = strings.TrimSpace(unescapeCatalogName(values[i]))
+	}
+	return values, true
+}
+
+// Splits s at every sep that is not escaped, the escapes are kept.
+func splitEscaped(s, sep string) []string {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], catalogEscape):
+			i += len(catalogEscape)
+		case strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var catalogEscaper = strings.NewReplacer(
+	catalogEscape, catalogEscape+catalogEscape,
+	catalogListSeparator, catalogEscape+catalogListSeparator,
+	catalogValueSeparator, catalogEscape+catalogValueSeparator,
+)
+
+func escapeCatalogName(name string) string {
+	return catalogEscaper.Replace(name)
+}
+
+// Removes the escapes, the character after an escape is kept as it is.
+func unescapeCatalogName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if strings.HasPrefix(name[i:], catalogEscape) && i+len(catalogEscape) < len(name) {
+			i += len(catalogEscape)
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+func hasDuplicates(values []string) bool {
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if seen[value] {
+			return true
+		}
+		seen[value] = true
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package product
+
+import (
+	"time"
+
+	"dreampos/internal/auth"
+	"dreampos/internal/order"
+)
+
+// Writes to the catalog. Products and variations are archived instead of deleted,
+// so orders that reference them keep their lines.
+type CatalogRepo interface {
+	GetBusinessInfo(username string) (auth.BusinessInfo, error)
+	// Business the entry belongs to, or the not found error of the entry.
+	GetProductBusiness(productId int64) (int64, error)
+	GetVariationBusiness(variationId int64) (int64, error)
+	GetVariationGroupBusiness(groupId int64) (int64, error)
+	GetBundleSlotBusiness(slotId int64) (int64, error)
+	GetPricingRuleBusiness(pricingRuleId int64) (int64, error)
+	GetPriceListBusiness(priceListId int64) (int64, error)
+	GetPriceChangeBusiness(priceChangeId int64) (int64, error)
+	GetCategoryBusiness(categoryId int64) (int64, error)
+	CreateProduct(product ProductUpdate) (int64, error)
+	UpdateProduct(productId int64, product ProductUpdate) error
+	ArchiveProduct(productId int64) error
+	// Default variations of a group have to fit in its maximum.
+	CreateVariation(productId int64, variation VariationUpdate) (int64, error)
+	UpdateVariation(variationId int64, variation VariationUpdate) error
+	ArchiveVariation(variationId int64) error
+	GetVariationGroups(productId int64) ([]order.VariationGroup, error)
+	CreateVariationGroup(productId int64, group VariationGroupUpdate) (int64, error)
+	// The maximum can't go below the number of default variations of the group.
+	UpdateVariationGroup(groupId int64, group VariationGroupUpdate) error
+	// Variations of the group are kept without a group.
+	DeleteVariationGroup(groupId int64) error
+	GetAllCategories(businessId int64) ([]Category, error)
+	// Names are unique within the business.
+	CreateCategory(businessId int64, name string) (int64, error)
+	RenameCategory(categoryId int64, name string) error
+	// Categories used by a kitchen station can't be deleted.
+	DeleteCategory(categoryId int64) error
+	// The category has to belong to the business of the product.
+	AddToCategory(productId int64, categoryId int64) error
+	RemoveFromCategory(productId int64, categoryId int64) error
+	// Active product with the barcode in the location, codes are normalized with NormalizeBarcode.
+	FindByBarcode(locationId int64, code string) (BarcodeMatch, error)
+	GetBarcodes(productId int64) ([]Barcode, error)
+	AddBarcode(productId int64, barcode Barcode) (int64, error)
+	RemoveBarcode(productId int64, code string) error
+	GetProductLocations(productId int64) ([]ProductLocation, error)
+	// Starts selling the product at the location or changes its availability and price there.
+	// The location has to belong to the business of the product.
+	SetProductLocation(productId int64, location ProductLocation) error
+	RemoveProductLocation(productId int64, locationId int64) error
+	GetPriceLists(businessId int64) ([]PriceList, error)
+	CreatePriceList(businessId int64, name string) (int64, error)
+	RenamePriceList(priceListId int64, name string) error
+	// Price lists used by open orders can't be deleted, other orders keep their prices.
+	DeletePriceList(priceListId int64) error
+	GetPriceListItems(priceListId int64) ([]PriceListItem, error)
+	// The product has to belong to the business of the price list.
+	SetPriceListItem(priceListId int64, productId int64, price int64) error
+	RemovePriceListItem(priceListId int64, productId int64) error
+	// Scheduled changes of the product, ordered by the time they take effect.
+	GetPriceChanges(productId int64) ([]PriceChange, error)
+	// The location has to belong to the business of the product.
+	SchedulePriceChange(productId int64, change PriceChangeUpdate) (int64, error)
+	// Only changes that have not been applied yet can be cancelled.
+	CancelPriceChange(priceChangeId int64) error
+	// Entries changed between from and to, ordered by time. Nil bounds are not checked.
+	GetPriceHistory(productId int64, from *time.Time, to *time.Time) ([]PriceHistoryEntry, error)
+	// Applies the scheduled changes that are due and returns how many there were.
+	ApplyPriceChanges() (int64, error)
+	GetPricingRules(businessId int64) ([]PricingRule, error)
+	// The location and product have to belong to the business of the rule.
+	CreatePricingRule(rule PricingRule) (int64, error)
+	// Replaces the rule, its business can't be changed. Order lines keep the adjustment they got.
+	UpdatePricingRule(pricingRuleId int64, rule PricingRule) error
+	DeletePricingRule(pricingRuleId int64) error
+	GetBundleSlots(productId int64) ([]order.BundleSlot, error)
+	// Adding a slot makes the product a bundle, products that are options of a bundle can't have slots.
+	CreateBundleSlot(productId int64, slot BundleSlotUpdate) (int64, error)
+	UpdateBundleSlot(slotId int64, slot BundleSlotUpdate) error
+	// Order lines keep the components they had in the slot.
+	DeleteBundleSlot(slotId int64) error
+	// Adds the product to the slot or changes its upcharge. The product has to belong to the business
+	// of the bundle and can't be a bundle itself.
+	SetBundleOption(slotId int64, productId int64, upcharge int64) error
+	RemoveBundleOption(slotId int64, productId int64) error
+	// Cancels the deletion of the files, has to be called before they are stored again.
+	KeepImages(keys []string) error
+	// Replaces the image of the product, nil image removes it. Files that are no longer used
+	// by any product are scheduled for deletion.
+	SetProductImage(productId int64, image *ProductImage) error
+	// Schedules the deletion of the files that are not used by any product.
+	DiscardImages(keys []string) error
+	// Calls deleteFile for the scheduled files that are due and still unused.
+	// Returns how many files were deleted.
+	DeleteUnusedImages(deleteFile func(key string) error) (int64, error)
+	// Active products listed at the location, ordered by name, with their price and availability at the location.
+	ExportCatalog(locationId int64) ([]CatalogRow, error)
+	// Creates or updates the products by SKU (or by name, if no product has the SKU) and reports each row.
+	// Products of the business of the location are matched, the price and availability of a row are set at the location.
+	// Rows are applied in one transaction that is committed only if apply is set and every row succeeded.
+	ImportCatalog(locationId int64, rows []CatalogRow, apply bool) ([]ImportRowResult, error)
+}
@@ -1,16 +1,28 @@
 package product
 
 import (
+	"bytes"
+	"dreampos/internal/auth"
 	"dreampos/internal/order"
+	"dreampos/internal/storage"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type ProductController struct {
 	ProductRepo order.ProductRepo
+	CatalogRepo CatalogRepo
+	Images      storage.Store
 }
 
 func (c ProductController) Routes() http.Handler {
@@ -18,8 +30,73 @@ func (c ProductController) Routes() http.Handler {
 
 	router.Get("/", c.getProductInfo)
 	router.Get("/category", c.getCategories)
+	router.Get("/category/all", c.allCategories)
 	router.Get("/tax/default", c.getDefaultVat)
-	router.Patch("/tax", c.setVat)
+	router.Get("/export", c.exportCatalog)
+	router.Get("/barcode/{code:^[0-9]{8,14}$}", c.findByBarcode)
+	router.Get("/{productId:^[0-9]{1,10}$}/barcode", c.getBarcodes)
+	router.Get("/image/{key:^[0-9a-f]{64}(-thumb)?[.](jpg|png|gif)$}", c.serveImage)
+	router.Get("/{productId:^[0-9]{1,10}$}/location", c.getProductLocations)
+	router.Get("/{productId:^[0-9]{1,10}$}/price-change", c.getPriceChanges)
+	router.Get("/{productId:^[0-9]{1,10}$}/price-history", c.getPriceHistory)
+	router.Get("/price-list", c.getPriceLists)
+	router.Get("/pricing-rule", c.getPricingRules)
+	router.Get("/price-list/{priceListId:^[0-9]{1,10}$}/item", c.getPriceListItems)
+	router.Get("/{productId:^[0-9]{1,10}$}/slot", c.getBundleSlots)
+	router.Get("/{productId:^[0-9]{1,10}$}/variation-group", c.getVariationGroups)
+
+	router.Group(func(router chi.Router) {
+		router.Use(auth.RequirePermission(auth.PermissionManageCatalog))
+
+		router.Patch("/tax", c.setVat)
+
+		router.Post("/import", c.importCatalog)
+
+		router.Post("/", c.createProduct)
+		router.Patch("/{productId:^[0-9]{1,10}$}", c.updateProduct)
+		router.Post("/{productId:^[0-9]{1,10}$}/archive", c.archiveProduct)
+
+		router.Post("/{productId:^[0-9]{1,10}$}/variation", c.createVariation)
+		router.Patch("/variation/{variationId:^[0-9]{1,10}$}", c.updateVariation)
+		router.Post("/variation/{variationId:^[0-9]{1,10}$}/archive", c.archiveVariation)
+		router.Post("/{productId:^[0-9]{1,10}$}/variation-group", c.createVariationGroup)
+		router.Put("/variation-group/{groupId:^[0-9]{1,10}$}", c.updateVariationGroup)
+		router.Delete("/variation-group/{groupId:^[0-9]{1,10}$}", c.deleteVariationGroup)
+
+		router.Post("/category", c.createCategory)
+		router.Patch("/category/{categoryId:^[0-9]{1,10}$}", c.renameCategory)
+		router.Delete("/category/{categoryId:^[0-9]{1,10}$}", c.deleteCategory)
+		router.Put("/{productId:^[0-9]{1,10}$}/category/{categoryId:^[0-9]{1,10}$}", c.addToCategory)
+		router.Delete("/{productId:^[0-9]{1,10}$}/category/{categoryId:^[0-9]{1,10}$}", c.removeFromCategory)
+
+		router.Post("/{productId:^[0-9]{1,10}$}/barcode", c.addBarcode)
+		router.Delete("/{productId:^[0-9]{1,10}$}/barcode/{code:^[0-9]{8,14}$}", c.removeBarcode)
+
+		router.Put("/{productId:^[0-9]{1,10}$}/image", c.uploadImage)
+		router.Delete("/{productId:^[0-9]{1,10}$}/image", c.removeImage)
+
+		router.Put("/{productId:^[0-9]{1,10}$}/location/{locationId:^[0-9]{1,10}$}", c.setProductLocation)
+		router.Delete("/{productId:^[0-9]{1,10}$}/location/{locationId:^[0-9]{1,10}$}", c.removeProductLocation)
+
+		router.Post("/{productId:^[0-9]{1,10}$}/price-change", c.schedulePriceChange)
+		router.Delete("/price-change/{priceChangeId:^[0-9]{1,10}$}", c.cancelPriceChange)
+
+		router.Post("/pricing-rule", c.createPricingRule)
+		router.Put("/pricing-rule/{pricingRuleId:^[0-9]{1,10}$}", c.updatePricingRule)
+		router.Delete("/pricing-rule/{pricingRuleId:^[0-9]{1,10}$}", c.deletePricingRule)
+
+		router.Post("/price-list", c.createPriceList)
+		router.Patch("/price-list/{priceListId:^[0-9]{1,10}$}", c.renamePriceList)
+		router.Delete("/price-list/{priceListId:^[0-9]{1,10}$}", c.deletePriceList)
+		router.Put("/price-list/{priceListId:^[0-9]{1,10}$}/item/{productId:^[0-9]{1,10}$}", c.setPriceListItem)
+		router.Delete("/price-list/{priceListId:^[0-9]{1,10}$}/item/{productId:^[0-9]{1,10}$}", c.removePriceListItem)
+
+		router.Post("/{productId:^[0-9]{1,10}$}/slot", c.createBundleSlot)
+		router.Put("/slot/{slotId:^[0-9]{1,10}$}", c.updateBundleSlot)
+		router.Delete("/slot/{slotId:^[0-9]{1,10}$}", c.deleteBundleSlot)
+		router.Put("/slot/{slotId:^[0-9]{1,10}$}/option/{productId:^[0-9]{1,10}$}", c.setBundleOption)
+		router.Delete("/slot/{slotId:^[0-9]{1,10}$}/option/{productId:^[0-9]{1,10}$}", c.removeBundleOption)
+	})
 
 	return router
 }
@@ -34,6 +111,9 @@ func (c ProductController) getProductInfo(w http.ResponseWriter, r *http.Request
 		http.Error(w, "bad or no location id", http.StatusBadRequest)
 		return
 	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
 
 	category := new(string)
 	*category = r.URL.Query().Get("category")
@@ -41,9 +121,15 @@ func (c ProductController) getProductInfo(w http.ResponseWriter, r *http.Request
 		category = nil
 	}
 
+	var sku *string
+	if value := r.URL.Query().Get("sku"); value != "" {
+		sku = &value
+	}
+
 	defaultVat, err := c.ProductRepo.GetProducts(order.ProductFilter{
 		LocationId: locationId,
 		Category:   category,
+		Sku:        sku,
 	})
 	if err != nil {
 		http.Error(w, "invalid location id", http.StatusBadRequest)
@@ -68,6 +154,9 @@ func (c ProductController) getCategories(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "bad or no location id", http.StatusBadRequest)
 		return
 	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
 
 	categories, err := c.ProductRepo.GetCategories(locationId)
 	if err != nil {
@@ -93,6 +182,9 @@ func (c ProductController) getDefaultVat(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "bad or no location id", http.StatusBadRequest)
 		return
 	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
 
 	defaultVat, err := c.ProductRepo.GetDefaultVat(locationId)
 	if err != nil {
@@ -118,21 +210,1822 @@ func (c ProductController) setVat(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad or no location id", http.StatusBadRequest)
 		return
 	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
 
+	// Without fulfillment type the default rate of the item is set,
+	// with it the rate for that type only (null vat removes the override).
+	// With an effective date (YYYY-MM-DD) the change is scheduled for midnight at the location.
 	var params struct {
-		ItemId	int64 `json:"id"`
-		NewVat	int64 `json:"vat"`
+		ItemId		int64 `json:"id"`
+		NewVat		*int64 `json:"vat"`
+		FulfillmentType	*string `json:"fulfillmentType"`
+		EffectiveDate	*string `json:"effectiveDate"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	err = c.ProductRepo.SetVat(locationId, params.ItemId, params.NewVat)
-	if err != nil {
+	var effectiveDate *time.Time
+	if params.EffectiveDate != nil {
+		date, err := time.Parse(time.DateOnly, *params.EffectiveDate)
+		if err != nil {
+			http.Error(w, "invalid effective date", http.StatusBadRequest)
+			return
+		}
+		effectiveDate = &date
+	}
+
+	if params.FulfillmentType != nil {
+		fulfillment := order.ParseFulfillmentType(*params.FulfillmentType)
+		if !fulfillment.Valid() {
+			http.Error(w, "invalid fulfillment type", http.StatusBadRequest)
+			return
+		}
+		err = c.ProductRepo.SetFulfillmentVat(locationId, params.ItemId, fulfillment, params.NewVat, effectiveDate)
+	} else if params.NewVat == nil {
+		http.Error(w, "vat is required", http.StatusBadRequest)
+		return
+	} else {
+		err = c.ProductRepo.SetVat(locationId, params.ItemId, *params.NewVat, effectiveDate)
+	}
+	if errors.Is(err, order.ErrEffectiveDatePast) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, "failed to set vat", http.StatusBadRequest)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (c ProductController) createProduct(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	var product ProductUpdate
+	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+		http.Error(w, "invalid product", http.StatusBadRequest)
+		return
+	}
+	if product.LocationId <= 0 || product.Name == nil || product.BasePrice == nil || product.Vat == nil {
+		http.Error(w, "location id, name, base price and vat are required", http.StatusBadRequest)
+		return
+	}
+	if product.Unit == nil {
+		unit := order.UnitPiece
+		product.Unit = &unit
+	}
+	if msg := validateProduct(&product); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, product.LocationId) {
+		return
+	}
+
+	id, err := c.CatalogRepo.CreateProduct(product)
+	if !writeCatalogError(w, err, "failed to create product") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) updateProduct(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	var product ProductUpdate
+	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+		http.Error(w, "invalid product", http.StatusBadRequest)
+		return
+	}
+	if msg := validateProduct(&product); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.UpdateProduct(productId, product)
+	if !writeCatalogError(w, err, "failed to update product") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) archiveProduct(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.ArchiveProduct(productId)
+	if !writeCatalogError(w, err, "failed to archive product") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) createVariation(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	var variation VariationUpdate
+	if err := json.NewDecoder(r.Body).Decode(&variation); err != nil {
+		http.Error(w, "invalid variation", http.StatusBadRequest)
+		return
+	}
+	if variation.Name == nil {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if variation.PriceModifier == nil {
+		variation.PriceModifier = new(uint64)
+	}
+	if msg := validateVariation(&variation); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	id, err := c.CatalogRepo.CreateVariation(productId, variation)
+	if !writeCatalogError(w, err, "failed to create variation") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) updateVariation(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	variationId, err := strconv.ParseInt(r.PathValue("variationId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, variationId, c.CatalogRepo.GetVariationBusiness, ErrVariationNotFound) {
+		return
+	}
+
+	var variation VariationUpdate
+	if err := json.NewDecoder(r.Body).Decode(&variation); err != nil {
+		http.Error(w, "invalid variation", http.StatusBadRequest)
+		return
+	}
+	if msg := validateVariation(&variation); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.UpdateVariation(variationId, variation)
+	if !writeCatalogError(w, err, "failed to update variation") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) archiveVariation(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	variationId, err := strconv.ParseInt(r.PathValue("variationId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, variationId, c.CatalogRepo.GetVariationBusiness, ErrVariationNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.ArchiveVariation(variationId)
+	if !writeCatalogError(w, err, "failed to archive variation") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) getVariationGroups(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	groups, err := c.CatalogRepo.GetVariationGroups(productId)
+	if !writeCatalogError(w, err, "failed to get variation groups") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		http.Error(w, "failed to send variation groups", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) createVariationGroup(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	var group VariationGroupUpdate
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "invalid variation group", http.StatusBadRequest)
+		return
+	}
+	if msg := validateVariationGroup(&group); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	id, err := c.CatalogRepo.CreateVariationGroup(productId, group)
+	if !writeCatalogError(w, err, "failed to create variation group") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) updateVariationGroup(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	groupId, err := strconv.ParseInt(r.PathValue("groupId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, groupId, c.CatalogRepo.GetVariationGroupBusiness, ErrGroupNotFound) {
+		return
+	}
+
+	var group VariationGroupUpdate
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "invalid variation group", http.StatusBadRequest)
+		return
+	}
+	if msg := validateVariationGroup(&group); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.UpdateVariationGroup(groupId, group)
+	if !writeCatalogError(w, err, "failed to update variation group") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) deleteVariationGroup(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	groupId, err := strconv.ParseInt(r.PathValue("groupId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, groupId, c.CatalogRepo.GetVariationGroupBusiness, ErrGroupNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.DeleteVariationGroup(groupId)
+	if !writeCatalogError(w, err, "failed to delete variation group") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) allCategories(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	business, ok := c.userBusiness(w, r)
+	if !ok {
+		return
+	}
+
+	categories, err := c.CatalogRepo.GetAllCategories(business.Id)
+	if err != nil {
+		http.Error(w, "failed to get categories", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(categories); err != nil {
+		http.Error(w, "failed to get categories", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) createCategory(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	name, ok := decodeCategoryName(w, r)
+	if !ok {
+		return
+	}
+
+	business, ok := c.userBusiness(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := c.CatalogRepo.CreateCategory(business.Id, name)
+	if !writeCatalogError(w, err, "failed to create category") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) renameCategory(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	categoryId, err := strconv.ParseInt(r.PathValue("categoryId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, categoryId, c.CatalogRepo.GetCategoryBusiness, ErrCategoryNotFound) {
+		return
+	}
+
+	name, ok := decodeCategoryName(w, r)
+	if !ok {
+		return
+	}
+
+	err = c.CatalogRepo.RenameCategory(categoryId, name)
+	if !writeCatalogError(w, err, "failed to rename category") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) deleteCategory(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	categoryId, err := strconv.ParseInt(r.PathValue("categoryId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, categoryId, c.CatalogRepo.GetCategoryBusiness, ErrCategoryNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.DeleteCategory(categoryId)
+	if !writeCatalogError(w, err, "failed to delete category") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) addToCategory(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	categoryId, err := strconv.ParseInt(r.PathValue("categoryId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.AddToCategory(productId, categoryId)
+	if !writeCatalogError(w, err, "failed to add product to category") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) removeFromCategory(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	categoryId, err := strconv.ParseInt(r.PathValue("categoryId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.RemoveFromCategory(productId, categoryId)
+	if !writeCatalogError(w, err, "failed to remove product from category") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Looks up a scanned code in the location, UPC-A and EAN-13 forms of a code find the same product.
+func (c ProductController) findByBarcode(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	locationId, err := strconv.ParseInt(r.URL.Query().Get("locationId"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad or no location id", http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
+	code, ok := NormalizeBarcode(r.PathValue("code"))
+	if !ok {
+		http.Error(w, "invalid barcode", http.StatusBadRequest)
+		return
+	}
+
+	match, err := c.CatalogRepo.FindByBarcode(locationId, code)
+	if !writeCatalogError(w, err, "failed to find product") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(match); err != nil {
+		http.Error(w, "failed to send product", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) getBarcodes(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	barcodes, err := c.CatalogRepo.GetBarcodes(productId)
+	if !writeCatalogError(w, err, "failed to get barcodes") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(barcodes); err != nil {
+		http.Error(w, "failed to send barcodes", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) addBarcode(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	var barcode Barcode
+	if err := json.NewDecoder(r.Body).Decode(&barcode); err != nil {
+		http.Error(w, "invalid barcode", http.StatusBadRequest)
+		return
+	}
+	code, ok := NormalizeBarcode(strings.TrimSpace(barcode.Code))
+	if !ok {
+		http.Error(w, "barcode must be an EAN-8, UPC-A, EAN-13 or GTIN-14 code with a valid check digit", http.StatusBadRequest)
+		return
+	}
+	barcode.Code = code
+
+	id, err := c.CatalogRepo.AddBarcode(productId, barcode)
+	if !writeCatalogError(w, err, "failed to add barcode") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) removeBarcode(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+	code, ok := NormalizeBarcode(r.PathValue("code"))
+	if !ok {
+		http.Error(w, "invalid barcode", http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.RemoveBarcode(productId, code)
+	if !writeCatalogError(w, err, "failed to remove barcode") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Expects a multipart form with the file in the "image" field.
+func (c ProductController) getProductLocations(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	locations, err := c.CatalogRepo.GetProductLocations(productId)
+	if !writeCatalogError(w, err, "failed to get product locations") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(locations); err != nil {
+		http.Error(w, "failed to send product locations", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Products are available by default, the price is cleared when it is not given.
+func (c ProductController) setProductLocation(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	locationId, err := strconv.ParseInt(r.PathValue("locationId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	var body struct {
+		Available *bool  `json:"available"`
+		Price     *int64 `json:"price"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid product location", http.StatusBadRequest)
+		return
+	}
+	if body.Price != nil && *body.Price <= 0 {
+		http.Error(w, "price must be positive", http.StatusBadRequest)
+		return
+	}
+
+	location := ProductLocation{LocationId: locationId, Available: true, Price: body.Price}
+	if body.Available != nil {
+		location.Available = *body.Available
+	}
+
+	err = c.CatalogRepo.SetProductLocation(productId, location)
+	if !writeCatalogError(w, err, "failed to set product location") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) removeProductLocation(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	locationId, err := strconv.ParseInt(r.PathValue("locationId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.RemoveProductLocation(productId, locationId)
+	if !writeCatalogError(w, err, "failed to remove product location") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) getPriceChanges(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	changes, err := c.CatalogRepo.GetPriceChanges(productId)
+	if !writeCatalogError(w, err, "failed to get price changes") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(changes); err != nil {
+		http.Error(w, "failed to send price changes", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) schedulePriceChange(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	var body struct {
+		LocationId      int64   `json:"locationId"`
+		EffectiveDate   string  `json:"effectiveDate"`
+		Price           *int64  `json:"price"`
+		Vat             *int64  `json:"vat"`
+		FulfillmentType *string `json:"fulfillmentType"`
+		LocationOnly    bool    `json:"locationOnly"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid price change", http.StatusBadRequest)
+		return
+	}
+
+	change := PriceChangeUpdate{LocationId: body.LocationId, Price: body.Price, Vat: body.Vat, LocationOnly: body.LocationOnly}
+	if change.EffectiveDate, err = time.Parse(time.DateOnly, body.EffectiveDate); err != nil {
+		http.Error(w, "effective date is required (YYYY-MM-DD)", http.StatusBadRequest)
+		return
+	}
+	if body.FulfillmentType != nil {
+		fulfillment := order.ParseFulfillmentType(*body.FulfillmentType)
+		if !fulfillment.Valid() {
+			http.Error(w, "invalid fulfillment type", http.StatusBadRequest)
+			return
+		}
+		change.FulfillmentType = &fulfillment
+	}
+	if msg := validatePriceChange(change); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	id, err := c.CatalogRepo.SchedulePriceChange(productId, change)
+	if errors.Is(err, ErrEffectiveDatePast) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !writeCatalogError(w, err, "failed to schedule price change") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) cancelPriceChange(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	priceChangeId, err := strconv.ParseInt(r.PathValue("priceChangeId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, priceChangeId, c.CatalogRepo.GetPriceChangeBusiness, ErrPriceChangeNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.CancelPriceChange(priceChangeId)
+	if !writeCatalogError(w, err, "failed to cancel price change") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Optional from and to params are dates (YYYY-MM-DD), both days are included.
+func (c ProductController) getPriceHistory(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	var from, to *time.Time
+	if paramString := r.URL.Query().Get("from"); paramString != "" {
+		date, err := time.Parse(time.DateOnly, paramString)
+		if err != nil {
+			http.Error(w, "invalid param 'from'.", http.StatusBadRequest)
+			return
+		}
+		from = &date
+	}
+	if paramString := r.URL.Query().Get("to"); paramString != "" {
+		date, err := time.Parse(time.DateOnly, paramString)
+		if err != nil {
+			http.Error(w, "invalid param 'to'.", http.StatusBadRequest)
+			return
+		}
+		date = date.Add(24 * time.Hour).Add(-1 * time.Nanosecond)
+		to = &date
+	}
+
+	history, err := c.CatalogRepo.GetPriceHistory(productId, from, to)
+	if !writeCatalogError(w, err, "failed to get price history") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		http.Error(w, "failed to send price history", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) getPricingRules(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	business, ok := c.userBusiness(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := c.CatalogRepo.GetPricingRules(business.Id)
+	if !writeCatalogError(w, err, "failed to get pricing rules") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rules); err != nil {
+		http.Error(w, "failed to send pricing rules", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) createPricingRule(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	// Rules are active unless the body says otherwise
+	rule := PricingRule{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "invalid pricing rule", http.StatusBadRequest)
+		return
+	}
+	if msg := validatePricingRule(&rule); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// Rules are created in the user's business, the id in the body is ignored
+	business, ok := c.userBusiness(w, r)
+	if !ok {
+		return
+	}
+	rule.BusinessId = business.Id
+
+	id, err := c.CatalogRepo.CreatePricingRule(rule)
+	if !writeCatalogError(w, err, "failed to create pricing rule") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) updatePricingRule(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	pricingRuleId, err := strconv.ParseInt(r.PathValue("pricingRuleId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, pricingRuleId, c.CatalogRepo.GetPricingRuleBusiness, ErrPricingRuleNotFound) {
+		return
+	}
+
+	rule := PricingRule{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "invalid pricing rule", http.StatusBadRequest)
+		return
+	}
+	if msg := validatePricingRule(&rule); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.UpdatePricingRule(pricingRuleId, rule)
+	if !writeCatalogError(w, err, "failed to update pricing rule") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) deletePricingRule(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	pricingRuleId, err := strconv.ParseInt(r.PathValue("pricingRuleId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, pricingRuleId, c.CatalogRepo.GetPricingRuleBusiness, ErrPricingRuleNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.DeletePricingRule(pricingRuleId)
+	if !writeCatalogError(w, err, "failed to delete pricing rule") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) getPriceLists(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	business, ok := c.userBusiness(w, r)
+	if !ok {
+		return
+	}
+
+	priceLists, err := c.CatalogRepo.GetPriceLists(business.Id)
+	if !writeCatalogError(w, err, "failed to get price lists") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(priceLists); err != nil {
+		http.Error(w, "failed to send price lists", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) createPriceList(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid price list", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if msg := validatePriceListName(name); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	business, ok := c.userBusiness(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := c.CatalogRepo.CreatePriceList(business.Id, name)
+	if !writeCatalogError(w, err, "failed to create price list") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) renamePriceList(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	priceListId, err := strconv.ParseInt(r.PathValue("priceListId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, priceListId, c.CatalogRepo.GetPriceListBusiness, ErrPriceListNotFound) {
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid price list", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if msg := validatePriceListName(name); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.RenamePriceList(priceListId, name)
+	if !writeCatalogError(w, err, "failed to rename price list") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) deletePriceList(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	priceListId, err := strconv.ParseInt(r.PathValue("priceListId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, priceListId, c.CatalogRepo.GetPriceListBusiness, ErrPriceListNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.DeletePriceList(priceListId)
+	if !writeCatalogError(w, err, "failed to delete price list") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) getPriceListItems(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	priceListId, err := strconv.ParseInt(r.PathValue("priceListId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, priceListId, c.CatalogRepo.GetPriceListBusiness, ErrPriceListNotFound) {
+		return
+	}
+
+	items, err := c.CatalogRepo.GetPriceListItems(priceListId)
+	if !writeCatalogError(w, err, "failed to get price list items") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(items); err != nil {
+		http.Error(w, "failed to send price list items", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) setPriceListItem(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	priceListId, err := strconv.ParseInt(r.PathValue("priceListId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, priceListId, c.CatalogRepo.GetPriceListBusiness, ErrPriceListNotFound) {
+		return
+	}
+
+	var body struct {
+		Price *int64 `json:"price"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid price list item", http.StatusBadRequest)
+		return
+	}
+	if body.Price == nil || *body.Price <= 0 {
+		http.Error(w, "price is required and must be positive", http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.SetPriceListItem(priceListId, productId, *body.Price)
+	if !writeCatalogError(w, err, "failed to set price list item") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) removePriceListItem(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	priceListId, err := strconv.ParseInt(r.PathValue("priceListId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, priceListId, c.CatalogRepo.GetPriceListBusiness, ErrPriceListNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.RemovePriceListItem(priceListId, productId)
+	if !writeCatalogError(w, err, "failed to remove price list item") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) getBundleSlots(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	slots, err := c.CatalogRepo.GetBundleSlots(productId)
+	if !writeCatalogError(w, err, "failed to get bundle slots") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(slots); err != nil {
+		http.Error(w, "failed to send bundle slots", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) createBundleSlot(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	var slot BundleSlotUpdate
+	if err := json.NewDecoder(r.Body).Decode(&slot); err != nil {
+		http.Error(w, "invalid bundle slot", http.StatusBadRequest)
+		return
+	}
+	slot.Name = strings.TrimSpace(slot.Name)
+	if msg := validateBundleSlot(slot); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	id, err := c.CatalogRepo.CreateBundleSlot(productId, slot)
+	if !writeCatalogError(w, err, "failed to create bundle slot") {
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c ProductController) updateBundleSlot(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	slotId, err := strconv.ParseInt(r.PathValue("slotId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, slotId, c.CatalogRepo.GetBundleSlotBusiness, ErrSlotNotFound) {
+		return
+	}
+
+	var slot BundleSlotUpdate
+	if err := json.NewDecoder(r.Body).Decode(&slot); err != nil {
+		http.Error(w, "invalid bundle slot", http.StatusBadRequest)
+		return
+	}
+	slot.Name = strings.TrimSpace(slot.Name)
+	if msg := validateBundleSlot(slot); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.UpdateBundleSlot(slotId, slot)
+	if !writeCatalogError(w, err, "failed to update bundle slot") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) deleteBundleSlot(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	slotId, err := strconv.ParseInt(r.PathValue("slotId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, slotId, c.CatalogRepo.GetBundleSlotBusiness, ErrSlotNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.DeleteBundleSlot(slotId)
+	if !writeCatalogError(w, err, "failed to delete bundle slot") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The upcharge is 0 when it is not given.
+func (c ProductController) setBundleOption(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	slotId, err := strconv.ParseInt(r.PathValue("slotId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, slotId, c.CatalogRepo.GetBundleSlotBusiness, ErrSlotNotFound) {
+		return
+	}
+
+	var body struct {
+		Upcharge int64 `json:"upcharge"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid bundle option", http.StatusBadRequest)
+		return
+	}
+	if body.Upcharge < 0 {
+		http.Error(w, "upcharge can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	err = c.CatalogRepo.SetBundleOption(slotId, productId, body.Upcharge)
+	if !writeCatalogError(w, err, "failed to set bundle option") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) removeBundleOption(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	slotId, err := strconv.ParseInt(r.PathValue("slotId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, slotId, c.CatalogRepo.GetBundleSlotBusiness, ErrSlotNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.RemoveBundleOption(slotId, productId)
+	if !writeCatalogError(w, err, "failed to remove bundle option") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProductController) uploadImage(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	// Leaves room for the rest of the multipart body
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageBytes+1<<20)
+	file, header, err := r.FormFile("image")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, ErrImageTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "image file is required (form field 'image')", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxImageBytes {
+		http.Error(w, ErrImageTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImageBytes+1))
+	if err != nil {
+		http.Error(w, "failed to read image", http.StatusBadRequest)
+		return
+	}
+
+	processed, err := processImage(data)
+	if errors.Is(err, ErrImageTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if errors.Is(err, ErrUnsupportedImage) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		slog.Error("failed to process image", "error", err)
+		http.Error(w, "failed to process image", http.StatusInternalServerError)
+		return
+	}
+
+	// Same content gives the same keys, so storing the files again is harmless
+	keys := []string{processed.imageKey, processed.thumbnailKey}
+	if err := c.CatalogRepo.KeepImages(keys); err != nil {
+		http.Error(w, "failed to store image", http.StatusInternalServerError)
+		return
+	}
+	if err := c.Images.Put(processed.imageKey, bytes.NewReader(processed.image)); err != nil {
+		slog.Error("failed to store image", "error", err)
+		http.Error(w, "failed to store image", http.StatusInternalServerError)
+		return
+	}
+	if err := c.Images.Put(processed.thumbnailKey, bytes.NewReader(processed.thumbnail)); err != nil {
+		slog.Error("failed to store thumbnail", "error", err)
+		http.Error(w, "failed to store image", http.StatusInternalServerError)
+		return
+	}
+
+	image := ProductImage{
+		ImageKey:     processed.imageKey,
+		ThumbnailKey: processed.thumbnailKey,
+		ImageUrl:     c.Images.URL(processed.imageKey),
+		ThumbnailUrl: c.Images.URL(processed.thumbnailKey),
+	}
+	err = c.CatalogRepo.SetProductImage(productId, &image)
+	if !writeCatalogError(w, err, "failed to save image") {
+		// Other products can have the same image, so only files no product uses are deleted
+		_ = c.CatalogRepo.DiscardImages(keys)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(image); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c ProductController) removeImage(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	productId, err := strconv.ParseInt(r.PathValue("productId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !c.checkBusiness(w, r, productId, c.CatalogRepo.GetProductBusiness, ErrProductNotFound) {
+		return
+	}
+
+	err = c.CatalogRepo.SetProductImage(productId, nil)
+	if !writeCatalogError(w, err, "failed to remove image") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Keys are derived from the content, so a file never changes and the ETag is the key itself.
+func (c ProductController) serveImage(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	key := r.PathValue("key")
+	file, err := c.Images.Open(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Error("failed to open image", "key", key, "error", err)
+		http.Error(w, "failed to get image", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Cache-Control", imageCacheControl)
+	w.Header().Set("ETag", `"`+key+`"`)
+	http.ServeContent(w, r, key, file.ModTime(), file)
+}
+
+func (c ProductController) exportCatalog(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	locationId, err := strconv.ParseInt(r.URL.Query().Get("locationId"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad or no location id", http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
+	format, ok := parseCatalogFormat(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := c.CatalogRepo.ExportCatalog(locationId)
+	if !writeCatalogError(w, err, "failed to export catalog") {
+		return
+	}
+
+	contentType := "text/csv"
+	if format == CatalogJson {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="catalog-%d.%s"`, locationId, format))
+	w.WriteHeader(http.StatusOK)
+	if err := writeCatalog(format, w, rows); err != nil {
+		slog.Error("failed to write catalog export", "error", err)
+	}
+}
+
+// Validates the whole file and reports every row. The import is only applied with dryRun=false
+// and if no row has errors, in a single transaction.
+func (c ProductController) importCatalog(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	locationId, err := strconv.ParseInt(r.URL.Query().Get("locationId"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad or no location id", http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
+	format, ok := parseCatalogFormat(w, r)
+	if !ok {
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") != "false"
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	rows, rowErrors, err := parseCatalog(format, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := ImportReport{DryRun: dryRun, Rows: make([]ImportRowResult, len(rows))}
+	names := map[string]int{}
+	skus := map[string]int{}
+	barcodes := map[string]int{}
+	valid := []CatalogRow{}
+	validIndex := []int{}
+	for i := range rows {
+		errs := append(append([]string{}, rowErrors[i]...), validateCatalogRow(&rows[i])...)
+		if first, ok := names[rows[i].Name]; ok {
+			errs = append(errs, fmt.Sprintf("same name as row %d", first))
+		} else {
+			names[rows[i].Name] = i + 1
+		}
+		if first, ok := skus[rows[i].Sku]; ok && rows[i].Sku != "" {
+			errs = append(errs, fmt.Sprintf("same SKU as row %d", first))
+		} else {
+			skus[rows[i].Sku] = i + 1
+		}
+		codes := slices.Clone(rows[i].Barcodes)
+		for _, variation := range rows[i].Variations {
+			codes = append(codes, variation.Barcodes...)
+		}
+		for _, code := range codes {
+			if first, ok := barcodes[code]; ok && first != i+1 {
+				errs = append(errs, fmt.Sprintf("barcode %s is also used in row %d", code, first))
+			} else {
+				barcodes[code] = i + 1
+			}
+		}
+
+		report.Rows[i] = ImportRowResult{Row: i + 1, Name: rows[i].Name, Errors: errs}
+		if len(errs) == 0 {
+			valid = append(valid, rows[i])
+			validIndex = append(validIndex, i)
+		}
+	}
+
+	apply := !dryRun && len(valid) == len(rows)
+	results, err := c.CatalogRepo.ImportCatalog(locationId, valid, apply)
+	if !writeCatalogError(w, err, "failed to import catalog") {
+		return
+	}
+	for i, result := range results {
+		row := &report.Rows[validIndex[i]]
+		row.Action = result.Action
+		row.Errors = append(row.Errors, result.Errors...)
+	}
+
+	for _, row := range report.Rows {
+		switch {
+		case len(row.Errors) > 0:
+			report.Failed++
+		case row.Action == ImportCreate:
+			report.Created++
+		case row.Action == ImportUpdate:
+			report.Updated++
+		}
+	}
+	report.Applied = apply && report.Failed == 0
+
+	status := http.StatusOK
+	if !dryRun && !report.Applied {
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Writes the error response and returns false if the format param is not valid.
+func parseCatalogFormat(w http.ResponseWriter, r *http.Request) (CatalogFormat, bool) {
+	format := CatalogFormat(r.URL.Query().Get("format"))
+	switch format {
+	case "":
+		return CatalogCsv, true
+	case CatalogCsv, CatalogJson:
+		return format, true
+	}
+	http.Error(w, "invalid param 'format'.", http.StatusBadRequest)
+	return "", false
+}
+
+// Trims the product name and returns the reason why the product is not valid, or an empty string.
+func validateProduct(product *ProductUpdate) string {
+	if product.Name != nil {
+		name := strings.TrimSpace(*product.Name)
+		if name == "" || len(name) > 64 {
+			return "product name is required (max 64 characters)"
+		}
+		product.Name = &name
+	}
+	if product.BasePrice != nil && *product.BasePrice <= 0 {
+		return "base price must be positive"
+	}
+	// item.vat is a DECIMAL(4, 2)
+	if product.Vat != nil && (*product.Vat < 0 || *product.Vat > 9999) {
+		return "vat must be between 0 and 9999 (hundredths of a percent)"
+	}
+	if product.Sku != nil {
+		sku := strings.TrimSpace(*product.Sku)
+		if len(sku) > 64 {
+			return "sku is too long (max 64 characters)"
+		}
+		product.Sku = &sku
+	}
+	if product.Unit != nil {
+		unit := order.ParseUnit(string(*product.Unit))
+		if !unit.Valid() {
+			return "invalid unit"
+		}
+		product.Unit = &unit
+	}
+	return ""
+}
+
+// Trims the variation name and returns the reason why the variation is not valid, or an empty string.
+func validateVariation(variation *VariationUpdate) string {
+	if variation.Name != nil {
+		name := strings.TrimSpace(*variation.Name)
+		if name == "" || len(name) > 64 {
+			return "variation name is required (max 64 characters)"
+		}
+		variation.Name = &name
+	}
+	if variation.GroupId != nil && *variation.GroupId < 0 {
+		return "group id can't be negative"
+	}
+	return ""
+}
+
+// Writes the error response and returns false if the body has no valid category name.
+func decodeCategoryName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid category", http.StatusBadRequest)
+		return "", false
+	}
+	name := strings.TrimSpace(body.Name)
+	if msg := validateCategoryName(name); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// Separators in the name are escaped in catalog exports, see escapeCatalogName.
+func validateCategoryName(name string) string {
+	if name == "" || len(name) > 64 {
+		return "category name is required (max 64 characters)"
+	}
+	return ""
+}
+
+func validatePriceChange(change PriceChangeUpdate) string {
+	switch {
+	case change.LocationId <= 0:
+		return "location id is required"
+	case change.Price != nil && *change.Price <= 0:
+		return "price must be positive"
+	case change.Vat != nil && (*change.Vat < 0 || *change.Vat > 9999):
+		return "vat must be between 0 and 9999 (hundredths of a percent)"
+	case change.Price == nil && change.Vat == nil && change.FulfillmentType == nil:
+		return "price or vat is required"
+	case change.LocationOnly && (change.Price == nil || change.Vat != nil || change.FulfillmentType != nil):
+		return "location only changes set the price, vat is the same at every location"
+	}
+	return ""
+}
+
+func validateBundleSlot(slot BundleSlotUpdate) string {
+	if slot.Name == "" || len(slot.Name) > 64 {
+		return "slot name is required (max 64 characters)"
+	}
+	return ""
+}
+
+func validatePriceListName(name string) string {
+	if name == "" || len(name) > 64 {
+		return "price list name is required (max 64 characters)"
+	}
+	return ""
+}
+
+// Writes an error and returns false if the business of the user can't be found.
+func (c ProductController) userBusiness(w http.ResponseWriter, r *http.Request) (auth.BusinessInfo, bool) {
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return auth.BusinessInfo{}, false
+	}
+
+	business, err := c.CatalogRepo.GetBusinessInfo(user.Username)
+	if err != nil {
+		http.Error(w, "failed to get business info", http.StatusInternalServerError)
+		return auth.BusinessInfo{}, false
+	}
+	return business, true
+}
+
+// Writes an error and returns false if the location is not a location of the user's business.
+func (c ProductController) checkLocation(w http.ResponseWriter, r *http.Request, locationId int64) bool {
+	business, ok := c.userBusiness(w, r)
+	if !ok {
+		return false
+	}
+
+	for _, location := range business.Locations {
+		if location.Id == locationId {
+			return true
+		}
+	}
+	http.Error(w, ErrLocationNotFound.Error(), http.StatusNotFound)
+	return false
+}
+
+// Writes an error and returns false if the entry doesn't exist or belongs to another business.
+// getBusiness is the lookup of the entry in the repo, notFound is written for entries of other businesses.
+func (c ProductController) checkBusiness(w http.ResponseWriter, r *http.Request, id int64, getBusiness func(int64) (int64, error), notFound error) bool {
+	businessId, err := getBusiness(id)
+	if !writeCatalogError(w, err, "failed to get business") {
+		return false
+	}
+
+	business, ok := c.userBusiness(w, r)
+	if !ok {
+		return false
+	}
+	if businessId != business.Id {
+		http.Error(w, notFound.Error(), http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// Writes the error response and returns false if there was an error.
+func writeCatalogError(w http.ResponseWriter, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrLocationNotFound), errors.Is(err, ErrProductNotFound), errors.Is(err, ErrVariationNotFound),
+		errors.Is(err, ErrCategoryNotFound), errors.Is(err, ErrBarcodeNotFound), errors.Is(err, ErrImageNotFound),
+		errors.Is(err, ErrBusinessNotFound), errors.Is(err, ErrNotSoldAtLocation), errors.Is(err, ErrPriceListNotFound),
+		errors.Is(err, ErrPriceChangeNotFound), errors.Is(err, ErrPricingRuleNotFound), errors.Is(err, ErrSlotNotFound),
+		errors.Is(err, ErrGroupNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrProductExists), errors.Is(err, ErrSkuExists), errors.Is(err, ErrCategoryExists),
+		errors.Is(err, ErrCategoryInUse), errors.Is(err, ErrBarcodeExists), errors.Is(err, ErrPriceListExists),
+		errors.Is(err, ErrPriceListInUse), errors.Is(err, ErrPriceChangeApplied), errors.Is(err, ErrPricingRuleExists),
+		errors.Is(err, ErrSlotExists), errors.Is(err, ErrNestedBundle), errors.Is(err, ErrGroupExists),
+		errors.Is(err, ErrTooManyDefaults):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+	return false
+}
+
+func writeCreated(w http.ResponseWriter, id int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]any{"id": id}); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,35 @@
+package product
+
+import "errors"
+
+var (
+	ErrLocationNotFound    = errors.New("location not found")
+	ErrBusinessNotFound    = errors.New("business not found")
+	ErrProductNotFound     = errors.New("product not found")
+	ErrProductExists       = errors.New("product with this name already exists")
+	ErrVariationNotFound   = errors.New("variation not found")
+	ErrCategoryNotFound    = errors.New("category not found")
+	ErrCategoryExists      = errors.New("category with this name already exists")
+	ErrCategoryInUse       = errors.New("category is used by a kitchen station")
+	ErrSkuExists           = errors.New("product with this SKU already exists")
+	ErrBarcodeExists       = errors.New("barcode is already used by another product")
+	ErrBarcodeNotFound     = errors.New("barcode not found")
+	ErrImageNotFound       = errors.New("product has no image")
+	ErrImageTooLarge       = errors.New("image is too large (max 5 MB and 4096x4096 pixels)")
+	ErrUnsupportedImage    = errors.New("image must be a JPEG, PNG or GIF")
+	ErrNotSoldAtLocation   = errors.New("product is not sold at this location")
+	ErrPriceListNotFound   = errors.New("price list not found")
+	ErrPriceListExists     = errors.New("price list with this name already exists")
+	ErrPriceListInUse      = errors.New("price list is used by open orders")
+	ErrEffectiveDatePast   = errors.New("effective date must be in the future at the location")
+	ErrPriceChangeNotFound = errors.New("price change not found")
+	ErrPriceChangeApplied  = errors.New("price change was already applied")
+	ErrPricingRuleNotFound = errors.New("pricing rule not found")
+	ErrPricingRuleExists   = errors.New("pricing rule with this name already exists")
+	ErrSlotNotFound        = errors.New("bundle slot not found")
+	ErrSlotExists          = errors.New("bundle slot with this name already exists")
+	ErrNestedBundle        = errors.New("bundles can't contain other bundles")
+	ErrGroupNotFound       = errors.New("variation group not found")
+	ErrGroupExists         = errors.New("variation group with this name already exists")
+	ErrTooManyDefaults     = errors.New("variation group has more default variations than it allows")
+)
@@ -0,0 +1,170 @@
+package product
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"time"
+
+	_ "image/gif"
+
+	"dreampos/internal/storage"
+)
+
+const (
+	maxImageBytes = 5 << 20
+	// Larger images are rejected before they are decoded, so a small file can't take up a lot of memory.
+	maxImageSide = 4096
+	// Thumbnails fit in a square of this size, smaller images are not scaled up.
+	thumbnailSide = 320
+	// Images never change under their key, so they can be cached for good.
+	imageCacheControl = "public, max-age=31536000, immutable"
+)
+
+// Files of a product image in the image storage.
+type ProductImage struct {
+	ImageKey     string `json:"-"`
+	ThumbnailKey string `json:"-"`
+	ImageUrl     string `json:"imageUrl"`
+	ThumbnailUrl string `json:"thumbnailUrl"`
+}
+
+// Deletes the files no product uses anymore. Files are not deleted right when they become
+// unused, an upload of the same content to another product could be referencing them.
+type ImageSweeper struct {
+	CatalogRepo CatalogRepo
+	Images      storage.Store
+	Interval    time.Duration
+}
+
+// Run never returns, it should be started in its own goroutine.
+func (s ImageSweeper) Run() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := s.CatalogRepo.DeleteUnusedImages(s.Images.Delete)
+		if err != nil {
+			continue
+		}
+		if deleted > 0 {
+			slog.Info("deleted unused images", "count", deleted)
+		}
+	}
+}
+
+// Uploaded image with its thumbnail, ready to be stored.
+type processedImage struct {
+	imageKey     string
+	image        []byte
+	thumbnailKey string
+	thumbnail    []byte
+}
+
+// File extension by the detected content type. Only the first frame of a GIF is used for the thumbnail.
+var imageExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// Validates the uploaded file and generates its thumbnail. The type is detected from
+// the content, the name and Content-Type of the upload are not trusted.
+func processImage(data []byte) (processedImage, error) {
+	if len(data) > maxImageBytes {
+		return processedImage{}, ErrImageTooLarge
+	}
+	extension, ok := imageExtensions[http.DetectContentType(data)]
+	if !ok {
+		return processedImage{}, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return processedImage{}, ErrUnsupportedImage
+	}
+	if config.Width > maxImageSide || config.Height > maxImageSide {
+		return processedImage{}, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return processedImage{}, ErrUnsupportedImage
+	}
+
+	// JPEGs stay JPEGs, other images are saved as PNG to keep the transparency
+	var thumbnail bytes.Buffer
+	thumbnailExtension := "png"
+	if extension == "jpg" {
+		thumbnailExtension = "jpg"
+		err = jpeg.Encode(&thumbnail, resizeToFit(src, thumbnailSide), &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&thumbnail, resizeToFit(src, thumbnailSide))
+	}
+	if err != nil {
+		return processedImage{}, err
+	}
+
+	hash := sha256.Sum256(data)
+	name := hex.EncodeToString(hash[:])
+	return processedImage{
+		imageKey:     name + "." + extension,
+		image:        data,
+		thumbnailKey: name + "-thumb." + thumbnailExtension,
+		thumbnail:    thumbnail.Bytes(),
+	}, nil
+}
+
+// Scales the image down to fit in a side × side square, keeping the aspect ratio.
+// Every pixel of the result is the average of the pixels it covers.
+func resizeToFit(src image.Image, side int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Premultiplied RGBA, so transparent pixels don't bleed their color into the average
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	if width <= side && height <= side {
+		return rgba
+	}
+
+	dstWidth, dstHeight := side, side
+	if width > height {
+		dstHeight = max(1, height*side/width)
+	} else {
+		dstWidth = max(1, width*side/height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := range dstHeight {
+		y0, y1 := y*height/dstHeight, (y+1)*height/dstHeight
+		for x := range dstWidth {
+			x0, x1 := x*width/dstWidth, (x+1)*width/dstWidth
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride+x0*4 : sy*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			count := (x1 - x0) * (y1 - y0)
+			i := y*dst.Stride + x*4
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,52 @@
+package product
+
+import "dreampos/internal/order"
+
+type Category struct {
+	Id   int64  `json:"id"   db:"id"`
+	Name string `json:"name" db:"name"`
+}
+
+// Payload for creating and updating a product; nil fields are ignored on update.
+// Vat is in hundredths of a percent, like in order.Product.
+// New products belong to the business of the location and are sold there.
+type ProductUpdate struct {
+	LocationId int64       `json:"locationId"`
+	Name       *string     `json:"name"`
+	BasePrice  *int64      `json:"basePrice"`
+	Vat        *int64      `json:"vat"`
+	Unit       *order.Unit `json:"unit"`
+	// Empty string removes the SKU.
+	Sku *string `json:"sku"`
+}
+
+// Location a product is sold at. Unavailable products are hidden from the location.
+type ProductLocation struct {
+	LocationId int64 `json:"locationId" db:"location_id"`
+	Available  bool  `json:"available"  db:"available"`
+	// Price at the location, nil if the base price of the product is used.
+	Price *int64 `json:"price" db:"price"`
+}
+
+// Named set of prices of a business, e.g. staff or wholesale.
+type PriceList struct {
+	Id   int64  `json:"id"   db:"id"`
+	Name string `json:"name" db:"name"`
+}
+
+// Price of a product on a price list, products without one keep their usual price.
+type PriceListItem struct {
+	ProductId int64  `json:"productId" db:"item_id"`
+	Name      string `json:"name"      db:"name"`
+	Price     int64  `json:"price"     db:"price"`
+}
+
+// Payload for creating and updating a variation; nil fields are ignored on update.
+type VariationUpdate struct {
+	Name          *string `json:"name"`
+	PriceModifier *uint64 `json:"priceModifier"`
+	// Group of the same product, 0 removes the variation from its group.
+	GroupId *int64 `json:"groupId"`
+	// Selected on new lines that have nothing selected in the group.
+	Default *bool `json:"default"`
+}
@@ -0,0 +1,72 @@
+package product
+
+import (
+	"dreampos/internal/order"
+	"log/slog"
+	"time"
+)
+
+// Scheduled price or VAT change of a product, see PriceChangeUpdate.
+type PriceChange struct {
+	Id              int64                  `json:"id"              db:"id"`
+	LocationId      int64                  `json:"locationId"      db:"location_id"`
+	EffectiveDate   string                 `json:"effectiveDate"   db:"effective_date"`
+	EffectiveAt     time.Time              `json:"effectiveAt"     db:"effective_at"`
+	Price           *int64                 `json:"price"           db:"price"`
+	Vat             *int64                 `json:"vat"             db:"vat"`
+	FulfillmentType *order.FulfillmentType `json:"fulfillmentType" db:"fulfillment_type"`
+	LocationOnly    bool                   `json:"locationOnly"    db:"location_only"`
+	// Nil until the change takes effect.
+	AppliedAt *time.Time `json:"appliedAt" db:"applied_at"`
+}
+
+// Change that takes effect at midnight of the effective date in the time zone of the location.
+// Nil price and VAT keep the current values. With a fulfillment type the VAT is set for that
+// type only and nil VAT removes the override, like in order.ProductRepo.SetFulfillmentVat.
+// Changes are business-wide, a location only change sets the price at the location
+// (the product has to be sold there) and can't change the VAT.
+type PriceChangeUpdate struct {
+	LocationId      int64
+	EffectiveDate   time.Time
+	Price           *int64
+	Vat             *int64
+	FulfillmentType *order.FulfillmentType
+	LocationOnly    bool
+}
+
+// Price and VAT of a product from ChangedAt on. Entries with a location are the price at
+// that location and entries with a price list the price in that list, nil price means it was removed.
+// Entries with a fulfillment type are the VAT of that type only, nil VAT means the override was removed.
+type PriceHistoryEntry struct {
+	LocationId      *int64                 `json:"locationId"      db:"location_id"`
+	PriceListId     *int64                 `json:"priceListId"     db:"price_list_id"`
+	FulfillmentType *order.FulfillmentType `json:"fulfillmentType" db:"fulfillment_type"`
+	Price           *int64                 `json:"price"           db:"price"`
+	Vat             *int64                 `json:"vat"             db:"vat"`
+	ChangedAt       time.Time              `json:"changedAt"       db:"changed_at"`
+	// Set if the entry was made by a scheduled change.
+	PriceChangeId *int64 `json:"priceChangeId" db:"price_change_id"`
+}
+
+// Applies scheduled price changes once they are due. Order lines that are added in between
+// still get the new prices, the repo applies due changes before it prices them.
+type PriceScheduler struct {
+	CatalogRepo CatalogRepo
+	Interval    time.Duration
+}
+
+// Run never returns, it should be started in its own goroutine.
+func (s PriceScheduler) Run() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		applied, err := s.CatalogRepo.ApplyPriceChanges()
+		if err != nil {
+			continue
+		}
+		if applied > 0 {
+			slog.Info("applied scheduled price changes", "count", applied)
+		}
+	}
+}
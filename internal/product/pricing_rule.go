@@ -0,0 +1,83 @@
+package product
+
+import (
+	"dreampos/internal/order"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Time-based price adjustment, e.g. a happy hour or a lunch menu. A rule applies to the products
+// of its business, narrowed down by the location, product and category when they are set.
+// The window is in the local time of the location, from StartTime on each of the weekdays
+// (every day if there are none) until EndTime. An EndTime before the StartTime runs past midnight
+// and equal times cover the whole day. Orders without a location match no rule, the window
+// needs the time zone of the location.
+type PricingRule struct {
+	Id         int64  `json:"id"         db:"id"`
+	BusinessId int64  `json:"businessId" db:"business_id"`
+	Name       string `json:"name"       db:"name"`
+	LocationId *int64 `json:"locationId" db:"location_id"`
+	ProductId  *int64 `json:"productId"  db:"item_id"`
+	CategoryId *int64 `json:"categoryId" db:"category_id"`
+	// Lowercase names, e.g. "monday".
+	Weekdays []string `json:"weekdays" db:"-"`
+	// HH:MM
+	StartTime      string               `json:"startTime"      db:"start_time"`
+	EndTime        string               `json:"endTime"        db:"end_time"`
+	AdjustmentType order.AdjustmentType `json:"adjustmentType" db:"adjustment_type"`
+	// See order.AdjustmentType for the units, negative adjustments lower the price.
+	Adjustment int64 `json:"adjustment" db:"adjustment"`
+	// The matching rule with the highest priority is applied, the newest one on a tie.
+	Priority int64 `json:"priority" db:"priority"`
+	Active   bool  `json:"active"   db:"active"`
+}
+
+// Returns an error message, or an empty string if the rule is valid.
+// Names, weekdays and times are normalized in place.
+func validatePricingRule(rule *PricingRule) string {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" || len(rule.Name) > 64 {
+		return "pricing rule name is required (max 64 characters)"
+	}
+	if (rule.LocationId != nil && *rule.LocationId <= 0) ||
+		(rule.ProductId != nil && *rule.ProductId <= 0) ||
+		(rule.CategoryId != nil && *rule.CategoryId <= 0) {
+		return "location, product and category ids must be positive"
+	}
+
+	if rule.Weekdays == nil {
+		rule.Weekdays = []string{}
+	}
+	for i, day := range rule.Weekdays {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if !slices.Contains(weekdayNames, day) {
+			return "weekdays must be day names, e.g. monday"
+		}
+		rule.Weekdays[i] = day
+	}
+	slices.SortFunc(rule.Weekdays, func(a, b string) int {
+		return slices.Index(weekdayNames, a) - slices.Index(weekdayNames, b)
+	})
+	rule.Weekdays = slices.Compact(rule.Weekdays)
+
+	for _, clock := range []*string{&rule.StartTime, &rule.EndTime} {
+		parsed, err := time.Parse("15:04", strings.TrimSpace(*clock))
+		if err != nil {
+			return "start and end times are required (HH:MM)"
+		}
+		*clock = parsed.Format("15:04")
+	}
+
+	rule.AdjustmentType = order.ParseAdjustmentType(strings.TrimSpace(string(rule.AdjustmentType)))
+	if !rule.AdjustmentType.Valid() {
+		return "adjustment type must be percent or fixed"
+	}
+	if rule.AdjustmentType == order.AdjustmentPercent && rule.Adjustment < -10000 {
+		return "percent adjustment can't be below -10000 (-100%)"
+	}
+	return ""
+}
+
+// In the order of the weekday enum in the DB.
+var weekdayNames = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
@@ -0,0 +1,127 @@
+package product
+
+import (
+	"dreampos/internal/order"
+	"slices"
+	"testing"
+)
+
+func validRule() PricingRule {
+	return PricingRule{
+		Name:           "Happy hour",
+		StartTime:      "16:00",
+		EndTime:        "18:00",
+		AdjustmentType: order.AdjustmentPercent,
+		Adjustment:     -2000,
+	}
+}
+
+func TestValidatePricingRuleWeekdays(t *testing.T) {
+	tests := []struct {
+		weekdays []string
+		want     []string
+		wantErr  bool
+	}{
+		{weekdays: nil, want: []string{}},
+		{weekdays: []string{"friday", "monday"}, want: []string{"monday", "friday"}},
+		{weekdays: []string{" Sunday ", "SATURDAY"}, want: []string{"saturday", "sunday"}},
+		{weekdays: []string{"tuesday", "Tuesday", "tuesday"}, want: []string{"tuesday"}},
+		{weekdays: []string{"funday"}, wantErr: true},
+		{weekdays: []string{"mon"}, wantErr: true},
+		{weekdays: []string{""}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		rule := validRule()
+		rule.Weekdays = slices.Clone(test.weekdays)
+		msg := validatePricingRule(&rule)
+		if test.wantErr {
+			if msg == "" {
+				t.Errorf("validatePricingRule with weekdays %q succeeded, want error", test.weekdays)
+			}
+			continue
+		}
+		if msg != "" {
+			t.Errorf("validatePricingRule with weekdays %q returned %q", test.weekdays, msg)
+			continue
+		}
+		if !slices.Equal(rule.Weekdays, test.want) {
+			t.Errorf("validatePricingRule with weekdays %q set %q, want %q", test.weekdays, rule.Weekdays, test.want)
+		}
+	}
+}
+
+// End times before the start time run past midnight and equal times cover the whole day, both are valid.
+func TestValidatePricingRuleWindow(t *testing.T) {
+	tests := []struct {
+		start     string
+		end       string
+		wantStart string
+		wantEnd   string
+		wantErr   bool
+	}{
+		{start: "16:00", end: "18:00", wantStart: "16:00", wantEnd: "18:00"},
+		{start: "22:00", end: "02:00", wantStart: "22:00", wantEnd: "02:00"},
+		{start: "23:30", end: "00:00", wantStart: "23:30", wantEnd: "00:00"},
+		{start: "00:00", end: "00:00", wantStart: "00:00", wantEnd: "00:00"},
+		{start: " 9:05", end: "7:00 ", wantStart: "09:05", wantEnd: "07:00"},
+		{start: "24:00", end: "02:00", wantErr: true},
+		{start: "22:00", end: "", wantErr: true},
+		{start: "10pm", end: "02:00", wantErr: true},
+	}
+
+	for _, test := range tests {
+		rule := validRule()
+		rule.StartTime, rule.EndTime = test.start, test.end
+		msg := validatePricingRule(&rule)
+		if test.wantErr {
+			if msg == "" {
+				t.Errorf("validatePricingRule from %q to %q succeeded, want error", test.start, test.end)
+			}
+			continue
+		}
+		if msg != "" {
+			t.Errorf("validatePricingRule from %q to %q returned %q", test.start, test.end, msg)
+			continue
+		}
+		if rule.StartTime != test.wantStart || rule.EndTime != test.wantEnd {
+			t.Errorf("validatePricingRule from %q to %q set %q to %q, want %q to %q",
+				test.start, test.end, rule.StartTime, rule.EndTime, test.wantStart, test.wantEnd)
+		}
+	}
+}
+
+func TestValidatePricingRuleAdjustment(t *testing.T) {
+	tests := []struct {
+		adjustmentType order.AdjustmentType
+		adjustment     int64
+		want           order.AdjustmentType
+		wantErr        bool
+	}{
+		{adjustmentType: "percent", adjustment: -10000, want: order.AdjustmentPercent},
+		{adjustmentType: "PERCENT", adjustment: 500, want: order.AdjustmentPercent},
+		{adjustmentType: "fixed", adjustment: -50000, want: order.AdjustmentFixed},
+		{adjustmentType: "percent", adjustment: -10001, wantErr: true},
+		{adjustmentType: "discount", adjustment: -100, wantErr: true},
+		{adjustmentType: "", adjustment: -100, wantErr: true},
+	}
+
+	for _, test := range tests {
+		rule := validRule()
+		rule.AdjustmentType, rule.Adjustment = test.adjustmentType, test.adjustment
+		msg := validatePricingRule(&rule)
+		if test.wantErr {
+			if msg == "" {
+				t.Errorf("validatePricingRule with %s %d succeeded, want error", test.adjustmentType, test.adjustment)
+			}
+			continue
+		}
+		if msg != "" {
+			t.Errorf("validatePricingRule with %s %d returned %q", test.adjustmentType, test.adjustment, msg)
+			continue
+		}
+		if rule.AdjustmentType != test.want {
+			t.Errorf("validatePricingRule with %s set type %s, want %s", test.adjustmentType, rule.AdjustmentType, test.want)
+		}
+	}
+}
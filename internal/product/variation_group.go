@@ -0,0 +1,31 @@
+package product
+
+import "strings"
+
+// Variation group as it is created or updated, see order.VariationGroup.
+// Groups with a minimum are required, there is no separate flag.
+type VariationGroupUpdate struct {
+	Name        string `json:"name"`
+	MinSelected int64  `json:"minSelected"`
+	// Nil if there is no limit.
+	MaxSelected *int64 `json:"maxSelected"`
+	Position    int64  `json:"position"`
+}
+
+// Returns an error message, or an empty string if the group is valid.
+// The name is normalized in place.
+func validateVariationGroup(group *VariationGroupUpdate) string {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" || len(group.Name) > 64 {
+		return "variation group name is required (max 64 characters)"
+	}
+
+	if group.MinSelected < 0 {
+		return "min selected can't be negative"
+	}
+
+	if group.MaxSelected != nil && *group.MaxSelected < max(group.MinSelected, 1) {
+		return "max selected must be at least 1 and not below min selected"
+	}
+	return ""
+}
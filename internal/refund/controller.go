@@ -2,12 +2,16 @@ package refund
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
+
+	"dreampos/internal/auth"
+	"dreampos/internal/order"
 )
 
 type RefundController struct {
@@ -39,6 +43,12 @@ func (c *RefundController) getPendingRefunds(w http.ResponseWriter, _ *http.Requ
 }
 
 func (c *RefundController) processRefundAction(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	refundId, err := strconv.ParseUint(chi.URLParam(r, "refundId"), 10, 32)
 	if err != nil {
 		http.Error(w, "invalid refund ID", http.StatusBadRequest)
@@ -64,8 +74,11 @@ func (c *RefundController) processRefundAction(w http.ResponseWriter, r *http.Re
 
 	switch req.Action {
 	case "approve":
-		_, err = c.RefundRepo.UpdateRefundStatus(refundRecord.ID, StatusProcessing, "")
-		if err != nil {
+		_, err = c.RefundRepo.UpdateRefundStatus(refundRecord.ID, StatusProcessing, "", user.Username)
+		if errors.Is(err, order.ErrInvalidTransition) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
 			http.Error(w, "failed to update refund status to processing", http.StatusInternalServerError)
 			return
 		}
@@ -84,13 +97,13 @@ func (c *RefundController) processRefundAction(w http.ResponseWriter, r *http.Re
 
 			stripeRefundID, err = c.RefundService.ProcessRefund(refundRecord.StripePaymentIntentID, refundRecord.AmountCents)
 			if err != nil {
-				_, _ = c.RefundRepo.UpdateRefundStatus(refundRecord.ID, StatusFailed, "")
+				_, _ = c.RefundRepo.UpdateRefundStatus(refundRecord.ID, StatusFailed, "", user.Username)
 				http.Error(w, "Stripe refund failed: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
 		}
 
-		_, err = c.RefundRepo.UpdateRefundStatus(refundRecord.ID, StatusCompleted, stripeRefundID)
+		_, err = c.RefundRepo.UpdateRefundStatus(refundRecord.ID, StatusCompleted, stripeRefundID, user.Username)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
@@ -111,8 +124,11 @@ func (c *RefundController) processRefundAction(w http.ResponseWriter, r *http.Re
 		_ = json.NewEncoder(w).Encode(map[string]string{"message": msg})
 
 	case "disapprove":
-		_, err = c.RefundRepo.UpdateRefundStatus(refundRecord.ID, StatusDisapproved, "")
-		if err != nil {
+		_, err = c.RefundRepo.UpdateRefundStatus(refundRecord.ID, StatusDisapproved, "", user.Username)
+		if errors.Is(err, order.ErrInvalidTransition) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
 			http.Error(w, "failed to update refund status", http.StatusInternalServerError)
 			return
 		}
@@ -9,7 +9,7 @@ import (
 // RefundRepo defines the interface for refund data operations.
 type RefundRepo interface {
 	GetPendingRefunds() ([]Refund, error)
-	UpdateRefundStatus(id uint32, status RefundStatus, stripeRefundID string) (*Refund, error)
+	UpdateRefundStatus(id uint32, status RefundStatus, stripeRefundID string, actor string) (*Refund, error)
 	GetRefundByID(id uint32) (*Refund, error)
 }
 
@@ -91,7 +91,7 @@ func (r *MockRefundRepo) GetRefundByID(id uint32) (*Refund, error) {
 }
 
 // UpdateRefundStatus updates the status and optionally the StripeRefundID of a refund.
-func (r *MockRefundRepo) UpdateRefundStatus(id uint32, status RefundStatus, stripeRefundID string) (*Refund, error) {
+func (r *MockRefundRepo) UpdateRefundStatus(id uint32, status RefundStatus, stripeRefundID string, actor string) (*Refund, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -2,6 +2,7 @@ package reservation
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -9,6 +10,8 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
+
+	"dreampos/internal/etag"
 )
 
 type ReservationController struct {
@@ -182,13 +185,17 @@ func (c *ReservationController) getReservation(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	items, err := c.ReservationRepo.GetReservationItems(int32(id))
-	if err != nil {
+	items, version, err := c.ReservationRepo.GetReservationItemsWithVersion(int32(id))
+	if errors.Is(err, ErrReservationNotFound) {
+		http.Error(w, "reservation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "failed to get reservation items", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag.Format(version))
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(items); err != nil {
 		http.Error(w, "failed to encode reservation items", http.StatusInternalServerError)
@@ -216,6 +223,12 @@ func (c *ReservationController) updateReservation(w http.ResponseWriter, r *http
 		return
 	}
 
+	version, ok := etag.ParseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		writeJSONError("If-Match header is required", http.StatusPreconditionRequired)
+		return
+	}
+
 	var update ReservationUpdate
 	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
 		writeJSONError("invalid reservation", http.StatusBadRequest)
@@ -234,12 +247,28 @@ func (c *ReservationController) updateReservation(w http.ResponseWriter, r *http
 		}
 	}
 
-	err = c.ReservationRepo.UpdateReservation(int32(id), update)
-	if err != nil {
+	newVersion, err := c.ReservationRepo.UpdateReservation(int32(id), version, update)
+	if errors.Is(err, ErrReservationNotFound) {
+		writeJSONError("reservation not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrVersionMismatch) {
+		// Send back the current state so the client can merge and retry.
+		current, err := c.ReservationRepo.GetReservation(int32(id))
+		if err != nil {
+			writeJSONError("reservation was modified by someone else", http.StatusPreconditionFailed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag.Format(current.Version))
+		w.WriteHeader(http.StatusPreconditionFailed)
+		_ = json.NewEncoder(w).Encode(current)
+		return
+	} else if err != nil {
 		writeJSONError("failed to update reservation", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", etag.Format(newVersion))
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -0,0 +1,8 @@
+package reservation
+
+import "errors"
+
+var (
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrVersionMismatch     = errors.New("reservation was modified by someone else")
+)
@@ -21,6 +21,7 @@ type Reservation struct {
 	ServiceId     string    `json:"serviceId"`
 	Datetime      time.Time `json:"datetime"`
 	Status        string    `json:"status"`
+	Version       int64     `json:"version"`
 }
 
 // Payload for updating a reservation; nil fields are ignored.
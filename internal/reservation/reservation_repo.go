@@ -5,9 +5,14 @@ import "time"
 type ReservationRepo interface {
 	GetReservations(filter ReservationFilter) ([]Reservation, error)
 	GetReservationCounts(filter ReservationFilter) (ReservationCounts, error)
+	GetReservation(id int32) (Reservation, error)
 	GetReservationItems(reservationId int32) ([]Service, error)
+	// Returns the items together with the version of the reservation they were read at.
+	GetReservationItemsWithVersion(reservationId int32) ([]Service, int64, error)
 	CreateReservation(res Reservation) (int32, error)
-	UpdateReservation(id int32, res ReservationUpdate) error
+	// Returns the new version of the reservation. If version is not nil,
+	// ErrVersionMismatch is returned when the reservation is at a different version.
+	UpdateReservation(id int32, version *int64, res ReservationUpdate) (int64, error)
 	CreateReservationRefundRequest(reservationId int32, refundData RefundData) error
 	CancelReservationRefundRequest(reservationId int32) error
 }
@@ -0,0 +1,82 @@
+// Package sse writes Server-Sent Events streams.
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Comments are sent this often so proxies don't close idle streams.
+const heartbeatInterval = 25 * time.Second
+
+type Event struct {
+	// Optional, sent back by the browser in the Last-Event-ID header when it reconnects.
+	Id   string
+	Type string
+	// Encoded as JSON.
+	Data any
+}
+
+// Serve writes the events to the client until the channel is closed or the client disconnects.
+func Serve(w http.ResponseWriter, r *http.Request, events <-chan Event) {
+	controller := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		slog.Error("response does not support streaming", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := write(w, event); err != nil {
+				return
+			}
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func write(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		slog.Error("failed to encode event", "type", event.Type, "error", err)
+		return nil
+	}
+
+	var b strings.Builder
+	if event.Id != "" {
+		fmt.Fprintf(&b, "id: %s\n", event.Id)
+	}
+	if event.Type != "" {
+		fmt.Fprintf(&b, "event: %s\n", event.Type)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", data)
+
+	_, err = fmt.Fprint(w, b.String())
+	return err
+}
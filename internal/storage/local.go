@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Keeps the files in a directory of the server.
+type LocalStore struct {
+	Dir string
+	// Prefix of the URLs, the route serving the files has to be mounted there.
+	BaseUrl string
+}
+
+func MustCreateLocalStore(dir, baseUrl string) LocalStore {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		panic(err)
+	}
+	return LocalStore{Dir: dir, BaseUrl: baseUrl}
+}
+
+// Written to a temporary file first, so readers never see a partly written file.
+func (s LocalStore) Put(key string, r io.Reader) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.Dir, key))
+}
+
+func (s LocalStore) Open(key string) (File, error) {
+	if !ValidKey(key) {
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(filepath.Join(s.Dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return localFile{File: f, modTime: info.ModTime()}, nil
+}
+
+func (s LocalStore) Delete(key string) error {
+	if !ValidKey(key) {
+		return nil
+	}
+
+	err := os.Remove(filepath.Join(s.Dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s LocalStore) URL(key string) string {
+	return s.BaseUrl + key
+}
+
+type localFile struct {
+	*os.File
+	modTime time.Time
+}
+
+func (f localFile) ModTime() time.Time {
+	return f.modTime
+}
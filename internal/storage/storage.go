@@ -0,0 +1,44 @@
+// Package storage keeps uploaded files, e.g. product images, outside of the DB.
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("file not found")
+	ErrInvalidKey = errors.New("invalid file key")
+)
+
+// File opened for reading. Seeking is needed to serve range requests.
+type File interface {
+	io.ReadSeekCloser
+	ModTime() time.Time
+}
+
+// Files are addressed by keys, e.g. "3f2a...c9.jpg". Keys are flat, they can't contain "/".
+type Store interface {
+	// Stores the file, an existing file with the same key is replaced.
+	Put(key string, r io.Reader) error
+	Open(key string) (File, error)
+	// Deleting a missing file is not an error.
+	Delete(key string) error
+	// URL the file can be downloaded from.
+	URL(key string) string
+}
+
+// Reports whether the key can be used with a Store.
+func ValidKey(key string) bool {
+	if key == "" || key == "." || key == ".." || len(key) > 255 {
+		return false
+	}
+	for _, c := range key {
+		isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isAlnum && c != '.' && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,437 @@
+package table
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"dreampos/internal/auth"
+)
+
+type TableController struct {
+	TableRepo TableRepo
+}
+
+func (c TableController) Routes() http.Handler {
+	router := chi.NewRouter()
+
+	// Floor plans are set up by catalog managers, the staff only seats orders
+	manage := router.With(auth.RequirePermission(auth.PermissionManageCatalog))
+
+	router.Get("/", c.floorPlans)
+	manage.Post("/floor-plan", c.createFloorPlan)
+	manage.Post("/floor-plan/{floorPlanId:^[0-9]{1,10}$}/table", c.createTable)
+	manage.Patch("/{tableId:^[0-9]{1,10}$}", c.updateTable)
+	manage.Delete("/{tableId:^[0-9]{1,10}$}", c.deleteTable)
+	router.Post("/{tableId:^[0-9]{1,10}$}/order", c.assignOrder)
+	router.Post("/{tableId:^[0-9]{1,10}$}/bill-request", c.requestBill)
+	router.Post("/{tableId:^[0-9]{1,10}$}/transfer", c.transferTable)
+	router.Post("/{tableId:^[0-9]{1,10}$}/merge", c.mergeTable)
+
+	return router
+}
+
+func (c TableController) floorPlans(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	locationId, err := strconv.ParseInt(r.URL.Query().Get("locationId"), 10, 64)
+	if err != nil {
+		http.Error(w, "failed to get location id", http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, locationId) {
+		return
+	}
+
+	floorPlans, err := c.TableRepo.GetFloorPlans(locationId)
+	if err != nil {
+		http.Error(w, "failed to get floor plans", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(floorPlans); err != nil {
+		http.Error(w, "failed to get floor plans", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c TableController) createFloorPlan(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	var body struct {
+		LocationId int64  `json:"locationId"`
+		Name       string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid floor plan", http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.LocationId <= 0 || body.Name == "" || len(body.Name) > 64 {
+		http.Error(w, "location id and name (max 64 characters) are required", http.StatusBadRequest)
+		return
+	}
+	if !c.checkLocation(w, r, body.LocationId) {
+		return
+	}
+
+	id, err := c.TableRepo.CreateFloorPlan(body.LocationId, body.Name)
+	if err != nil {
+		http.Error(w, "failed to create floor plan", http.StatusInternalServerError)
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c TableController) createTable(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	floorPlanId, err := strconv.ParseInt(r.PathValue("floorPlanId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkFloorPlan(w, r, floorPlanId) {
+		return
+	}
+
+	var table TableUpdate
+	if err := json.NewDecoder(r.Body).Decode(&table); err != nil {
+		http.Error(w, "invalid table", http.StatusBadRequest)
+		return
+	}
+	if table.Name == nil || table.Seats == nil {
+		http.Error(w, "name and seats are required", http.StatusBadRequest)
+		return
+	}
+	if msg := validateTable(&table); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	id, err := c.TableRepo.CreateTable(floorPlanId, table)
+	if errors.Is(err, ErrFloorPlanNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to create table", http.StatusInternalServerError)
+		return
+	}
+
+	writeCreated(w, id)
+}
+
+func (c TableController) updateTable(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	tableId, err := strconv.ParseInt(r.PathValue("tableId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkTable(w, r, tableId) {
+		return
+	}
+
+	var table TableUpdate
+	if err := json.NewDecoder(r.Body).Decode(&table); err != nil {
+		http.Error(w, "invalid table", http.StatusBadRequest)
+		return
+	}
+	if msg := validateTable(&table); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = c.TableRepo.UpdateTable(tableId, table)
+	if errors.Is(err, ErrTableNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to update table", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c TableController) deleteTable(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	tableId, err := strconv.ParseInt(r.PathValue("tableId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkTable(w, r, tableId) {
+		return
+	}
+
+	err = c.TableRepo.DeleteTable(tableId)
+	if errors.Is(err, ErrTableNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrTableNotFree) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to delete table", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c TableController) assignOrder(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tableId, err := strconv.ParseInt(r.PathValue("tableId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkTable(w, r, tableId) {
+		return
+	}
+
+	var body struct {
+		OrderId int64 `json:"orderId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.OrderId <= 0 {
+		http.Error(w, "order id is required", http.StatusBadRequest)
+		return
+	}
+
+	err = c.TableRepo.AssignOrder(tableId, body.OrderId, user.Username)
+	if !writeTableError(w, err, "failed to assign order") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c TableController) requestBill(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	tableId, err := strconv.ParseInt(r.PathValue("tableId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkTable(w, r, tableId) {
+		return
+	}
+
+	err = c.TableRepo.RequestBill(tableId)
+	if !writeTableError(w, err, "failed to request bill") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c TableController) transferTable(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tableId, err := strconv.ParseInt(r.PathValue("tableId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkTable(w, r, tableId) {
+		return
+	}
+
+	var body struct {
+		ToTableId int64 `json:"toTableId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ToTableId <= 0 {
+		http.Error(w, "target table id is required", http.StatusBadRequest)
+		return
+	}
+	if !c.checkTable(w, r, body.ToTableId) {
+		return
+	}
+
+	err = c.TableRepo.TransferTable(tableId, body.ToTableId, user.Username)
+	if !writeTableError(w, err, "failed to transfer table") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c TableController) mergeTable(w http.ResponseWriter, r *http.Request) {
+	if w == nil || r == nil {
+		return
+	}
+
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tableId, err := strconv.ParseInt(r.PathValue("tableId"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !c.checkTable(w, r, tableId) {
+		return
+	}
+
+	var body struct {
+		IntoTableId int64 `json:"intoTableId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.IntoTableId <= 0 {
+		http.Error(w, "target table id is required", http.StatusBadRequest)
+		return
+	}
+	if !c.checkTable(w, r, body.IntoTableId) {
+		return
+	}
+
+	err = c.TableRepo.MergeTables(tableId, body.IntoTableId, user.Username)
+	if !writeTableError(w, err, "failed to merge tables") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Trims the table name and returns the reason why the table is not valid, or an empty string.
+func validateTable(table *TableUpdate) string {
+	if table.Name != nil {
+		name := strings.TrimSpace(*table.Name)
+		if name == "" || len(name) > 32 {
+			return "table name is required (max 32 characters)"
+		}
+		table.Name = &name
+	}
+	if table.Seats != nil && *table.Seats == 0 {
+		return "table must have at least one seat"
+	}
+	if table.Area != nil && len(*table.Area) > 64 {
+		return "area is too long (max 64 characters)"
+	}
+	return ""
+}
+
+// Writes the error response and returns false if there was an error.
+func writeTableError(w http.ResponseWriter, err error, fallback string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrTableNotFound), errors.Is(err, ErrOrderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrTableNotFree), errors.Is(err, ErrTableFree),
+		errors.Is(err, ErrOrderNotOpen), errors.Is(err, ErrSameTable),
+		errors.Is(err, ErrOrderLocation), errors.Is(err, ErrTableLocation):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+	return false
+}
+
+// Writes an error and returns false if the location is not one of the user's business.
+func (c TableController) checkLocation(w http.ResponseWriter, r *http.Request, locationId int64) bool {
+	user, ok := r.Context().Value("user").(auth.User)
+	if !ok || user.Username == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	business, err := c.TableRepo.GetBusinessInfo(user.Username)
+	if err != nil {
+		http.Error(w, "failed to get business info", http.StatusInternalServerError)
+		return false
+	}
+
+	for _, location := range business.Locations {
+		if location.Id == locationId {
+			return true
+		}
+	}
+	http.Error(w, ErrLocationNotFound.Error(), http.StatusNotFound)
+	return false
+}
+
+// Writes an error and returns false if the floor plan doesn't exist or is not at a location of the user's business.
+func (c TableController) checkFloorPlan(w http.ResponseWriter, r *http.Request, floorPlanId int64) bool {
+	locationId, err := c.TableRepo.GetFloorPlanLocation(floorPlanId)
+	if errors.Is(err, ErrFloorPlanNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	} else if err != nil {
+		http.Error(w, "failed to get floor plan", http.StatusInternalServerError)
+		return false
+	}
+
+	return c.checkLocation(w, r, locationId)
+}
+
+// Writes an error and returns false if the table doesn't exist or is not at a location of the user's business.
+func (c TableController) checkTable(w http.ResponseWriter, r *http.Request, tableId int64) bool {
+	locationId, err := c.TableRepo.GetTableLocation(tableId)
+	if errors.Is(err, ErrTableNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	} else if err != nil {
+		http.Error(w, "failed to get table", http.StatusInternalServerError)
+		return false
+	}
+
+	return c.checkLocation(w, r, locationId)
+}
+
+func writeCreated(w http.ResponseWriter, id int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]any{"id": id}); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,16 @@
+package table
+
+import "errors"
+
+var (
+	ErrFloorPlanNotFound = errors.New("floor plan not found")
+	ErrTableNotFound     = errors.New("table not found")
+	ErrTableNotFree      = errors.New("table is not free")
+	ErrTableFree         = errors.New("table has no open orders")
+	ErrSameTable         = errors.New("source and target table are the same")
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrOrderNotOpen      = errors.New("order is not open")
+	ErrLocationNotFound  = errors.New("location not found")
+	ErrOrderLocation     = errors.New("order is at a different location than the table")
+	ErrTableLocation     = errors.New("tables are at different locations")
+)
@@ -0,0 +1,35 @@
+package table
+
+type Status string
+
+const (
+	StatusFree          Status = "free"
+	StatusOccupied      Status = "occupied"
+	StatusBillRequested Status = "bill_requested"
+)
+
+type FloorPlan struct {
+	Id         int64   `json:"id"         db:"id"`
+	LocationId int64   `json:"locationId" db:"location_id"`
+	Name       string  `json:"name"       db:"name"`
+	Tables     []Table `json:"tables"`
+}
+
+type Table struct {
+	Id          int64   `json:"id"          db:"id"`
+	FloorPlanId int64   `json:"floorPlanId" db:"floor_plan_id"`
+	Name        string  `json:"name"        db:"name"`
+	Seats       uint16  `json:"seats"       db:"seats"`
+	Area        string  `json:"area"        db:"area"`
+	Status      Status  `json:"status"      db:"status"`
+	MergedInto  *int64  `json:"mergedInto"  db:"merged_into_id"`
+	OpenOrders  []int64 `json:"openOrders"`
+	PartySize   uint16  `json:"partySize"   db:"party_size"`
+}
+
+// Payload for creating and updating a table; nil fields are ignored on update.
+type TableUpdate struct {
+	Name  *string `json:"name"`
+	Seats *uint16 `json:"seats"`
+	Area  *string `json:"area"`
+}
@@ -0,0 +1,25 @@
+package table
+
+import "dreampos/internal/auth"
+
+type TableRepo interface {
+	GetBusinessInfo(username string) (auth.BusinessInfo, error)
+	GetFloorPlans(locationId int64) ([]FloorPlan, error)
+	// Location of the floor plan, for checking it belongs to the user's business.
+	GetFloorPlanLocation(floorPlanId int64) (int64, error)
+	// Location of the floor plan the table is on.
+	GetTableLocation(tableId int64) (int64, error)
+	CreateFloorPlan(locationId int64, name string) (int64, error)
+	CreateTable(floorPlanId int64, table TableUpdate) (int64, error)
+	UpdateTable(tableId int64, table TableUpdate) error
+	// Only free tables can be deleted.
+	DeleteTable(tableId int64) error
+	// Seats an open order at the table. Orders seated at a merged table go to the table it was merged into.
+	// The order must be at the location of the table.
+	AssignOrder(tableId int64, orderId int64, username string) error
+	RequestBill(tableId int64) error
+	// Moves all open orders of the table to a free table at the same location.
+	TransferTable(fromTableId int64, toTableId int64, username string) error
+	// Moves all open orders of fromTableId to toTableId and marks fromTableId as merged into it.
+	MergeTables(fromTableId int64, toTableId int64, username string) error
+}
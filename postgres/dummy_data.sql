@@ -10,15 +10,16 @@ INSERT INTO role (id, name) VALUES
 
 -- Permissions
 INSERT INTO permissions (id, name) VALUES 
-(1, 'FULL_ADMIN'), (2, 'VIEW_REPORTS'), (3, 'CREATE_ORDER'), (4, 'MANAGE_STOCK'), (5, 'BOOK_APPOINTMENT');
+(1, 'FULL_ADMIN'), (2, 'VIEW_REPORTS'), (3, 'CREATE_ORDER'), (4, 'MANAGE_STOCK'), (5, 'BOOK_APPOINTMENT'), (6, 'MANAGE_CATALOG'),
+(7, 'PRICE_MODIFIERS');
 
 -- Role <> Permissions
 INSERT INTO role_permission (role_id, permission_id) VALUES 
-(1, 1), (1, 2), (1, 3), (1, 4), (1, 5), -- Owner
-(2, 2), (2, 3), (2, 4), (2, 5),         -- Manager
-(3, 3), (3, 4),                         -- Barista
-(4, 3), (4, 5),                         -- Stylist
-(5, 3), (5, 5);                         -- Receptionist
+(1, 1), (1, 2), (1, 3), (1, 4), (1, 5), (1, 6), (1, 7), -- Owner
+(2, 2), (2, 3), (2, 4), (2, 5), (2, 6), (2, 7),         -- Manager
+(3, 3), (3, 4),                                         -- Barista
+(4, 3), (4, 5),                                         -- Stylist
+(5, 3), (5, 5);                                         -- Receptionist
 
 -- Currencies
 INSERT INTO currency_info (code, name, symbol) VALUES 
@@ -51,26 +52,26 @@ INSERT INTO business (id, name, description, type, email, phone, created_at) VAL
 -- 3. LOCATIONS (2 Per Business)
 -- ================================================================================================
 
-INSERT INTO location (id, name, business_id, country_code, city, street, postal_code) VALUES 
+INSERT INTO location (id, name, business_id, country_code, city, street, postal_code, time_zone) VALUES 
 -- Morning Roast Locations (Business ID 1 - Coffee Shop)
-(1, 'Morning Roast Downtown', 1, 'US', 'Seattle', 'Pike Place', '98101'),
-(2, 'Morning Roast Pearl St.', 1, 'US', 'Portland', 'Pearl District', '97209'),
+(1, 'Morning Roast Downtown', 1, 'US', 'Seattle', 'Pike Place', '98101', 'America/Los_Angeles'),
+(2, 'Morning Roast Pearl St.', 1, 'US', 'Portland', 'Pearl District', '97209', 'America/Los_Angeles'),
 
 -- Urban Cuts Locations (Business ID 2 - Barbershop/Salon)
-(3, 'Urban Cuts Oxford', 2, 'GB', 'London', 'Oxford Street', 'W1D 1BS'),
-(4, 'Urban Cuts Market Square', 2, 'GB', 'Manchester', 'Market Street', 'M1 1WR'),
+(3, 'Urban Cuts Oxford', 2, 'GB', 'London', 'Oxford Street', 'W1D 1BS', 'Europe/London'),
+(4, 'Urban Cuts Market Square', 2, 'GB', 'Manchester', 'Market Street', 'M1 1WR', 'Europe/London'),
 
 -- Tech Gadgets Locations (Business ID 3 - Retail Electronics)
-(5, 'Tech Gadgets Center', 3, 'DE', 'Berlin', 'Alexanderplatz', '10178'),
-(6, 'Tech Gadgets South', 3, 'DE', 'Munich', 'Marienplatz', '80331'),
+(5, 'Tech Gadgets Center', 3, 'DE', 'Berlin', 'Alexanderplatz', '10178', 'Europe/Berlin'),
+(6, 'Tech Gadgets South', 3, 'DE', 'Munich', 'Marienplatz', '80331', 'Europe/Berlin'),
 
 -- Serenity Spa Locations (Business ID 4 - Spa/Wellness)
-(7, 'Serenity Spa Queen West', 4, 'CA', 'Toronto', 'Queen Street West', 'M5V 2A2'),
-(8, 'Serenity Spa Robson', 4, 'CA', 'Vancouver', 'Robson Street', 'V6B 2B2'),
+(7, 'Serenity Spa Queen West', 4, 'CA', 'Toronto', 'Queen Street West', 'M5V 2A2', 'America/Toronto'),
+(8, 'Serenity Spa Robson', 4, 'CA', 'Vancouver', 'Robson Street', 'V6B 2B2', 'America/Vancouver'),
 
 -- Burger Joint Locations (Business ID 5 - Fast Food)
-(9, 'Burger Joint Circular Quay', 5, 'AU', 'Sydney', 'George Street', '2000'),
-(10, 'Burger Joint Bourke St.', 5, 'AU', 'Melbourne', 'Bourke Street', '3000');
+(9, 'Burger Joint Circular Quay', 5, 'AU', 'Sydney', 'George Street', '2000', 'Australia/Sydney'),
+(10, 'Burger Joint Bourke St.', 5, 'AU', 'Melbourne', 'Bourke Street', '3000', 'Australia/Melbourne');
 
 -- Location Opening Times (Sample)
 INSERT INTO location_open (location_id, day_of_the_week, open_at, closes_at) VALUES 
@@ -80,6 +81,17 @@ INSERT INTO location_open (location_id, day_of_the_week, open_at, closes_at) VAL
 (4, 'MONDAY', '10:00', '20:00'), (4, 'TUESDAY', '10:00', '20:00'),
 (9, 'FRIDAY', '11:00', '23:00'), (10, 'SATURDAY', '11:00', '23:00');
 
+-- Floor plans and tables of the dine-in locations
+INSERT INTO floor_plan (location_id, name) VALUES 
+(1, 'Cafe'),
+(9, 'Ground Floor'),
+(9, 'Terrace');
+
+INSERT INTO dining_table (floor_plan_id, name, seats, area) VALUES 
+(1, 'T1', 2, 'Window'), (1, 'T2', 2, 'Window'), (1, 'T3', 4, 'Main'),
+(2, 'A1', 4, 'Main'), (2, 'A2', 4, 'Main'), (2, 'A3', 6, 'Booth'),
+(3, 'P1', 4, 'Terrace'), (3, 'P2', 4, 'Terrace');
+
 -- ================================================================================================
 -- 4. EMPLOYEES & SHIFTS
 -- ================================================================================================
@@ -128,73 +140,138 @@ INSERT INTO employee_role (employee_id, role_id) VALUES
 -- 5. ITEMS & INVENTORY (For Order-Based Businesses)
 -- ================================================================================================
 
-INSERT INTO item (id, name, location_id, price_per_unit, vat, status) VALUES 
--- Morning Roast Items (Loc 1 & 2)
+INSERT INTO item (id, name, business_id, price_per_unit, vat, status) VALUES 
+-- Morning Roast Items (Business 1)
 (1, 'House Blend Coffee', 1, 300, 8.00, 'ACTIVE'),
 (2, 'Espresso Shot', 1, 250, 8.00, 'ACTIVE'),
 (3, 'Blueberry Muffin', 1, 350, 8.00, 'ACTIVE'),
-(4, 'Bagel with Cream Cheese', 2, 400, 8.00, 'ACTIVE'),
-(5, 'Iced Latte', 2, 450, 8.00, 'ACTIVE'),
--- Tech Gadgets Items (Loc 5 & 6)
-(6, 'USB-C Cable', 5, 1500, 19.00, 'ACTIVE'),
-(7, 'Wireless Mouse', 5, 2500, 19.00, 'ACTIVE'),
-(8, 'Mechanical Keyboard', 6, 12000, 19.00, 'ACTIVE'),
-(9, 'HDMI Adapter', 6, 2000, 19.00, 'ACTIVE'),
--- Burger Joint Items (Loc 9 & 10)
-(10, 'Cheeseburger', 9, 800, 10.00, 'ACTIVE'),
-(11, 'Fries', 9, 400, 10.00, 'ACTIVE'),
-(12, 'Soda', 9, 250, 10.00, 'ACTIVE'),
-(13, 'Double Burger', 10, 1100, 10.00, 'ACTIVE'),
-(14, 'Onion Rings', 10, 500, 10.00, 'ACTIVE'),
+(4, 'Bagel with Cream Cheese', 1, 400, 8.00, 'ACTIVE'),
+(5, 'Iced Latte', 1, 450, 8.00, 'ACTIVE'),
+-- Tech Gadgets Items (Business 3)
+(6, 'USB-C Cable', 3, 1500, 19.00, 'ACTIVE'),
+(7, 'Wireless Mouse', 3, 2500, 19.00, 'ACTIVE'),
+(8, 'Mechanical Keyboard', 3, 12000, 19.00, 'ACTIVE'),
+(9, 'HDMI Adapter', 3, 2000, 19.00, 'ACTIVE'),
+-- Burger Joint Items (Business 5)
+(10, 'Cheeseburger', 5, 800, 10.00, 'ACTIVE'),
+(11, 'Fries', 5, 400, 10.00, 'ACTIVE'),
+(12, 'Soda', 5, 250, 10.00, 'ACTIVE'),
+(13, 'Double Burger', 5, 1100, 10.00, 'ACTIVE'),
+(14, 'Onion Rings', 5, 500, 10.00, 'ACTIVE'),
 -- Burger Joint Circular Quay (Loc 9) - extra items
-(15, 'Aussie Burger', 9, 1050, 10.00, 'ACTIVE'),
-(16, 'Chicken Burger', 9, 950, 10.00, 'ACTIVE'),
-(17, 'Sweet Potato Fries', 9, 550, 10.00, 'ACTIVE'),
-(18, 'Lemon Lime Bitters', 9, 450, 10.00, 'ACTIVE'),
-(19, 'Vanilla Thickshake', 9, 600, 10.00, 'ACTIVE'),
-(20, 'Pavlova Slice', 9, 650, 10.00, 'ACTIVE'),
-(27, 'Barramundi Burger', 9, 1150, 10.00, 'ACTIVE'),
-(28, 'Mozzarella Sticks', 9, 600, 10.00, 'ACTIVE'),
-(29, 'Sparkling Water', 9, 300, 10.00, 'ACTIVE'),
+(15, 'Aussie Burger', 5, 1050, 10.00, 'ACTIVE'),
+(16, 'Chicken Burger', 5, 950, 10.00, 'ACTIVE'),
+(17, 'Sweet Potato Fries', 5, 550, 10.00, 'ACTIVE'),
+(18, 'Lemon Lime Bitters', 5, 450, 10.00, 'ACTIVE'),
+(19, 'Vanilla Thickshake', 5, 600, 10.00, 'ACTIVE'),
+(20, 'Pavlova Slice', 5, 650, 10.00, 'ACTIVE'),
+(27, 'Barramundi Burger', 5, 1150, 10.00, 'ACTIVE'),
+(28, 'Mozzarella Sticks', 5, 600, 10.00, 'ACTIVE'),
+(29, 'Sparkling Water', 5, 300, 10.00, 'ACTIVE'),
 -- Burger Joint Bourke St. (Loc 10) - extra items
-(21, 'Spicy Chicken Burger', 10, 1000, 10.00, 'ACTIVE'),
-(22, 'Veggie Burger', 10, 900, 10.00, 'ACTIVE'),
-(23, 'Loaded Fries', 10, 650, 10.00, 'ACTIVE'),
-(24, 'Craft Cola', 10, 450, 10.00, 'ACTIVE'),
-(25, 'Chiuras', 10, 700, 10.00, 'ACTIVE'),
-(26, 'Gelato Cup', 10, 550, 10.00, 'ACTIVE'),
-(30, 'BBQ Bacon Burger', 10, 1200, 10.00, 'ACTIVE'),
-(31, 'Kids Nuggets', 10, 650, 10.00, 'ACTIVE'),
-(32, 'Apple Pie', 10, 650, 10.00, 'ACTIVE'),
-(33, 'Peach Iced Tea', 10, 400, 10.00, 'ACTIVE');
+(21, 'Spicy Chicken Burger', 5, 1000, 10.00, 'ACTIVE'),
+(22, 'Veggie Burger', 5, 900, 10.00, 'ACTIVE'),
+(23, 'Loaded Fries', 5, 650, 10.00, 'ACTIVE'),
+(24, 'Craft Cola', 5, 450, 10.00, 'ACTIVE'),
+(25, 'Chiuras', 5, 700, 10.00, 'ACTIVE'),
+(26, 'Gelato Cup', 5, 550, 10.00, 'ACTIVE'),
+(30, 'BBQ Bacon Burger', 5, 1200, 10.00, 'ACTIVE'),
+(31, 'Kids Nuggets', 5, 650, 10.00, 'ACTIVE'),
+(32, 'Apple Pie', 5, 650, 10.00, 'ACTIVE'),
+(33, 'Peach Iced Tea', 5, 400, 10.00, 'ACTIVE'),
+-- Burger Joint bundles, their slots are below the categories
+(34, 'Burger Meal', 5, 1200, 10.00, 'ACTIVE');
+
+-- Locations the items are sold at
+INSERT INTO item_location (item_id, location_id, price_per_unit) VALUES
+(1, 1, NULL),
+(2, 1, NULL),
+(3, 1, NULL),
+(4, 2, NULL),
+(5, 2, NULL),
+(6, 5, NULL),
+(7, 5, NULL),
+(8, 6, NULL),
+(9, 6, NULL),
+(10, 9, NULL),
+(11, 9, NULL),
+(12, 9, NULL),
+(15, 9, NULL),
+(16, 9, NULL),
+(17, 9, NULL),
+(18, 9, NULL),
+(19, 9, NULL),
+(20, 9, NULL),
+(27, 9, NULL),
+(28, 9, NULL),
+(29, 9, NULL),
+(13, 10, NULL),
+(14, 10, NULL),
+(21, 10, NULL),
+(22, 10, NULL),
+(23, 10, NULL),
+(24, 10, NULL),
+(25, 10, NULL),
+(26, 10, NULL),
+(30, 10, NULL),
+(31, 10, NULL),
+(32, 10, NULL),
+(33, 10, NULL),
+-- Burger Joint basics are sold at both locations, the cheeseburger costs more at Bourke St.
+(10, 10, 850),
+(11, 10, NULL),
+(12, 10, NULL),
+(34, 9, NULL);
+
+INSERT INTO price_list (id, business_id, name) VALUES
+(1, 5, 'Staff');
+
+INSERT INTO price_list_item (price_list_id, item_id, price_per_unit) VALUES
+(1, 10, 500),
+(1, 11, 200),
+(1, 12, 100);
+
+-- Scheduled price changes of the Burger Joint
+INSERT INTO item_price_change (item_id, location_id, effective_date, effective_at, price_per_unit, vat, fulfillment_type) VALUES
+(10, 9, '2030-01-01', '2030-01-01'::TIMESTAMP AT TIME ZONE 'Australia/Sydney', 850, NULL, NULL),
+(11, 9, '2030-01-01', '2030-01-01'::TIMESTAMP AT TIME ZONE 'Australia/Sydney', NULL, 0.00, 'TAKEAWAY');
+
+-- Variation groups, sizes are required and small by default
+INSERT INTO variation_group (id, item_id, name, min_selected, max_selected, position) VALUES
+(1, 1, 'Size', 1, 1, 0),
+(2, 5, 'Size', 1, 1, 0),
+(3, 5, 'Milk', 0, 1, 1),
+(4, 11, 'Size', 1, 1, 0),
+(5, 19, 'Size', 1, 1, 0);
 
 -- Item Variations
-INSERT INTO item_variation (id, item_id, name, price_difference) VALUES 
-(1, 1, 'Small', 0),
-(2, 1, 'Large', 50),
-(3, 5, 'Small', 0),
-(4, 5, 'Large', 50),
-(5, 5, 'Oat Milk', 50),
-(6, 5, 'Almond Milk', 50),
-(7, 11, 'Small', 0),
-(8, 11, 'Large', 150),
+INSERT INTO item_variation (id, item_id, name, price_difference, group_id, is_default) VALUES 
+(1, 1, 'Small', 0, 1, TRUE),
+(2, 1, 'Large', 50, 1, FALSE),
+(3, 5, 'Small', 0, 2, TRUE),
+(4, 5, 'Large', 50, 2, FALSE),
+(5, 5, 'Oat Milk', 50, 3, FALSE),
+(6, 5, 'Almond Milk', 50, 3, FALSE),
+(7, 11, 'Small', 0, 4, TRUE),
+(8, 11, 'Large', 150, 4, FALSE),
 -- Burger Joint variations
-(11, 19, 'Small', 0),
-(12, 19, 'Large', 150),
-(13, 23, 'Large', 200)
+(11, 19, 'Small', 0, 5, TRUE),
+(12, 19, 'Large', 150, 5, FALSE),
+(13, 23, 'Large', 200, NULL, FALSE)
 ;
 
 -- Category
-INSERT INTO category (id, name) VALUES
-(1, 'hot drinks'),
-(2, 'cold drinks'),
-(3, 'pastries'),
-(4, 'accessories'),
-(5, 'peripherals'),
-(6, 'burgers'),
-(7, 'sides'),
-(8, 'beverages'),
-(9, 'desserts');
+INSERT INTO category (id, business_id, name) VALUES
+(1, 1, 'hot drinks'),
+(2, 1, 'cold drinks'),
+(3, 1, 'pastries'),
+(4, 3, 'accessories'),
+(5, 3, 'peripherals'),
+(6, 5, 'burgers'),
+(7, 5, 'sides'),
+(8, 5, 'beverages'),
+(9, 5, 'desserts'),
+(10, 5, 'meals');
 
 INSERT INTO item_category (item_id, category_id) VALUES
 -- Morning Roast Items
@@ -234,7 +311,38 @@ INSERT INTO item_category (item_id, category_id) VALUES
 (30, 6), -- BBQ Bacon Burger -> Burgers
 (31, 7), -- Kids Nuggets -> Sides
 (32, 9), -- Apple Pie -> Desserts
-(33, 8); -- Peach Iced Tea -> Beverages
+(33, 8), -- Peach Iced Tea -> Beverages
+(34, 10); -- Burger Meal -> Meals
+
+-- Burger Meal: a burger, a side and a drink, the better choices cost extra
+INSERT INTO bundle_slot (id, bundle_id, name, position) VALUES
+(1, 34, 'Burger', 0),
+(2, 34, 'Side', 1),
+(3, 34, 'Drink', 2);
+
+INSERT INTO bundle_slot_option (slot_id, item_id, upcharge) VALUES
+(1, 10, 0),
+(1, 16, 0),
+(1, 15, 150),
+(2, 11, 0),
+(2, 17, 100),
+(3, 12, 0),
+(3, 18, 150);
+
+-- Pricing rules of the Burger Joint
+INSERT INTO pricing_rule (id, business_id, name, location_id, item_id, category_id, weekdays, start_time, end_time, adjustment_type, adjustment, priority) VALUES
+(1, 5, 'Happy hour', NULL, NULL, 8, '{MONDAY,TUESDAY,WEDNESDAY,THURSDAY,FRIDAY}', '16:00', '18:00', 'PERCENT', -2000, 0),
+(2, 5, 'Lunch burgers', 9, NULL, 6, '{MONDAY,TUESDAY,WEDNESDAY,THURSDAY,FRIDAY}', '11:30', '14:00', 'FIXED', -150, 0),
+(3, 5, 'Late night fries', NULL, 11, NULL, '{}', '22:00', '02:00', 'PERCENT', -5000, 1);
+
+-- Catalog ids are fixed above, the sequences continue after them
+SELECT setval('item_id_seq', (SELECT MAX(id) FROM item));
+SELECT setval('item_variation_id_seq', (SELECT MAX(id) FROM item_variation));
+SELECT setval('variation_group_id_seq', (SELECT MAX(id) FROM variation_group));
+SELECT setval('category_id_seq', (SELECT MAX(id) FROM category));
+SELECT setval('price_list_id_seq', (SELECT MAX(id) FROM price_list));
+SELECT setval('pricing_rule_id_seq', (SELECT MAX(id) FROM pricing_rule));
+SELECT setval('bundle_slot_id_seq', (SELECT MAX(id) FROM bundle_slot));
 
 -- ================================================================================================
 -- 6. SERVICES (For Appointment-Based Businesses)
@@ -121,7 +121,9 @@ CREATE TABLE location (
 	country_code    CHAR(3)     NOT NULL REFERENCES country(code),
 	city            VARCHAR(64) NOT NULL,
     street          VARCHAR(64) NOT NULL,
-    postal_code     VARCHAR(16) NOT NULL
+    postal_code     VARCHAR(16) NOT NULL,
+    -- IANA name, scheduled price changes take effect at midnight of this zone
+    time_zone       VARCHAR(64) NOT NULL DEFAULT 'UTC'
 );
 
 DROP TABLE IF EXISTS location_open CASCADE;
@@ -222,12 +224,59 @@ CREATE TABLE service_employee (
     PRIMARY KEY(service_location_id, employee_id)
 );
 
+-- ------------------------------------------------------------------------------------------------
+-- Table data -------------------------------------------------------------------------------------
+-- ------------------------------------------------------------------------------------------------
+
+DROP TABLE IF EXISTS floor_plan CASCADE;
+CREATE TABLE floor_plan (
+    id              SERIAL PRIMARY KEY,
+    location_id     INTEGER     NOT NULL REFERENCES location(id),
+    name            VARCHAR(64) NOT NULL,
+
+    UNIQUE (location_id, name)
+);
+
+-- Status of a table is derived from its open orders, only bill_requested is stored.
+-- merged_into_id points to the table this one was merged into, orders are seated at that table.
+DROP TABLE IF EXISTS dining_table CASCADE;
+CREATE TABLE dining_table (
+    id              SERIAL PRIMARY KEY,
+    floor_plan_id   INTEGER     NOT NULL REFERENCES floor_plan(id),
+    name            VARCHAR(32) NOT NULL,
+    seats           SMALLINT    NOT NULL,
+    area            VARCHAR(64) NOT NULL DEFAULT '',
+    bill_requested  BOOLEAN     NOT NULL DEFAULT FALSE,
+    merged_into_id  INTEGER     NULL REFERENCES dining_table(id) ON DELETE SET NULL,
+
+    UNIQUE (floor_plan_id, name),
+    CONSTRAINT positive_seats       CHECK (seats > 0),
+    CONSTRAINT not_merged_into_self CHECK (merged_into_id <> id)
+);
+
 -- ------------------------------------------------------------------------------------------------
 -- Order ------------------------------------------------------------------------------------------
 -- ------------------------------------------------------------------------------------------------
 
 DROP TYPE IF EXISTS order_status CASCADE;
-CREATE TYPE order_status AS ENUM('OPEN', 'CLOSED', 'REFUND_PENDING', 'REFUNDED');
+CREATE TYPE order_status AS ENUM('OPEN', 'CLOSED', 'REFUND_PENDING', 'REFUNDED', 'CANCELLED');
+
+DROP TYPE IF EXISTS order_cancel_reason CASCADE;
+CREATE TYPE order_cancel_reason AS ENUM('CUSTOMER_LEFT', 'ORDER_MISTAKE', 'DUPLICATE', 'PAYMENT_FAILED', 'OTHER', 'MERGED');
+
+DROP TYPE IF EXISTS fulfillment_type CASCADE;
+CREATE TYPE fulfillment_type AS ENUM('DINE_IN', 'TAKEAWAY', 'DELIVERY', 'PICKUP');
+
+-- Named set of prices, e.g. staff or wholesale, an order can be priced by one.
+-- Items that are not on the list keep the price of the location (see item_price).
+DROP TABLE IF EXISTS price_list CASCADE;
+CREATE TABLE price_list (
+    id              SERIAL PRIMARY KEY,
+    business_id     INTEGER     NOT NULL REFERENCES business(id),
+    name            VARCHAR(64) NOT NULL,
+
+    CONSTRAINT unique_price_list_name UNIQUE (business_id, name)
+);
 
 DROP TABLE IF EXISTS order_data CASCADE;
 CREATE TABLE order_data (
@@ -239,18 +288,83 @@ CREATE TABLE order_data (
     discount        DECIMAL(15)     NOT NULL DEFAULT 0,
     tip             DECIMAL(15)     NOT NULL DEFAULT 0,
     service_charge  DECIMAL(15)     NOT NULL DEFAULT 0,
+    version         INTEGER         NOT NULL DEFAULT 1,
+    table_id        INTEGER         NULL REFERENCES dining_table(id) ON DELETE SET NULL,
+    party_size      SMALLINT        NULL,
+    customer_label  VARCHAR(64)     NOT NULL DEFAULT '',
+    notes           VARCHAR(512)    NOT NULL DEFAULT '',
+    customer_phone  VARCHAR(16)     NULL,
+    location_id     INTEGER         NULL REFERENCES location(id),
+    -- Parked orders have held_at set, held_terminal is the terminal that parked them.
+    held_at         TIMESTAMP       NULL,
+    held_terminal   VARCHAR(64)     NULL,
+    -- Terminal that last picked the order up
+    picked_up_by    VARCHAR(64)     NULL,
+    -- Decides which VAT rate of the items applies
+    fulfillment_type fulfillment_type NOT NULL DEFAULT 'DINE_IN',
+    price_list_id   INTEGER         NULL REFERENCES price_list(id) ON DELETE SET NULL,
 
     CONSTRAINT non_negative_discount        CHECK (discount >= 0),
     CONSTRAINT non_negative_tip             CHECK (tip >= 0),
-    CONSTRAINT non_negative_service_charge  CHECK (service_charge >= 0)
+    CONSTRAINT non_negative_service_charge  CHECK (service_charge >= 0),
+    CONSTRAINT positive_party_size          CHECK (party_size > 0),
+    CONSTRAINT valid_customer_phone         CHECK (customer_phone ~ '^\+[0-9]{3,15}$')
 );
 
+DROP INDEX IF EXISTS order_data_customer_phone_index CASCADE;
+CREATE INDEX order_data_customer_phone_index ON order_data(customer_phone text_pattern_ops);
+
 DROP TRIGGER IF EXISTS business_valid_created_at ON business;
 CREATE TRIGGER business_valid_created_at
     BEFORE INSERT OR UPDATE ON business
     FOR EACH ROW
     EXECUTE FUNCTION not_in_future();
 
+DROP INDEX IF EXISTS order_data_employee_id_index CASCADE;
+CREATE INDEX order_data_employee_id_index ON order_data(employee_id, id);
+
+DROP INDEX IF EXISTS order_data_created_at_index CASCADE;
+CREATE INDEX order_data_created_at_index ON order_data(created_at);
+
+DROP INDEX IF EXISTS order_data_open_table_id_index CASCADE;
+CREATE INDEX order_data_open_table_id_index ON order_data(table_id) WHERE status = 'OPEN';
+
+DROP INDEX IF EXISTS order_data_held_index CASCADE;
+CREATE INDEX order_data_held_index ON order_data(location_id, held_at) WHERE held_at IS NOT NULL;
+
+-- Every status change of an order, made through the order state machine.
+-- actor is the username of the employee or the name of the system that made the change.
+DROP TABLE IF EXISTS order_status_change CASCADE;
+CREATE TABLE order_status_change (
+    id              SERIAL PRIMARY KEY,
+    order_id        INTEGER         NOT NULL REFERENCES order_data(id),
+    from_status     order_status    NOT NULL,
+    to_status       order_status    NOT NULL,
+    event           VARCHAR(32)     NOT NULL,
+    actor           VARCHAR(64)     NOT NULL,
+    employee_id     INTEGER         DEFAULT NULL REFERENCES employee(id),
+    changed_at      TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP INDEX IF EXISTS order_status_change_order_id_index CASCADE;
+CREATE INDEX order_status_change_order_id_index ON order_status_change(order_id);
+
+-- Domain events of an order that are not status changes (items, tip, checkout...).
+-- Together with order_status_change it makes up the order timeline.
+DROP TABLE IF EXISTS order_event CASCADE;
+CREATE TABLE order_event (
+    id              SERIAL PRIMARY KEY,
+    order_id        INTEGER         NOT NULL REFERENCES order_data(id),
+    type            VARCHAR(32)     NOT NULL,
+    actor           VARCHAR(64)     NOT NULL,
+    employee_id     INTEGER         DEFAULT NULL REFERENCES employee(id),
+    details         JSONB           NOT NULL DEFAULT '{}',
+    created_at      TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+DROP INDEX IF EXISTS order_event_order_id_index CASCADE;
+CREATE INDEX order_event_order_id_index ON order_event(order_id);
+
 -- ------------------------------------------------------------------------------------------------
 -- Payment ----------------------------------------------------------------------------------------
 -- ------------------------------------------------------------------------------------------------
@@ -264,7 +378,9 @@ CREATE TYPE payment_method AS ENUM('STRIPE', 'CASH', 'CARD');
 DROP TABLE IF EXISTS payment CASCADE;
 CREATE TABLE payment (
     id                      SERIAL PRIMARY KEY,
-    order_id                INTEGER         NOT NULL REFERENCES order_data(id),
+    -- Exactly one of order_id and reservation_id is set.
+    order_id                INTEGER         DEFAULT NULL REFERENCES order_data(id),
+    reservation_id          INTEGER         DEFAULT NULL,
     amount                  DECIMAL(15)     NOT NULL,
     currency                currency        NOT NULL,
     payment_method          payment_method  NOT NULL,
@@ -274,12 +390,16 @@ CREATE TABLE payment (
     created_at              TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at              TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP,
 
-    CONSTRAINT positive_amount CHECK (amount > 0)
+    CONSTRAINT positive_amount CHECK (amount > 0),
+    CONSTRAINT payment_for_order_or_reservation CHECK ((order_id IS NULL) <> (reservation_id IS NULL))
 );
 
 DROP INDEX IF EXISTS payment_order_id_index CASCADE;
 CREATE INDEX payment_order_id_index ON payment(order_id);
 
+DROP INDEX IF EXISTS payment_reservation_id_index CASCADE;
+CREATE INDEX payment_reservation_id_index ON payment(reservation_id);
+
 DROP INDEX IF EXISTS payment_stripe_session_id_index CASCADE;
 CREATE INDEX payment_stripe_session_id_index ON payment(stripe_session_id);
 
@@ -299,16 +419,26 @@ CREATE TRIGGER payment_valid_created_at
 DROP TYPE IF EXISTS item_status CASCADE;
 CREATE TYPE item_status AS ENUM('ACTIVE', 'ARCHIVED');
 
+-- Unit of measure the item is sold in, quantities of KG, L and M can have decimals.
+DROP TYPE IF EXISTS measure_unit CASCADE;
+CREATE TYPE measure_unit AS ENUM('PIECE', 'KG', 'G', 'L', 'M');
 
+
+-- Items make up the catalog of the business, item_location decides where they are sold.
 DROP TABLE IF EXISTS item CASCADE;
 CREATE TABLE item (
-    id              INTEGER PRIMARY KEY,
-    name            VARCHAR(64)     NOT NULL UNIQUE,
-    location_id     INTEGER         NOT NULL REFERENCES location(id),
+    id              SERIAL PRIMARY KEY,
+    name            VARCHAR(64)     NOT NULL,
+    business_id     INTEGER         NOT NULL REFERENCES business(id),
     price_per_unit  DECIMAL(15)     NOT NULL,
     vat             DECIMAL(4, 2)   NOT NULL,
     status          item_status     NOT NULL DEFAULT 'ACTIVE',
+    unit            measure_unit    NOT NULL DEFAULT 'PIECE',
+    sku             VARCHAR(64)     NULL,
 
+    -- Names and SKUs identify the products of a business in catalog imports
+    CONSTRAINT unique_item_name                 UNIQUE (business_id, name),
+    CONSTRAINT unique_item_sku                  UNIQUE (business_id, sku),
     CONSTRAINT positive_price_per_unit_price    CHECK (price_per_unit > 0),
     CONSTRAINT non_negative_vat_price           CHECK (vat >= 0)
 );
@@ -316,50 +446,676 @@ CREATE TABLE item (
 DROP INDEX IF EXISTS item_index CASCADE;
 CREATE INDEX item_index ON item(name);
 
+-- Locations the item is sold at. Unavailable items are hidden from the location
+-- but keep their price, price_per_unit overrides the price of the item when set.
+DROP TABLE IF EXISTS item_location CASCADE;
+CREATE TABLE item_location (
+    item_id         INTEGER     NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    location_id     INTEGER     NOT NULL REFERENCES location(id),
+    available       BOOLEAN     NOT NULL DEFAULT TRUE,
+    price_per_unit  DECIMAL(15) NULL,
+
+    PRIMARY KEY (item_id, location_id),
+    CONSTRAINT positive_price_per_unit CHECK (price_per_unit > 0)
+);
+
+DROP INDEX IF EXISTS item_location_location_id_index CASCADE;
+CREATE INDEX item_location_location_id_index ON item_location(location_id);
+
+DROP TABLE IF EXISTS price_list_item CASCADE;
+CREATE TABLE price_list_item (
+    price_list_id   INTEGER     NOT NULL REFERENCES price_list(id) ON DELETE CASCADE,
+    item_id         INTEGER     NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    price_per_unit  DECIMAL(15) NOT NULL,
+
+    PRIMARY KEY (price_list_id, item_id),
+    CONSTRAINT positive_price_per_unit CHECK (price_per_unit > 0)
+);
+
+-- Price of the item in an order: the price list comes first, then the price at the location
+-- and then the price of the item. Location and price list can be NULL.
+CREATE OR REPLACE FUNCTION item_price(item_id INTEGER, location_id INTEGER, price_list_id INTEGER)
+RETURNS DECIMAL(15) AS
+$$
+    SELECT COALESCE(
+        (
+            SELECT price_list_item.price_per_unit
+            FROM price_list_item
+            WHERE
+                price_list_item.price_list_id = $3
+                AND price_list_item.item_id = $1
+        ),
+        (
+            SELECT item_location.price_per_unit
+            FROM item_location
+            WHERE
+                item_location.location_id = $2
+                AND item_location.item_id = $1
+        ),
+        (
+            SELECT item.price_per_unit
+            FROM item
+            WHERE item.id = $1
+        )
+    )
+$$ LANGUAGE sql STABLE;
+
+
+-- VAT rate of the item for a fulfillment type, e.g. takeaway food in a lower bracket.
+-- item.vat is used for fulfillment types without a rate here.
+DROP TABLE IF EXISTS item_vat CASCADE;
+CREATE TABLE item_vat (
+    item_id             INTEGER             NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    fulfillment_type    fulfillment_type    NOT NULL,
+    vat                 DECIMAL(4, 2)       NOT NULL,
+
+    PRIMARY KEY (item_id, fulfillment_type),
+    CONSTRAINT non_negative_vat CHECK (vat >= 0)
+);
+
+-- Price and VAT changes that take effect at midnight of effective_date in the time zone of the location.
+-- They are applied by apply_item_price_changes(), NULL price and VAT keep the current values.
+-- With a fulfillment type the VAT is set for that type only and NULL VAT removes the override.
+-- Changes are business-wide, unless location_only is set: then only the price at the location changes.
+DROP TABLE IF EXISTS item_price_change CASCADE;
+CREATE TABLE item_price_change (
+    id                  SERIAL PRIMARY KEY,
+    item_id             INTEGER             NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    location_id         INTEGER             NOT NULL REFERENCES location(id),
+    effective_date      DATE                NOT NULL,
+    effective_at        TIMESTAMPTZ         NOT NULL,
+    price_per_unit      DECIMAL(15)         NULL,
+    vat                 DECIMAL(4, 2)       NULL,
+    fulfillment_type    fulfillment_type    NULL,
+    location_only       BOOLEAN             NOT NULL DEFAULT FALSE,
+    created_at          TIMESTAMPTZ         NOT NULL DEFAULT NOW(),
+    applied_at          TIMESTAMPTZ         NULL,
+
+    CONSTRAINT positive_price_per_unit  CHECK (price_per_unit > 0),
+    CONSTRAINT non_negative_vat         CHECK (vat >= 0),
+    CONSTRAINT changes_something        CHECK (price_per_unit IS NOT NULL OR vat IS NOT NULL OR fulfillment_type IS NOT NULL),
+    -- VAT is the same at every location of the business
+    CONSTRAINT location_only_price      CHECK (NOT location_only OR (price_per_unit IS NOT NULL AND vat IS NULL AND fulfillment_type IS NULL))
+);
+
+DROP INDEX IF EXISTS item_price_change_item_id_index CASCADE;
+CREATE INDEX item_price_change_item_id_index ON item_price_change(item_id);
+DROP INDEX IF EXISTS item_price_change_pending_index CASCADE;
+CREATE INDEX item_price_change_pending_index ON item_price_change(effective_at) WHERE applied_at IS NULL;
+
+-- Every price and VAT rate an item has had. Rows are written by triggers, so changes from
+-- the catalog, imports and scheduled changes all end up here. Rows with a location are the price
+-- at that location and rows with a price list the price in that list (NULL when removed).
+-- Rows with a fulfillment type are the VAT of that type (NULL when removed), the other rows
+-- are the price and default VAT of the item.
+DROP TABLE IF EXISTS item_price_history CASCADE;
+CREATE TABLE item_price_history (
+    id                  SERIAL PRIMARY KEY,
+    item_id             INTEGER             NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    location_id         INTEGER             NULL REFERENCES location(id),
+    -- Not a reference, the history stays when the price list is deleted
+    price_list_id       INTEGER             NULL,
+    fulfillment_type    fulfillment_type    NULL,
+    price_per_unit      DECIMAL(15)         NULL,
+    vat                 DECIMAL(4, 2)       NULL,
+    changed_at          TIMESTAMPTZ         NOT NULL DEFAULT NOW(),
+    -- Set when the row was written by a scheduled change
+    price_change_id     INTEGER             NULL REFERENCES item_price_change(id) ON DELETE SET NULL
+);
+
+DROP INDEX IF EXISTS item_price_history_item_id_index CASCADE;
+CREATE INDEX item_price_history_item_id_index ON item_price_history(item_id, changed_at);
+
+-- Scheduled change being applied in this transaction, see apply_item_price_changes().
+CREATE OR REPLACE FUNCTION current_item_price_change()
+RETURNS INTEGER AS
+$$
+    SELECT NULLIF(current_setting('dreampos.price_change_id', TRUE), '')::INTEGER;
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION record_item_price()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF  TG_OP = 'INSERT'
+        OR NEW.price_per_unit IS DISTINCT FROM OLD.price_per_unit
+        OR NEW.vat IS DISTINCT FROM OLD.vat
+    THEN
+        INSERT INTO item_price_history (item_id, price_per_unit, vat, price_change_id)
+            VALUES (NEW.id, NEW.price_per_unit, NEW.vat, current_item_price_change());
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS item_price_history_trigger ON item;
+CREATE TRIGGER item_price_history_trigger
+    AFTER INSERT OR UPDATE OF price_per_unit, vat ON item
+    FOR EACH ROW
+    EXECUTE FUNCTION record_item_price();
+
+CREATE OR REPLACE FUNCTION record_item_vat()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO item_price_history (item_id, fulfillment_type, vat, price_change_id)
+            VALUES (OLD.item_id, OLD.fulfillment_type, NULL, current_item_price_change());
+    ELSIF TG_OP = 'INSERT' OR NEW.vat IS DISTINCT FROM OLD.vat THEN
+        INSERT INTO item_price_history (item_id, fulfillment_type, vat, price_change_id)
+            VALUES (NEW.item_id, NEW.fulfillment_type, NEW.vat, current_item_price_change());
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS item_vat_history_trigger ON item_vat;
+CREATE TRIGGER item_vat_history_trigger
+    AFTER INSERT OR UPDATE OR DELETE ON item_vat
+    FOR EACH ROW
+    EXECUTE FUNCTION record_item_vat();
+
+-- Only overrides are recorded, a location without one uses the price of the item.
+CREATE OR REPLACE FUNCTION record_item_location_price()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.price_per_unit IS NOT NULL THEN
+            INSERT INTO item_price_history (item_id, location_id, price_per_unit, price_change_id)
+                VALUES (OLD.item_id, OLD.location_id, NULL, current_item_price_change());
+        END IF;
+    ELSIF (TG_OP = 'INSERT' AND NEW.price_per_unit IS NOT NULL)
+        OR (TG_OP = 'UPDATE' AND NEW.price_per_unit IS DISTINCT FROM OLD.price_per_unit)
+    THEN
+        INSERT INTO item_price_history (item_id, location_id, price_per_unit, price_change_id)
+            VALUES (NEW.item_id, NEW.location_id, NEW.price_per_unit, current_item_price_change());
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS item_location_price_history_trigger ON item_location;
+CREATE TRIGGER item_location_price_history_trigger
+    AFTER INSERT OR UPDATE OF price_per_unit OR DELETE ON item_location
+    FOR EACH ROW
+    EXECUTE FUNCTION record_item_location_price();
+
+CREATE OR REPLACE FUNCTION record_price_list_price()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO item_price_history (item_id, price_list_id, price_per_unit)
+            VALUES (OLD.item_id, OLD.price_list_id, NULL);
+    ELSIF TG_OP = 'INSERT' OR NEW.price_per_unit IS DISTINCT FROM OLD.price_per_unit THEN
+        INSERT INTO item_price_history (item_id, price_list_id, price_per_unit)
+            VALUES (NEW.item_id, NEW.price_list_id, NEW.price_per_unit);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS price_list_item_history_trigger ON price_list_item;
+CREATE TRIGGER price_list_item_history_trigger
+    AFTER INSERT OR UPDATE OF price_per_unit OR DELETE ON price_list_item
+    FOR EACH ROW
+    EXECUTE FUNCTION record_price_list_price();
+
+-- Applies the scheduled changes that are due and returns how many there were.
+-- Due changes are locked, so a transaction that calls this while another one applies
+-- the same changes waits for it and then sees the new prices.
+CREATE OR REPLACE FUNCTION apply_item_price_changes()
+RETURNS INTEGER AS
+$$
+DECLARE
+    change  item_price_change;
+    applied INTEGER := 0;
+BEGIN
+    FOR change IN
+        SELECT *
+        FROM item_price_change
+        WHERE
+            applied_at IS NULL
+            AND effective_at <= NOW()
+        ORDER BY effective_at, id
+        FOR UPDATE
+    LOOP
+        PERFORM set_config('dreampos.price_change_id', change.id::TEXT, TRUE);
+
+        -- Does nothing if the item is no longer sold at the location
+        IF change.location_only THEN
+            UPDATE item_location
+            SET price_per_unit = change.price_per_unit
+            WHERE
+                item_id = change.item_id
+                AND location_id = change.location_id;
+        ELSE
+            UPDATE item
+            SET
+                price_per_unit = COALESCE(change.price_per_unit, price_per_unit),
+                vat            = CASE WHEN change.fulfillment_type IS NULL THEN COALESCE(change.vat, vat) ELSE vat END
+            WHERE id = change.item_id;
+        END IF;
+
+        IF change.fulfillment_type IS NOT NULL AND change.vat IS NULL THEN
+            DELETE FROM item_vat
+            WHERE
+                item_id = change.item_id
+                AND fulfillment_type = change.fulfillment_type;
+        ELSIF change.fulfillment_type IS NOT NULL THEN
+            INSERT INTO item_vat (item_id, fulfillment_type, vat)
+                VALUES (change.item_id, change.fulfillment_type, change.vat)
+            ON CONFLICT (item_id, fulfillment_type) DO UPDATE
+                SET vat = EXCLUDED.vat;
+        END IF;
+
+        UPDATE item_price_change
+        SET applied_at = NOW()
+        WHERE id = change.id;
+        applied := applied + 1;
+    END LOOP;
+
+    PERFORM set_config('dreampos.price_change_id', '', TRUE);
+    RETURN applied;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Selection rules for the variations of an item, e.g. "size" (exactly one) or "milk" (at most one).
+-- A line needs at least min_selected and at most max_selected (no limit when NULL) variations
+-- of the group, groups with a minimum are required. Variations without a group are not limited.
+DROP TABLE IF EXISTS variation_group CASCADE;
+CREATE TABLE variation_group (
+    id              SERIAL PRIMARY KEY,
+    item_id         INTEGER     NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    name            VARCHAR(64) NOT NULL,
+    min_selected    INTEGER     NOT NULL DEFAULT 0,
+    max_selected    INTEGER     NULL,
+    position        INTEGER     NOT NULL DEFAULT 0,
+
+    CONSTRAINT unique_variation_group_name  UNIQUE (item_id, name),
+    CONSTRAINT valid_selection_limits       CHECK (min_selected >= 0 AND (max_selected IS NULL OR max_selected >= GREATEST(min_selected, 1)))
+);
 
 DROP TABLE IF EXISTS item_variation CASCADE;
+-- Variations are archived instead of deleted, order lines keep referencing them.
+-- Default variations are selected on new lines that have nothing selected in their group.
 CREATE TABLE item_variation (
-    id                  INTEGER PRIMARY KEY,
+    id                  SERIAL PRIMARY KEY,
     item_id             INTEGER     NOT NULL REFERENCES item(id),
     name                VARCHAR(64) NOT NULL,
-    price_difference    DECIMAL(15) NOT NULL DEFAULT 0
+    price_difference    DECIMAL(15) NOT NULL DEFAULT 0,
+    status              item_status NOT NULL DEFAULT 'ACTIVE',
+    group_id            INTEGER     NULL REFERENCES variation_group(id) ON DELETE SET NULL,
+    is_default          BOOLEAN     NOT NULL DEFAULT FALSE
+);
+
+-- Codes are EAN-8, EAN-13 or GTIN-14 with a valid check digit, UPC-A is stored as EAN-13 (leading 0).
+-- Scanning the barcode of a variation selects that variation.
+DROP TABLE IF EXISTS item_barcode CASCADE;
+CREATE TABLE item_barcode (
+    id              SERIAL PRIMARY KEY,
+    business_id     INTEGER     NOT NULL REFERENCES business(id),
+    item_id         INTEGER     NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    variation_id    INTEGER     NULL REFERENCES item_variation(id) ON DELETE CASCADE,
+    code            VARCHAR(14) NOT NULL,
+
+    UNIQUE (business_id, code)
 );
 
+DROP INDEX IF EXISTS item_barcode_item_id_index CASCADE;
+CREATE INDEX item_barcode_item_id_index ON item_barcode(item_id);
+
+-- Files are kept in the image storage, keys are derived from the content so products
+-- with the same picture share the files. URLs are stored as the storage returned them.
+DROP TABLE IF EXISTS item_image CASCADE;
+CREATE TABLE item_image (
+    item_id         INTEGER         PRIMARY KEY REFERENCES item(id) ON DELETE CASCADE,
+    image_key       VARCHAR(255)    NOT NULL,
+    thumbnail_key   VARCHAR(255)    NOT NULL,
+    image_url       TEXT            NOT NULL,
+    thumbnail_url   TEXT            NOT NULL,
+    uploaded_at     TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Files no product uses anymore, they are deleted by the image sweep once delete_after passed.
+-- Uploads remove the row of their keys before they store the files, the delay covers uploads
+-- that stored the files but didn't reference them yet.
+DROP TABLE IF EXISTS image_deletion CASCADE;
+CREATE TABLE image_deletion (
+    key             VARCHAR(255)    PRIMARY KEY,
+    delete_after    TIMESTAMP       NOT NULL
+);
+
+-- Every business has its own categories, products, pricing rules and kitchen stations only use
+-- the categories of their business.
 DROP TABLE IF EXISTS category CASCADE;
 CREATE TABLE category (
-    id      INTEGER PRIMARY KEY,
-    name    VARCHAR(64) NOT NULL UNIQUE
+    id          SERIAL PRIMARY KEY,
+    business_id INTEGER     NOT NULL REFERENCES business(id),
+    name        VARCHAR(64) NOT NULL,
+
+    UNIQUE (business_id, name)
 );
 
 DROP TABLE IF EXISTS item_category CASCADE;
 CREATE TABLE item_category (
     item_id     INTEGER NOT NULL REFERENCES item(id),
-    category_id INTEGER NOT NULL REFERENCES category(id),
+    category_id INTEGER NOT NULL REFERENCES category(id) ON DELETE CASCADE,
 
     PRIMARY KEY (item_id, category_id)
 );
 
+-- Items with slots are bundles (e.g. a burger menu), the price of the item is the price of the whole bundle.
+-- Every slot is filled with one of its options when the bundle is ordered. Options are items of the same
+-- business that are not bundles themselves, the upcharge is added to the bundle price when it's chosen.
+DROP TABLE IF EXISTS bundle_slot CASCADE;
+CREATE TABLE bundle_slot (
+    id          SERIAL PRIMARY KEY,
+    bundle_id   INTEGER     NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    name        VARCHAR(64) NOT NULL,
+    position    INTEGER     NOT NULL DEFAULT 0,
+
+    CONSTRAINT unique_bundle_slot_name UNIQUE (bundle_id, name)
+);
+
+DROP TABLE IF EXISTS bundle_slot_option CASCADE;
+CREATE TABLE bundle_slot_option (
+    slot_id     INTEGER     NOT NULL REFERENCES bundle_slot(id) ON DELETE CASCADE,
+    item_id     INTEGER     NOT NULL REFERENCES item(id) ON DELETE CASCADE,
+    upcharge    DECIMAL(15) NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (slot_id, item_id),
+    CONSTRAINT non_negative_upcharge CHECK (upcharge >= 0)
+);
 
+DROP INDEX IF EXISTS bundle_slot_option_item_id_index CASCADE;
+CREATE INDEX bundle_slot_option_item_id_index ON bundle_slot_option(item_id);
+
+DROP TYPE IF EXISTS price_adjustment_type CASCADE;
+CREATE TYPE price_adjustment_type AS ENUM('PERCENT', 'FIXED');
+
+-- Time-based price adjustments, e.g. happy hours and lunch menus. A rule applies to the products
+-- of its business, narrowed down by location, item and category when they are set.
+-- The window is in the local time of the location, from start_time on each of the weekdays
+-- (every day when empty) until end_time; an end before the start runs past midnight
+-- and equal times cover the whole day.
+-- PERCENT adjustments are in hundredths of a percent, FIXED ones in cents per unit,
+-- negative adjustments lower the price.
+DROP TABLE IF EXISTS pricing_rule CASCADE;
+CREATE TABLE pricing_rule (
+    id                  SERIAL PRIMARY KEY,
+    business_id         INTEGER                 NOT NULL REFERENCES business(id),
+    name                VARCHAR(64)             NOT NULL,
+    location_id         INTEGER                 NULL REFERENCES location(id) ON DELETE CASCADE,
+    item_id             INTEGER                 NULL REFERENCES item(id) ON DELETE CASCADE,
+    category_id         INTEGER                 NULL REFERENCES category(id) ON DELETE CASCADE,
+    weekdays            weekday[]               NOT NULL DEFAULT '{}',
+    start_time          TIME                    NOT NULL,
+    end_time            TIME                    NOT NULL,
+    adjustment_type     price_adjustment_type   NOT NULL,
+    adjustment          BIGINT                  NOT NULL,
+    -- The matching rule with the highest priority is applied, the newest one on a tie
+    priority            INTEGER                 NOT NULL DEFAULT 0,
+    active              BOOLEAN                 NOT NULL DEFAULT TRUE,
+
+    CONSTRAINT unique_pricing_rule_name UNIQUE (business_id, name),
+    CONSTRAINT percent_above_minus_100  CHECK (adjustment_type <> 'PERCENT' OR adjustment >= -10000)
+);
+
+DROP INDEX IF EXISTS pricing_rule_business_id_index CASCADE;
+CREATE INDEX pricing_rule_business_id_index ON pricing_rule(business_id);
+
+-- Rule that applies to the item at the location right now, see pricing_rule.
+-- Nothing matches without a location, the time window is in the time zone of the location.
+CREATE OR REPLACE FUNCTION find_pricing_rule(item_id INTEGER, location_id INTEGER)
+RETURNS SETOF pricing_rule AS
+$$
+    SELECT pricing_rule.*
+    FROM pricing_rule
+    JOIN location
+        ON location.id = $2
+        AND location.business_id = pricing_rule.business_id
+    CROSS JOIN LATERAL (
+        SELECT NOW() AT TIME ZONE location.time_zone AS local_time
+    ) local_now
+    WHERE
+        pricing_rule.active
+        AND (pricing_rule.location_id IS NULL OR pricing_rule.location_id = $2)
+        AND (pricing_rule.item_id IS NULL OR pricing_rule.item_id = $1)
+        AND (
+            pricing_rule.category_id IS NULL
+            OR EXISTS (
+                SELECT 1
+                FROM item_category
+                WHERE
+                    item_category.item_id = $1
+                    AND item_category.category_id = pricing_rule.category_id
+            )
+        )
+        AND CASE
+            WHEN pricing_rule.start_time < pricing_rule.end_time THEN
+                local_time::TIME >= pricing_rule.start_time
+                AND local_time::TIME < pricing_rule.end_time
+                AND (CARDINALITY(pricing_rule.weekdays) = 0 OR TO_CHAR(local_time, 'FMDAY')::weekday = ANY(pricing_rule.weekdays))
+            WHEN pricing_rule.start_time = pricing_rule.end_time THEN
+                CARDINALITY(pricing_rule.weekdays) = 0 OR TO_CHAR(local_time, 'FMDAY')::weekday = ANY(pricing_rule.weekdays)
+            -- Past midnight the window belongs to the day it started on
+            ELSE
+                (
+                    local_time::TIME >= pricing_rule.start_time
+                    AND (CARDINALITY(pricing_rule.weekdays) = 0 OR TO_CHAR(local_time, 'FMDAY')::weekday = ANY(pricing_rule.weekdays))
+                )
+                OR (
+                    local_time::TIME < pricing_rule.end_time
+                    AND (CARDINALITY(pricing_rule.weekdays) = 0 OR TO_CHAR(local_time - INTERVAL '1 day', 'FMDAY')::weekday = ANY(pricing_rule.weekdays))
+                )
+        END
+    ORDER BY
+        pricing_rule.priority DESC,
+        pricing_rule.id DESC
+    LIMIT 1;
+$$ LANGUAGE sql STABLE;
+
+
+-- Preparation status of an order line on the kitchen screens.
+DROP TYPE IF EXISTS prep_status CASCADE;
+CREATE TYPE prep_status AS ENUM('NEW', 'IN_PROGRESS', 'READY', 'SERVED');
+
+-- item_name, price_per_unit and vat are a snapshot of the item taken when the line is added
+-- (see snapshot_order_item) and refreshed when the order's location, price list or fulfillment type change.
 DROP TABLE IF EXISTS order_item CASCADE;
 CREATE TABLE order_item (
-    id          SERIAL PRIMARY KEY,
-    order_id    INTEGER     NOT NULL REFERENCES order_data(id),
-    item_id     INTEGER     NOT NULL REFERENCES item(id),
-    quantity    INTEGER     NOT NULL DEFAULT 1,
-    discount    DECIMAL(15) NOT NULL DEFAULT 0,
+    id              SERIAL PRIMARY KEY,
+    order_id        INTEGER         NOT NULL REFERENCES order_data(id),
+    item_id         INTEGER         NOT NULL REFERENCES item(id),
+    -- Up to 3 decimals for items sold by weight, volume or length
+    quantity        DECIMAL(10, 3)  NOT NULL DEFAULT 1,
+    discount        DECIMAL(15)     NOT NULL DEFAULT 0,
+    item_name       VARCHAR(64)     NOT NULL,
+    price_per_unit  DECIMAL(15)     NOT NULL,
+    vat             DECIMAL(4, 2)   NOT NULL,
+    -- Free-text instructions for the kitchen
+    note            VARCHAR(256)    NOT NULL DEFAULT '',
+    -- Lines show up on the kitchen screens once they are sent
+    sent_at                 TIMESTAMP       NULL,
+    prep_status             prep_status     NOT NULL DEFAULT 'NEW',
+    prep_status_changed_at  TIMESTAMP       NULL,
+    -- Pricing rule in effect when the line was added, the adjustment is kept even if the rule changes
+    pricing_rule_id         INTEGER                 NULL REFERENCES pricing_rule(id) ON DELETE SET NULL,
+    pricing_rule_name       VARCHAR(64)             NULL,
+    price_adjustment_type   price_adjustment_type   NULL,
+    price_adjustment        BIGINT                  NULL,
 
     CONSTRAINT positive_quantity        CHECK (quantity > 0),
-    CONSTRAINT non_negative_discount    CHECK (discount >= 0)
+    CONSTRAINT non_negative_discount    CHECK (discount >= 0),
+    CONSTRAINT complete_pricing_rule    CHECK ((pricing_rule_name IS NULL) = (price_adjustment_type IS NULL) AND (pricing_rule_name IS NULL) = (price_adjustment IS NULL))
 );
 
+DROP INDEX IF EXISTS order_item_order_id_index CASCADE;
+CREATE INDEX order_item_order_id_index ON order_item(order_id);
+
+DROP INDEX IF EXISTS order_item_item_id_index CASCADE;
+CREATE INDEX order_item_item_id_index ON order_item(item_id, order_id);
+
+DROP INDEX IF EXISTS order_item_kitchen_index CASCADE;
+CREATE INDEX order_item_kitchen_index ON order_item(sent_at) WHERE sent_at IS NOT NULL AND prep_status <> 'SERVED';
+
+-- variation_name and price_difference are a snapshot of the variation, same as on order_item.
 DROP TABLE IF EXISTS order_item_variation CASCADE;
 CREATE TABLE order_item_variation (
-    order_item_id   INTEGER NOT NULL REFERENCES order_item(id) ON DELETE CASCADE,
-    variation_id    INTEGER NOT NULL REFERENCES item_variation(id),
+    order_item_id       INTEGER     NOT NULL REFERENCES order_item(id) ON DELETE CASCADE,
+    variation_id        INTEGER     NOT NULL REFERENCES item_variation(id),
+    variation_name      VARCHAR(64) NOT NULL,
+    price_difference    DECIMAL(15) NOT NULL,
 
     PRIMARY KEY(order_item_id, variation_id)
 );
 
+-- Free-text modifiers of an order line, e.g. "extra hot". They are not in the catalog,
+-- so the name and price are stored as given and never change.
+DROP TABLE IF EXISTS order_item_modifier CASCADE;
+CREATE TABLE order_item_modifier (
+    id                  SERIAL PRIMARY KEY,
+    order_item_id       INTEGER     NOT NULL REFERENCES order_item(id) ON DELETE CASCADE,
+    group_name          VARCHAR(64) NOT NULL DEFAULT '',
+    name                VARCHAR(64) NOT NULL,
+    price_difference    DECIMAL(15) NOT NULL DEFAULT 0,
+
+    CONSTRAINT non_negative_price_difference CHECK (price_difference >= 0)
+);
+
+DROP INDEX IF EXISTS order_item_modifier_order_item_id_index CASCADE;
+CREATE INDEX order_item_modifier_order_item_id_index ON order_item_modifier(order_item_id);
+
+-- Items chosen for the slots of a bundle line. slot_name, item_name, upcharge, price_per_unit and vat
+-- are a snapshot like on order_item, price_per_unit is the price of the item sold on its own
+-- and only decides the share of the line price the component gets (see order_item_component_allocation).
+DROP TABLE IF EXISTS order_item_component CASCADE;
+CREATE TABLE order_item_component (
+    id              SERIAL PRIMARY KEY,
+    order_item_id   INTEGER         NOT NULL REFERENCES order_item(id) ON DELETE CASCADE,
+    slot_id         INTEGER         NULL REFERENCES bundle_slot(id) ON DELETE SET NULL,
+    slot_name       VARCHAR(64)     NOT NULL,
+    item_id         INTEGER         NOT NULL REFERENCES item(id),
+    item_name       VARCHAR(64)     NOT NULL,
+    upcharge        DECIMAL(15)     NOT NULL DEFAULT 0,
+    price_per_unit  DECIMAL(15)     NOT NULL,
+    vat             DECIMAL(4, 2)   NOT NULL
+);
+
+DROP INDEX IF EXISTS order_item_component_order_item_id_index CASCADE;
+CREATE INDEX order_item_component_order_item_id_index ON order_item_component(order_item_id);
+
+-- Kitchen screen of a location. Lines are routed to a station by the categories of their item,
+-- a station without categories gets every line of its location (e.g. expo).
+DROP TABLE IF EXISTS kitchen_station CASCADE;
+CREATE TABLE kitchen_station (
+    id              SERIAL PRIMARY KEY,
+    location_id     INTEGER     NOT NULL REFERENCES location(id),
+    name            VARCHAR(64) NOT NULL,
+
+    UNIQUE (location_id, name)
+);
+
+DROP TABLE IF EXISTS kitchen_station_category CASCADE;
+CREATE TABLE kitchen_station_category (
+    station_id      INTEGER NOT NULL REFERENCES kitchen_station(id) ON DELETE CASCADE,
+    category_id     INTEGER NOT NULL REFERENCES category(id),
+
+    PRIMARY KEY (station_id, category_id)
+);
+
+-- Fills in the snapshot of the item if it is not given explicitly
+-- or the line now points to a different item.
+CREATE OR REPLACE FUNCTION snapshot_order_item()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF  NEW.item_name IS NULL OR NEW.price_per_unit IS NULL OR NEW.vat IS NULL
+        OR (TG_OP = 'UPDATE' AND NEW.item_id <> OLD.item_id)
+    THEN
+        SELECT item.name, item_price(item.id, order_data.location_id, order_data.price_list_id), COALESCE(item_vat.vat, item.vat)
+        INTO NEW.item_name, NEW.price_per_unit, NEW.vat
+        FROM item
+        JOIN order_data
+            ON order_data.id = NEW.order_id
+        LEFT JOIN item_vat
+            ON item_vat.item_id = item.id
+            AND item_vat.fulfillment_type = order_data.fulfillment_type
+        WHERE item.id = NEW.item_id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS order_item_snapshot ON order_item;
+CREATE TRIGGER order_item_snapshot
+    BEFORE INSERT OR UPDATE ON order_item
+    FOR EACH ROW
+    EXECUTE FUNCTION snapshot_order_item();
+
+CREATE OR REPLACE FUNCTION snapshot_order_item_variation()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF  NEW.variation_name IS NULL OR NEW.price_difference IS NULL
+        OR (TG_OP = 'UPDATE' AND NEW.variation_id <> OLD.variation_id)
+    THEN
+        SELECT name, price_difference
+        INTO NEW.variation_name, NEW.price_difference
+        FROM item_variation
+        WHERE id = NEW.variation_id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS order_item_variation_snapshot ON order_item_variation;
+CREATE TRIGGER order_item_variation_snapshot
+    BEFORE INSERT OR UPDATE ON order_item_variation
+    FOR EACH ROW
+    EXECUTE FUNCTION snapshot_order_item_variation();
+
+CREATE OR REPLACE FUNCTION snapshot_order_item_component()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF  NEW.slot_name IS NULL OR NEW.item_name IS NULL OR NEW.price_per_unit IS NULL OR NEW.vat IS NULL
+        OR (TG_OP = 'UPDATE' AND (NEW.item_id <> OLD.item_id OR NEW.slot_id IS DISTINCT FROM OLD.slot_id))
+    THEN
+        SELECT
+            bundle_slot.name,
+            item.name,
+            COALESCE(bundle_slot_option.upcharge, 0),
+            item_price(item.id, order_data.location_id, order_data.price_list_id),
+            COALESCE(item_vat.vat, item.vat)
+        INTO NEW.slot_name, NEW.item_name, NEW.upcharge, NEW.price_per_unit, NEW.vat
+        FROM item
+        JOIN order_item
+            ON order_item.id = NEW.order_item_id
+        JOIN order_data
+            ON order_data.id = order_item.order_id
+        LEFT JOIN bundle_slot
+            ON bundle_slot.id = NEW.slot_id
+        LEFT JOIN bundle_slot_option
+            ON bundle_slot_option.slot_id = NEW.slot_id
+            AND bundle_slot_option.item_id = item.id
+        LEFT JOIN item_vat
+            ON item_vat.item_id = item.id
+            AND item_vat.fulfillment_type = order_data.fulfillment_type
+        WHERE item.id = NEW.item_id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS order_item_component_snapshot ON order_item_component;
+CREATE TRIGGER order_item_component_snapshot
+    BEFORE INSERT OR UPDATE ON order_item_component
+    FOR EACH ROW
+    EXECUTE FUNCTION snapshot_order_item_component();
+
 CREATE OR REPLACE FUNCTION check_if_item_id_is_consistent()
 RETURNS TRIGGER AS
 $$
@@ -436,7 +1192,8 @@ CREATE TABLE appointment (
     customer_name       VARCHAR(64)         NOT NULL,
     customer_phone      VARCHAR(16)         NOT NULL UNIQUE,
     appointment_at      TIMESTAMP           NOT NULL,
-	status              appointment_status  NOT NULL DEFAULT 'PENDING'
+	status              appointment_status  NOT NULL DEFAULT 'PENDING',
+    version             INTEGER             NOT NULL DEFAULT 1,
 
     CONSTRAINT valid_customer_phone CHECK (customer_phone ~ '^\+[0-9]{3,15}$')
 );
@@ -455,6 +1212,10 @@ CREATE TABLE appointment_bill (
     CONSTRAINT non_negative_tip         CHECK (tip >= 0)
 );
 
+-- Appointment is created after payment, so the reference is added here.
+ALTER TABLE payment
+    ADD CONSTRAINT payment_reservation_id_fkey FOREIGN KEY (reservation_id) REFERENCES appointment(id);
+
 DROP TRIGGER IF EXISTS appointment_bill_valid_created_at ON appointment_bill;
 CREATE TRIGGER appointment_bill_valid_created_at
     BEFORE INSERT OR UPDATE ON appointment_bill
@@ -477,6 +1238,20 @@ CREATE TABLE refund_data (
     CONSTRAINT valid_phone          CHECK (phone ~ '^\+[0-9]{3,15}$')
 );
 
+DROP INDEX IF EXISTS refund_data_phone_index CASCADE;
+CREATE INDEX refund_data_phone_index ON refund_data(phone text_pattern_ops);
+
+DROP TABLE IF EXISTS order_cancellation CASCADE;
+CREATE TABLE order_cancellation (
+    order_id        INTEGER PRIMARY KEY REFERENCES order_data(id),
+    reason          order_cancel_reason NOT NULL,
+    note            VARCHAR(512)        NOT NULL DEFAULT '',
+    cancelled_by    INTEGER             NOT NULL REFERENCES employee(id),
+    cancelled_at    TIMESTAMP           NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT other_reason_has_note CHECK (reason <> 'OTHER' OR note <> '')
+);
+
 DROP TABLE IF EXISTS reservation_refund_data CASCADE;
 CREATE TABLE reservation_refund_data (
     appointment_id  INTEGER PRIMARY KEY REFERENCES appointment(id),
@@ -489,12 +1264,152 @@ CREATE TABLE reservation_refund_data (
     CONSTRAINT valid_reservation_refund_phone  CHECK (phone ~ '^\+[0-9]{3,15}$')
 );
 
+-- -------------------------------------------------------------------------------------------------
+-- Request data-------------------------------------------------------------------------------------
+-- -------------------------------------------------------------------------------------------------
+
+-- First response of a request sent with an Idempotency-Key header.
+-- status_code is NULL while the first request is still being handled.
+DROP TABLE IF EXISTS idempotency_key CASCADE;
+CREATE TABLE idempotency_key (
+    username        VARCHAR(64)     NOT NULL,
+    key             VARCHAR(255)    NOT NULL,
+    request_hash    CHAR(64)        NOT NULL,
+    status_code     INTEGER         NULL,
+    headers         JSONB           NOT NULL DEFAULT '{}',
+    body            BYTEA           NOT NULL DEFAULT '',
+    created_at      TIMESTAMP       NOT NULL DEFAULT NOW(),
+    -- Claims in progress expire quickly, so a crashed request doesn't block its key for the whole retention.
+    expires_at      TIMESTAMP       NOT NULL,
+    PRIMARY KEY (username, key)
+);
+
+DROP INDEX IF EXISTS idempotency_key_expires_at_index CASCADE;
+CREATE INDEX idempotency_key_expires_at_index ON idempotency_key(expires_at);
+
+-- -------------------------------------------------------------------------------------------------
+-- Events ------------------------------------------------------------------------------------------
+-- -------------------------------------------------------------------------------------------------
+
+-- Changes pushed to the clients over /api/events. Every event is announced to the servers
+-- over NOTIFY, rows are kept for a while so clients can catch up after they reconnect.
+DROP TABLE IF EXISTS app_event CASCADE;
+CREATE TABLE app_event (
+    id              BIGSERIAL PRIMARY KEY,
+    business_id     INTEGER         NOT NULL REFERENCES business(id),
+    location_id     INTEGER         NULL REFERENCES location(id),
+    type            VARCHAR(32)     NOT NULL,
+    data            JSONB           NOT NULL DEFAULT '{}',
+    -- Events are streamed in the order of the transactions that wrote them, ids are assigned
+    -- before commit and can become visible out of order.
+    xid             XID8            NOT NULL DEFAULT pg_current_xact_id(),
+    created_at      TIMESTAMP       NOT NULL DEFAULT NOW()
+);
+
+DROP INDEX IF EXISTS app_event_stream_index CASCADE;
+CREATE INDEX app_event_stream_index ON app_event(xid, id);
+
+DROP INDEX IF EXISTS app_event_business_id_index CASCADE;
+CREATE INDEX app_event_business_id_index ON app_event(business_id, xid, id);
+
+DROP INDEX IF EXISTS app_event_created_at_index CASCADE;
+CREATE INDEX app_event_created_at_index ON app_event(created_at);
+
+-- Notifications only wake the listeners up, the events are read from the table.
+-- The payload is empty so the events of one transaction are notified once.
+CREATE OR REPLACE FUNCTION notify_app_event()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    PERFORM pg_notify('app_event', '');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS app_event_notify ON app_event;
+CREATE TRIGGER app_event_notify
+    AFTER INSERT ON app_event
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_app_event();
+
+CREATE OR REPLACE FUNCTION publish_order_event()
+RETURNS TRIGGER AS
+$$
+DECLARE
+    event_type VARCHAR(32);
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        event_type := 'order.created';
+    ELSIF NEW.status = 'CLOSED' AND OLD.status <> 'CLOSED' THEN
+        event_type := 'order.closed';
+    ELSE
+        event_type := 'order.updated';
+    END IF;
+
+    INSERT INTO app_event (business_id, location_id, type, data)
+        SELECT
+            employee.business_id,
+            NEW.location_id,
+            event_type,
+            jsonb_build_object('id', NEW.id, 'status', LOWER(NEW.status::TEXT), 'version', NEW.version)
+        FROM employee
+        WHERE employee.id = NEW.employee_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Every change of an order bumps its version, so one modification is one event.
+DROP TRIGGER IF EXISTS order_data_created_event ON order_data;
+CREATE TRIGGER order_data_created_event
+    AFTER INSERT ON order_data
+    FOR EACH ROW
+    EXECUTE FUNCTION publish_order_event();
+
+DROP TRIGGER IF EXISTS order_data_updated_event ON order_data;
+CREATE TRIGGER order_data_updated_event
+    AFTER UPDATE ON order_data
+    FOR EACH ROW
+    WHEN (OLD.version IS DISTINCT FROM NEW.version OR OLD.status IS DISTINCT FROM NEW.status)
+    EXECUTE FUNCTION publish_order_event();
+
+CREATE OR REPLACE FUNCTION publish_reservation_event()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    INSERT INTO app_event (business_id, location_id, type, data)
+        SELECT
+            location.business_id,
+            location.id,
+            CASE WHEN TG_OP = 'INSERT' THEN 'reservation.created' ELSE 'reservation.updated' END,
+            jsonb_build_object('id', NEW.id, 'status', LOWER(NEW.status::TEXT), 'version', NEW.version)
+        FROM service_location
+        JOIN location
+            ON location.id = service_location.location_id
+        WHERE service_location.id = NEW.service_location_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS appointment_created_event ON appointment;
+CREATE TRIGGER appointment_created_event
+    AFTER INSERT ON appointment
+    FOR EACH ROW
+    EXECUTE FUNCTION publish_reservation_event();
+
+DROP TRIGGER IF EXISTS appointment_updated_event ON appointment;
+CREATE TRIGGER appointment_updated_event
+    AFTER UPDATE ON appointment
+    FOR EACH ROW
+    WHEN (OLD.version IS DISTINCT FROM NEW.version OR OLD.status IS DISTINCT FROM NEW.status)
+    EXECUTE FUNCTION publish_reservation_event();
+
 -- -------------------------------------------------------------------------------------------------
 -- -------------------------------------------------------------------------------------------------
 -- Views -------------------------------------------------------------------------------------------
 -- -------------------------------------------------------------------------------------------------
 -- -------------------------------------------------------------------------------------------------
 
+-- Lines are priced by the snapshot taken when they were added, later price changes don't affect them.
 CREATE OR REPLACE VIEW order_item_detail
 AS
     WITH item_info AS 
@@ -503,12 +1418,15 @@ AS
             order_item.id AS order_item_id,
             order_id,
             item.id AS item_id,
-            item.name AS item_name,
-            price_per_unit,
+            order_item.item_name,
+            order_item.price_per_unit,
             quantity,
-            discount AS unit_discount,
-            vat,
-            status 
+            order_item.discount AS unit_discount,
+            order_item.vat,
+            item.status,
+            item.unit,
+            order_item.price_adjustment_type,
+            order_item.price_adjustment
         FROM item 
         JOIN order_item 
             ON item.id = order_item.item_id 
@@ -516,11 +1434,9 @@ AS
         SELECT
             order_item_id,
             variation_id,
-            name AS variation_name,
-            price_difference 
+            variation_name,
+            price_difference
         FROM order_item_variation 
-        JOIN item_variation 
-            ON order_item_variation.variation_id = item_variation.id 
     )
     SELECT
         item_info.order_item_id,
@@ -532,45 +1448,179 @@ AS
         unit_discount,
         vat,
         status,
+        unit,
         variation_id,
         variation_name,
-        price_difference
+        price_difference,
+        price_adjustment_type,
+        price_adjustment
     FROM item_info 
     LEFT JOIN variation_info 
         ON item_info.order_item_id = variation_info.order_item_id
 ;
 
+-- Components of bundle lines, priced like order_item_detail.
+CREATE OR REPLACE VIEW order_item_component_detail
+AS
+    SELECT
+        order_item_component.id AS component_id,
+        order_item_component.order_item_id,
+        order_item.order_id,
+        order_item_component.slot_id,
+        order_item_component.slot_name,
+        order_item_component.item_id,
+        order_item_component.item_name,
+        order_item_component.upcharge,
+        order_item_component.price_per_unit,
+        order_item_component.vat
+    FROM order_item_component
+    JOIN order_item
+        ON order_item.id = order_item_component.order_item_id
+;
+
+-- Share of the line price every component of a bundle gets, for VAT and stock. It goes by the price
+-- of the component sold on its own plus its upcharge, the shares of a line add up to 1.
+-- Components without any price split the line evenly.
+CREATE OR REPLACE VIEW order_item_component_allocation
+AS
+    SELECT
+        order_item_component_detail.*,
+        CASE
+            WHEN SUM(price_per_unit + upcharge) OVER bundle_line = 0 THEN 1.0 / COUNT(*) OVER bundle_line
+            ELSE (price_per_unit + upcharge) / SUM(price_per_unit + upcharge) OVER bundle_line
+        END AS share
+    FROM order_item_component_detail
+    WINDOW bundle_line AS (PARTITION BY order_item_id)
+;
+
+-- Line totals are rounded to whole cents once, after multiplying by the (possibly fractional) quantity.
+-- The pricing rule of the line adjusts the unit price with variations, modifiers and the upcharges
+-- of bundle components, before the discount.
+-- Net amounts of bundle lines are the sum of the net shares of the components, each at its own VAT rate,
+-- and vat is the effective rate of the whole line.
 CREATE OR REPLACE VIEW order_item_total
 AS
-     SELECT
+    WITH line AS (
+        SELECT
+            order_item_id,
+            order_id,
+            item_id,
+            item_name,
+            price_per_unit,
+            unit_discount,
+            vat,
+            quantity,
+            unit,
+            price_adjustment_type,
+            price_adjustment,
+            price_per_unit + SUM(COALESCE(price_difference, 0)) + modifier_price + component_upcharge AS unit_price
+        FROM order_item_detail
+        LEFT JOIN LATERAL (
+            SELECT COALESCE(SUM(order_item_modifier.price_difference), 0) AS modifier_price
+            FROM order_item_modifier
+            WHERE order_item_modifier.order_item_id = order_item_detail.order_item_id
+        ) modifiers ON TRUE
+        LEFT JOIN LATERAL (
+            SELECT COALESCE(SUM(order_item_component_detail.upcharge), 0) AS component_upcharge
+            FROM order_item_component_detail
+            WHERE order_item_component_detail.order_item_id = order_item_detail.order_item_id
+        ) components ON TRUE
+        GROUP BY 
+            order_item_id,
+            order_id,
+            item_id,
+            item_name,
+            price_per_unit,
+            unit_discount,
+            vat,
+            quantity,
+            unit,
+            price_adjustment_type,
+            price_adjustment,
+            modifier_price,
+            component_upcharge
+    ), adjusted_line AS (
+        SELECT
+            line.*,
+            GREATEST(
+                CASE price_adjustment_type
+                    WHEN 'PERCENT'  THEN ROUND(unit_price * (10000 + price_adjustment) / 10000)
+                    WHEN 'FIXED'    THEN unit_price + price_adjustment
+                    ELSE unit_price
+                END,
+                0
+            ) - unit_discount AS adjusted_price
+        FROM line
+    ), net_line AS (
+        SELECT
+            adjusted_line.*,
+            -- Net amount per unit of gross, NULL for lines that are not bundles
+            bundle.net_share
+        FROM adjusted_line
+        LEFT JOIN LATERAL (
+            SELECT SUM(share / (1 + order_item_component_allocation.vat / 100)) AS net_share
+            FROM order_item_component_allocation
+            WHERE order_item_component_allocation.order_item_id = adjusted_line.order_item_id
+        ) bundle ON TRUE
+    )
+    SELECT
         order_item_id,
         order_id,
+        item_id,
+        item_name,
         price_per_unit,
         unit_discount,
-        vat,
+        CASE WHEN net_share IS NULL THEN vat ELSE ROUND((1 / net_share - 1) * 100, 2) END AS vat,
         quantity,
-        ((price_per_unit + SUM(COALESCE(price_difference, 0)) - unit_discount)                         )::DECIMAL(15) AS gross,
-        ((price_per_unit + SUM(COALESCE(price_difference, 0)) - unit_discount) / (0.01 * (100 + vat))  )::DECIMAL(15) AS net,
-        ((price_per_unit + SUM(COALESCE(price_difference, 0)) - unit_discount) * quantity              )::DECIMAL(15) AS total
-    FROM order_item_detail
-    GROUP BY 
-        order_item_id,
-        order_id,
-        price_per_unit,
-        unit_discount,
-        vat,
-        quantity
+        unit,
+        (adjusted_price                         )::DECIMAL(15) AS gross,
+        (CASE
+            WHEN net_share IS NULL THEN adjusted_price / (0.01 * (100 + vat))
+            ELSE adjusted_price * net_share
+        END)::DECIMAL(15) AS net,
+        (adjusted_price * quantity              )::DECIMAL(15) AS total,
+        price_adjustment_type,
+        price_adjustment,
+        (CASE
+            WHEN net_share IS NULL THEN (adjusted_price * quantity)::DECIMAL(15) / (1 + vat / 100)
+            ELSE adjusted_price * quantity * net_share
+        END)::DECIMAL(15) AS net_total
+    FROM net_line
 ;
 
+-- Stations every item is routed to, at every location the item is sold at.
+CREATE OR REPLACE VIEW kitchen_station_item
+AS
+    SELECT
+        kitchen_station.id AS station_id,
+        kitchen_station.location_id,
+        item.id AS item_id
+    FROM kitchen_station
+    JOIN item_location
+        ON item_location.location_id = kitchen_station.location_id
+    JOIN item
+        ON item.id = item_location.item_id
+    WHERE
+        NOT EXISTS (
+            SELECT 1
+            FROM kitchen_station_category
+            WHERE kitchen_station_category.station_id = kitchen_station.id
+        )
+        OR EXISTS (
+            SELECT 1
+            FROM kitchen_station_category
+            JOIN item_category
+                ON item_category.category_id = kitchen_station_category.category_id
+            WHERE
+                kitchen_station_category.station_id = kitchen_station.id
+                AND item_category.item_id = item.id
+        )
+;
+
+-- Item totals are summed per order (LATERAL), so queries that read only a page of orders
+-- don't have to aggregate the items of every order.
 CREATE OR REPLACE VIEW order_detail
 AS
-    WITH item_total_sum AS (
-        SELECT
-            order_id,
-            SUM(total) AS sum_of_totals
-        FROM order_item_total
-        GROUP BY order_id
-    )
     SELECT 
         id,
         employee_id,
@@ -580,11 +1630,20 @@ AS
         discount,
         tip,
         service_charge,
+        customer_label,
+        notes,
+        customer_phone,
+        held_at,
+        fulfillment_type,
+        location_id,
         GREATEST(COALESCE(sum_of_totals, 0) + service_charge - discount, 0)         AS total,
         GREATEST(COALESCE(sum_of_totals, 0) + service_charge - discount, 0) + tip   AS total_with_tip
     FROM order_data
-    LEFT JOIN item_total_sum
-        ON order_data.id = item_total_sum.order_id
+    LEFT JOIN LATERAL (
+        SELECT SUM(total) AS sum_of_totals
+        FROM order_item_total
+        WHERE order_item_total.order_id = order_data.id
+    ) item_total_sum ON TRUE
 ;
 
 -- -------------------------------------------------------------------------------------------------